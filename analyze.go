@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Heatmap shades from worst to best; a space marks hours without data
+var heatmapShades = []string{"·", "░", "▒", "▓", "█"}
+
+// heatmapMetric extracts one value from a history entry. For metrics where
+// lower is better (latency), the shading is inverted.
+type heatmapMetric struct {
+	Name        string
+	Unit        string
+	LowerBetter bool
+	Value       func(historyEntry) float64
+}
+
+var heatmapMetrics = []heatmapMetric{
+	{Name: "Download", Unit: "Mbps", Value: func(e historyEntry) float64 { return e.DownloadMbps }},
+	{Name: "Upload", Unit: "Mbps", Value: func(e historyEntry) float64 { return e.UploadMbps }},
+	{Name: "Latency", Unit: "ms", LowerBetter: true, Value: func(e historyEntry) float64 { return e.LatencyMs }},
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func newAnalyzeFlagSet(historyPath, network *string, plain *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli analyze", flag.ContinueOnError)
+	fs.StringVar(historyPath, "history", defaultHistoryPath(), "history `file` to analyze")
+	fs.StringVar(network, "network", "", networkFilterHelp)
+	fs.BoolVar(plain, "plain", false, "list the medians of each day's hours instead of drawing heatmaps, for screen readers")
+	return fs
+}
+
+// runAnalyze implements `fast-cli analyze`: a time-of-day congestion report
+// built from the recorded history.
+func runAnalyze(args []string) error {
+	var historyPath, network string
+	fs := newAnalyzeFlagSet(&historyPath, &network, &plainOutput)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	entries, err := loadHistory(historyPath)
+	if err != nil {
+		return err
+	}
+	entries = speedResults(onNetwork(entries, network))
+	if len(entries) == 0 {
+		return fmt.Errorf("no results recorded in %s yet", historyPath)
+	}
+
+	first, last := entries[0].Time.Local(), entries[len(entries)-1].Time.Local()
+	fmt.Printf("Analyzing %d results from %s to %s\n", len(entries), first.Format("2006-01-02"), last.Format("2006-01-02"))
+
+	for _, m := range heatmapMetrics {
+		printHeatmap(entries, m)
+	}
+	printPeakDegradation(entries)
+	return nil
+}
+
+func printHeatmap(entries []historyEntry, m heatmapMetric) {
+	// cells[weekday][hour] holds every value recorded in that slot
+	var cells [7][24][]float64
+	for _, e := range entries {
+		t := e.Time.Local()
+		cells[t.Weekday()][t.Hour()] = append(cells[t.Weekday()][t.Hour()], m.Value(e))
+	}
+
+	var medians [7][24]float64
+	var hasData [7][24]bool
+	lo, hi := 0.0, 0.0
+	first := true
+	for d := range cells {
+		for h := range cells[d] {
+			if len(cells[d][h]) == 0 {
+				continue
+			}
+			v := median(cells[d][h])
+			medians[d][h], hasData[d][h] = v, true
+			if first || v < lo {
+				lo = v
+			}
+			if first || v > hi {
+				hi = v
+			}
+			first = false
+		}
+	}
+
+	// Start the week on Monday, as most ISP reports do
+	week := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+	if plainOutput {
+		fmt.Printf("\n%s (median %s by day and hour)\n", m.Name, m.Unit)
+		for _, d := range week {
+			var hours []string
+			for h := 0; h < 24; h++ {
+				if hasData[d][h] {
+					hours = append(hours, fmt.Sprintf("%02d:00 %.1f", h, medians[d][h]))
+				}
+			}
+			if len(hours) > 0 {
+				fmt.Printf("%s: %s\n", d, strings.Join(hours, ", "))
+			}
+		}
+		return
+	}
+	fmt.Printf("\n%s (median %s by day and hour, %s = best)\n", m.Name, m.Unit, heatmapShades[len(heatmapShades)-1])
+	fmt.Printf("     %s\n", "0     6     12    18   23")
+	for _, d := range week {
+		var row strings.Builder
+		for h := 0; h < 24; h++ {
+			if !hasData[d][h] {
+				row.WriteString(" ")
+				continue
+			}
+			row.WriteString(heatmapShade(medians[d][h], lo, hi, m.LowerBetter))
+		}
+		fmt.Printf("%s  %s\n", d.String()[:3], row.String())
+	}
+	fmt.Printf("     range: %.1f - %.1f %s\n", lo, hi, m.Unit)
+}
+
+func heatmapShade(v, lo, hi float64, lowerBetter bool) string {
+	level := len(heatmapShades) - 1
+	if hi > lo {
+		ratio := (v - lo) / (hi - lo)
+		if lowerBetter {
+			ratio = 1 - ratio
+		}
+		level = int(ratio * float64(len(heatmapShades)-1))
+	}
+	return heatmapShades[level]
+}
+
+// printPeakDegradation compares evening results against the rest of the day,
+// which is the figure ISPs are asked about in congestion complaints.
+func printPeakDegradation(entries []historyEntry) {
+	var peakDown, offDown, peakLat, offLat []float64
+	for _, e := range entries {
+		if isEvening(e.Time) {
+			peakDown = append(peakDown, e.DownloadMbps)
+			peakLat = append(peakLat, e.LatencyMs)
+		} else {
+			offDown = append(offDown, e.DownloadMbps)
+			offLat = append(offLat, e.LatencyMs)
+		}
+	}
+
+	fmt.Printf("\n--- Peak Hours (%02d:00-%02d:00) vs Off-Peak ---\n", eveningStartHour, eveningEndHour)
+	if len(peakDown) == 0 || len(offDown) == 0 {
+		fmt.Println("Not enough data: need results from both peak and off-peak hours.")
+		return
+	}
+
+	peak, off := median(peakDown), median(offDown)
+	fmt.Printf("Median download: %.1f Mbps peak vs %.1f Mbps off-peak (%d / %d tests)\n", peak, off, len(peakDown), len(offDown))
+	if off > 0 {
+		fmt.Printf("Peak-hour degradation: %.0f%%\n", 100*(off-peak)/off)
+	}
+	fmt.Printf("Median latency: %.1f ms peak vs %.1f ms off-peak\n", median(peakLat), median(offLat))
+}
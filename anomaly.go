@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	anomalyBaselineRuns    = 30     // Most recent runs in the same hour that form the rolling baseline
+	anomalyMinBaselineRuns = 5      // Below this, an hour bucket has no baseline yet
+	madToSigma             = 1.4826 // Scales MAD to be comparable with a standard deviation
+)
+
+// anomaly is a metric that deviates from the baseline for its hour of day
+type anomaly struct {
+	Metric   heatmapMetric
+	Value    float64
+	Baseline float64 // Median of the hour bucket
+	Score    float64 // Deviation in robust standard deviations, positive = worse
+}
+
+func (a anomaly) String() string {
+	return fmt.Sprintf("%s %.1f %s vs usual %.1f %s (%.1fσ)", a.Metric.Name, a.Value, a.Metric.Unit, a.Baseline, a.Metric.Unit, a.Score)
+}
+
+// robustSpread returns the MAD scaled to σ, falling back to the standard
+// deviation when more than half the baseline is identical (MAD of zero).
+func robustSpread(values []float64, center float64) float64 {
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - center)
+	}
+	if mad := median(deviations); mad > 0 {
+		return mad * madToSigma
+	}
+
+	var mean, variance float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
+
+// detectAnomalies compares the current run against the previous runs that
+// happened in the same hour of day. Only degradations are reported (slower
+// speeds, higher latency), improvements are never worth an alert.
+func detectAnomalies(baseline []historyEntry, current historyEntry, threshold float64) []anomaly {
+	hour := current.Time.Local().Hour()
+	var bucket []historyEntry
+	for i := len(baseline) - 1; i >= 0 && len(bucket) < anomalyBaselineRuns; i-- {
+		if baseline[i].Time.Local().Hour() == hour {
+			bucket = append(bucket, baseline[i])
+		}
+	}
+	if len(bucket) < anomalyMinBaselineRuns {
+		return nil
+	}
+
+	var anomalies []anomaly
+	for _, m := range heatmapMetrics {
+		values := make([]float64, len(bucket))
+		for i, e := range bucket {
+			values[i] = m.Value(e)
+		}
+		center := median(values)
+		spread := robustSpread(values, center)
+		if spread == 0 {
+			continue
+		}
+
+		score := (center - m.Value(current)) / spread
+		if m.LowerBetter {
+			score = -score
+		}
+		if score > threshold {
+			anomalies = append(anomalies, anomaly{Metric: m, Value: m.Value(current), Baseline: center, Score: score})
+		}
+	}
+	return anomalies
+}
+
+func anomalyNotification(entry historyEntry, anomalies []anomaly) notification {
+	lines := make([]string, len(anomalies))
+	for i, a := range anomalies {
+		lines[i] = a.String()
+	}
+	return notification{
+		Title:   "fast-cli: unusual speed test result",
+		Message: strings.Join(lines, "\n"),
+		Result:  entry,
+	}
+}
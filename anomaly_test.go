@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// hourlyRuns are runs at 20:00 on consecutive days, with download, upload
+// and latency cycling through the values given.
+func hourlyRuns(n int, download, upload, latency []float64) []historyEntry {
+	start := time.Date(2026, 3, 1, 20, 0, 0, 0, time.Local)
+	runs := make([]historyEntry, n)
+	for i := range runs {
+		runs[i] = historyEntry{
+			Time:         start.AddDate(0, 0, i),
+			DownloadMbps: download[i%len(download)],
+			UploadMbps:   upload[i%len(upload)],
+			LatencyMs:    latency[i%len(latency)],
+		}
+	}
+	return runs
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	baseline := hourlyRuns(20, []float64{95, 100, 105}, []float64{9, 10, 11}, []float64{18, 20, 22})
+	at := func(hour int, download, upload, latency float64) historyEntry {
+		return historyEntry{Time: time.Date(2026, 4, 1, hour, 30, 0, 0, time.Local), DownloadMbps: download, UploadMbps: upload, LatencyMs: latency}
+	}
+	for _, tc := range []struct {
+		name     string
+		baseline []historyEntry
+		current  historyEntry
+		want     []string
+	}{
+		{"usual", baseline, at(20, 98, 10, 21), nil},
+		{"slow download", baseline, at(20, 40, 10, 20), []string{"Download"}},
+		{"faster than usual", baseline, at(20, 300, 50, 5), nil},
+		{"high latency and slow upload", baseline, at(20, 100, 2, 90), []string{"Upload", "Latency"}},
+		{"another hour", baseline, at(9, 40, 10, 20), nil},
+		{"too few runs in the hour", baseline[:anomalyMinBaselineRuns-1], at(20, 40, 10, 20), nil},
+		{"constant baseline", hourlyRuns(10, []float64{100}, []float64{10}, []float64{20}), at(20, 40, 1, 90), nil},
+	} {
+		var got []string
+		for _, a := range detectAnomalies(tc.baseline, tc.current, 3) {
+			got = append(got, a.Metric.Name)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: anomalies %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDetectAnomaliesUsesRecentRuns(t *testing.T) {
+	// Old runs at 10 Mbps, followed by a full baseline of recent ones at 100
+	old := hourlyRuns(anomalyBaselineRuns, []float64{10, 11, 12}, []float64{10}, []float64{20})
+	recent := hourlyRuns(anomalyBaselineRuns, []float64{95, 100, 105}, []float64{10}, []float64{20})
+	current := historyEntry{Time: recent[0].Time, DownloadMbps: 12, UploadMbps: 10, LatencyMs: 20}
+	anomalies := detectAnomalies(append(old, recent...), current, 3)
+	if len(anomalies) != 1 || anomalies[0].Baseline != 100 {
+		t.Errorf("got %v, want a download anomaly against the recent median of 100", anomalies)
+	}
+}
+
+func TestRobustSpread(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"MAD", []float64{1, 2, 3, 4, 100}, 1 * madToSigma},
+		{"standard deviation when most are equal", []float64{10, 10, 10, 10, 20}, 4},
+		{"all equal", []float64{5, 5, 5}, 0},
+	} {
+		if got := robustSpread(tc.values, median(tc.values)); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With --auto-streams a phase starts with autoStartStreams streams and adds
+// one every autoScaleStep for as long as the last one raised its speed by
+// autoScaleGain, up to maxAutoStreams: a stream per server tops out at what
+// one core can copy, or at the share one server gives a client, well below a
+// multi-gigabit link, while more streams than needed only add queueing. The
+// stream that didn't pay off is stopped again, so that the phase runs at the
+// least parallelism that fills the link.
+const (
+	autoStartStreams = 2
+	autoScaleStep    = time.Second
+	autoScaleGain    = 1.1
+	maxAutoStreams   = 32
+	autoScaleSamples = 3 // Loaded latency pings compared to the ceiling, see transferLimits
+)
+
+// streamSet is the streams of a phase: one per server, and with
+// --auto-streams as many over the same servers in turn as pay off.
+type streamSet struct {
+	servers []target
+	limits  transferLimits
+	mu      sync.Mutex
+	stats   []serverStats        // Allocated for the most streams, so that their addresses hold
+	stops   []context.CancelFunc // Of the streams still meant to run, oldest first
+	running atomic.Int32
+	joined  []pingedTarget // Servers of limits.Join, in the order they arrived
+}
+
+func newStreamSet(servers []target, limits transferLimits) *streamSet {
+	limit := len(servers) + max(limits.JoinStreams, 0)
+	if limits.AutoStreams {
+		limit = max(limit, maxAutoStreams)
+	}
+	return &streamSet{servers: slices.Clip(servers), limits: limits, stats: make([]serverStats, 0, limit)}
+}
+
+// limit is the most streams there can be.
+func (s *streamSet) limit() int { return cap(s.stats) }
+
+// start runs run in wg for a stream to each server, or with --auto-streams
+// to the first few, tuning their number to the speed counter measures until
+// ctx is done. Servers joining later get one too. run must call wg.Done, and
+// stop before its next request once stop is done; rng is the stream's, see
+// streamRand.
+func (s *streamSet) start(ctx context.Context, wg *sync.WaitGroup, counter *int64, run func(stop context.Context, srv target, stats *serverStats, rng *rand.Rand)) {
+	add := func() bool { return s.add(ctx, wg, run) }
+	defer s.startJoining(ctx, wg, add) // Once the first streams run
+	if !s.limits.AutoStreams {
+		for range s.servers {
+			add()
+		}
+		return
+	}
+	for range min(len(s.servers), autoStartStreams) {
+		add()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.scale(ctx, counter, add)
+	}()
+}
+
+func (s *streamSet) startJoining(ctx context.Context, wg *sync.WaitGroup, add func() bool) {
+	if s.limits.Join == nil || s.limits.JoinStreams <= 0 {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.join(ctx, add)
+	}()
+}
+
+// join adds the servers of limits.Join as they arrive, until ctx is done,
+// starting a stream to each unless --auto-streams does. Their ping was
+// measured while the phase ran.
+func (s *streamSet) join(ctx context.Context, add func() bool) {
+	for range s.limits.JoinStreams {
+		var pt pingedTarget
+		select {
+		case <-ctx.Done():
+			return
+		case next, ok := <-s.limits.Join:
+			if !ok {
+				return
+			}
+			pt = next
+		}
+		s.mu.Lock()
+		s.servers = append(s.servers, pt.Target)
+		s.joined = append(s.joined, pt)
+		s.mu.Unlock()
+		fmt.Fprintf(statusOut, "Adding %s to the test as it answered, in %v under load.\n", pt.Target.Name, pt.Latency.Round(time.Millisecond))
+		if !s.limits.AutoStreams {
+			add()
+		}
+	}
+}
+
+// joinedServers returns the servers that joined, once the phase is over.
+func (s *streamSet) joinedServers() []pingedTarget {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.joined
+}
+
+// add starts the next stream unless there are as many as allowed.
+func (s *streamSet) add(ctx context.Context, wg *sync.WaitGroup, run func(context.Context, target, *serverStats, *rand.Rand)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stats) == s.limit() {
+		return false
+	}
+	srv, rng := s.servers[len(s.stats)%len(s.servers)], s.limits.streamRand(len(s.stats))
+	s.stats = append(s.stats, serverStats{})
+	stats := &s.stats[len(s.stats)-1]
+	stop, cancel := context.WithCancel(ctx)
+	s.stops = append(s.stops, cancel)
+	wg.Add(1)
+	s.running.Add(1)
+	go func() {
+		defer s.running.Add(-1)
+		run(stop, srv, stats, rng)
+	}()
+	return true
+}
+
+// remove stops the newest stream after its current request, keeping one.
+func (s *streamSet) remove() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stops) <= 1 {
+		return false
+	}
+	s.stops[len(s.stops)-1]()
+	s.stops = s.stops[:len(s.stops)-1]
+	return true
+}
+
+// active is how many streams are meant to run, which --auto-streams settled
+// on once the phase is over.
+func (s *streamSet) active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.stops)
+}
+
+// streams returns what every stream started did, once they're done.
+func (s *streamSet) streams() []serverStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// overLatency reports whether the loaded latency of the last few pings is
+// above the ceiling of --auto-streams-latency.
+func (s *streamSet) overLatency() (time.Duration, bool) {
+	if s.limits.LatencyCeiling <= 0 || s.limits.Latency == nil {
+		return 0, false
+	}
+	recent := s.limits.Latency.recent(autoScaleSamples)
+	return recent, recent > s.limits.LatencyCeiling
+}
+
+// scale adds streams through add, after the ramp-up, until one doesn't pay
+// off, and then keeps removing streams while the loaded latency is over its
+// ceiling.
+func (s *streamSet) scale(ctx context.Context, counter *int64, add func() bool) {
+	wait := func(d time.Duration) bool {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+			return s.running.Load() > 0 // No use tuning streams that all died
+		}
+	}
+	rate := func() (int64, bool) {
+		before := atomic.LoadInt64(counter)
+		ok := wait(autoScaleStep)
+		return atomic.LoadInt64(counter) - before, ok
+	}
+	if !wait(consistencyRampUp) {
+		return
+	}
+	best, ok := rate()
+	if !ok {
+		return
+	}
+	for add() {
+		if !wait(autoScaleStep / 2) { // The new stream's slow start
+			return
+		}
+		next, ok := rate()
+		if !ok {
+			return
+		}
+		if _, over := s.overLatency(); over || float64(next) < autoScaleGain*float64(best) {
+			s.remove()
+			break
+		}
+		best = next
+	}
+	fmt.Fprintf(statusOut, "Auto-streams: settled on %d streams.\n", s.active())
+	if s.limits.LatencyCeiling <= 0 {
+		return
+	}
+	for wait(autoScaleStep) {
+		if latency, over := s.overLatency(); over && s.remove() {
+			fmt.Fprintf(statusOut, "Auto-streams: loaded latency %s is over %s, down to %d streams.\n", latency.Round(100*time.Microsecond), s.limits.LatencyCeiling.Round(100*time.Microsecond), s.active())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	backgroundSampleTime = 2 * time.Second
+	backgroundLimitMbps  = 2 // Other traffic above this skews results noticeably
+)
+
+// errCountersUnsupported means the platform has no interface byte counters
+// to read; the check is then skipped.
+var errCountersUnsupported = errors.New("interface counters are not available on this platform")
+
+// backgroundTraffic is what the egress interface carried just before a test,
+// while fast-cli itself was quiet.
+type backgroundTraffic struct {
+	Interface    string  `json:"interface"`
+	DownloadMbps float64 `json:"download_mbps"`
+	UploadMbps   float64 `json:"upload_mbps"`
+}
+
+func (b backgroundTraffic) Significant() bool {
+	return b.DownloadMbps > backgroundLimitMbps || b.UploadMbps > backgroundLimitMbps
+}
+
+// measureBackgroundTraffic samples the counters of the interface traffic to
+// t goes through, so that a test taken during a game download or a backup
+// can be flagged instead of blamed on the ISP.
+func measureBackgroundTraffic(t target) (*backgroundTraffic, error) {
+	iface, err := egressInterface(targetHostname(t))
+	if err != nil {
+		return nil, err
+	}
+	rx1, tx1, err := interfaceCounters(iface.Name)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	time.Sleep(backgroundSampleTime)
+	rx2, tx2, err := interfaceCounters(iface.Name)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	return &backgroundTraffic{
+		Interface:    iface.Name,
+		DownloadMbps: toMbps(int64(rx2-rx1), elapsed),
+		UploadMbps:   toMbps(int64(tx2-tx1), elapsed),
+	}, nil
+}
+
+// checkBackgroundTraffic warns about other traffic before a test, or fails
+// with --require-idle.
+func checkBackgroundTraffic(opts *options, t target) (*backgroundTraffic, error) {
+	if opts.SkipPrecheck || opts.Provider == providerMock {
+		return nil, nil
+	}
+	fmt.Fprintln(statusOut, lang.tr("\nChecking for other traffic..."))
+	bg, err := measureBackgroundTraffic(t)
+	if errors.Is(err, errCountersUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("measuring background traffic: %w", err)
+	}
+	if !bg.Significant() {
+		return bg, nil
+	}
+	msg := fmt.Sprintf("%s is already carrying %.1f Mbps down and %.1f Mbps up of other traffic", bg.Interface, bg.DownloadMbps, bg.UploadMbps)
+	if opts.RequireIdle {
+		return bg, withCode(codeLinkBusy, fmt.Errorf("%s, not testing (--require-idle)", msg))
+	}
+	fmt.Fprintf(statusOut, "Warning: %s; the results will be low. Pause downloads, backups and streams, or pass --require-idle to refuse to test.\n", msg)
+	return bg, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// The benchmarks are the hot paths of a transfer, per chunk: what limits the
+// speed fast-cli can measure before the network does.
+
+// BenchmarkDownloadDrain reads a download chunk as a stream does, through
+// its chunkReader, and with stall-watch through a stallWatch armed per chunk.
+func BenchmarkDownloadDrain(b *testing.B) {
+	for _, bm := range []struct {
+		name    string
+		timeout time.Duration
+	}{{"no-watch", 0}, {"stall-watch", defaultStallTimeout}} {
+		b.Run(bm.name, func(b *testing.B) {
+			var counter int64
+			reader := newChunkReader(&counter)
+			src := make([]byte, 64<<10)
+			chunkFiller(payloadRandom, transferLimits{Seed: 1}.streamRand(0))(src)
+			reader.drain(&benchBody{}, 0, &stallWatch{}) // Allocates its buffer
+			b.SetBytes(downloadChunkSizeBytes)
+			b.ReportAllocs()
+			for b.Loop() {
+				ctx, cancel := context.WithCancel(context.Background())
+				_, w := watchStalls(ctx, bm.timeout)
+				body := benchBody{left: downloadChunkSizeBytes, src: src}
+				if _, err := reader.drain(&body, 0, w); err != nil {
+					b.Fatal(err)
+				}
+				cancel()
+			}
+		})
+	}
+}
+
+// benchBody is a download body of left bytes, copied as a socket read would
+// from src, which is small enough to stay in cache and keep memory bandwidth
+// out of what's measured.
+type benchBody struct {
+	left int
+	src  []byte
+}
+
+func (r *benchBody) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && n < r.left {
+		n += copy(p[n:min(len(p), r.left)], r.src)
+	}
+	r.left -= n
+	return n, nil
+}
+
+// BenchmarkUploadBody reads an upload chunk through its body as the
+// transport does.
+func BenchmarkUploadBody(b *testing.B) {
+	var counter int64
+	chunk := make([]byte, uploadChunkSizeBytes)
+	chunkFiller(payloadRandom, transferLimits{Seed: 1}.streamRand(0))(chunk) // Touched, as zero pages would read faster than memory
+	reader := newChunkReader(&counter)
+	watch := &stallWatch{}
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := io.Copy(io.Discard, reader.body(chunk, watch)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkChunkFill(b *testing.B) {
+	for _, payload := range []string{payloadRandom, payloadMixed} {
+		b.Run(payload, func(b *testing.B) {
+			chunk := make([]byte, uploadChunkSizeBytes)
+			fill := chunkFiller(payload, transferLimits{Seed: 1}.streamRand(0))
+			b.SetBytes(int64(len(chunk)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := fill(chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDownloadURL builds the URL of a chunk's random range, which
+// streams do for every chunk unless --fixed-ranges.
+func BenchmarkDownloadURL(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		t    target
+	}{
+		{"fast", target{URL: "https://ipv4-c001-ams001-ix.1.oca.nflxvideo.net/speedtest?c=nl&n=1136&v=5&e=1700000000&t=abcdefghijklmnop"}},
+		{"cloudflare", target{URL: "https://speed.cloudflare.com", Provider: providerCloudflare}},
+		{"librespeed", target{URL: "https://librespeed.example/backend/", Provider: providerLibreSpeed}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			rng := transferLimits{Seed: 1}.streamRand(0)
+			b.ReportAllocs()
+			for b.Loop() {
+				_ = bm.t.rangeURL(randomRange(rng, downloadChunkSizeBytes))
+			}
+		})
+	}
+}
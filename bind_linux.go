@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import (
+	"log"
+	"syscall"
+)
+
+// bindToDevice returns a dialer hook that binds sockets to a network
+// interface, so that traffic leaves through it whatever the routing table
+// says. Binding needs CAP_NET_RAW; without it the source address has to do.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	warned := false
+	return func(network, address string, c syscall.RawConn) error {
+		return c.Control(func(fd uintptr) {
+			if err := syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name); err != nil && !warned {
+				warned = true
+				log.Printf("Warning: binding to %s: %v, relying on the source address", name, err)
+			}
+		})
+	}
+}
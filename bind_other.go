@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// bindToDevice binds by source address only outside Linux.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	budgetReserve   = time.Second     // Left at the end of a time budget for the results
+	minPhaseBudget  = 2 * time.Second // Shorter phases aren't worth running
+	selectionBudget = 5 * time.Second // Weighed against the phase durations to give server selection its share
+)
+
+var errBudgetExhausted = errors.New("the time budget ran out")
+
+// budgetDoer cancels every request still running when ctx is done.
+type budgetDoer struct {
+	base doer
+	ctx  context.Context
+}
+
+func (d budgetDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	stop := context.AfterFunc(d.ctx, func() { cancel(errBudgetExhausted) })
+	return doCancelable(d.base, req.WithContext(ctx), func() {
+		stop()
+		cancel(nil)
+	})
+}
+
+// startBudget enforces --total-timeout, or what is left of --max-runtime if
+// that ends first, on the test about to run: requests are cut off once it
+// has passed and selection and phases are shortened to end before it. The
+// returned function lifts it again.
+func startBudget(opts *options) (stop func()) {
+	deadline, budget := opts.runDeadline, fmt.Sprintf("--max-runtime %s", opts.MaxRuntime)
+	if opts.TotalTimeout > 0 {
+		if d := time.Now().Add(opts.TotalTimeout); deadline.IsZero() || d.Before(deadline) {
+			deadline, budget = d, fmt.Sprintf("--total-timeout %s", opts.TotalTimeout)
+		}
+	}
+	if deadline.IsZero() {
+		return func() {}
+	}
+	opts.deadline, opts.budget = deadline, budget
+	ctx, cancel := context.WithDeadline(context.Background(), opts.deadline)
+	base := httpClient
+	httpClient = budgetDoer{base: base, ctx: ctx}
+	return func() {
+		httpClient = base
+		cancel()
+		opts.deadline, opts.budget = time.Time{}, ""
+	}
+}
+
+// enforceMaxRuntime exits with codeTimedOut once --max-runtime has passed
+// since the invocation started, whatever is still running, e.g. a hung
+// pre-cmd. The returned function disarms it.
+func enforceMaxRuntime(opts *options) (stop func()) {
+	if opts.MaxRuntime <= 0 {
+		return func() {}
+	}
+	opts.runDeadline = time.Now().Add(opts.MaxRuntime)
+	t := time.AfterFunc(opts.MaxRuntime, func() {
+		err := withCode(codeTimedOut, fmt.Errorf("%w (--max-runtime %s), giving up", errBudgetExhausted, opts.MaxRuntime))
+		if opts.Format == formatJSON {
+			writeJSON(struct {
+				Error jsonError `json:"error"`
+			}{newJSONErrors([]error{err})[0]})
+		}
+		fatal(err)
+	})
+	return func() { t.Stop() }
+}
+
+// selectionContext bounds server selection by selectionTimeout and, under a
+// time budget, by its share of what is left next to the phases to come.
+func (o *options) selectionContext() (context.Context, context.CancelFunc) {
+	timeout := selectionTimeout
+	if !o.deadline.IsZero() {
+		phases := cmp.Or(o.DownloadDuration, downloadTestDuration)
+		if !o.SkipUpload {
+			phases += cmp.Or(o.UploadDuration, uploadTestDuration)
+		}
+		left := time.Until(o.deadline) - budgetReserve
+		timeout = min(timeout, time.Duration(float64(left)*float64(selectionBudget)/float64(selectionBudget+phases)))
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// phaseDuration shortens a phase to its share of what is left of
+// the time budget, in proportion to the phases still to run after it, which
+// take later. When those shares are too short to run, the phase gets all
+// that is left instead and runLater is false: the later phases are skipped
+// for it. It fails when too little is left to run even this phase.
+func (o *options) phaseDuration(nominal, later time.Duration, phase string) (d time.Duration, runLater bool, err error) {
+	if o.deadline.IsZero() {
+		return nominal, true, nil
+	}
+	left := time.Until(o.deadline) - budgetReserve
+	d, runLater = min(nominal, left), true
+	if later > 0 && left < nominal+later {
+		d = time.Duration(float64(left) * float64(nominal) / float64(nominal+later))
+		if left-d < minPhaseBudget { // Too short for the later phases
+			d, runLater = min(nominal, left), false
+		}
+	}
+	if d < minPhaseBudget {
+		return 0, false, withCode(codeTimedOut, fmt.Errorf("%w before the %s phase (%s)", errBudgetExhausted, phase, o.budget))
+	}
+	if d < nominal {
+		fmt.Fprintf(statusOut, "Shortening the %s phase to %s to stay within %s.\n", phase, d.Round(100*time.Millisecond), o.budget)
+	}
+	return d, runLater, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPhaseDuration(t *testing.T) {
+	out := statusOut
+	statusOut = io.Discard
+	t.Cleanup(func() { statusOut = out })
+
+	const nominal = 10 * time.Second
+	for _, tc := range []struct {
+		name         string
+		budget       time.Duration // Until the deadline, 0 for none
+		later        time.Duration // The upload's nominal duration
+		want         time.Duration
+		wantRunLater bool
+		wantErr      bool
+	}{
+		{"no budget", 0, nominal, nominal, true, false},
+		{"budget holding both phases", time.Minute, nominal, nominal, true, false},
+		{"both phases shortened", 15 * time.Second, nominal, 7 * time.Second, true, false},
+		{"upload skipped", 5 * time.Second, nominal, 4 * time.Second, false, false},
+		{"download alone, just over the minimum", 3100 * time.Millisecond, nominal, 2100 * time.Millisecond, false, false},
+		{"too little for the download", 2500 * time.Millisecond, nominal, 0, false, true},
+		{"last phase shortened", 6 * time.Second, 0, 5 * time.Second, true, false},
+		{"too little for the last phase", 2 * time.Second, 0, 0, false, true},
+	} {
+		o := &options{budget: "--max-runtime"}
+		if tc.budget > 0 {
+			o.deadline = time.Now().Add(tc.budget)
+		}
+		got, runLater, err := o.phaseDuration(nominal, tc.later, "download")
+		if (err != nil) != tc.wantErr || runLater != tc.wantRunLater {
+			t.Errorf("%s: run later %v, error %v; want %v, error %v", tc.name, runLater, err, tc.wantRunLater, tc.wantErr)
+		}
+		// The deadline moves closer while the test runs
+		if got > tc.want || got < tc.want-100*time.Millisecond {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+		if tc.wantErr && errorCodeOf(err) != codeTimedOut {
+			t.Errorf("%s: error code %v, want %v", tc.name, errorCodeOf(err), codeTimedOut)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Values of --cache-bust, which keep caches on the path from answering
+// download chunks: a Cache-Control: no-cache request header, a random nonce
+// in the query of every chunk's URL, or both.
+const (
+	cacheBustNone   = "none"
+	cacheBustHeader = "header"
+	cacheBustQuery  = "query"
+	cacheBustBoth   = "both"
+)
+
+var cacheBustModes = []string{cacheBustNone, cacheBustHeader, cacheBustQuery, cacheBustBoth}
+
+// cacheHeaders are the response headers that caches mark what they serve
+// with.
+var cacheHeaders = []string{"X-Cache", "X-Cache-Status", "Age"}
+
+// bustURL adds a nonce to url with --cache-bust query or both.
+func bustURL(mode, url string) string {
+	if mode != cacheBustQuery && mode != cacheBustBoth {
+		return url
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "nonce=" + strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// bustHeader asks caches to revalidate with --cache-bust header or both.
+func bustHeader(mode string, h http.Header) {
+	if mode == cacheBustHeader || mode == cacheBustBoth {
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Pragma", "no-cache") // For HTTP/1.0 proxies
+	}
+}
+
+// streamCache is what the cache headers of one stream's downloads said.
+type streamCache struct {
+	Chunks  int    // Responses looked at
+	Marked  int    // With a cache header
+	Hits    int    // Served by a cache: an X-Cache HIT, or an Age
+	MaxAge  int    // Seconds, of the oldest copy served
+	Example string // Header of the first hit, or of the first marked response
+}
+
+func (c *streamCache) observe(h http.Header) {
+	c.Chunks++
+	var marked, hit bool
+	for _, name := range cacheHeaders {
+		v := h.Get(name)
+		if v == "" {
+			continue
+		}
+		marked = true
+		isHit := strings.Contains(strings.ToUpper(v), "HIT")
+		if name == "Age" {
+			age, err := strconv.Atoi(strings.TrimSpace(v))
+			isHit = err == nil && age > 0
+			c.MaxAge = max(c.MaxAge, age)
+		}
+		switch {
+		case isHit && c.Hits == 0 && !hit: // The first hit
+			c.Example = name + ": " + v
+		case c.Example == "":
+			c.Example = name + ": " + v
+		}
+		hit = hit || isHit
+	}
+	if marked {
+		c.Marked++
+	}
+	if hit {
+		c.Hits++
+	}
+}
+
+// cacheReport is what caches on the path did to a download phase.
+type cacheReport struct {
+	Bust    string // The --cache-bust mode
+	Chunks  int
+	Marked  int
+	Hits    int
+	MaxAge  int
+	Example string
+}
+
+// summarizeCache sums up the streams of a phase, or returns nil when no
+// response had a cache header and nothing was done to bust caches.
+func summarizeCache(streams []serverStats, bust string) *cacheReport {
+	r := cacheReport{Bust: bust}
+	var hitExample bool
+	for _, s := range streams {
+		c := s.Cache
+		r.Chunks += c.Chunks
+		r.Marked += c.Marked
+		r.Hits += c.Hits
+		r.MaxAge = max(r.MaxAge, c.MaxAge)
+		if c.Example != "" && (r.Example == "" || c.Hits > 0 && !hitExample) {
+			r.Example, hitExample = c.Example, c.Hits > 0
+		}
+	}
+	if r.Marked == 0 && bust == cacheBustNone {
+		return nil
+	}
+	return &r
+}
+
+func (r cacheReport) String() string {
+	switch {
+	case r.Hits > 0:
+		s := fmt.Sprintf("%d of %d download chunks came from a cache on the path (%s", r.Hits, r.Chunks, r.Example)
+		if r.MaxAge > 0 {
+			s += fmt.Sprintf(", up to %ds old", r.MaxAge)
+		}
+		s += "), so the download may measure it rather than the server"
+		if r.Bust != cacheBustBoth {
+			s += "; try --cache-bust both"
+		}
+		return s
+	case r.Marked > 0:
+		return fmt.Sprintf("%d of %d download chunks had cache headers (%s), none a hit", r.Marked, r.Chunks, r.Example)
+	}
+	return fmt.Sprintf("no cache headers on %d download chunks, with --cache-bust %s", r.Chunks, r.Bust)
+}
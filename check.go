@@ -0,0 +1,276 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unknownConfigKeys returns a "file:line: ..." message for every entry that is
+// not a flag of any command, which would otherwise be silently ignored.
+func unknownConfigKeys(path string, sections map[string][]configEntry) []string {
+	known := map[string]bool{"config": true, "profile": true}
+	addFlags := func(fs *flag.FlagSet) { fs.VisitAll(func(f *flag.Flag) { known[f.Name] = true }) }
+	for _, cmd := range commands {
+		if cmd.Flags != nil {
+			addFlags(cmd.Flags())
+		}
+		for _, flags := range cmd.Actions {
+			if flags != nil {
+				addFlags(flags())
+			}
+		}
+	}
+
+	var unknown []string
+	for section, entries := range sections {
+		if strings.HasPrefix(section, sinkSectionPrefix) {
+			continue // Checked by loadSinkSections
+		}
+		for _, e := range entries {
+			if !known[e.Key] {
+				where := "global settings"
+				if section != "" {
+					where = "profile " + section
+				}
+				unknown = append(unknown, fmt.Sprintf("%s:%d: unknown setting %q in %s", path, e.Line, e.Key, where))
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// maskToken keeps just enough of a token to recognize it.
+func maskToken(token string) string {
+	if len(token) <= 8 {
+		return strings.Repeat("*", len(token))
+	}
+	return token[:4] + "..." + token[len(token)-4:]
+}
+
+func phasePlan(d time.Duration, servers, chunkSize int, maxDataMB float64) string {
+	plan := fmt.Sprintf("%s across %d server(s) in %d MiB chunks", d, servers, chunkSize/(1024*1024))
+	if maxDataMB > 0 {
+		plan += fmt.Sprintf(", stopping after %g MB", maxDataMB)
+	}
+	return plan
+}
+
+// dryRun validates the configuration, selects servers the same way a real
+// test does and prints what would be tested, without transferring bulk data.
+// Unknown config settings make it fail, so it can gate deployments.
+func dryRun(opts *options) error {
+	var problems []string
+
+	fmt.Println("Configuration:")
+	sections, err := loadConfig(opts.ConfigPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Printf("  Config file: %s (not present, using defaults)\n", opts.ConfigPath)
+	case err != nil:
+		return fmt.Errorf("reading config: %w", err)
+	default:
+		fmt.Printf("  Config file: %s\n", opts.ConfigPath)
+		problems = append(problems, unknownConfigKeys(opts.ConfigPath, sections)...)
+	}
+	if opts.Profile != "" {
+		fmt.Printf("  Profile: %s\n", opts.Profile)
+	}
+	switch {
+	case opts.Simulate.IsSet():
+		fmt.Printf("  Provider: none, simulating %s\n", opts.Simulate)
+	case len(opts.Servers) > 0:
+		fmt.Printf("  Provider: fast-cli serve (%s)\n", strings.Join(opts.Servers, ", "))
+	case opts.Provider == providerCloudflare:
+		fmt.Printf("  Provider: Cloudflare (%s)\n", cloudflareBaseURL)
+	case opts.Provider == providerLibreSpeed:
+		fmt.Printf("  Provider: LibreSpeed (%s)\n", libreSpeedServerList)
+	case opts.Provider == providerMock:
+		fmt.Printf("  Provider: mock fast.com (offline, %s Mbps, %s latency)\n", opts.Mock.Rate, opts.Mock.Latency)
+	default:
+		fmt.Printf("  Provider: fast.com (%s, token %s)\n", fastComBaseURL, maskToken(fastComToken))
+	}
+
+	if opts.Simulate.IsSet() {
+		fmt.Println("\nNothing would be tested: --simulate makes up the result without using the network.")
+		return nil
+	}
+	if err := precheck(opts); err != nil {
+		return fmt.Errorf("connectivity pre-check: %w", err)
+	}
+
+	fmt.Println()
+	byLatency := *opts
+	byLatency.Rank = rankLatency // Ranking by throughput would download
+	client, servers, err := selectServers(&byLatency)
+	if err != nil {
+		return err
+	}
+	if client.IP != "" {
+		fmt.Printf("\nClient: %s (%s, %s, %s)\n", client.IP, asnISP(client.Asn), client.Location.City, client.Location.Country)
+	} else {
+		fmt.Println()
+	}
+	fmt.Println("Would test against:")
+	if opts.Rank == rankCombined {
+		fmt.Println("  (by latency alone; the test also downloads briefly from each candidate and may pick others)")
+	}
+	for _, pt := range servers {
+		fmt.Printf("  - %s (%s, %s) - Latency: %v\n", pt.Target.Name, pt.Target.Location.City, pt.Target.Location.Country, pt.Latency.Round(time.Millisecond))
+	}
+
+	fmt.Println("\nTest plan:")
+	fmt.Printf("  Idle latency: %d pings to %s\n", cmp.Or(opts.LatencySamples, idleLatencySamples), servers[0].Target.Name)
+	downloadChunk, uploadChunk := opts.chunkSizes()
+	fmt.Printf("  Download: %s\n", phasePlan(opts.DownloadDuration, len(servers), downloadChunk, opts.MaxDataMB))
+	if opts.SkipUpload {
+		fmt.Println("  Upload: skipped")
+	} else {
+		fmt.Printf("  Upload: %s\n", phasePlan(opts.UploadDuration, len(servers), uploadChunk, opts.MaxDataMB))
+	}
+	if opts.PreCmd != "" {
+		fmt.Printf("  Pre-cmd: %s\n", opts.PreCmd)
+	}
+	if opts.PostCmd != "" {
+		fmt.Printf("  Post-cmd: %s\n", opts.PostCmd)
+	}
+
+	fmt.Println("\nOutputs:")
+	fmt.Printf("  Format: %s\n", opts.Format)
+	if opts.NoHistory {
+		fmt.Println("  History: disabled")
+	} else {
+		fmt.Printf("  History: %s\n", opts.HistoryPath)
+	}
+	if opts.GHA {
+		fmt.Println("  GitHub Actions annotations: on")
+	}
+	if opts.Plan.IsSet() {
+		fmt.Printf("  Plan comparison: %s Mbps\n", opts.Plan)
+	}
+	var limits []string
+	for _, c := range opts.Thresholds.evaluate(testResult{}) {
+		if c.Limit != "" {
+			limits = append(limits, c.Name+" "+c.Limit)
+		}
+	}
+	if len(limits) > 0 {
+		fmt.Printf("  Thresholds: %s\n", strings.Join(limits, ", "))
+	}
+	for _, sink := range append([]*options{opts}, opts.sinks...) {
+		for _, line := range sink.describeSinks() {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if opts.Interval > 0 {
+		fmt.Printf("  Daemon schedule: every %s, anomaly threshold %g\n", opts.Interval, opts.AnomalyThreshold)
+		for _, url := range opts.NotifyWebhooks {
+			fmt.Printf("  Webhook: %s\n", redactURL(url))
+		}
+		if opts.PushTo != "" {
+			fmt.Printf("  Collector: %s\n", redactURL(opts.PushTo))
+		}
+		if opts.MetricsListen != "" {
+			fmt.Printf("  Metrics: http://%s%s\n", opts.MetricsListen, metricsPath)
+		}
+		if opts.Retention.IsSet() {
+			fmt.Printf("  Retention: delete after %s, downsample after %s\n", &opts.Retention.Retention, &opts.Retention.DownsampleAfter)
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Println("\nProblems:")
+		for _, p := range problems {
+			fmt.Printf("  %s\n", p)
+		}
+		return fmt.Errorf("configuration has %d problem(s)", len(problems))
+	}
+	fmt.Println("\nDry run complete, no test data was transferred.")
+	return nil
+}
+
+// describeSinks lists the sinks of opts for --dry-run, without their
+// credentials.
+func (opts *options) describeSinks() []string {
+	var sinks []string
+	if opts.PromTextfile != "" {
+		sinks = append(sinks, "Prometheus textfile: "+opts.PromTextfile)
+	}
+	if opts.CSVFile != "" {
+		sinks = append(sinks, "CSV file: "+opts.CSVFile)
+	}
+	if opts.PushURL != "" {
+		push := "Push URL: " + redactURL(opts.PushURL)
+		for _, h := range opts.PushHeaders {
+			name, _, _ := parsePushHeader(h)
+			push += ", header " + name
+		}
+		sinks = append(sinks, push)
+	}
+	if opts.Syslog.Network != "" {
+		sinks = append(sinks, fmt.Sprintf("Syslog: %s, facility %s", opts.Syslog.String(), opts.SyslogFacility))
+	}
+	if opts.SplunkURL != "" {
+		sinks = append(sinks, "Splunk HEC: "+redactURL(opts.SplunkURL))
+	}
+	if opts.ElasticURL != "" {
+		sinks = append(sinks, fmt.Sprintf("Elasticsearch: %s, index %s", redactURL(opts.ElasticURL), opts.ElasticIndex))
+	}
+	if opts.Kafka.Topic != "" {
+		sinks = append(sinks, "Kafka: "+opts.Kafka.String())
+	}
+	if opts.NATS.URL != nil {
+		sinks = append(sinks, "NATS: "+redactURL(opts.NATS.URL.String()))
+	}
+	if opts.sinkName != "" {
+		for i := range sinks {
+			sinks[i] = fmt.Sprintf("[sink %s] on %s: %s", opts.sinkName, opts.SinkOn, sinks[i])
+		}
+	}
+	return sinks
+}
+
+// dryRunProviders dry-runs each provider of a --provider comparison in turn.
+func dryRunProviders(opts *options) error {
+	providers, err := parseProviders(opts.Provider)
+	if err != nil {
+		return err
+	}
+	for _, name := range providers {
+		providerOpts := *opts
+		providerOpts.Provider = name
+		if err := dryRun(&providerOpts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCheck implements `fast-cli check`: the same as --dry-run, accepting
+// every run and daemon flag so any invocation can be checked.
+func runCheck(args []string) error {
+	opts := &options{}
+	fs := newDaemonFlagSet("fast-cli check", opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := opts.validateTest(); err != nil {
+		return err
+	}
+	if err := opts.resolveSecrets(); err != nil {
+		return err
+	}
+	if err := loadSinkSections(opts); err != nil {
+		return err
+	}
+	return dryRunProviders(opts)
+}
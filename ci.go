@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// thresholds are the minimums a result must meet, zero disables a check.
+type thresholds struct {
+	MinDownload float64 // Mbps
+	MinUpload   float64 // Mbps
+	MaxLatency  time.Duration
+}
+
+func (t *thresholds) register(fs *flag.FlagSet) {
+	fs.Float64Var(&t.MinDownload, "min-download", 0, "fail below this download speed in `Mbps`")
+	fs.Float64Var(&t.MinUpload, "min-upload", 0, "fail below this upload speed in `Mbps`")
+	fs.DurationVar(&t.MaxLatency, "max-latency", 0, "fail above this idle latency")
+}
+
+// thresholdCheck is the outcome for one metric, whether or not it had a threshold.
+type thresholdCheck struct {
+	Name     string // download, upload or latency
+	Value    string // Measured value, for reports
+	Limit    string // Empty when no threshold was set
+	Failure  string // Empty when the threshold was met or not set
+	Duration time.Duration
+}
+
+func (t thresholds) evaluate(res testResult) []thresholdCheck {
+	download := thresholdCheck{Name: "download", Value: fmt.Sprintf("%.2f Mbps", res.Download.Mbps), Duration: res.Download.Duration}
+	if t.MinDownload > 0 {
+		download.Limit = fmt.Sprintf(">= %g Mbps", t.MinDownload)
+		if res.Download.Mbps < t.MinDownload {
+			download.Failure = fmt.Sprintf("download %.2f Mbps < %g", res.Download.Mbps, t.MinDownload)
+		}
+	}
+
+	upload := thresholdCheck{Name: "upload", Value: fmt.Sprintf("%.2f Mbps", res.Upload.Mbps), Duration: res.Upload.Duration}
+	if t.MinUpload > 0 {
+		upload.Limit = fmt.Sprintf(">= %g Mbps", t.MinUpload)
+		if res.Upload.Mbps < t.MinUpload {
+			upload.Failure = fmt.Sprintf("upload %.2f Mbps < %g", res.Upload.Mbps, t.MinUpload)
+		}
+	}
+
+	latency := thresholdCheck{Name: "latency", Value: formatLatency(res.IdleLatency)}
+	for _, s := range res.IdleLatency.Samples {
+		latency.Duration += s
+	}
+	if t.MaxLatency > 0 {
+		latency.Limit = fmt.Sprintf("<= %s", t.MaxLatency)
+		switch avg := res.IdleLatency.Avg; {
+		case len(res.IdleLatency.Samples) == 0:
+			latency.Failure = "latency unavailable"
+		case avg > t.MaxLatency:
+			latency.Failure = fmt.Sprintf("latency %s > %s", avg.Round(time.Millisecond), t.MaxLatency)
+		}
+	}
+	return []thresholdCheck{download, upload, latency}
+}
+
+// check returns one message per threshold the result misses.
+func (t thresholds) check(res testResult) []string {
+	var failures []string
+	for _, c := range t.evaluate(res) {
+		if c.Failure != "" {
+			failures = append(failures, c.Failure)
+		}
+	}
+	return failures
+}
+
+// JUnit XML output (--format junit), in the dialect Jenkins, GitLab and the
+// common GitHub Actions reporters understand
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Hostname   string          `xml:"hostname,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func writeJUnit(w io.Writer, res testResult, t thresholds) error {
+	host, _ := os.Hostname()
+	suite := junitTestSuite{
+		Name:      "fast-cli",
+		Timestamp: res.StartedAt.Format("2006-01-02T15:04:05"),
+		Time:      junitSeconds(time.Since(res.StartedAt)),
+		Hostname:  host,
+		Properties: []junitProperty{
+			{"id", res.ID},
+			{"isp", asnISP(res.Client.Asn)},
+			{"download_mbps", fmt.Sprintf("%.2f", res.Download.Mbps)},
+			{"upload_mbps", fmt.Sprintf("%.2f", res.Upload.Mbps)},
+			{"latency_ms", fmt.Sprintf("%.1f", durationMs(res.IdleLatency.Avg))},
+		},
+	}
+	if best, ok := bestServer(res); ok {
+		suite.Properties = append(suite.Properties, junitProperty{"server", targetHost(best.Target)})
+	}
+
+	for _, c := range t.evaluate(res) {
+		tc := junitTestCase{
+			Name:      c.Name,
+			Classname: "fast-cli." + c.Name,
+			Time:      junitSeconds(c.Duration),
+			SystemOut: "measured " + c.Value,
+		}
+		if c.Limit != "" {
+			tc.Name += " " + c.Limit
+		}
+		if c.Failure != "" {
+			tc.Failure = &junitFailure{Message: c.Failure, Type: "ThresholdNotMet", Text: fmt.Sprintf("expected %s %s, measured %s", c.Name, c.Limit, c.Value)}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Tests = len(suite.Cases)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// GitHub Actions workflow commands (--gha)
+
+var (
+	ghaDataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	ghaPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func ghaCommand(w io.Writer, command, title, message string) {
+	fmt.Fprintf(w, "::%s title=%s::%s\n", command, ghaPropertyEscaper.Replace(title), ghaDataEscaper.Replace(message))
+}
+
+// writeGHAAnnotations adds a notice with the result and an error per missed
+// threshold. The runner picks them up from either output stream.
+func writeGHAAnnotations(w io.Writer, res testResult, t thresholds) {
+	ghaCommand(w, "notice", "Speed test", fmt.Sprintf("Download %.2f Mbps, upload %.2f Mbps, latency %s",
+		res.Download.Mbps, res.Upload.Mbps, formatLatency(res.IdleLatency)))
+	for _, c := range t.evaluate(res) {
+		if c.Failure != "" {
+			ghaCommand(w, "error", "Speed test: "+c.Name+" threshold not met", fmt.Sprintf("Expected %s %s, measured %s", c.Name, c.Limit, c.Value))
+		}
+	}
+}
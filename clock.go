@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How far the local clock may be off: beyond clockSkewLimit, certificate
+// validation starts failing for freshly issued certificates, and beyond
+// clockSkewNotice the timestamps of results and the history are noticeably
+// wrong, as on boards without a real-time clock or in a container of a host
+// without NTP. Durations are measured on the monotonic clock, which no skew
+// or step of the wall clock affects.
+const (
+	clockSkewLimit  = 5 * time.Minute
+	clockSkewNotice = 10 * time.Second
+)
+
+// monoEpoch is what timestamps sent to peers count from, so that they read
+// the monotonic clock rather than the wall clock; see monoNanos.
+var monoEpoch = time.Now()
+
+// monoNanos is the monotonic time since monoEpoch.
+func monoNanos() int64 { return int64(time.Since(monoEpoch)) }
+
+// clockSkew is how far the local clock is off from a server's, positive
+// when it is ahead.
+func clockSkew(resp *http.Response, sent time.Time) (time.Duration, bool) {
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	// Date is truncated to the second, and stamped about halfway through
+	local := sent.Add(time.Since(sent) / 2)
+	return local.Sub(remote.Add(time.Second / 2)).Round(time.Second), true
+}
+
+// clockCheck is the skew of the local clock, from the Date of the first
+// server list API response of a test.
+var clockCheck = &clockObserver{}
+
+type clockObserver struct {
+	mu      sync.Mutex
+	checked bool
+	skew    time.Duration
+}
+
+func (c *clockObserver) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checked, c.skew = false, 0
+}
+
+// observe compares the clock with the Date of resp, to a request sent at
+// sent, and warns when it is off by more than clockSkewNotice.
+func (c *clockObserver) observe(resp *http.Response, sent time.Time) {
+	skew, ok := clockSkew(resp, sent)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok || c.checked {
+		return
+	}
+	c.checked, c.skew = true, skew
+	if skew.Abs() > clockSkewNotice {
+		log.Printf("Warning: %s", describeSkew(skew, resp.Request.URL.Host))
+	}
+}
+
+// offset returns the skew when it is past clockSkewNotice, 0 otherwise.
+func (c *clockObserver) offset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.skew.Abs() > clockSkewNotice {
+		return c.skew
+	}
+	return 0
+}
+
+func describeSkew(skew time.Duration, host string) string {
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	return fmt.Sprintf("the system clock is %s %s %s's, so the timestamps of results and the history are off by as much; check that NTP is running", skew.Abs(), direction, host)
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	collectorResultsPath = "/api/v1/results"
+	maxUploadSize        = 1 << 20
+	collectorPushTimeout = 30 * time.Second
+)
+
+// collectedResult is one upload as stored by the collector. The result is
+// kept as uploaded, so that it can still be checked with fast-cli verify.
+type collectedResult struct {
+	ReceivedAt time.Time       `json:"received_at"`
+	Probe      string          `json:"probe"` // Base64 public key that signed the result
+	Result     json.RawMessage `json:"result"`
+}
+
+// collectorStore is the collector's database: every accepted result, one
+// per line, in the order received.
+type collectorStore struct {
+	mu      sync.Mutex
+	path    string
+	results []collectedResult
+	ids     map[string]bool
+}
+
+func openCollectorStore(path string) (*collectorStore, error) {
+	s := &collectorStore{path: path, ids: map[string]bool{}}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening collector database: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxUploadSize+4096)
+	for line := 1; scanner.Scan(); line++ {
+		var c collectedResult
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		s.results = append(s.results, c)
+		s.ids[c.id()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading collector database: %w", err)
+	}
+	return s, nil
+}
+
+func (c collectedResult) id() string {
+	var r struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(c.Result, &r)
+	return c.Probe + "/" + r.ID
+}
+
+// add stores c unless the probe already uploaded a result with its ID, which
+// happens when a probe retries after a lost response.
+func (s *collectorStore) add(c collectedResult) (added bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids[c.id()] {
+		return false, nil
+	}
+	line, err := json.Marshal(c)
+	if err != nil {
+		return false, fmt.Errorf("encoding result: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return false, fmt.Errorf("creating database directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("opening collector database: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return false, fmt.Errorf("writing collector database: %w", err)
+	}
+	s.results = append(s.results, c)
+	s.ids[c.id()] = true
+	return true, nil
+}
+
+func (s *collectorStore) all() []collectedResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.results)
+}
+
+// historyEntryFromJSON turns an uploaded result into the history format, so
+// that the collected results can be exported and analyzed like local ones.
+func historyEntryFromJSON(r jsonResult) historyEntry {
+	e := historyEntry{
+		ID:           r.ID,
+		Time:         r.StartedAt,
+		EndTime:      r.EndedAt,
+		Host:         r.Host.Hostname,
+		Platform:     r.Host.OS + "/" + r.Host.Arch,
+		Version:      r.Host.Version,
+		Provider:     r.Provider,
+		Via:          r.Via,
+		Tags:         r.Tags,
+		Trigger:      r.Trigger,
+		Network:      r.Network,
+		DownloadMbps: r.Download.Mbps,
+		UploadMbps:   r.Upload.Mbps,
+		RPM:          r.RPM,
+		WiFi:         r.WiFi,
+	}
+	if e.Provider == providerFast {
+		e.Provider = ""
+	}
+	e.ClockSkewSeconds = r.ClockSkew
+	if r.Ping != nil {
+		e.LatencyMs, e.JitterMs = r.Ping.AvgMs, r.Ping.JitterMs
+	}
+	if r.Download.Latency != nil {
+		e.DownloadLatencyMs = r.Download.Latency.AvgMs
+	}
+	if r.Upload.Latency != nil {
+		e.UploadLatencyMs = r.Upload.Latency.AvgMs
+	}
+	if r.Download.Consistency != nil {
+		e.DownloadCV = r.Download.Consistency.CV
+	}
+	if r.Upload.Consistency != nil {
+		e.UploadCV = r.Upload.Consistency.CV
+	}
+	return e
+}
+
+// collectorProbe summarizes what one probe has uploaded.
+type collectorProbe struct {
+	Host               string    `json:"host"`
+	Probe              string    `json:"probe"`
+	Platform           string    `json:"platform"`
+	Version            string    `json:"version"`
+	Results            int       `json:"results"`
+	FirstSeen          time.Time `json:"first_seen"`
+	LastSeen           time.Time `json:"last_seen"`
+	LastDownloadMbps   float64   `json:"last_download_mbps"`
+	LastUploadMbps     float64   `json:"last_upload_mbps"`
+	MedianDownloadMbps float64   `json:"median_download_mbps"`
+	MedianUploadMbps   float64   `json:"median_upload_mbps"`
+}
+
+// collectorHandler serves the collector's REST API.
+type collectorHandler struct {
+	store   *collectorStore
+	trusted []ed25519.PublicKey // Any signer is accepted when empty
+}
+
+func (h *collectorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == collectorResultsPath:
+		h.upload(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == collectorResultsPath:
+		h.listResults(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/history":
+		h.history(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/probes":
+		h.probes(w, r)
+	case r.URL.Path == grafanaPath || strings.HasPrefix(r.URL.Path, grafanaPath+"/"):
+		h.grafana(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (h *collectorHandler) upload(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+	if err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	var result jsonResult
+	if err := json.Unmarshal(body, &result); err != nil || result.ID == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("the body is not a fast-cli JSON result"))
+		return
+	}
+	pub, err := verifyResult(body, nil)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err)
+		return
+	}
+	if len(h.trusted) > 0 && !slices.ContainsFunc(h.trusted, func(k ed25519.PublicKey) bool { return k.Equal(pub) }) {
+		writeAPIError(w, http.StatusForbidden, errors.New("signed by a key that isn't trusted"))
+		return
+	}
+
+	var compact bytes.Buffer
+	json.Compact(&compact, body)
+	c := collectedResult{ReceivedAt: time.Now(), Probe: base64.StdEncoding.EncodeToString(pub), Result: compact.Bytes()}
+	added, err := h.store.add(c)
+	if err != nil {
+		log.Printf("Warning: storing result from %s: %v", result.Host.Hostname, err)
+		writeAPIError(w, http.StatusInternalServerError, errors.New("storing the result failed"))
+		return
+	}
+	status := http.StatusOK // Already stored
+	if added {
+		status = http.StatusCreated
+		log.Printf("Result %s from %s: download %.2f Mbps, upload %.2f Mbps", result.ID, result.Host.Hostname, result.Download.Mbps, result.Upload.Mbps)
+	}
+	writeAPIJSON(w, status, map[string]string{"id": result.ID})
+}
+
+// collectorQuery is the filter the GET endpoints take: ?host=NAME to keep
+// one probe's results, ?since= a time (RFC 3339) or a duration back from now.
+type collectorQuery struct {
+	host  string
+	since time.Time
+}
+
+func parseCollectorQuery(r *http.Request) (collectorQuery, error) {
+	q := collectorQuery{host: r.URL.Query().Get("host")}
+	if v := r.URL.Query().Get("since"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			q.since = time.Now().Add(-d)
+		} else if q.since, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("since must be an RFC 3339 time or a duration such as 24h")
+		}
+	}
+	return q, nil
+}
+
+// matching returns the stored results the query keeps, decoded, oldest first.
+func (h *collectorHandler) matching(q collectorQuery) ([]collectedResult, []jsonResult) {
+	var kept []collectedResult
+	var decoded []jsonResult
+	for _, c := range h.store.all() {
+		var r jsonResult
+		if json.Unmarshal(c.Result, &r) != nil {
+			continue
+		}
+		if (q.host != "" && r.Host.Hostname != q.host) || r.StartedAt.Before(q.since) {
+			continue
+		}
+		kept = append(kept, c)
+		decoded = append(decoded, r)
+	}
+	return kept, decoded
+}
+
+func (h *collectorHandler) listResults(w http.ResponseWriter, r *http.Request) {
+	q, err := parseCollectorQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, _ := h.matching(q)
+	if results == nil {
+		results = []collectedResult{}
+	}
+	writeAPIJSON(w, http.StatusOK, results)
+}
+
+// history serves the results of every probe in the history format, as JSON
+// (the same as history export, so history import accepts it) or ?format=csv.
+func (h *collectorHandler) history(w http.ResponseWriter, r *http.Request) {
+	q, err := parseCollectorQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	_, results := h.matching(q)
+	entries := []historyEntry{}
+	for _, res := range results {
+		entries = append(entries, historyEntryFromJSON(res))
+	}
+	slices.SortStableFunc(entries, func(a, b historyEntry) int { return a.Time.Compare(b.Time) })
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeAPIJSON(w, http.StatusOK, entries)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		writeHistoryCSV(w, entries)
+	default:
+		writeAPIError(w, http.StatusBadRequest, errors.New("format must be json or csv"))
+	}
+}
+
+func (h *collectorHandler) probes(w http.ResponseWriter, r *http.Request) {
+	stored, results := h.matching(collectorQuery{})
+	byProbe := map[string]*collectorProbe{}
+	download, upload := map[string][]float64{}, map[string][]float64{}
+	for i, res := range results {
+		key := stored[i].Probe + "/" + res.Host.Hostname
+		p := byProbe[key]
+		if p == nil {
+			p = &collectorProbe{Host: res.Host.Hostname, Probe: stored[i].Probe, FirstSeen: res.StartedAt}
+			byProbe[key] = p
+		}
+		p.Results++
+		if !res.StartedAt.Before(p.LastSeen) {
+			p.LastSeen = res.StartedAt
+			p.Platform, p.Version = res.Host.OS+"/"+res.Host.Arch, res.Host.Version
+			p.LastDownloadMbps, p.LastUploadMbps = res.Download.Mbps, res.Upload.Mbps
+		}
+		if res.StartedAt.Before(p.FirstSeen) {
+			p.FirstSeen = res.StartedAt
+		}
+		download[key] = append(download[key], res.Download.Mbps)
+		upload[key] = append(upload[key], res.Upload.Mbps)
+	}
+	probes := []collectorProbe{}
+	for key, p := range byProbe {
+		p.MedianDownloadMbps, p.MedianUploadMbps = median(download[key]), median(upload[key])
+		probes = append(probes, *p)
+	}
+	slices.SortFunc(probes, func(a, b collectorProbe) int {
+		return cmp.Or(strings.Compare(a.Host, b.Host), strings.Compare(a.Probe, b.Probe))
+	})
+	writeAPIJSON(w, http.StatusOK, probes)
+}
+
+// collectorFlags are the flags of `fast-cli collector`.
+type collectorFlags struct {
+	Listen          string
+	DBPath          string
+	Trust           stringList
+	TLSCert, TLSKey string
+	Pprof           string
+}
+
+func defaultCollectorDBPath() string {
+	return filepath.Join(filepath.Dir(defaultHistoryPath()), "collector.jsonl")
+}
+
+func newCollectorFlagSet(f *collectorFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli collector", flag.ContinueOnError)
+	fs.StringVar(&f.Listen, "listen", ":9443", "`address` to listen on")
+	fs.StringVar(&f.DBPath, "db", defaultCollectorDBPath(), "`file` the uploaded results are stored in")
+	fs.Var(&f.Trust, "trust", "accept only results signed with this PEM public key `file` (repeatable)")
+	fs.StringVar(&f.TLSCert, "tls-cert", "", "serve HTTPS with this PEM certificate `file`")
+	fs.StringVar(&f.TLSKey, "tls-key", "", "PEM private key `file` for --tls-cert")
+	fs.StringVar(&f.Pprof, "pprof", "", pprofHelp)
+	return fs
+}
+
+// runCollector implements `fast-cli collector`: the central server that
+// probes running `fast-cli daemon --push-to` upload their signed results to.
+func runCollector(args []string) error {
+	var f collectorFlags
+	if err := parseFlags(newCollectorFlagSet(&f), args); err != nil {
+		return err
+	}
+	if (f.TLSCert == "") != (f.TLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	h := &collectorHandler{}
+	for _, path := range f.Trust {
+		key, err := loadVerifyKey(path)
+		if err != nil {
+			return err
+		}
+		h.trusted = append(h.trusted, key)
+	}
+	if len(h.trusted) == 0 {
+		log.Printf("Warning: no --trust keys given, accepting results signed with any key")
+	}
+	store, err := openCollectorStore(f.DBPath)
+	if err != nil {
+		return err
+	}
+	h.store = store
+
+	ln, err := net.Listen("tcp", f.Listen)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if f.Pprof != "" {
+		if err := servePprof(ctx, f.Pprof); err != nil {
+			return err
+		}
+	}
+
+	scheme := "http"
+	if f.TLSCert != "" {
+		scheme = "https"
+	}
+	log.Printf("Collecting results in %s (%d so far) on %s, push with: fast-cli daemon --sign-key KEY --push-to %s://HOST:%d",
+		f.DBPath, len(store.all()), ln.Addr(), scheme, ln.Addr().(*net.TCPAddr).Port)
+	if f.TLSCert != "" {
+		err = srv.ServeTLS(ln, f.TLSCert, f.TLSKey)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// pushResult uploads the result, signed by exportResult, to the collector
+// at opts.PushTo.
+func pushResult(opts *options, out jsonResult) error {
+	return uploadResult(httpClient, opts.PushTo, out)
+}
+
+// uploadResult uploads out to the collector at base through client.
+func uploadResult(client doer, base string, out jsonResult) error {
+	body, err := json.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("encoding result: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), collectorPushTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(base, "/")+collectorResultsPath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating upload request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading result: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return rejectedIfClientError(resp.StatusCode, fmt.Errorf("collector returned status %d: %s", resp.StatusCode, cmp.Or(apiErr.Error, http.StatusText(resp.StatusCode))))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectorStoreDedup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	store, err := openCollectorStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := func(probe, id string) collectedResult {
+		return collectedResult{Probe: probe, Result: json.RawMessage(`{"id":"` + id + `"}`)}
+	}
+	for _, tc := range []struct {
+		name      string
+		result    collectedResult
+		wantAdded bool
+	}{
+		{"first upload", result("probe-a", "run-1"), true},
+		{"retried upload", result("probe-a", "run-1"), false},
+		{"next run", result("probe-a", "run-2"), true},
+		{"same ID from another probe", result("probe-b", "run-1"), true},
+	} {
+		added, err := store.add(tc.result)
+		if err != nil || added != tc.wantAdded {
+			t.Errorf("%s: added %v, %v; want %v", tc.name, added, err, tc.wantAdded)
+		}
+	}
+
+	reopened, err := openCollectorStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(reopened.all()); n != 3 {
+		t.Errorf("reopened store holds %d results, want 3", n)
+	}
+	if added, err := reopened.add(result("probe-b", "run-1")); err != nil || added {
+		t.Errorf("reopened store added a result it held already: %v, %v", added, err)
+	}
+}
+
+func TestCollectorUpload(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	signed := func(key ed25519.PrivateKey, id string) []byte {
+		out := newJSONResult(testResult{ID: id}, plan{})
+		if key != nil {
+			if err := signResult(&out, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	store, err := openCollectorStore(filepath.Join(t.TempDir(), "results.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &collectorHandler{store: store, trusted: []ed25519.PublicKey{pub}}
+
+	for _, tc := range []struct {
+		name       string
+		body       []byte
+		wantStatus int
+	}{
+		{"new result", signed(key, "run-1"), http.StatusCreated},
+		{"retried result", signed(key, "run-1"), http.StatusOK},
+		{"unsigned", signed(nil, "run-2"), http.StatusForbidden},
+		{"untrusted key", signed(otherKey, "run-3"), http.StatusForbidden},
+		{"not a result", []byte(`{"status":"ok"}`), http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, collectorResultsPath, bytes.NewReader(tc.body)))
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.wantStatus, rec.Body)
+		}
+	}
+	if n := len(store.all()); n != 1 {
+		t.Errorf("stored %d results, want 1", n)
+	}
+}
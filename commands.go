@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// command is one fast-cli subcommand. Flags builds the command's flag set
+// with throwaway destinations, for help and shell completion.
+type command struct {
+	Summary string
+	Run     func(args []string) error
+	Flags   func() *flag.FlagSet            // Nil when the command takes no flags
+	Actions map[string]func() *flag.FlagSet // Second-level commands such as history export, with their flags
+}
+
+// commands are dispatched on the first argument. Assigned in init because
+// help and completion refer back to the table.
+var commands map[string]*command
+
+func init() {
+	runFlags := func() *flag.FlagSet { return newRunFlagSet("fast-cli run", &options{}) }
+	daemonFlags := func() *flag.FlagSet { return newDaemonFlagSet("fast-cli daemon", &options{}) }
+	commands = map[string]*command{
+		"run":               {Summary: "run a speed test (the default without a command)", Run: runTest, Flags: runFlags},
+		"servers":           {Summary: "list the servers a test would use, best first", Run: runServers, Flags: func() *flag.FlagSet { return newServersFlagSet(&serversFlags{}) }},
+		"check":             {Summary: "validate the configuration and preview a test without running it", Run: runCheck, Flags: daemonFlags},
+		"probe":             {Summary: "short healthcheck test with a time budget and exit codes", Run: runProbe, Flags: func() *flag.FlagSet { return newProbeFlagSet(&options{}, &probeFlags{}) }},
+		"doctor":            {Summary: "checklist of DNS, TLS, IPv6, MTU, clock and proxy problems that break tests", Run: runDoctor, Flags: func() *flag.FlagSet { return newDoctorFlagSet(new(string)) }},
+		"detect-throttling": {Summary: "compare Netflix, neutral, port and HTTP version throughput to spot traffic shaping", Run: runDetectThrottling, Flags: func() *flag.FlagSet { return newThrottleFlagSet(&throttleFlags{}) }},
+		"trace":             {Summary: "show DNS, connect, TLS and first-byte timings to each server", Run: runTrace, Flags: func() *flag.FlagSet { return newTraceFlagSet(&stringList{}) }},
+		"monitor":           {Summary: "ping the best server continuously and report loss", Run: runMonitor, Flags: func() *flag.FlagSet { return newMonitorFlagSet(&monitorFlags{}) }},
+		"serve":             {Summary: "act as a self-hosted test server for run --server", Run: runServe, Flags: func() *flag.FlagSet { return newServeFlagSet(&serveFlags{}) }},
+		"lan":               {Summary: "find serve peers on the local network and test against them", Run: runLAN, Flags: func() *flag.FlagSet { return newLANFlagSet(&lanFlags{}) }},
+		"udp":               {Summary: "measure UDP goodput, loss and reordering against a serve peer", Run: runUDP, Flags: func() *flag.FlagSet { return newUDPFlagSet(&udpFlags{}) }},
+		"compare":           {Summary: "show what changed between two JSON results, and whether it stands out", Run: runCompare, Flags: newCompareFlagSet},
+		"verify":            {Summary: "check the signature of a JSON result written with --sign-key", Run: runVerify, Flags: func() *flag.FlagSet { return newVerifyFlagSet(new(string)) }},
+		"history": {Summary: "export, import, prune or summarize recorded results", Run: runHistory, Actions: map[string]func() *flag.FlagSet{
+			"export": func() *flag.FlagSet { return newHistoryExportFlagSet(&historyExportFlags{}) },
+			"import": func() *flag.FlagSet { return newHistoryImportFlagSet(new(string)) },
+			"prune":  func() *flag.FlagSet { return newHistoryPruneFlagSet(new(string), &retentionPolicy{}) },
+			"stats":  func() *flag.FlagSet { return newHistoryStatsFlagSet(&historyStatsFlags{}) },
+		}},
+		"analyze":         {Summary: "time-of-day congestion report from the history", Run: runAnalyze, Flags: func() *flag.FlagSet { return newAnalyzeFlagSet(new(string), new(string), new(bool)) }},
+		"daemon":          {Summary: "run tests on a schedule and notify on anomalies", Run: runDaemon, Flags: daemonFlags},
+		"collector":       {Summary: "central server that stores signed results pushed by remote probes", Run: runCollector, Flags: func() *flag.FlagSet { return newCollectorFlagSet(&collectorFlags{}) }},
+		"plugin":          {Summary: "long-running collectd or netdata plugin", Run: runPlugin, Flags: func() *flag.FlagSet { return newPluginFlagSet(&options{}, new(string)) }},
+		"install-service": {Summary: "write systemd units for scheduled tests", Run: runInstallService, Flags: func() *flag.FlagSet { return newInstallServiceFlagSet(&installServiceFlags{}) }},
+		"service": {Summary: "install, start or stop the Windows service", Run: runService, Actions: map[string]func() *flag.FlagSet{
+			"install": nil, "uninstall": nil, "start": nil, "stop": nil, "run": nil,
+		}},
+		"update":     {Summary: "update fast-cli to the latest release", Run: runUpdate, Flags: func() *flag.FlagSet { return newUpdateFlagSet(&updateFlags{}) }},
+		"completion": {Summary: "print a bash, zsh or fish completion script", Run: runCompletion, Actions: map[string]func() *flag.FlagSet{"bash": nil, "zsh": nil, "fish": nil}},
+		"version":    {Summary: "print the version", Run: runVersion},
+		"help":       {Summary: "show help for fast-cli or a command", Run: runHelp},
+	}
+}
+
+// globalFlags may come before the command name and apply to whichever
+// command runs: `fast-cli --profile quick daemon`.
+var globalFlags = []string{"config", "profile"}
+
+// parseGlobalFlags consumes leading global flags and hands them to the
+// command through its FAST_* environment variable, which keeps their
+// precedence below flags given to the command itself.
+func parseGlobalFlags(args []string) ([]string, error) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		if !slices.Contains(globalFlags, name) {
+			break
+		}
+		if !hasValue {
+			if len(args) < 2 {
+				return nil, fmt.Errorf("flag needs an argument: -%s", name)
+			}
+			value, args = args[1], args[1:]
+		}
+		os.Setenv(envName(name), value)
+		args = args[1:]
+	}
+	return args, nil
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printUsage() {
+	fmt.Println("Usage: fast-cli [--config FILE] [--profile NAME] [command] [flags]")
+	fmt.Println("\nWithout a command, fast-cli runs a speed test and accepts the run flags.")
+	fmt.Println("\nCommands:")
+	for _, name := range commandNames() {
+		fmt.Printf("  %-16s %s\n", name, commands[name].Summary)
+	}
+	fmt.Println("\nRun 'fast-cli help COMMAND' for the flags of a command.")
+}
+
+// runHelp implements `fast-cli help [command [action]]`.
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	fmt.Printf("fast-cli %s: %s\n", args[0], cmd.Summary)
+	if len(cmd.Actions) > 0 {
+		var actions []string
+		for action := range cmd.Actions {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+		fmt.Printf("Actions: %s\n", strings.Join(actions, ", "))
+		if len(args) > 1 {
+			if flags := cmd.Actions[args[1]]; flags != nil {
+				fs := flags()
+				fs.SetOutput(os.Stdout)
+				fmt.Println()
+				fs.PrintDefaults()
+			}
+		}
+		return nil
+	}
+	if cmd.Flags != nil {
+		fs := cmd.Flags()
+		fs.SetOutput(os.Stdout)
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	return nil
+}
+
+func runVersion(args []string) error {
+	fmt.Printf("fast-cli %s\n", version)
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Samples are averaged in blocks this long before estimating how noisy a
+// phase was, since neighbouring samples aren't independent.
+const compareBlock = time.Second
+
+func newCompareFlagSet() *flag.FlagSet {
+	return flag.NewFlagSet("fast-cli compare", flag.ContinueOnError)
+}
+
+// runCompare implements `fast-cli compare A.json B.json`: the change of each
+// metric from result A to result B, and whether it stands out from the
+// noise within the runs. Either file may be - for stdin.
+func runCompare(args []string) error {
+	fs := newCompareFlagSet()
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: fast-cli compare BEFORE.json AFTER.json")
+	}
+	a, err := readJSONResult(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := readJSONResult(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("A: %s, %s\nB: %s, %s\n", fs.Arg(0), describeRun(a), fs.Arg(1), describeRun(b))
+	if a.Provider != b.Provider || a.Via != b.Via {
+		fmt.Println("Warning: the results were measured differently (provider or --compare-via), so part of any change may come from that.")
+	}
+	if pa, pb := cmp.Or(a.Upload.Payload, payloadRandom), cmp.Or(b.Upload.Payload, payloadRandom); pa != pb {
+		fmt.Printf("Upload payloads differ (%s and %s): an upload much faster with the compressible one points to a middlebox that compresses or dedupes.\n", pa, pb)
+	}
+	for _, r := range []struct {
+		name string
+		res  jsonResult
+	}{{"A", a}, {"B", b}} {
+		if r.res.Status == "degraded" {
+			fmt.Printf("Warning: %s is degraded, streams failed during it.\n", r.name)
+		}
+	}
+
+	fmt.Printf("\n%-24s %10s %10s  %s\n", "Metric", "A", "B", "Change")
+	for _, m := range compareMetrics(a, b) {
+		fmt.Println(m)
+	}
+	fmt.Println("\nSignificance compares each change to the variation within the two runs; it can't tell a lasting change from a busy evening, which `fast-cli analyze` can.")
+	return nil
+}
+
+func readJSONResult(path string) (jsonResult, error) {
+	var doc []byte
+	var err error
+	if path == "-" {
+		doc, err = io.ReadAll(os.Stdin)
+	} else {
+		doc, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return jsonResult{}, fmt.Errorf("reading result: %w", err)
+	}
+	var res jsonResult
+	if err := json.Unmarshal(doc, &res); err != nil {
+		return jsonResult{}, fmt.Errorf("decoding %s, expected the output of --format json: %w", path, err)
+	}
+	return res, nil
+}
+
+func describeRun(res jsonResult) string {
+	s := res.StartedAt.Local().Format("2006-01-02 15:04")
+	if res.Host.Hostname != "" {
+		s += " on " + res.Host.Hostname
+	}
+	if len(res.Servers) > 0 {
+		s += fmt.Sprintf(", %d servers from %s", len(res.Servers), cmp.Or(res.Provider, providerFast))
+	}
+	return s
+}
+
+// metricDelta is the change of one metric between two results.
+type metricDelta struct {
+	Name         string
+	A, B         float64
+	HigherBetter bool
+	StdErr       float64 // Of the difference, 0 if the results don't tell
+	missing      bool    // A or B didn't measure it
+}
+
+// String is a line of the compare table, e.g.
+// "Download (Mbps)  812.43  905.10  +92.67 (+11.4%), better, significant".
+func (m metricDelta) String() string {
+	if m.missing {
+		return fmt.Sprintf("%-24s %10s %10s  not measured in both", m.Name, formatMetric(m.A), formatMetric(m.B))
+	}
+	diff := m.B - m.A
+	line := fmt.Sprintf("%-24s %10.2f %10.2f  %+.2f", m.Name, m.A, m.B, diff)
+	if m.A != 0 {
+		line += fmt.Sprintf(" (%+.1f%%)", 100*diff/math.Abs(m.A))
+	}
+	if diff != 0 {
+		if (diff > 0) == m.HigherBetter {
+			line += ", better"
+		} else {
+			line += ", worse"
+		}
+	}
+	if hint := m.significance(); hint != "" {
+		line += ", " + hint
+	}
+	return line
+}
+
+func formatMetric(v float64) string {
+	if v == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// significance rates the change by how many standard errors it spans.
+func (m metricDelta) significance() string {
+	if m.StdErr <= 0 {
+		return ""
+	}
+	switch z := math.Abs(m.B-m.A) / m.StdErr; {
+	case z >= 3:
+		return "significant"
+	case z >= 2:
+		return "probably real"
+	default:
+		return "within noise"
+	}
+}
+
+func compareMetrics(a, b jsonResult) []metricDelta {
+	speed := func(name string, pa, pb jsonPhase) metricDelta {
+		m := metricDelta{Name: name, A: pa.Mbps, B: pb.Mbps, HigherBetter: true, missing: pa.Bytes == 0 || pb.Bytes == 0}
+		ea, na := sampleNoise(pa.SamplesMbps)
+		eb, nb := sampleNoise(pb.SamplesMbps)
+		if na > 1 && nb > 1 {
+			m.StdErr = math.Sqrt(ea*ea/float64(na) + eb*eb/float64(nb))
+		}
+		return m
+	}
+	latency := func(name string, la, lb *jsonLatency) metricDelta {
+		if la == nil || lb == nil {
+			m := metricDelta{Name: name, missing: true}
+			if la != nil {
+				m.A = la.AvgMs
+			}
+			if lb != nil {
+				m.B = lb.AvgMs
+			}
+			return m
+		}
+		m := metricDelta{Name: name, A: la.AvgMs, B: lb.AvgMs}
+		if la.Samples > 1 && lb.Samples > 1 {
+			// Jitter, the mean change between pings, stands in for their spread
+			m.StdErr = math.Sqrt(la.JitterMs*la.JitterMs/float64(la.Samples) + lb.JitterMs*lb.JitterMs/float64(lb.Samples))
+		}
+		return m
+	}
+	metrics := []metricDelta{
+		speed("Download (Mbps)", a.Download, b.Download),
+		speed("Upload (Mbps)", a.Upload, b.Upload),
+		latency("Idle latency (ms)", a.Ping, b.Ping),
+		latency("Download latency (ms)", a.Download.Latency, b.Download.Latency),
+		latency("Upload latency (ms)", a.Upload.Latency, b.Upload.Latency),
+	}
+	if a.Ping != nil && b.Ping != nil {
+		metrics = append(metrics, metricDelta{Name: "Jitter (ms)", A: a.Ping.JitterMs, B: b.Ping.JitterMs})
+	}
+	metrics = append(metrics, metricDelta{Name: "Responsiveness (RPM)", A: a.RPM, B: b.RPM, HigherBetter: true, missing: a.RPM == 0 || b.RPM == 0})
+	return metrics
+}
+
+// sampleNoise returns the standard deviation of the throughput samples of a
+// phase, past the ramp-up and averaged over compareBlock, and how many
+// blocks it comes from.
+func sampleNoise(samples []float64) (float64, int) {
+	if skip := int(consistencyRampUp / throughputSampleInterval); len(samples)-skip >= 4 {
+		samples = samples[skip:]
+	}
+	per := int(compareBlock / throughputSampleInterval)
+	var blocks []float64
+	for i := 0; i+per <= len(samples); i += per {
+		var sum float64
+		for _, s := range samples[i : i+per] {
+			sum += s
+		}
+		blocks = append(blocks, sum/float64(per))
+	}
+	if len(blocks) < 2 {
+		return 0, len(blocks)
+	}
+	var mean float64
+	for _, v := range blocks {
+		mean += v
+	}
+	mean /= float64(len(blocks))
+	var variance float64
+	for _, v := range blocks {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(blocks)-1)), len(blocks)
+}
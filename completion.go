@@ -0,0 +1,260 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completionFlag is what the completion scripts need to know about a flag.
+type completionFlag struct {
+	Name, Usage string
+	TakesValue  bool
+	Files       bool // The value is a path
+}
+
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	if fs == nil {
+		return nil
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		valueName, usage := flag.UnquoteUsage(f)
+		boolFlag, _ := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			Name:       f.Name,
+			Usage:      usage,
+			TakesValue: boolFlag == nil || !boolFlag.IsBoolFlag(),
+			Files:      valueName == "file" || valueName == "directory",
+		})
+	})
+	return flags
+}
+
+// completionNode is a command or action together with what can follow it.
+type completionNode struct {
+	Path    []string // e.g. ["history", "export"]
+	Summary string
+	Flags   []completionFlag
+	Actions []string
+}
+
+// completionTree flattens the command table. The first node is the bare
+// invocation, which takes the global and run flags.
+func completionTree() []completionNode {
+	top := completionNode{Actions: commandNames(), Flags: completionFlags(commands["run"].Flags())}
+	nodes := []completionNode{top}
+	for _, name := range commandNames() {
+		cmd := commands[name]
+		node := completionNode{Path: []string{name}, Summary: cmd.Summary}
+		if cmd.Flags != nil {
+			node.Flags = completionFlags(cmd.Flags())
+		}
+		if name == "help" {
+			node.Actions = commandNames()
+		}
+		for action := range cmd.Actions {
+			node.Actions = append(node.Actions, action)
+		}
+		sort.Strings(node.Actions)
+		nodes = append(nodes, node)
+		for _, action := range node.Actions {
+			if flags, ok := cmd.Actions[action]; ok && flags != nil {
+				nodes = append(nodes, completionNode{Path: []string{name, action}, Flags: completionFlags(flags())})
+			}
+		}
+	}
+	return nodes
+}
+
+func flagNames(flags []completionFlag) []string {
+	var names []string
+	for _, f := range flags {
+		names = append(names, "--"+f.Name)
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer) {
+	nodes := completionTree()
+	seen := map[string]bool{}
+	var valueFlags, fileFlags []string
+	for _, n := range nodes {
+		for _, f := range n.Flags {
+			if f.TakesValue && !seen[f.Name] {
+				seen[f.Name] = true
+				valueFlags = append(valueFlags, "-"+f.Name, "--"+f.Name)
+				if f.Files {
+					fileFlags = append(fileFlags, "-"+f.Name, "--"+f.Name)
+				}
+			}
+		}
+	}
+	for _, g := range globalFlags {
+		if !seen[g] {
+			valueFlags = append(valueFlags, "-"+g, "--"+g)
+		}
+	}
+
+	fmt.Fprintln(w, "# bash completion for fast-cli, generated by `fast-cli completion bash`")
+	fmt.Fprintln(w, "_fast_cli() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `    local path="" i w`)
+	fmt.Fprintln(w, `    for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `        w="${COMP_WORDS[i]}"`)
+	fmt.Fprintf(w, "        case \"${COMP_WORDS[i-1]}\" in %s) continue ;; esac\n", strings.Join(valueFlags, "|"))
+	fmt.Fprintln(w, `        [[ $w == -* ]] || path="${path:+$path }$w"`)
+	fmt.Fprintln(w, `    done`)
+	if len(fileFlags) > 0 {
+		fmt.Fprintf(w, "    case \"$prev\" in\n        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(fileFlags, "|"))
+		fmt.Fprintf(w, "        %s) return ;;\n    esac\n", strings.Join(valueFlags, "|"))
+	}
+	fmt.Fprintln(w, `    local words=""`)
+	fmt.Fprintln(w, `    case "$path" in`)
+	for _, n := range nodes {
+		words := append([]string(nil), n.Actions...)
+		words = append(words, flagNames(n.Flags)...)
+		if len(n.Path) == 0 {
+			words = append(words, "--config", "--profile", "--version")
+		}
+		fmt.Fprintf(w, "        %q) words=%q ;;\n", strings.Join(n.Path, " "), strings.Join(words, " "))
+	}
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o default -F _fast_cli fast-cli")
+}
+
+var zshEscaper = strings.NewReplacer(`'`, `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+
+func zshArguments(flags []completionFlag) string {
+	var specs []string
+	for _, f := range flags {
+		spec := fmt.Sprintf("'--%s[%s]", f.Name, zshEscaper.Replace(f.Usage))
+		switch {
+		case f.Files:
+			spec += ":file:_files"
+		case f.TakesValue:
+			spec += ":value: "
+		}
+		specs = append(specs, spec+"'")
+	}
+	return "_arguments " + strings.Join(specs, " \\\n          ")
+}
+
+func writeZshCompletion(w io.Writer) {
+	nodes := completionTree()
+	fmt.Fprintln(w, "#compdef fast-cli")
+	fmt.Fprintln(w, "# zsh completion for fast-cli, generated by `fast-cli completion zsh`")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "_fast_cli() {")
+	fmt.Fprintln(w, "  local -a commands")
+	fmt.Fprintln(w, "  commands=(")
+	for _, n := range nodes[1:] {
+		if len(n.Path) == 1 {
+			fmt.Fprintf(w, "    '%s:%s'\n", n.Path[0], zshEscaper.Replace(n.Summary))
+		}
+	}
+	fmt.Fprintln(w, "  )")
+	fmt.Fprintln(w, "  if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then")
+	fmt.Fprintln(w, "    _describe -t commands 'fast-cli command' commands")
+	fmt.Fprintln(w, "    return")
+	fmt.Fprintln(w, "  fi")
+	fmt.Fprintln(w, "  local cmd=$words[2]")
+	fmt.Fprintln(w, "  if [[ $cmd == -* ]]; then")
+	fmt.Fprintf(w, "    %s\n    return\n  fi\n", zshArguments(append(nodes[0].Flags,
+		completionFlag{Name: "config", Usage: "config file", TakesValue: true, Files: true},
+		completionFlag{Name: "profile", Usage: "config profile", TakesValue: true})))
+	fmt.Fprintln(w, "  shift words; (( CURRENT-- ))")
+	fmt.Fprintln(w, "  case $cmd in")
+	for _, n := range nodes[1:] {
+		if len(n.Path) != 1 {
+			continue
+		}
+		fmt.Fprintf(w, "    %s)\n", n.Path[0])
+		if len(n.Actions) > 0 {
+			fmt.Fprintf(w, "      if (( CURRENT == 2 )); then\n        _values 'action' %s\n        return\n      fi\n", strings.Join(n.Actions, " "))
+			fmt.Fprintln(w, "      local action=$words[2]; shift words; (( CURRENT-- ))")
+			fmt.Fprintln(w, "      case $action in")
+			for _, sub := range nodes {
+				if len(sub.Path) == 2 && sub.Path[0] == n.Path[0] {
+					fmt.Fprintf(w, "        %s) %s ;;\n", sub.Path[1], zshArguments(sub.Flags))
+				}
+			}
+			fmt.Fprintln(w, "      esac")
+		} else if len(n.Flags) > 0 {
+			fmt.Fprintf(w, "      %s\n", zshArguments(n.Flags))
+		}
+		fmt.Fprintln(w, "      ;;")
+	}
+	fmt.Fprintln(w, "  esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, `_fast_cli "$@"`)
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func writeFishFlags(w io.Writer, condition string, flags []completionFlag) {
+	for _, f := range flags {
+		line := fmt.Sprintf("complete -c fast-cli -n %s -l %s -d %s", fishQuote(condition), f.Name, fishQuote(f.Usage))
+		switch {
+		case f.Files:
+			line += " -r -F"
+		case f.TakesValue:
+			line += " -r"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+func writeFishCompletion(w io.Writer) {
+	nodes := completionTree()
+	fmt.Fprintln(w, "# fish completion for fast-cli, generated by `fast-cli completion fish`")
+	fmt.Fprintln(w, "complete -c fast-cli -f")
+	for _, n := range nodes[1:] {
+		if len(n.Path) == 1 {
+			fmt.Fprintf(w, "complete -c fast-cli -n __fish_use_subcommand -a %s -d %s\n", n.Path[0], fishQuote(n.Summary))
+		}
+	}
+	writeFishFlags(w, "__fish_use_subcommand", append(nodes[0].Flags,
+		completionFlag{Name: "config", Usage: "config file", TakesValue: true, Files: true},
+		completionFlag{Name: "profile", Usage: "config profile", TakesValue: true}))
+
+	for _, n := range nodes[1:] {
+		seen := "__fish_seen_subcommand_from " + n.Path[0]
+		if len(n.Path) == 2 {
+			writeFishFlags(w, seen+"; and __fish_seen_subcommand_from "+n.Path[1], n.Flags)
+			continue
+		}
+		if len(n.Actions) > 0 {
+			fmt.Fprintf(w, "complete -c fast-cli -n %s -a %s\n",
+				fishQuote(seen+"; and not __fish_seen_subcommand_from "+strings.Join(n.Actions, " ")), fishQuote(strings.Join(n.Actions, " ")))
+		}
+		writeFishFlags(w, seen, n.Flags)
+	}
+}
+
+// runCompletion implements `fast-cli completion bash|zsh|fish`. The scripts
+// are generated from the command table, so they never go stale.
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: fast-cli completion <bash|zsh|fish>")
+	}
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout)
+	case "zsh":
+		writeZshCompletion(os.Stdout)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configEntry is one "key = value" line of the config file
+type configEntry struct {
+	Key, Value string
+	Line       int
+}
+
+// loadConfig reads an INI-style config file. Keys are flag names: lines
+// before any [section] apply to every run, and each [name] section is a
+// profile selected with --profile name. A key without a value sets a boolean
+// flag. # and ; start comments, also after a value when preceded by
+// whitespace; quote values that contain them.
+func loadConfig(path string) (map[string][]configEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := map[string][]configEntry{"": nil}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";"):
+			continue
+		case strings.HasPrefix(text, "["):
+			name, ok := strings.CutSuffix(text[1:], "]")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("%s:%d: malformed section header %q", path, line, text)
+			}
+			section = strings.TrimSpace(name)
+			if _, dup := sections[section]; dup {
+				return nil, fmt.Errorf("%s:%d: profile %q defined twice", path, line, section)
+			}
+			sections[section] = nil
+			continue
+		}
+
+		key, value, hasValue := strings.Cut(text, "=")
+		value = strings.TrimSpace(value)
+		if !hasValue {
+			key, value = stripComment(key), "true"
+		}
+		key = strings.TrimPrefix(strings.TrimSpace(key), "--")
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		} else {
+			value = stripComment(value)
+		}
+		sections[section] = append(sections[section], configEntry{Key: key, Value: value, Line: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// stripComment removes a trailing # or ; comment preceded by whitespace.
+func stripComment(s string) string {
+	for i := 1; i < len(s); i++ {
+		if (s[i] == '#' || s[i] == ';') && (s[i-1] == ' ' || s[i-1] == '\t') {
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return s
+}
+
+// applyConfig fills flags not already given (on the command line or in the
+// environment) from the selected profile first, then from the global
+// section. Keys that are not flags of this command are ignored, so one file
+// can serve every subcommand.
+func applyConfig(fs *flag.FlagSet, given map[string]bool) error {
+	path, explicit := defaultConfigPath(), false
+	if f := fs.Lookup("config"); f != nil {
+		path, explicit = f.Value.String(), given["config"]
+	}
+	var profile string
+	if f := fs.Lookup("profile"); f != nil {
+		profile = f.Value.String()
+	}
+
+	sections, err := loadConfig(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit && profile == "" {
+		return nil // The config file is optional
+	}
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	order := []string{""}
+	if profile != "" {
+		if _, ok := sections[profile]; !ok {
+			return fmt.Errorf("profile %q not found in %s", profile, path)
+		}
+		order = []string{profile, ""}
+	}
+	for _, name := range order {
+		// Marked only after the whole section so that repeatable keys accumulate
+		set := make(map[string]bool)
+		for _, e := range sections[name] {
+			if e.Key == "config" || e.Key == "profile" || given[e.Key] || fs.Lookup(e.Key) == nil {
+				continue
+			}
+			if err := fs.Set(e.Key, e.Value); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for %s: %v", path, e.Line, e.Value, e.Key, err)
+			}
+			set[e.Key] = true
+		}
+		for key := range set {
+			given[key] = true
+		}
+	}
+	return nil
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "fast-cli.ini"
+	}
+	return filepath.Join(dir, "fast-cli", "config.ini")
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// congestionControl checks that test connections may use the TCP congestion
+// control algorithm algo, by trying it on a socket of their own, and returns
+// the dialer hook selecting it. With algo empty there is no hook and active
+// is the kernel's default, which connections then run.
+func congestionControl(algo string) (hook func(network, address string, c syscall.RawConn) error, active string, err error) {
+	if algo == "" {
+		return nil, congestionSysctl("tcp_congestion_control"), nil
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("opening a socket to check --congestion: %w", err)
+	}
+	err = syscall.SetsockoptString(fd, syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, algo)
+	syscall.Close(fd)
+	switch {
+	case errors.Is(err, syscall.ENOENT):
+		return nil, "", fmt.Errorf("the kernel has no %s congestion control (available: %s), try modprobe tcp_%s", algo, congestionSysctl("tcp_available_congestion_control"), algo)
+	case errors.Is(err, syscall.EPERM):
+		return nil, "", fmt.Errorf("%s congestion control needs root or CAP_NET_ADMIN (allowed without: %s, see net.ipv4.tcp_allowed_congestion_control)", algo, congestionSysctl("tcp_allowed_congestion_control"))
+	case err != nil:
+		return nil, "", fmt.Errorf("selecting %s congestion control: %w", algo, err)
+	}
+
+	hook = func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, algo)
+		}); err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("selecting %s congestion control: %w", algo, sockErr)
+		}
+		return nil
+	}
+	return hook, algo, nil
+}
+
+// congestionSysctl reads a net.ipv4 setting, or returns "unknown".
+func congestionSysctl(name string) string {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/" + name)
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(data))
+}
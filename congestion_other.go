@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// congestionControl needs Linux's TCP_CONGESTION socket option; elsewhere
+// the algorithm is neither chosen nor known.
+func congestionControl(algo string) (hook func(network, address string, c syscall.RawConn) error, active string, err error) {
+	if algo == "" {
+		return nil, "", nil
+	}
+	return nil, "", errors.New("--congestion is only available on Linux")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// connTracker records which TCP connections the requests of a phase went
+// over. With HTTP/2, streams to the same host can end up multiplexed on one
+// connection, which then measures one TCP flow instead of several.
+type connTracker struct {
+	mu         sync.Mutex
+	inFlight   map[string]int // Requests on each connection right now
+	conns      int
+	maxStreams int // Most requests seen on one connection at once
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{inFlight: map[string]int{}}
+}
+
+// trace returns ctx set up to record the connection a request in it gets,
+// and the function to call once the request is done with it.
+func (t *connTracker) trace(ctx context.Context) (context.Context, func()) {
+	var key string
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			key = info.Conn.LocalAddr().String() + "-" + info.Conn.RemoteAddr().String()
+			if _, seen := t.inFlight[key]; !seen {
+				t.conns++
+			}
+			t.inFlight[key]++
+			t.maxStreams = max(t.maxStreams, t.inFlight[key])
+		},
+	})
+	return ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if key != "" {
+			t.inFlight[key]--
+			key = ""
+		}
+	}
+}
+
+// summary returns how many connections were used, and the most requests one
+// of them carried at the same time.
+func (t *connTracker) summary() (conns, maxStreams int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conns, t.maxStreams
+}
+
+// testTransport is the transport under httpClient.
+var testTransport = newHTTPTransport()
+
+// useHTTP1Only keeps testTransport from negotiating HTTP/2, so that every
+// request in flight has a TCP connection of its own. It must be called
+// before the first request.
+func useHTTP1Only() {
+	if t, ok := testTransport.(*http.Transport); ok {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{} // Non-nil and empty disables HTTP/2
+	}
+}
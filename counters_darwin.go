@@ -0,0 +1,35 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// interfaceCounters reads the received and sent byte counters of an
+// interface from `netstat -ibn`, whose link-level row carries the totals.
+func interfaceCounters(name string) (rx, tx uint64, err error) {
+	out, err := exec.Command("netstat", "-ibn", "-I", name).Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("running netstat: %w", err)
+	}
+	// Name Mtu Network Address Ipkts Ierrs Ibytes Opkts Oerrs Obytes Coll
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[0] != name || !strings.HasPrefix(fields[2], "<Link#") {
+			continue
+		}
+		rx, err = strconv.ParseUint(fields[6], 10, 64)
+		if err == nil {
+			tx, err = strconv.ParseUint(fields[9], 10, 64)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("parsing netstat output: %w", err)
+		}
+		return rx, tx, nil
+	}
+	return 0, 0, fmt.Errorf("netstat has no counters for %s", name)
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// interfaceCounters reads the received and sent byte counters of an
+// interface from sysfs.
+func interfaceCounters(name string) (rx, tx uint64, err error) {
+	read := func(counter string) (uint64, error) {
+		b, err := os.ReadFile("/sys/class/net/" + name + "/statistics/" + counter)
+		if err != nil {
+			return 0, fmt.Errorf("reading %s counters: %w", name, err)
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+	if rx, err = read("rx_bytes"); err != nil {
+		return 0, 0, err
+	}
+	tx, err = read("tx_bytes")
+	return rx, tx, err
+}
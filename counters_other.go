@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package main
+
+func interfaceCounters(name string) (rx, tx uint64, err error) {
+	return 0, 0, errCountersUnsupported
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// newDaemonFlagSet registers the run flags plus the daemon-only ones.
+func newDaemonFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := newRunFlagSet(name, opts)
+	fs.DurationVar(&opts.Interval, "interval", time.Hour, "time between speed tests")
+	fs.DurationVar(&opts.ProbeInterval, "probe-interval", 0, "check that the network is up every `duration`, such as 30s, with a request of a few bytes, to record outages between tests; 0 checks only after a test failed")
+	fs.BoolVar(&opts.OnNetworkChange, "on-network-change", false, "also test shortly after the interface, default route or Wi-Fi network changed, tagging the result with the new network")
+	fs.Var(&opts.NotifyWebhooks, "notify-webhook", "POST notifications as JSON to this `URL`; "+secretHelp+" (repeatable)")
+	fs.Float64Var(&opts.AnomalyThreshold, "anomaly-threshold", 3, "notify when a result is this many robust standard deviations worse than usual for its hour")
+	fs.StringVar(&opts.Digest, "digest", "", "send a "+digestDaily+" or "+digestWeekly+" digest of the results through the notifiers")
+	fs.StringVar(&opts.DigestFormat, "digest-format", "text", "`format` of the digest: "+strings.Join(digestFormats, ", "))
+	fs.StringVar(&opts.PushTo, "push-to", "", "upload every result, signed with --sign-key, to the fast-cli collector at this `URL`")
+	fs.StringVar(&opts.Pprof, "pprof", "", pprofHelp)
+	fs.StringVar(&opts.MetricsListen, "metrics-listen", "", "serve the last result and the daemon's own health as OpenMetrics on http://`address`/metrics, e.g. :9516")
+	opts.Retention.register(fs)
+	return fs
+}
+
+func parseDaemonOptions(args []string) (*options, error) {
+	opts := &options{}
+	fs := newDaemonFlagSet("fast-cli daemon", opts)
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("--interval must be positive")
+	}
+	if opts.ProbeInterval < 0 {
+		return nil, fmt.Errorf("--probe-interval must not be negative")
+	}
+	if err := opts.validateTest(); err != nil {
+		return nil, err
+	}
+	if err := singleProvider(opts.Provider, "daemon"); err != nil {
+		return nil, err
+	}
+	if opts.Digest != "" && opts.Digest != digestDaily && opts.Digest != digestWeekly {
+		return nil, fmt.Errorf("--digest must be %s or %s", digestDaily, digestWeekly)
+	}
+	if !slices.Contains(digestFormats, opts.DigestFormat) {
+		return nil, fmt.Errorf("--digest-format must be one of %s", strings.Join(digestFormats, ", "))
+	}
+	if opts.Digest != "" && opts.NoHistory {
+		return nil, fmt.Errorf("--digest needs the history, it can't be combined with --no-history")
+	}
+	if opts.PushTo != "" && opts.SignKey == "" {
+		return nil, fmt.Errorf("--push-to needs --sign-key, the collector only accepts signed results")
+	}
+	if opts.SignKey != "" {
+		var err error
+		if opts.signingKey, err = loadSigningKey(opts.SignKey); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if err := opts.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if opts.pushTemplate, err = loadPushTemplate(opts.PushTemplate); err != nil {
+		return nil, err
+	}
+	if err := loadSinkSections(opts); err != nil {
+		return nil, err
+	}
+	if err := configureConnections(opts); err != nil {
+		return nil, err
+	}
+	applyLowResource(opts)
+	return opts, nil
+}
+
+// runDaemon implements `fast-cli daemon`: run a speed test every interval,
+// record it, and notify only when a run is anomalous for its hour of day.
+func runDaemon(args []string) error {
+	opts, err := parseDaemonOptions(args)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return dryRun(opts)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return runDaemonLoop(ctx, opts)
+}
+
+// runDaemonLoop runs scheduled tests until ctx is cancelled, by a signal or
+// by the Windows service control manager.
+func runDaemonLoop(ctx context.Context, opts *options) error {
+	notifiers := buildNotifiers(opts)
+	if opts.Pprof != "" {
+		if err := servePprof(ctx, opts.Pprof); err != nil {
+			return err
+		}
+	}
+	if opts.MetricsListen != "" {
+		if err := serveMetrics(ctx, opts, opts.MetricsListen); err != nil {
+			return err
+		}
+	}
+	log.Printf("Daemon started, testing every %s", opts.Interval)
+	sdNotify("READY=1")
+
+	// A nil channel never fires, which disables watchdog pings outside systemd
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	// Likewise without --digest; it is sent at the end of each period
+	var digestDue <-chan time.Time
+	var digestFrom, digestTo time.Time
+	if opts.Digest != "" {
+		digestFrom, digestTo = digestPeriod(opts.Digest, time.Now())
+		digestDue = time.After(time.Until(digestTo))
+	}
+
+	// And while no outbox has anything to retry
+	var retryDue <-chan time.Time
+	retryDelay := outboxRetryMin
+
+	// And while the network is up, unless it is probed all along
+	outages := outageTracker{every: cmp.Or(opts.ProbeInterval, outageProbeInterval)}
+	var probeDue, reachability <-chan time.Time
+	if opts.ProbeInterval > 0 {
+		ticker := time.NewTicker(opts.ProbeInterval)
+		defer ticker.Stop()
+		reachability = ticker.C
+	}
+
+	// And without --on-network-change
+	var networkChanged <-chan networkIdentity
+	if opts.OnNetworkChange {
+		networkChanged = watchNetwork(ctx)
+	}
+
+	for {
+		sdNotify("WATCHDOG=1\nSTATUS=Running speed test")
+		runScheduledTest(opts, notifiers, &outages)
+		if outages.entry != nil && probeDue == nil && reachability == nil {
+			probeDue = time.After(outageProbeInterval)
+		}
+		if retryDue == nil && outboxesPending(opts) {
+			retryDue = time.After(retryDelay)
+		}
+		next := time.Now().Add(opts.Interval)
+		sdNotify(fmt.Sprintf("WATCHDOG=1\nSTATUS=Idle, next test at %s", next.Format(time.TimeOnly)))
+
+		timer := time.NewTimer(time.Until(next))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				outages.end(opts, time.Now(), true)
+				log.Printf("Daemon stopping")
+				sdNotify("STOPPING=1")
+				return nil
+			case <-watchdog:
+				sdNotify("WATCHDOG=1")
+			case <-digestDue:
+				sendDigest(opts, notifiers, digestFrom, digestTo)
+				digestFrom, digestTo = digestPeriod(opts.Digest, digestTo)
+				digestDue = time.After(time.Until(digestTo))
+			case <-retryDue:
+				if retryOutboxes(opts) {
+					retryDelay = min(2*retryDelay, outboxRetryMax)
+					retryDue = time.After(retryDelay)
+				} else {
+					retryDelay, retryDue = outboxRetryMin, nil
+				}
+			case <-reachability:
+				outages.probe(opts)
+			case <-probeDue:
+				outages.probe(opts)
+				probeDue = nil
+				if outages.entry != nil {
+					probeDue = time.After(outageProbeInterval)
+				}
+			case id := <-networkChanged:
+				log.Printf("Network changed to %s, testing", id)
+				opts.trigger = &id
+				runScheduledTest(opts, notifiers, &outages)
+				opts.trigger = nil
+				if outages.entry != nil && probeDue == nil && reachability == nil {
+					probeDue = time.After(outageProbeInterval)
+				}
+			case <-timer.C:
+				selfMetrics.scheduled(time.Since(next))
+				break wait
+			}
+		}
+	}
+}
+
+// runScheduledTest runs one daemon iteration. Failures are logged, not
+// returned, so that a single bad run never stops the schedule; those for
+// want of a network start or continue an outage.
+func runScheduledTest(opts *options, notifiers []notifier, outages *outageTracker) {
+	start := time.Now()
+	res, err := runSpeedTest(opts)
+	if opts.trigger != nil {
+		res.Trigger, res.Network = triggerNetworkChange, cmp.Or(res.Network, opts.trigger)
+	}
+	selfMetrics.ran(res, err, start)
+	if err != nil {
+		log.Printf("Scheduled test failed: %v", err)
+		exportResult(opts, res, err)
+		outages.failed(res, err, start)
+		return
+	}
+	outages.end(opts, start, false) // The network was back by then
+	printResults(res, opts)
+	log.Printf("Result: download %.*f Mbps, upload %.*f Mbps, latency %s", opts.Precision, res.Download.Mbps, opts.Precision, res.Upload.Mbps, formatLatency(res.IdleLatency))
+	exportResult(opts, res, nil)
+
+	history := recordHistory(opts, res)
+	if len(history) == 0 {
+		return
+	}
+	current := history[len(history)-1]
+	defer enforceRetention(opts.HistoryPath, opts.Retention)
+
+	if anomalies := detectAnomalies(sameNetwork(history[:len(history)-1], current.Network), current, opts.AnomalyThreshold); len(anomalies) > 0 {
+		for _, a := range anomalies {
+			log.Printf("Anomaly: %s", a)
+		}
+		notifyAll(notifiers, anomalyNotification(current, anomalies))
+	}
+}
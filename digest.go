@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Digest schedules, for --digest
+const (
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+)
+
+// Digest formats, for --digest-format
+var digestFormats = []string{"text", "markdown", "html"}
+
+// digestPeriod returns the local day, or the week from Monday, that t is in.
+func digestPeriod(schedule string, t time.Time) (start, end time.Time) {
+	t = t.Local()
+	start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	if schedule == digestWeekly {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+// digest summarizes the runs of one period.
+type digest struct {
+	Schedule         string
+	From, To         time.Time
+	Runs             int
+	Download, Upload [3]float64 // Min, median and max in Mbps
+	WorstLatencyMs   float64    // Highest latency under load
+	WorstLatencyAt   time.Time
+	Anomalies        []string // One per anomalous run, with its time
+	last             historyEntry
+
+	// Of the month so far, see monthAvailability
+	Month        time.Time
+	Availability float64 // Percent
+	Downtime     time.Duration
+	Outages      int
+}
+
+// newDigest summarizes the entries of history from from until to, flagging
+// each run that was anomalous against the runs before it, and reports the
+// availability of the month until to.
+func newDigest(schedule string, history []historyEntry, from, to time.Time, threshold float64) digest {
+	d := digest{Schedule: schedule, From: from, To: to}
+	d.Month, d.Availability, d.Downtime, d.Outages = monthAvailability(history, to)
+	history = speedResults(history)
+	var down, up []float64
+	for i, e := range history {
+		if e.Time.Before(from) || !e.Time.Before(to) || e.Runs > 0 {
+			continue
+		}
+		d.Runs++
+		d.last = e
+		down, up = append(down, e.DownloadMbps), append(up, e.UploadMbps)
+		if l := max(e.DownloadLatencyMs, e.UploadLatencyMs); l > d.WorstLatencyMs {
+			d.WorstLatencyMs, d.WorstLatencyAt = l, e.Time
+		}
+		if anomalies := detectAnomalies(history[:i], e, threshold); len(anomalies) > 0 {
+			texts := make([]string, len(anomalies))
+			for j, a := range anomalies {
+				texts[j] = a.String()
+			}
+			d.Anomalies = append(d.Anomalies, e.Time.Local().Format("Mon 15:04")+": "+strings.Join(texts, ", "))
+		}
+	}
+	if d.Runs > 0 {
+		d.Download = [3]float64{slices.Min(down), median(down), slices.Max(down)}
+		d.Upload = [3]float64{slices.Min(up), median(up), slices.Max(up)}
+	}
+	return d
+}
+
+func (d digest) title() string {
+	return fmt.Sprintf("fast-cli %s digest, %s", d.Schedule, d.From.Format("Mon 2 Jan 2006"))
+}
+
+// render writes the digest as text, Markdown or HTML.
+func (d digest) render(format string) string {
+	var rows [][2]string
+	rows = append(rows, [2]string{"Runs", fmt.Sprint(d.Runs)})
+	if d.Runs > 0 {
+		rows = append(rows,
+			[2]string{"Download", fmt.Sprintf("%.1f / %.1f / %.1f Mbps (min / median / max)", d.Download[0], d.Download[1], d.Download[2])},
+			[2]string{"Upload", fmt.Sprintf("%.1f / %.1f / %.1f Mbps (min / median / max)", d.Upload[0], d.Upload[1], d.Upload[2])},
+		)
+	}
+	if d.WorstLatencyMs > 0 {
+		rows = append(rows, [2]string{"Worst loaded latency", fmt.Sprintf("%.0f ms at %s", d.WorstLatencyMs, d.WorstLatencyAt.Local().Format("Mon 15:04"))})
+	}
+	rows = append(rows, [2]string{"Availability", fmt.Sprintf("%.2f%% in %s, %d outages, %s down", d.Availability, d.Month.Format("January"), d.Outages, d.Downtime.Round(time.Second))})
+	period := fmt.Sprintf("%s to %s", d.From.Format(time.DateTime), d.To.Format(time.DateTime))
+
+	var b strings.Builder
+	switch format {
+	case "markdown":
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n| | |\n|---|---|\n", d.title(), period)
+		for _, r := range rows {
+			fmt.Fprintf(&b, "| %s | %s |\n", r[0], r[1])
+		}
+		if len(d.Anomalies) > 0 {
+			b.WriteString("\n**Anomalies**\n\n")
+			for _, a := range d.Anomalies {
+				fmt.Fprintf(&b, "- %s\n", a)
+			}
+		}
+	case "html":
+		fmt.Fprintf(&b, "<h2>%s</h2>\n<p>%s</p>\n<table>\n", html.EscapeString(d.title()), html.EscapeString(period))
+		for _, r := range rows {
+			fmt.Fprintf(&b, "<tr><th>%s</th><td>%s</td></tr>\n", html.EscapeString(r[0]), html.EscapeString(r[1]))
+		}
+		b.WriteString("</table>\n")
+		if len(d.Anomalies) > 0 {
+			b.WriteString("<h3>Anomalies</h3>\n<ul>\n")
+			for _, a := range d.Anomalies {
+				fmt.Fprintf(&b, "<li>%s</li>\n", html.EscapeString(a))
+			}
+			b.WriteString("</ul>\n")
+		}
+	default:
+		fmt.Fprintf(&b, "%s\n%s\n", d.title(), period)
+		for _, r := range rows {
+			fmt.Fprintf(&b, "%-22s %s\n", r[0]+":", r[1])
+		}
+		if len(d.Anomalies) > 0 {
+			b.WriteString("Anomalies:\n")
+			for _, a := range d.Anomalies {
+				fmt.Fprintf(&b, "  - %s\n", a)
+			}
+		}
+	}
+	return b.String()
+}
+
+// sendDigest delivers the digest of the period from from until to through
+// the notifiers, or prints it when there are none.
+func sendDigest(opts *options, notifiers []notifier, from, to time.Time) {
+	history, err := loadHistory(opts.HistoryPath)
+	if err != nil {
+		log.Printf("Warning: reading history for the digest: %v", err)
+		return
+	}
+	d := newDigest(opts.Digest, history, from, to, opts.AnomalyThreshold)
+	body := d.render(opts.DigestFormat)
+	if len(notifiers) == 0 {
+		fmt.Print(body)
+		return
+	}
+	notifyAll(notifiers, notification{Title: d.title(), Message: body, Format: opts.DigestFormat, Result: d.last})
+	log.Printf("Sent the %s digest of %d runs", opts.Digest, d.Runs)
+}
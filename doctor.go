@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Answers 204 and nothing else when the internet is reachable directly
+	connectivityCheckURL = "http://connectivitycheck.gstatic.com/generate_204"
+	connectivityTimeout  = 5 * time.Second
+)
+
+// errCaptivePortal is returned when something between us and the internet
+// answers for it, typically a hotel or airport login page.
+var errCaptivePortal = errors.New("captive portal detected")
+
+// probeConnectivity fetches the generate_204 URL without following
+// redirects. A portal answers with a redirect or its own page instead.
+func probeConnectivity() error {
+	client := &http.Client{
+		Timeout:       connectivityTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	req, err := http.NewRequest("GET", connectivityCheckURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return withCode(codeNoConnectivity, fmt.Errorf("no internet connectivity: %w", err))
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent && len(body) == 0:
+		return nil
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		return withCode(codeCaptivePortal, fmt.Errorf("%w: redirected to %s, log in through a browser first", errCaptivePortal, resp.Header.Get("Location")))
+	default:
+		return withCode(codeCaptivePortal, fmt.Errorf("%w: connectivity check answered %d with %d bytes instead of 204, log in through a browser first", errCaptivePortal, resp.StatusCode, len(body)))
+	}
+}
+
+// providerHost is the host a provider's server list is fetched from.
+func providerHost(provider string) string {
+	raw := fastComBaseURL
+	switch provider {
+	case providerCloudflare:
+		raw = cloudflareBaseURL
+	case providerLibreSpeed:
+		raw = libreSpeedServerList
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// checkDNSHijack resolves a public host and fails when it only resolves to
+// private addresses, which is how portals and broken DNS filters answer.
+func checkDNSHijack(host string) error {
+	if host == "" || net.ParseIP(host) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return withCode(codeDNSFailure, fmt.Errorf("resolving %s: %w", host, err))
+	}
+	for _, a := range addrs {
+		if !a.IP.IsPrivate() && !a.IP.IsLoopback() && !a.IP.IsLinkLocalUnicast() && !a.IP.IsUnspecified() {
+			return nil
+		}
+	}
+	return withCode(codeDNSHijack, fmt.Errorf("DNS hijack detected: %s resolves to %v, which is not a public address", host, addrs))
+}
+
+// precheck makes sure the internet is actually reachable before a test, so
+// that a portal login page doesn't get measured as a 0.3 Mbps connection.
+// Self-hosted peers are usually on the LAN and are not checked.
+func precheck(opts *options) error {
+	if opts.SkipPrecheck || len(opts.Servers) > 0 || opts.Provider == providerMock {
+		return nil // Nothing to check about a LAN peer or the offline mock
+	}
+	if err := probeConnectivity(); err != nil {
+		return err
+	}
+	return checkDNSHijack(providerHost(opts.Provider))
+}
+
+// doctorCheck is one line of the doctor checklist.
+type doctorCheck struct {
+	Name   string
+	Err    error
+	Warn   bool   // Err is worth knowing about but doesn't break tests
+	Detail string // Shown when the check passed
+}
+
+func newDoctorFlagSet(provider *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli doctor", flag.ContinueOnError)
+	fs.StringVar(provider, "provider", providerFast, "check the `backend` tests would use: "+strings.Join(providerNames, ", "))
+	return fs
+}
+
+// runDoctor implements `fast-cli doctor`: a checklist of what has to work
+// for a speed test to work. It exits with status 1 when a check failed.
+func runDoctor(args []string) error {
+	var provider string
+	if err := parseFlags(newDoctorFlagSet(&provider), args); err != nil {
+		return err
+	}
+	if _, err := parseProviders(provider); err != nil {
+		return err
+	}
+	if err := singleProvider(provider, "doctor"); err != nil {
+		return err
+	}
+
+	checks := []doctorCheck{
+		{Name: "Internet connectivity (no captive portal)", Err: probeConnectivity(), Detail: connectivityCheckURL + " answered 204"},
+		{Name: "DNS answers for " + providerHost(provider), Err: checkDNSHijack(providerHost(provider)), Detail: "resolves to a public address"},
+		doctorProxy(),
+	}
+	servers, err := fetchProviderServers(httpClient, provider, 1, defaultURLCount)
+	if err != nil {
+		checks = append(checks, doctorCheck{Name: "Server list", Err: err})
+	} else {
+		checks = append(checks, doctorCheck{Name: "Server list", Detail: fmt.Sprintf("%d servers offered", len(servers.Targets))})
+		checks = append(checks, doctorServerDNS(servers.Targets))
+	}
+	hosts := []string{providerHost(provider)}
+	if err == nil && len(servers.Targets) > 0 {
+		hosts = append(hosts, targetHostname(servers.Targets[0]))
+	}
+	checks = append(checks, doctorTLS(hosts), doctorClock(), doctorIPv6(hosts))
+	if err == nil && len(servers.Targets) > 0 {
+		checks = append(checks, doctorPathMTU(servers.Targets[0]))
+	} else {
+		checks = append(checks, doctorMTU())
+	}
+
+	failed := 0
+	for _, c := range checks {
+		switch {
+		case c.Err == nil:
+			fmt.Printf("[ OK ] %s: %s\n", c.Name, c.Detail)
+		case c.Warn:
+			fmt.Printf("[WARN] %s: %v\n", c.Name, c.Err)
+		default:
+			fmt.Printf("[FAIL] %s: %v\n", c.Name, c.Err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("\n%d check(s) failed, speed tests will likely fail or be wrong.\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("\nAll checks passed.")
+	return nil
+}
+
+// targetHostname is the host of a server, without the port.
+func targetHostname(t target) string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// doctorProxy reports proxy variables, which fast-cli deliberately ignores:
+// a proxy in the path would be measured instead of the connection.
+func doctorProxy() doctorCheck {
+	c := doctorCheck{Name: "Proxy environment", Detail: "no proxy variables set"}
+	var set []string
+	for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "ALL_PROXY", "https_proxy", "http_proxy", "all_proxy"} {
+		if os.Getenv(name) != "" {
+			set = append(set, name)
+		}
+	}
+	if len(set) > 0 {
+		c.Warn = true
+		c.Err = fmt.Errorf("%s set, but fast-cli connects directly; tests fail where only the proxy may reach the internet", strings.Join(set, ", "))
+	}
+	return c
+}
+
+// doctorServerDNS resolves every test server, since fast.com hands out
+// per-ISP hostnames that a filtering resolver may not know.
+func doctorServerDNS(targets []target) doctorCheck {
+	c := doctorCheck{Name: "DNS answers for the test servers"}
+	var failures []string
+	resolved := map[string]bool{}
+	for _, t := range targets {
+		host := targetHostname(t)
+		if host == "" || resolved[host] {
+			continue
+		}
+		resolved[host] = true
+		if err := checkDNSHijack(host); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		c.Err = errors.New(strings.Join(failures, "; "))
+	}
+	c.Detail = fmt.Sprintf("%d host(s) resolve to public addresses", len(resolved))
+	return c
+}
+
+// doctorTLS connects to each host with certificate verification. A failure
+// means the certificate chain isn't trusted, which is what TLS interception
+// without an installed root looks like. Hosts run by different companies
+// have different issuers, so one issuer for all of them points at an
+// inspecting proxy whose root was installed.
+func doctorTLS(hosts []string) doctorCheck {
+	c := doctorCheck{Name: "TLS without interception"}
+	issuers := map[string]bool{}
+	var seen []string
+	for _, host := range hosts {
+		if host == "" || net.ParseIP(host) != nil {
+			continue
+		}
+		dialer := &net.Dialer{Timeout: connectivityTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, "443"), &tls.Config{ServerName: host})
+		if err != nil {
+			c.Err = fmt.Errorf("%s: %w (a TLS-inspecting proxy or firewall is likely)", host, err)
+			return c
+		}
+		issuer := conn.ConnectionState().PeerCertificates[0].Issuer.String()
+		conn.Close()
+		issuers[issuer] = true
+		seen = append(seen, fmt.Sprintf("%s by %s", host, issuer))
+	}
+	switch {
+	case len(seen) == 0:
+		c.Detail = "nothing to check, servers are addressed by IP"
+	case len(seen) > 1 && len(issuers) == 1:
+		c.Warn = true
+		c.Err = fmt.Errorf("every host is certified by the same issuer (%s), which suggests a TLS-inspecting proxy", seen[0])
+	default:
+		c.Detail = strings.Join(seen, "; ")
+	}
+	return c
+}
+
+// doctorClock compares the local clock with the Date header of the
+// connectivity check's response.
+func doctorClock() doctorCheck {
+	c := doctorCheck{Name: "Clock"}
+	client := &http.Client{Timeout: connectivityTimeout}
+	sent := time.Now()
+	resp, err := client.Head(connectivityCheckURL)
+	if err != nil {
+		c.Warn, c.Err = true, fmt.Errorf("no server to compare with: %w", err)
+		return c
+	}
+	resp.Body.Close()
+	skew, ok := clockSkew(resp, sent)
+	if !ok {
+		c.Warn, c.Err = true, fmt.Errorf("server sent no usable Date header")
+		return c
+	}
+	switch {
+	case skew.Abs() > clockSkewLimit:
+		c.Err = fmt.Errorf("local clock is off by %s, TLS certificates may be rejected and history timestamps are wrong", skew)
+	case skew.Abs() > clockSkewNotice:
+		c.Warn, c.Err = true, fmt.Errorf("local clock is off by %s", skew)
+	default:
+		c.Detail = fmt.Sprintf("within %s of %s", max(skew.Abs(), time.Second), resp.Request.URL.Host)
+	}
+	return c
+}
+
+// doctorIPv6 tries a TCP connection over IPv6 to the first host that has an
+// IPv6 address. Missing IPv6 is only a warning; tests fall back to IPv4.
+func doctorIPv6(hosts []string) doctorCheck {
+	c := doctorCheck{Name: "IPv6", Warn: true}
+	for _, host := range hosts {
+		if host == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
+		addrs, err := net.DefaultResolver.LookupIP(ctx, "ip6", host)
+		cancel()
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+		conn, err := net.DialTimeout("tcp6", net.JoinHostPort(addrs[0].String(), "443"), connectivityTimeout)
+		if err != nil {
+			c.Err = fmt.Errorf("%s has an IPv6 address but connecting to it failed: %w", host, err)
+			return c
+		}
+		conn.Close()
+		c.Detail = fmt.Sprintf("connected to %s over IPv6", host)
+		return c
+	}
+	c.Err = errors.New("no test host could be reached over IPv6, tests use IPv4")
+	return c
+}
+
+// doctorPathMTU probes the path MTU toward a test server, falling back to
+// the interface MTU where probing isn't possible.
+func doctorPathMTU(t target) doctorCheck {
+	m, err := probeTargetMTU(t)
+	if m.MTU == 0 {
+		c := doctorMTU()
+		if err != nil && c.Err == nil {
+			c.Detail += fmt.Sprintf(" (path not probed: %v)", err)
+		}
+		return c
+	}
+	c := doctorCheck{Name: "Path MTU to " + targetHost(t), Detail: m.String()}
+	if m.MTU < ethernetMTU {
+		c.Warn, c.Err = true, fmt.Errorf("%s; poor uploads can mean broken path MTU discovery", m)
+	}
+	return c
+}
+
+// doctorMTU reports the MTU of the interface that routes to the internet.
+// Less than Ethernet's 1500 bytes means PPPoE or a tunnel, where broken
+// path MTU discovery often stalls uploads.
+func doctorMTU() doctorCheck {
+	c := doctorCheck{Name: "Interface MTU"}
+	iface, err := egressInterface("8.8.8.8")
+	if err != nil {
+		c.Warn, c.Err = true, err
+		return c
+	}
+	if iface.MTU < 1500 {
+		c.Warn = true
+		c.Err = fmt.Errorf("%s has MTU %d, below Ethernet's 1500 (PPPoE or VPN); poor uploads can mean broken path MTU discovery", iface.Name, iface.MTU)
+	} else {
+		c.Detail = fmt.Sprintf("%s has MTU %d", iface.Name, iface.MTU)
+	}
+	return c
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// doer sends HTTP requests. The functions that talk to the test servers and
+// APIs take the doer to send through, httpClient outside tests, so a test
+// can answer from canned responses and another stack (custom dialers, uTLS,
+// SOCKS chains) fits in without touching the measurement code. *http.Client
+// is one.
+type doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// doerFunc adapts a function to doer, the way http.HandlerFunc does for
+// handlers, e.g. to answer from recorded responses.
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+// timeoutDoer gives a request without a deadline of its own one after
+// timeout, like http.Client.Timeout but leaving transfer chunks free to set
+// a longer one.
+type timeoutDoer struct {
+	base    doer
+	timeout time.Duration
+}
+
+func (d timeoutDoer) Do(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); ok {
+		return d.base.Do(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), d.timeout)
+	return doCancelable(d.base, req.WithContext(ctx), cancel)
+}
+
+// doCancelable sends req and calls cancel once the response body is closed,
+// or right away if there is no response.
+func doCancelable(d doer, req *http.Request, cancel func()) (*http.Response, error) {
+	resp, err := d.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cannedResponse answers req with status and body, without a network.
+func cannedResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func TestFetchAndDownloadWithDoer(t *testing.T) {
+	out := statusOut
+	statusOut = io.Discard
+	t.Cleanup(func() { statusOut = out })
+
+	const servers = `{
+		"client": {"ip": "192.0.2.10", "asn": "64496", "location": {"city": "Sydney", "country": "AU"}},
+		"targets": [
+			{"name": "https://a.example/speedtest?c=au", "url": "https://a.example/speedtest?c=au", "location": {"city": "Sydney", "country": "AU"}},
+			{"name": "https://b.example/speedtest?c=au", "url": "https://b.example/speedtest?c=au", "location": {"city": "Melbourne", "country": "AU"}}
+		]
+	}`
+	var listRequests int
+	var chunkRequests atomic.Int64 // Streams run in goroutines of their own
+	client := doerFunc(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case req.URL.Host == "api.fast.com":
+			listRequests++
+			if got := req.URL.Query().Get("urlCount"); got != "2" {
+				return cannedResponse(req, http.StatusBadRequest, []byte("urlCount "+got)), nil
+			}
+			return cannedResponse(req, http.StatusOK, []byte(servers)), nil
+		case strings.Contains(req.URL.Path, "/speedtest/range/"):
+			var from, to int
+			if _, err := fmt.Sscanf(req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:], "%d-%d", &from, &to); err != nil {
+				return cannedResponse(req, http.StatusBadRequest, []byte(err.Error())), nil
+			}
+			chunkRequests.Add(1)
+			return cannedResponse(req, http.StatusOK, make([]byte, to-from+1)), nil
+		}
+		return nil, fmt.Errorf("unexpected request to %s", req.URL)
+	})
+
+	resp, err := fetchTestServers(client, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listRequests != 1 || len(resp.Targets) != 2 || resp.Client.IP != "192.0.2.10" {
+		t.Fatalf("after %d requests: %d targets, client %+v; want 2 targets for 192.0.2.10", listRequests, len(resp.Targets), resp.Client)
+	}
+	if resp.Targets[1].Location.City != "Melbourne" {
+		t.Errorf("second target in %s, want Melbourne", resp.Targets[1].Location.City)
+	}
+
+	const chunkSize = 1 << 16
+	result, err := performDownloadTest(client, resp.Targets, 500*time.Millisecond, chunkSize, transferLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Bytes < chunkSize/2 || result.Mbps <= 0 || chunkRequests.Load() == 0 {
+		t.Errorf("downloaded %d bytes at %.1f Mbps in %d requests, want some of each", result.Bytes, result.Mbps, chunkRequests.Load())
+	}
+	if result.FailedRequests != 0 {
+		t.Errorf("%d failed requests, want none", result.FailedRequests)
+	}
+}
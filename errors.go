@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// errorCode names the cause of a failed test, so that scripts can branch on
+// it instead of matching log text. Each code has its own exit status.
+type errorCode string
+
+const (
+	codeNoConnectivity  errorCode = "NO_CONNECTIVITY"
+	codeCaptivePortal   errorCode = "CAPTIVE_PORTAL"
+	codeDNSFailure      errorCode = "DNS_FAILURE"
+	codeDNSHijack       errorCode = "DNS_HIJACK"
+	codeAPIUnreachable  errorCode = "API_UNREACHABLE"
+	codeTokenRejected   errorCode = "TOKEN_REJECTED"
+	codeAPIError        errorCode = "API_ERROR"
+	codeNoServers       errorCode = "NO_SERVERS"
+	codeAllPingsFailed  errorCode = "ALL_PINGS_FAILED"
+	codeDownloadStalled errorCode = "DOWNLOAD_STALLED"
+	codeUploadStalled   errorCode = "UPLOAD_STALLED"
+	codeLinkBusy        errorCode = "LINK_BUSY"
+	codeStreamFailed    errorCode = "STREAM_FAILED"
+	codeTimedOut        errorCode = "TIMED_OUT"
+	codeRedirected      errorCode = "REDIRECTED"
+	codeUnexpectedHost  errorCode = "UNEXPECTED_HOST"
+)
+
+// errorExitStatus maps codes to exit statuses, which start at 10 to stay
+// clear of 1 (thresholds not met, other errors) and 2 (usage).
+var errorExitStatus = map[errorCode]int{
+	codeNoConnectivity:  10,
+	codeCaptivePortal:   11,
+	codeDNSFailure:      12,
+	codeDNSHijack:       13,
+	codeAPIUnreachable:  14,
+	codeTokenRejected:   15,
+	codeAPIError:        16,
+	codeNoServers:       17,
+	codeAllPingsFailed:  18,
+	codeDownloadStalled: 19,
+	codeUploadStalled:   20,
+	codeLinkBusy:        21,
+	codeStreamFailed:    22,
+	codeTimedOut:        23,
+	codeRedirected:      24,
+	codeUnexpectedHost:  25,
+}
+
+// codedError attaches an errorCode to an error.
+type codedError struct {
+	Code errorCode
+	Err  error
+}
+
+func (e *codedError) Error() string { return e.Err.Error() }
+func (e *codedError) Unwrap() error { return e.Err }
+
+func withCode(code errorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{Code: code, Err: err}
+}
+
+// errorCodeOf returns the code of the first coded error in err's chain, or
+// "" if there is none.
+func errorCodeOf(err error) errorCode {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return ""
+}
+
+// apiStatusCode classifies an unexpected HTTP status from a server list API.
+func apiStatusCode(status int) errorCode {
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return codeTokenRejected
+	}
+	return codeAPIError
+}
+
+func exitStatus(err error) int {
+	if status, ok := errorExitStatus[errorCodeOf(err)]; ok {
+		return status
+	}
+	return 1
+}
+
+// fatal logs err and exits with its status.
+func fatal(err error) {
+	log.Printf("Error: %v", err)
+	os.Exit(exitStatus(err))
+}
+
+type jsonError struct {
+	Code    errorCode `json:"code,omitempty"`
+	Message string    `json:"message"`
+}
+
+func newJSONErrors(errs []error) []jsonError {
+	var out []jsonError
+	for _, err := range errs {
+		out = append(out, jsonError{Code: errorCodeOf(err), Message: err.Error()})
+	}
+	return out
+}
+
+// Status is "degraded" when a phase failed or lost streams, so that its
+// numbers understate the connection, and "ok" otherwise.
+func (res testResult) Status() string {
+	if len(res.Errors) > 0 || res.Download.FailedStreams > 0 || res.Upload.FailedStreams > 0 {
+		return "degraded"
+	}
+	return "ok"
+}
+
+// strictCheck fails a degraded result for --strict: any phase error, any
+// stream that died, or more than maxErrors percent of failed requests.
+func (res testResult) strictCheck(maxErrors float64) error {
+	if len(res.Errors) > 0 {
+		return res.Errors[0]
+	}
+	for _, phase := range []struct {
+		name string
+		p    phaseResult
+	}{{"download", res.Download}, {"upload", res.Upload}} {
+		switch {
+		case phase.p.FailedStreams > 0:
+			return withCode(codeStreamFailed, fmt.Errorf("%s: %d of %d streams died (--strict)", phase.name, phase.p.FailedStreams, phase.p.Streams))
+		case phase.p.ErrorRate() > maxErrors:
+			return withCode(codeStreamFailed, fmt.Errorf("%s: %.1f%% of requests failed (--strict)", phase.name, phase.p.ErrorRate()))
+		}
+	}
+	return nil
+}
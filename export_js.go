@@ -0,0 +1,71 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"syscall/js"
+)
+
+// jsTest lets one test run at a time: tests keep their state in globals.
+var jsTest sync.Mutex
+
+// exportEntryPoints sets fastCLI on the page's global object for a frontend
+// to run tests with, and reports that it did, see main. fastCLI.run takes
+// the flags of `fast-cli run` as strings and returns a promise of the
+// result, parsed from what --format json writes. fastCLI.version is the
+// version. Progress goes to the console, as it goes to stderr elsewhere.
+func exportEntryPoints() bool {
+	js.Global().Set("fastCLI", js.ValueOf(map[string]any{
+		"version": version,
+		"run":     js.FuncOf(runFromJS),
+	}))
+	return true
+}
+
+func runFromJS(_ js.Value, args []js.Value) any {
+	flags := make([]string, len(args))
+	for i, a := range args {
+		flags[i] = a.String()
+	}
+	// The test waits on fetches, which only the event loop completes, so it
+	// can't run in the callback itself
+	executor := js.FuncOf(func(_ js.Value, settle []js.Value) any {
+		resolve, reject := settle[0], settle[1]
+		go func() {
+			doc, err := runJSTest(flags)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(js.Global().Get("JSON").Call("parse", string(doc)))
+		}()
+		return nil
+	})
+	defer executor.Release() // Promise calls it right away
+	return js.Global().Get("Promise").New(executor)
+}
+
+// runJSTest runs a test with flags as runTest does, without the sinks and
+// hooks setup or exiting, and returns its result as JSON.
+func runJSTest(flags []string) ([]byte, error) {
+	if !jsTest.TryLock() {
+		return nil, errors.New("a test is already running")
+	}
+	defer jsTest.Unlock()
+	opts, err := parseOptions(flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := configureConnections(opts); err != nil {
+		return nil, err
+	}
+	applyLowResource(opts)
+	res, err := runSpeedTest(opts)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(newJSONResult(res, opts.Plan))
+}
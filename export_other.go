@@ -0,0 +1,7 @@
+//go:build !js
+
+package main
+
+// exportEntryPoints exports entry points for a frontend under js/wasm only,
+// see export_js.go.
+func exportEntryPoints() bool { return false }
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Delays of RFC 8305, Happy Eyeballs Version 2
+const (
+	resolutionDelay        = 50 * time.Millisecond  // How long an A answer waits for the AAAA one
+	connectionAttemptDelay = 250 * time.Millisecond // Between connection attempts
+)
+
+// connectReport is how the last connection to a host was made: the address
+// family that won and what became of each family.
+type connectReport struct {
+	Family  string // "IPv6" or "IPv4"
+	Address string
+	IPv6    string // Outcome of the family, e.g. "connected" or the error
+	IPv4    string
+}
+
+func (r connectReport) outcome(family string) string {
+	if family == "IPv6" {
+		return r.IPv6
+	}
+	return r.IPv4
+}
+
+// String is e.g. "over IPv4 (IPv6: failed: connect: network is unreachable)",
+// the other family only mentioned when something went wrong with it.
+func (r connectReport) String() string {
+	if r.Family == "" {
+		return ""
+	}
+	other := otherFamily(r.Family)
+	switch outcome := r.outcome(other); outcome {
+	case "", "not tried", "slower":
+		return "over " + r.Family
+	default:
+		return fmt.Sprintf("over %s (%s: %s)", r.Family, other, outcome)
+	}
+}
+
+// connectReports holds a connectReport per dialed host:port.
+var connectReports sync.Map
+
+// connectReportFor returns the connectReport of the server at rawURL, or the
+// zero one if no connection to it was dialed.
+func connectReportFor(rawURL string) connectReport {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return connectReport{}
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	r, _ := connectReports.Load(net.JoinHostPort(u.Hostname(), port))
+	report, _ := r.(connectReport)
+	return report
+}
+
+func otherFamily(family string) string {
+	if family == "IPv6" {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// dialFailure is the gist of a failed connection attempt, without the
+// addresses that make net.OpError messages long.
+func dialFailure(err error) string {
+	var op *net.OpError
+	if errors.As(err, &op) && op.Err != nil {
+		return op.Err.Error()
+	}
+	return err.Error()
+}
+
+func familyOf(ip netip.Addr) string {
+	if ip.Unmap().Is4() {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// testDialer dials the connections of httpClient.
+var testDialer = &happyEyeballs{Dialer: net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}}
+
+// configureConnections applies the connection options of opts to
+// testDialer and testTransport.
+func configureConnections(opts *options) error {
+	congestion, active, err := congestionControl(opts.Congestion)
+	if err != nil {
+		return err
+	}
+	buffers, err := socketBufferHook(opts.SendBuffer, opts.ReceiveBuffer)
+	if err != nil {
+		return err
+	}
+	testDialer.Control, opts.congestion = chainControl(congestion, buffers), active
+	if opts.ForceNewConns {
+		useHTTP1Only()
+	}
+	return nil
+}
+
+// happyEyeballs dials TCP the way RFC 8305 describes: AAAA and A records are
+// looked up at once, addresses of both families are tried alternately,
+// IPv6 first, each attempt starting connectionAttemptDelay after the last or
+// as soon as it fails, and the first connection wins. Which one did is kept
+// in connectReports.
+type happyEyeballs struct {
+	net.Dialer
+}
+
+func (d *happyEyeballs) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || (network != "tcp" && network != "tcp4" && network != "tcp6") {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return d.Dialer.DialContext(ctx, network, addr) // A literal, nothing to race
+	}
+
+	report := connectReport{IPv6: "not tried", IPv4: "not tried"}
+	outcome := func(family, text string) {
+		if family == "IPv6" {
+			report.IPv6 = text
+		} else {
+			report.IPv4 = text
+		}
+	}
+	addrs, err := d.resolve(ctx, host, network, outcome)
+	if err != nil {
+		return nil, err
+	}
+
+	type attempt struct {
+		conn net.Conn
+		ip   netip.Addr
+		err  error
+	}
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan attempt)
+	pending := 0
+	start := func(ip netip.Addr) {
+		pending++
+		go func() {
+			conn, err := d.Dialer.DialContext(raceCtx, network, net.JoinHostPort(ip.String(), port))
+			select {
+			case results <- attempt{conn, ip, err}:
+			case <-raceCtx.Done():
+				if conn != nil {
+					conn.Close() // Lost the race
+				}
+			}
+		}()
+	}
+
+	var lastErr error
+	started := map[string]bool{}
+	next := 0
+	launch := time.After(0)
+	for next < len(addrs) || pending > 0 {
+		select {
+		case <-launch:
+			started[familyOf(addrs[next])] = true
+			start(addrs[next])
+			next++
+			launch = nil
+			if next < len(addrs) {
+				launch = time.After(connectionAttemptDelay)
+			}
+		case a := <-results:
+			pending--
+			if a.err != nil {
+				lastErr = a.err
+				outcome(familyOf(a.ip), "failed: "+dialFailure(a.err))
+				if next < len(addrs) {
+					launch = time.After(0) // Move on without waiting
+				}
+				continue
+			}
+			report.Family, report.Address = familyOf(a.ip), a.ip.Unmap().String()
+			outcome(report.Family, "connected")
+			if other := otherFamily(report.Family); started[other] && report.outcome(other) == "not tried" {
+				outcome(other, "slower")
+			}
+			connectReports.Store(addr, report)
+			return a.conn, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	connectReports.Store(addr, report)
+	return nil, lastErr
+}
+
+// resolve looks up the addresses of host for network, AAAA and A at once,
+// and orders them for the race: families alternating, IPv6 first.
+func (d *happyEyeballs) resolve(ctx context.Context, host, network string, outcome func(family, text string)) ([]netip.Addr, error) {
+	type answer struct {
+		family string
+		addrs  []netip.Addr
+		err    error
+	}
+	var lookups []string
+	if network != "tcp4" {
+		lookups = append(lookups, "ip6")
+	} else {
+		outcome("IPv6", "not allowed")
+	}
+	if network != "tcp6" {
+		lookups = append(lookups, "ip4")
+	} else {
+		outcome("IPv4", "not allowed")
+	}
+	answers := make(chan answer, len(lookups))
+	for _, network := range lookups {
+		go func() {
+			addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+			family := "IPv4"
+			if network == "ip6" {
+				family = "IPv6"
+			}
+			answers <- answer{family, addrs, err}
+		}()
+	}
+
+	var v6, v4 []netip.Addr
+	var lastErr error
+	var wait <-chan time.Time
+	for received := 0; received < len(lookups); received++ {
+		var a answer
+		select {
+		case a = <-answers:
+		case <-wait:
+			outcome("IPv6", "no AAAA answer in time")
+			received = len(lookups)
+			continue
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		switch {
+		case a.err != nil || len(a.addrs) == 0:
+			lastErr = cmp.Or(a.err, lastErr)
+			outcome(a.family, "no address")
+		case a.family == "IPv6":
+			v6 = a.addrs
+		default:
+			v4 = a.addrs
+		}
+		if a.family == "IPv4" && len(v4) > 0 && received+1 < len(lookups) {
+			wait = time.After(resolutionDelay) // Give AAAA a little longer
+		}
+	}
+	if len(v6)+len(v4) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no addresses for %s", host)
+		}
+		return nil, lastErr
+	}
+
+	addrs := make([]netip.Addr, 0, len(v6)+len(v4))
+	for i := 0; i < max(len(v6), len(v4)); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+	return addrs, nil
+}
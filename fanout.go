@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Config file sections named "sink NAME" configure more sinks, each with the
+// sink flags of registerSinkFlags and the runs it gets:
+//
+//	[sink slack]
+//	on = failure
+//	push-url = https://hooks.slack.com/services/...
+//	push-template = @/etc/fast-cli/slack.tmpl
+const sinkSectionPrefix = "sink "
+
+// Values of on in a [sink] section
+const (
+	sinkOnAll     = "all"
+	sinkOnSuccess = "success"
+	sinkOnFailure = "failure" // Failed or degraded, or missed a threshold
+)
+
+// loadSinkSections reads the [sink] sections of the config file into
+// opts.sinks, each a copy of opts with only its own sinks set.
+func loadSinkSections(opts *options) error {
+	sections, err := loadConfig(opts.ConfigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Reported by parseFlags when it matters
+	}
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	var names []string
+	for name := range sections {
+		if strings.HasPrefix(name, sinkSectionPrefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, section := range names {
+		s := *opts
+		s.sinks, s.PushTo, s.NotifyWebhooks = nil, "", nil // The command line's only
+		s.PushHeaders, s.Syslog, s.Kafka, s.NATS = nil, syslogTarget{}, kafkaTarget{}, natsTarget{}
+		s.sinkName = strings.TrimSpace(strings.TrimPrefix(section, sinkSectionPrefix))
+		fs := flag.NewFlagSet(section, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		registerSinkFlags(fs, &s)
+		fs.StringVar(&s.SinkOn, "on", sinkOnAll, "")
+		for _, e := range sections[section] {
+			if fs.Lookup(e.Key) == nil {
+				return fmt.Errorf("%s:%d: %s isn't a setting of a sink", opts.ConfigPath, e.Line, e.Key)
+			}
+			if err := fs.Set(e.Key, e.Value); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for %s: %v", opts.ConfigPath, e.Line, e.Value, e.Key, err)
+			}
+		}
+		if !slices.Contains([]string{sinkOnAll, sinkOnSuccess, sinkOnFailure}, s.SinkOn) {
+			return fmt.Errorf("[%s]: on must be %s, %s or %s", section, sinkOnAll, sinkOnSuccess, sinkOnFailure)
+		}
+		if len(s.exports(testResult{}, nil)) == 0 {
+			return fmt.Errorf("[%s] configures no sink", section)
+		}
+		if err := s.validateTest(); err != nil {
+			return fmt.Errorf("[%s]: %w", section, err)
+		}
+		if err := s.resolveSecrets(); err != nil {
+			return fmt.Errorf("[%s]: %w", section, err)
+		}
+		if s.pushTemplate, err = loadPushTemplate(s.PushTemplate); err != nil {
+			return fmt.Errorf("[%s]: %w", section, err)
+		}
+		opts.sinks = append(opts.sinks, &s)
+	}
+	return nil
+}
+
+// wants reports whether the sinks of opts get this run.
+func (opts *options) wants(res testResult, testErr error) bool {
+	failed := testErr != nil || res.Status() == "degraded" || len(opts.Thresholds.check(res)) > 0
+	switch opts.SinkOn {
+	case sinkOnSuccess:
+		return !failed
+	case sinkOnFailure:
+		return failed
+	}
+	return true
+}
+
+// warn logs a failed sink, with its [sink] section if it has one.
+func (opts *options) warn(format string, args ...any) {
+	if opts.sinkName != "" {
+		format = "sink " + opts.sinkName + ": " + format
+	}
+	log.Printf("Warning: "+format, args...)
+}
+
+// exportResult hands the outcome of a test to the sinks of the command line
+// and of every [sink] section that wants it, all at once, and returns when
+// they're done.
+func exportResult(opts *options, res testResult, testErr error) {
+	var wg sync.WaitGroup
+	for _, s := range append([]*options{opts}, opts.sinks...) {
+		if !s.wants(res, testErr) {
+			continue
+		}
+		for _, job := range s.exports(res, testErr) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				job()
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// sinkFileName makes a [sink] name safe for the names of its outbox files.
+var sinkFileName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// sinkID names a sink of opts of the given kind in file names and metrics,
+// prefixed with the name of its [sink] section.
+func (opts *options) sinkID(kind string) string {
+	if opts.sinkName == "" {
+		return kind
+	}
+	return sinkFileName.ReplaceAllString(opts.sinkName, "_") + "-" + kind
+}
+
+// appendCSV appends e to the CSV file at path in the columns of history
+// export, writing the header first into a new file.
+func appendCSV(path string, e historyEntry) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		header := make([]string, len(historyCSVColumns))
+		for i, col := range historyCSVColumns {
+			header[i] = col.Name
+		}
+		w.Write(header)
+	}
+	row := make([]string, len(historyCSVColumns))
+	for i, col := range historyCSVColumns {
+		row[i] = col.Get(&e)
+	}
+	w.Write(row)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
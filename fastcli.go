@@ -1,18 +1,23 @@
 package main
 
 import (
-	"bytes"
-	crand "crypto/rand" // aliased to avoid conflict with math/rand if used
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httptrace"
+	"os"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	"context"
 )
 
 const (
@@ -23,13 +28,20 @@ const (
 	numServersToTest = 3                                  // Number of top servers to use for actual tests
 
 	// Test Configuration
-	downloadTestDuration   = 15 * time.Second     // Duration for the download test
-	downloadChunkSizeBytes = 25 * 1024 * 1024     // 25 MiB chunk size for download
-	uploadTestDuration     = 15 * time.Second     // Duration for the upload test
-	uploadChunkSizeBytes   = 10 * 1024 * 1024     // 10 MiB chunk size for upload
+	downloadTestDuration   = 15 * time.Second // Duration for the download test
+	downloadChunkSizeBytes = 25 * 1024 * 1024 // 25 MiB chunk size for download
+	uploadTestDuration     = 15 * time.Second // Duration for the upload test
+	uploadChunkSizeBytes   = 10 * 1024 * 1024 // 10 MiB chunk size for upload
+
+	// Latency Configuration
+	idleLatencySamples    = 5                      // Sequential pings to the best server for idle latency/jitter
+	loadedLatencyInterval = 500 * time.Millisecond // Ping interval while download/upload are running
+	defaultPingTimeout    = 3 * time.Second        // Per ping during server selection
+	maxParallelPings      = 8                      // Servers pinged at once during selection
+	selectionTimeout      = 30 * time.Second       // For pinging and ranking servers as a whole
 
 	// Network
-	httpClientTimeout = 60 * time.Second
+	httpClientTimeout = 60 * time.Second // For requests without a deadline of their own
 	userAgent         = "go-speedtest-cli/0.1"
 )
 
@@ -54,26 +66,64 @@ type target struct {
 	Name     string   `json:"name"`
 	URL      string   `json:"url"`
 	Location location `json:"location"`
+	Provider string   `json:"-"` // One of providerNames, empty for fast.com and fast-cli serve
 }
 
 // Ping Result Structure
 type pingedTarget struct {
 	Target  target
-	Latency time.Duration
+	Latency time.Duration // Application round trip, over an open connection unless --ping-warmup=false
+	Setup   time.Duration // DNS, TCP and TLS of the connection the pings went over, 0 if it was reused
 	Err     error
+	Connect connectReport // How the connection the ping went over was made
+	// From the short download of --rank combined, 0 if not probed
+	ProbeMbps float64
+}
+
+// testResult collects everything measured during one run
+type testResult struct {
+	ID              string // Random UUID identifying this run
+	Provider        string // Backend tested against, one of providerNames
+	Via             string // Interface or proxy of a --compare-via run, empty on the default route
+	Tags            resultTags
+	Seed            uint64
+	StartedAt       time.Time
+	EndedAt         time.Time
+	Servers         []pingedTarget // Servers selected for the test, best first
+	AvgPing         time.Duration  // Average selection ping to the selected servers
+	Client          clientInfo     // As reported by the server list API
+	Download        phaseResult
+	Upload          phaseResult
+	IdleLatency     latencyStats
+	DownloadLatency latencyStats // Latency while the download test was saturating the link
+	UploadLatency   latencyStats // Latency while the upload test was saturating the link
+	PathMTU         pathMTU      // Only probed with --pmtu, zero otherwise
+	Congestion      string       // TCP congestion control of the test connections, if known
+	SendBuffer      int          // Socket buffers the kernel granted for --sndbuf and --rcvbuf
+	ReceiveBuffer   int
+	WiFi            *wifiInfo          // Nil unless the test went out over Wi-Fi
+	Background      *backgroundTraffic // Other traffic just before the test, nil if not measured
+	Redirects       []redirectHop      // Followed by the test's requests
+	ClockSkew       time.Duration      // Of the local clock from the server list API's, when past clockSkewNotice
+	Trigger         string             // Why the daemon ran the test out of schedule, empty on schedule
+	Network         *networkIdentity   // The test went out on, nil if it couldn't be told
+	Errors          []error            // Phases that failed while the test carried on
 }
 
-// HTTP Client
-var httpClient = &http.Client{
-	Timeout: httpClientTimeout,
-	Transport: &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
-		ForceAttemptHTTP2:   true,
-	},
+// LoadedLatencySamples returns every round trip measured during both saturation phases.
+func (r testResult) LoadedLatencySamples() []time.Duration {
+	samples := append([]time.Duration(nil), r.DownloadLatency.Samples...)
+	return append(samples, r.UploadLatency.Samples...)
 }
 
+// statusOut receives progress messages. It is stdout for the text format
+// and stderr for machine-readable formats, so that stdout stays parseable.
+var statusOut io.Writer = os.Stdout
+
+// httpClient sends every request of a test. --compare-via and --har swap it
+// for the duration of a test.
+var httpClient doer = timeoutDoer{base: &http.Client{Transport: testTransport, CheckRedirect: redirects.check}, timeout: httpClientTimeout}
+
 // modifySpeedtestURL helper to change /speedtest to /speedtest/newSegment
 // e.g., /speedtest?query -> /speedtest/range/0-0?query
 // or /speedtest -> /speedtest/range/0-0
@@ -91,8 +141,9 @@ func modifySpeedtestURL(originalURL string, pathSegmentToAdd string) string {
 
 }
 
-func fetchTestServers() ([]target, error) {
-	apiURL := fmt.Sprintf("%s?https=true&token=%s&urlCount=%d", fastComBaseURL, fastComToken, defaultURLCount)
+// fetchTestServers asks fast.com for count candidate servers.
+func fetchTestServers(client doer, count int) (*apiResponse, error) {
+	apiURL := fmt.Sprintf("%s?https=true&token=%s&urlCount=%d", fastComBaseURL, fastComToken, count)
 
 	req, err := http.NewRequest("GET", apiURL, nil)
 	if err != nil {
@@ -100,66 +151,79 @@ func fetchTestServers() ([]target, error) {
 	}
 	req.Header.Set("User-Agent", userAgent)
 
-	resp, err := httpClient.Do(req)
+	sent := time.Now()
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("fetching server list: %w", err)
+		return nil, withCode(codeAPIUnreachable, fmt.Errorf("fetching server list: %w", err))
 	}
 	defer resp.Body.Close()
+	clockCheck.observe(resp, sent)
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server list API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, withCode(apiStatusCode(resp.StatusCode), fmt.Errorf("server list API request failed with status %d: %s", resp.StatusCode, string(bodyBytes)))
 	}
 
 	var apiResp apiResponse
 	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("decoding server list JSON: %w", err)
+		return nil, withCode(codeAPIError, fmt.Errorf("decoding server list JSON: %w", err))
 	}
 
-	return apiResp.Targets, nil
+	return &apiResp, nil
 }
 
-func measurePings(targetsToPing []target) []pingedTarget {
-	var wg sync.WaitGroup
-	resultsChan := make(chan pingedTarget, len(targetsToPing))
-
-	for _, t := range targetsToPing {
-		wg.Add(1)
-		go func(srv target) {
-			defer wg.Done()
-			pingURL := modifySpeedtestURL(srv.URL, "/range/0-0")
+// pingOnce issues a single zero-length range request against a server and
+// returns the observed round-trip time.
+func pingOnce(ctx context.Context, client doer, srv target) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.pingURL(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating ping request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
 
-			req, err := http.NewRequest("GET", pingURL, nil)
-			if err != nil {
-				resultsChan <- pingedTarget{Target: srv, Err: fmt.Errorf("creating ping request: %w", err)}
-				return
-			}
-			req.Header.Set("User-Agent", userAgent)
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
 
-			start := time.Now()
-			resp, err := httpClient.Do(req)
-			latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) // Ensure body is read and closed
 
-			if err != nil {
-				resultsChan <- pingedTarget{Target: srv, Latency: latency, Err: err}
-				return
-			}
-			defer resp.Body.Close()
-			io.Copy(io.Discard, resp.Body) // Ensure body is read and closed
+	if resp.StatusCode != http.StatusOK {
+		return latency, fmt.Errorf("ping failed with status %d", resp.StatusCode)
+	}
+	return latency, nil
+}
 
-			if resp.StatusCode != http.StatusOK {
-				resultsChan <- pingedTarget{Target: srv, Latency: latency, Err: fmt.Errorf("ping failed with status %d", resp.StatusCode)}
-				return
+// pingWarm pings srv and returns the round trip and the time it took to set
+// up the connection for it. With warmup, the first ping only opens the
+// connection and a second one over it gives the round trip.
+func pingWarm(ctx context.Context, client doer, srv target, warmup bool) (rtt, setup time.Duration, err error) {
+	var getConn time.Time
+	traceCtx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { getConn = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				setup = time.Since(getConn)
 			}
-			resultsChan <- pingedTarget{Target: srv, Latency: latency}
-		}(t)
+		},
+	})
+	rtt, err = pingOnce(traceCtx, client, srv)
+	if err != nil || !warmup {
+		return rtt, setup, err
 	}
+	rtt, err = pingOnce(ctx, client, srv)
+	return rtt, setup, err
+}
 
-	wg.Wait()
-	close(resultsChan)
-
+// measurePings pings every target, at most maxParallelPings at a time and
+// each for up to timeout, and returns those that answered, nearest first.
+// Once ctx is done, the pings left fail.
+func measurePings(ctx context.Context, targetsToPing []target, timeout time.Duration, warmup bool) []pingedTarget {
 	var pingedTargetsResult []pingedTarget
-	for res := range resultsChan {
+	for res := range pingCandidates(ctx, targetsToPing, timeout, warmup) {
 		pingedTargetsResult = append(pingedTargetsResult, res)
 	}
 
@@ -180,272 +244,819 @@ func measurePings(targetsToPing []target) []pingedTarget {
 	return successfulPings
 }
 
-func performDownloadTest(servers []target, testDuration time.Duration, chunkSize int) (float64, error) {
+// pingCandidates pings every target, maxParallelPings at once, and sends
+// each outcome as it arrives, failures too, closing the channel after the
+// last.
+func pingCandidates(ctx context.Context, targetsToPing []target, timeout time.Duration, warmup bool) <-chan pingedTarget {
+	var wg sync.WaitGroup
+	resultsChan := make(chan pingedTarget, len(targetsToPing))
+	slots := make(chan struct{}, maxParallelPings)
+	rec, client := recording, httpClient
+	if replay := mockReplay(); replay != nil {
+		return replay.replayPings(targetsToPing)
+	}
+
+	for _, t := range targetsToPing {
+		wg.Add(1)
+		go func(srv target) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			pingCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			latency, setup, err := pingWarm(pingCtx, client, srv, warmup)
+			if err != nil && errors.Is(pingCtx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("%w within %s", errNoAnswer, timeout)
+				if ctx.Err() != nil {
+					err = errSelectionTimedOut
+				}
+			}
+			pt := pingedTarget{Target: srv, Latency: latency, Setup: setup, Err: err, Connect: connectReportFor(srv.URL)}
+			rec.ping(pt)
+			resultsChan <- pt
+		}(t)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultsChan)
+	}()
+	return resultsChan
+}
+
+// performDownloadTest downloads from all servers in parallel through client
+// for testDuration, or until limits.MaxBytes have arrived if it is positive.
+func performDownloadTest(client doer, servers []target, testDuration time.Duration, chunkSize int, limits transferLimits) (phaseResult, error) {
 	if len(servers) == 0 {
-		return 0, fmt.Errorf("no servers available for download test")
+		return phaseResult{}, fmt.Errorf("no servers available for download test")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), testDuration)
 	defer cancel()
 
+	streams := newStreamSet(servers, limits)
 	var wg sync.WaitGroup
 	var totalBytesDownloaded int64
-	var totalBytesDownloadedMutex sync.Mutex // Mutex still fine for sum, or use atomic.AddInt64
-	errorsChan := make(chan error, len(servers)*5) // Increased buffer in case of multiple errors per goroutine
-
-	fmt.Printf("Starting download from %d server(s) for %s, chunk size %d bytes...\n", len(servers), testDuration, chunkSize)
+	var totalBytesDownloadedMutex sync.Mutex          // Mutex still fine for sum, or use atomic.AddInt64
+	errorsChan := make(chan error, streams.limit()*5) // Increased buffer in case of multiple errors per goroutine
+	var liveBytes int64                               // Updated as bytes arrive, for per-interval samples
+	var requests, stalls atomic.Int64
+	conns := newConnTracker()
+	samplesChan := sampleThroughput(ctx, &liveBytes, throughputSampleInterval)
+	usageChan := sampleResources(ctx, throughputSampleInterval)
+	capped := stopAtDataCap(ctx, cancel, &liveBytes, limits.MaxBytes)
+	stable := stopWhenStable(ctx, cancel, &liveBytes, limits.Stabilize)
+	reportProgress(ctx, &liveBytes, "Download", testDuration, limits)
+	start := time.Now()
+
+	lang.fprintf(statusOut, "Starting download from %d server(s) for %s, chunk size %d bytes...\n", len(servers), testDuration, chunkSize)
+
+	streams.start(ctx, &wg, &liveBytes, func(stop context.Context, s target, stats *serverStats, rng *rand.Rand) {
+		defer wg.Done()
+		stats.Host = targetHost(s)
+		var streamStalls int
+		streamStart := time.Now()
+		defer func() { stats.Active = time.Since(streamStart) }()
+		chunkURL, reader := s.downloadURL(chunkSize), newChunkReader(&liveBytes)
+		if limits.Integrity {
+			reader.sample, stats.Integrity = &chunkSampler{}, newStreamIntegrity()
+		}
+		for {
+			select {
+			case <-stop.Done(): // Test duration elapsed, explicitly cancelled or the stream removed
+				return
+			default:
+				// Continue downloading next chunk
+			}
 
-	for _, srv := range servers {
-		wg.Add(1)
-		go func(s target) {
-			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done(): // Test duration elapsed or explicitly cancelled
-					return
-				default:
-					// Continue downloading next chunk
+			url, offset, size := chunkURL, 0, chunkSize
+			if !limits.FixedRanges {
+				offset, size = randomRange(rng, chunkSize)
+				url = s.rangeURL(offset, size)
+			}
+			url = bustURL(limits.CacheBust, url)
+			requests.Add(1)
+			stats.Requests++
+			timeout := chunkTimeout(chunkSize, stats.Bytes, time.Since(streamStart))
+			reqCtx, cancelReq := context.WithTimeout(ctx, timeout)
+			reqCtx, watch := watchStalls(reqCtx, limits.StallTimeout)
+			traceCtx, release := conns.trace(reqCtx)
+			req, err := http.NewRequestWithContext(traceCtx, "GET", url, nil)
+			if err != nil {
+				// If context is done, this is not an unexpected error for this request
+				if ctx.Err() == nil {
+					errorsChan <- fmt.Errorf("server %s: creating download request: %w", s.Name, err)
+					stats.Failed++
 				}
+				cancelReq()
+				release()
+				return // Stop this goroutine
+			}
+			req.Header.Set("User-Agent", userAgent)
+			bustHeader(limits.CacheBust, req.Header)
 
-				downloadURL := modifySpeedtestURL(s.URL, fmt.Sprintf("/range/0-%d", chunkSize-1)) // range is 0-indexed
-
-				req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
-				if err != nil {
-					// If context is done, this is not an unexpected error for this request
-					if ctx.Err() == nil {
-						errorsChan <- fmt.Errorf("server %s: creating download request: %w", s.Name, err)
-					}
-					return // Stop this goroutine
+			resp, err := client.Do(req)
+			if err != nil {
+				if stalled(reqCtx) && streamStalls < maxStallRetries {
+					streamStalls++
+					stalls.Add(1)
+					stats.Failed++
+					cancelReq()
+					release()
+					continue // Again, on a new connection
 				}
-				req.Header.Set("User-Agent", userAgent)
-
-				resp, err := httpClient.Do(req)
-				if err != nil {
-					if ctx.Err() == nil { // Don't report error if it's due to context cancellation
-						errorsChan <- fmt.Errorf("server %s: download request error: %w", s.Name, err)
-					}
-					return // Stop this goroutine on significant error
+				if ctx.Err() == nil { // Don't report error if it's due to context cancellation
+					errorsChan <- fmt.Errorf("server %s: download request error: %w", s.Name, chunkError(reqCtx, timeout, err))
+					stats.Failed++
 				}
+				cancelReq()
+				release()
+				return // Stop this goroutine on significant error
+			}
 
-				if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-					bodyBytes, _ := io.ReadAll(resp.Body)
-					resp.Body.Close()
-					if ctx.Err() == nil {
-						errorsChan <- fmt.Errorf("server %s: download failed with status %d: %s", s.Name, resp.StatusCode, string(bodyBytes))
-					}
-					return // Stop this goroutine
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+				bodyBytes, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if ctx.Err() == nil {
+					errorsChan <- fmt.Errorf("server %s: download failed with status %d: %s", s.Name, resp.StatusCode, string(bodyBytes))
+					stats.Failed++
 				}
+				cancelReq()
+				release()
+				return // Stop this goroutine
+			}
 
-				written, err := io.Copy(io.Discard, resp.Body)
-				resp.Body.Close() // Ensure body is closed
+			stats.Cache.observe(resp.Header)
+			written, err := reader.drain(resp.Body, int64(offset), watch)
+			resp.Body.Close() // Ensure body is closed
+			cancelReq()
+			release()
 
-				if err != nil {
-					if ctx.Err() == nil {
-						errorsChan <- fmt.Errorf("server %s: error reading download body: %w", s.Name, err)
-					}
-					return // Stop this goroutine
+			if err != nil {
+				if stalled(reqCtx) && streamStalls < maxStallRetries {
+					streamStalls++
+					stalls.Add(1)
+					stats.Failed++
+					continue // Again, on a new connection
+				}
+				if ctx.Err() == nil {
+					errorsChan <- fmt.Errorf("server %s: error reading download body: %w", s.Name, chunkError(reqCtx, timeout, err))
+					stats.Failed++
 				}
+				return // Stop this goroutine
+			}
+			stats.Bytes += written
+			streamStalls = 0
+			if stats.Integrity != nil {
+				stats.Integrity.record(resp.ContentLength, written, size, reader.sample.windows)
+			}
 
-				totalBytesDownloadedMutex.Lock()
-				totalBytesDownloaded += written
-				totalBytesDownloadedMutex.Unlock()
+			totalBytesDownloadedMutex.Lock()
+			totalBytesDownloaded += written
+			totalBytesDownloadedMutex.Unlock()
 
-				// If we received less than requested, and context is not done,
-				// it might be end of stream or server limit for that specific request.
-				// The loop will continue trying to fetch more unless context is done.
-				if written < int64(chunkSize) && ctx.Err() == nil {
-					// Optional: log this, but loop continues
-					// log.Printf("Server %s sent %d bytes, expected up to %d for this chunk", s.Name, written, chunkSize)
-				}
+			// If we received less than requested, and context is not done,
+			// it might be end of stream or server limit for that specific request.
+			// The loop will continue trying to fetch more unless context is done.
+			if written < int64(size) && ctx.Err() == nil {
+				// Optional: log this, but loop continues
+				// log.Printf("Server %s sent %d bytes, expected up to %d for this chunk", s.Name, written, size)
 			}
-		}(srv)
-	}
+		}
+	})
 
 	wg.Wait()
 	close(errorsChan)
 
+	result := phaseResult{Samples: <-samplesChan, Bytes: totalBytesDownloaded, Duration: testDuration, Requests: requests.Load(), Stalls: int(stalls.Load())}
+	result.FailedRequests = result.Stalls
+	result.Connections, result.StreamsPerConn = conns.summary()
+	result.PerServer, result.Resources = streams.streams(), <-usageChan
+	result.Joined = streams.joinedServers()
+	result.Streams, result.Integrity = len(result.PerServer), summarizeIntegrity(result.PerServer)
+	result.Cache = summarizeCache(result.PerServer, limits.CacheBust)
+	warnIfCPUBound("download", result.Resources)
 	for err := range errorsChan {
 		log.Printf("Download stream error: %v\n", err)
+		result.FailedRequests++
+		result.FailedStreams++
+	}
+	if capped.Load() {
+		// Chunks were cut short, so count every byte over the time actually taken
+		result.Bytes, result.Duration = atomic.LoadInt64(&liveBytes), time.Since(start)
+		lang.fprintf(statusOut, "Data cap reached after %s.\n", result.Duration.Round(time.Millisecond))
+	} else if stable.Load() {
+		result.Bytes, result.Duration = atomic.LoadInt64(&liveBytes), time.Since(start)
+		lang.fprintf(statusOut, "Speed stable, stopped after %s.\n", result.Duration.Round(time.Millisecond))
 	}
 
 	// Use the actual testDuration for calculation, as it's the controlled variable.
 	// totalBytesDownloaded will be the sum from all successful chunk downloads.
-	if testDuration.Seconds() == 0 || totalBytesDownloaded == 0 {
+	if result.Duration.Seconds() == 0 || result.Bytes == 0 {
 		// Check if ctx.Err() indicates premature stop for a different reason if needed.
 		// For now, if no bytes or no time (which shouldn't happen for testDuration), return 0.
-		return 0, fmt.Errorf("download test yielded no data or test duration was zero")
+		return result, withCode(codeDownloadStalled, errors.New("download test yielded no data or test duration was zero"))
 	}
 
 	// Speed in Mbps (Megabits per second)
-	speedMbps := (float64(totalBytesDownloaded) * 8) / (testDuration.Seconds() * 1000000)
-	return speedMbps, nil
+	result.Mbps, result.Parallelism = toMbps(result.Bytes, result.Duration), float64(len(servers))
+	if len(result.Joined) > 0 { // Their streams started late
+		_, result.Parallelism = aggregateStreams(result.PerServer, result.Duration)
+	}
+	if limits.AutoStreams {
+		_, result.Parallelism = aggregateStreams(result.PerServer, result.Duration)
+		result.AutoStreams = streams.active()
+	}
+	if result.FailedStreams > 0 && !capped.Load() && !stable.Load() {
+		result.Mbps, result.Parallelism = aggregateStreams(result.PerServer, result.Duration)
+	}
+	return result, nil
 }
 
-func performUploadTest(servers []target, testDuration time.Duration, chunkSize int) (float64, error) {
+// performUploadTest uploads to all servers in parallel through client for
+// testDuration, or until limits.MaxBytes have been sent if it is positive.
+func performUploadTest(client doer, servers []target, testDuration time.Duration, chunkSize int, limits transferLimits) (phaseResult, error) {
 	if len(servers) == 0 {
-		return 0, fmt.Errorf("no servers available for upload test")
+		return phaseResult{}, fmt.Errorf("no servers available for upload test")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), testDuration)
 	defer cancel()
 
+	streams := newStreamSet(servers, limits)
 	var wg sync.WaitGroup
 	var totalBytesUploaded int64
 	var totalBytesUploadedMutex sync.Mutex // Mutex still fine, or use atomic.AddInt64
-	errorsChan := make(chan error, len(servers)*5)
-
-	fmt.Printf("Starting upload to %d server(s) for %s, chunk size %d bytes...\n", len(servers), testDuration, chunkSize)
-
-	randomDataBase := make([]byte, chunkSize) // Pre-allocate base for random data
-	_, err := crand.Read(randomDataBase)
-	if err != nil {
-		return 0, fmt.Errorf("failed to generate initial random data for upload: %w", err)
-	}
-
-	for _, srv := range servers {
-		wg.Add(1)
-		go func(s target) {
-			defer wg.Done()
-
-			// Each goroutine can reuse a slice for its random data, but needs to fill it.
-			// Or, if crypto/rand is fast enough, generate each time.
-			// For simplicity here, let's assume we'll generate fresh random data or copy from a base.
-			// Small optimization: copy from pre-generated base to avoid repeated crand.Read calls in tight loop
-			// if performance of crand.Read becomes an issue. Here, new generation per chunk is fine.
+	errorsChan := make(chan error, streams.limit()*5)
+	var liveBytes int64 // Updated as the transport reads request bodies, for per-interval samples
+	var requests, stalls atomic.Int64
+	conns := newConnTracker()
+
+	lang.fprintf(statusOut, "Starting upload to %d server(s) for %s, chunk size %d bytes...\n", len(servers), testDuration, chunkSize)
+
+	// Chunks are reused once the request that sent one is done; one whose
+	// request failed may still be read by the transport and isn't put back.
+	chunks := sync.Pool{New: func() any { return make([]byte, chunkSize) }}
+	samplesChan := sampleThroughput(ctx, &liveBytes, throughputSampleInterval)
+	usageChan := sampleResources(ctx, throughputSampleInterval)
+	capped := stopAtDataCap(ctx, cancel, &liveBytes, limits.MaxBytes)
+	stable := stopWhenStable(ctx, cancel, &liveBytes, limits.Stabilize)
+	reportProgress(ctx, &liveBytes, "Upload", testDuration, limits)
+	start := time.Now()
+
+	streams.start(ctx, &wg, &liveBytes, func(stop context.Context, s target, stats *serverStats, rng *rand.Rand) {
+		defer wg.Done()
+		stats.Host = targetHost(s)
+		var streamStalls int
+		streamStart := time.Now()
+		defer func() { stats.Active = time.Since(streamStart) }()
+
+		fill := chunkFiller(limits.Payload, rng)
+		chunkURL, reader := s.uploadURL(), newChunkReader(&liveBytes)
+
+		for {
+			select {
+			case <-stop.Done(): // Test duration elapsed, explicitly cancelled or the stream removed
+				return
+			default:
+				// Continue uploading next chunk
+			}
 
-			for {
-				select {
-				case <-ctx.Done(): // Test duration elapsed or explicitly cancelled
-					return
-				default:
-					// Continue uploading next chunk
+			// It's important to generate new random data for each POST to avoid network/server-side caching/compression
+			// tricks that might inflate speed results.
+			currentChunkData := chunks.Get().([]byte)
+			if err := fill(currentChunkData); err != nil {
+				if ctx.Err() == nil {
+					errorsChan <- fmt.Errorf("server %s: generating random data: %w", s.Name, err)
+					stats.Failed++
 				}
+				return // Stop this goroutine
+			}
 
-				// It's important to generate new random data for each POST to avoid network/server-side caching/compression
-				// tricks that might inflate speed results.
-				currentChunkData := make([]byte, chunkSize)
-				n, err := crand.Read(currentChunkData)
-				if err != nil || n != chunkSize {
-					if ctx.Err() == nil {
-						errorsChan <- fmt.Errorf("server %s: generating random data: %w", s.Name, err)
-					}
-					return // Stop this goroutine
+			requests.Add(1)
+			stats.Requests++
+			timeout := chunkTimeout(chunkSize, stats.Bytes, time.Since(streamStart))
+			reqCtx, cancelReq := context.WithTimeout(ctx, timeout)
+			reqCtx, watch := watchStalls(reqCtx, limits.StallTimeout)
+			body := reader.body(currentChunkData, watch)
+			traceCtx, release := conns.trace(reqCtx)
+			req, err := http.NewRequestWithContext(traceCtx, "POST", chunkURL, body)
+			if err != nil {
+				if ctx.Err() == nil {
+					errorsChan <- fmt.Errorf("server %s: creating upload request: %w", s.Name, err)
+					stats.Failed++
 				}
-				body := bytes.NewReader(currentChunkData)
-
-				req, err := http.NewRequestWithContext(ctx, "POST", s.URL, body)
-				if err != nil {
-					if ctx.Err() == nil {
-						errorsChan <- fmt.Errorf("server %s: creating upload request: %w", s.Name, err)
-					}
-					return // Stop this goroutine
+				cancelReq()
+				release()
+				return // Stop this goroutine
+			}
+			req.Header.Set("User-Agent", userAgent)
+			req.Header.Set("Content-Type", "application/octet-stream")
+			req.ContentLength = int64(chunkSize)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				if stalled(reqCtx) && streamStalls < maxStallRetries {
+					streamStalls++
+					stalls.Add(1)
+					stats.Failed++
+					cancelReq()
+					release()
+					// The transport may still be reading the old body
+					reader = newChunkReader(&liveBytes)
+					continue // Again, on a new connection
 				}
-				req.Header.Set("User-Agent", userAgent)
-				req.Header.Set("Content-Type", "application/octet-stream")
-				req.ContentLength = int64(chunkSize)
-
-				resp, err := httpClient.Do(req)
-				if err != nil {
-					if ctx.Err() == nil {
-						errorsChan <- fmt.Errorf("server %s: upload request error: %w", s.Name, err)
-					}
-					return // Stop this goroutine
+				if ctx.Err() == nil {
+					errorsChan <- fmt.Errorf("server %s: upload request error: %w", s.Name, chunkError(reqCtx, timeout, err))
+					stats.Failed++
 				}
+				cancelReq()
+				release()
+				return // Stop this goroutine
+			}
 
-				// Consume and close response body
-				io.Copy(io.Discard, resp.Body)
-				resp.Body.Close()
-
-				if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-					if ctx.Err() == nil {
-						errorsChan <- fmt.Errorf("server %s: upload failed with status %d", s.Name, resp.StatusCode)
-					}
-					return // Stop this goroutine
+			// Consume and close response body
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			cancelReq()
+			release()
+			chunks.Put(currentChunkData)
+
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+				if ctx.Err() == nil {
+					errorsChan <- fmt.Errorf("server %s: upload failed with status %d", s.Name, resp.StatusCode)
+					stats.Failed++
 				}
-
-				totalBytesUploadedMutex.Lock()
-				totalBytesUploaded += int64(chunkSize) // We successfully sent one full chunk
-				totalBytesUploadedMutex.Unlock()
+				return // Stop this goroutine
 			}
-		}(srv)
-	}
+
+			totalBytesUploadedMutex.Lock()
+			totalBytesUploaded += int64(chunkSize) // We successfully sent one full chunk
+			totalBytesUploadedMutex.Unlock()
+			stats.Bytes += int64(chunkSize)
+			streamStalls = 0
+		}
+	})
 
 	wg.Wait()
 	close(errorsChan)
 
+	result := phaseResult{Samples: <-samplesChan, Bytes: totalBytesUploaded, Duration: testDuration, Requests: requests.Load(), Stalls: int(stalls.Load())}
+	result.FailedRequests = result.Stalls
+	result.Connections, result.StreamsPerConn = conns.summary()
+	result.PerServer, result.Resources = streams.streams(), <-usageChan
+	result.Streams, result.Payload = len(result.PerServer), limits.Payload
+	warnIfCPUBound("upload", result.Resources)
 	for err := range errorsChan {
 		log.Printf("Upload stream error: %v\n", err)
+		result.FailedRequests++
+		result.FailedStreams++
+	}
+	if capped.Load() {
+		result.Bytes, result.Duration = atomic.LoadInt64(&liveBytes), time.Since(start)
+		lang.fprintf(statusOut, "Data cap reached after %s.\n", result.Duration.Round(time.Millisecond))
+	} else if stable.Load() {
+		result.Bytes, result.Duration = atomic.LoadInt64(&liveBytes), time.Since(start)
+		lang.fprintf(statusOut, "Speed stable, stopped after %s.\n", result.Duration.Round(time.Millisecond))
 	}
 
-	if testDuration.Seconds() == 0 || totalBytesUploaded == 0 {
-		return 0, fmt.Errorf("upload test yielded no data or test duration was zero")
+	if result.Duration.Seconds() == 0 || result.Bytes == 0 {
+		return result, withCode(codeUploadStalled, errors.New("upload test yielded no data or test duration was zero"))
 	}
 
-	speedMbps := (float64(totalBytesUploaded) * 8) / (testDuration.Seconds() * 1000000)
-	return speedMbps, nil
+	result.Mbps, result.Parallelism = toMbps(result.Bytes, result.Duration), float64(len(servers))
+	if limits.AutoStreams {
+		_, result.Parallelism = aggregateStreams(result.PerServer, result.Duration)
+		result.AutoStreams = streams.active()
+	}
+	if result.FailedStreams > 0 && !capped.Load() && !stable.Load() {
+		result.Mbps, result.Parallelism = aggregateStreams(result.PerServer, result.Duration)
+	}
+	return result, nil
 }
 
 func main() {
 	log.SetFlags(0) // Simpler logging output
+	log.SetOutput(redactingWriter{os.Stderr})
 
-	fmt.Println("Fetching server list...")
-	initialTargets, err := fetchTestServers()
-	if err != nil || len(initialTargets) == 0 {
-		log.Fatalf("Error fetching test servers: %v", err)
+	args, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if len(args) == 0 && exportEntryPoints() {
+		select {} // Tests run as the page calls them
 	}
-	fmt.Printf("Found %d potential servers from API.\n", len(initialTargets))
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			if err := cmd.Run(args[1:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					return
+				}
+				fatal(err)
+			}
+			return
+		}
+		if !strings.HasPrefix(args[0], "-") {
+			fmt.Fprintf(os.Stderr, "unknown command %q, see 'fast-cli help'\n", args[0])
+			os.Exit(2)
+		}
+	}
+
+	// Without a command, run a test like earlier versions did
+	if err := runTest(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		fatal(err)
+	}
+}
+
+// runTest implements `fast-cli run`: one test, printed in the selected
+// format. It exits with status 1 when a threshold was not met.
+func runTest(args []string) error {
+	opts, err := parseOptions(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		os.Exit(2) // The flag package already printed the error and usage
+	}
+
+	defer enforceMaxRuntime(opts)()
+	if opts.SignKey != "" {
+		// Before testing, so that a bad key doesn't waste a test
+		if opts.signingKey, err = loadSigningKey(opts.SignKey); err != nil {
+			return err
+		}
+	}
+	if err := opts.resolveSecrets(); err != nil {
+		return err
+	}
+	if opts.pushTemplate, err = loadPushTemplate(opts.PushTemplate); err != nil {
+		return err
+	}
+	if opts.ReplayDir != "" {
+		if opts.Mock.Replay, err = loadBundle(opts.ReplayDir); err != nil {
+			return err
+		}
+		opts.Provider = providerMock
+	}
+	if err := loadSinkSections(opts); err != nil {
+		return err
+	}
+	if err := configureConnections(opts); err != nil {
+		return err
+	}
+	applyLowResource(opts)
+	if opts.DryRun {
+		return dryRunProviders(opts)
+	}
+	switch opts.Format {
+	case formatText:
+		lang = detectLocale(opts.Lang)
+	case formatSimple, formatStatusbar:
+		statusOut = io.Discard // Status bars show stderr too
+	default:
+		statusOut = os.Stderr
+	}
+	plainOutput = opts.Plain
+	if providers, _ := parseProviders(opts.Provider); len(providers) > 1 {
+		return runProviderComparison(opts, providers)
+	}
+	if opts.CompareVia != "" {
+		return runViaComparison(opts)
+	}
+	if opts.BarInterval > 0 {
+		return runStatusbar(opts)
+	}
+
+	res, err := runSpeedTest(opts)
+	if err != nil {
+		exportResult(opts, res, err)
+		switch opts.Format {
+		case formatJSON:
+			writeJSON(struct {
+				Error jsonError `json:"error"`
+			}{newJSONErrors([]error{err})[0]})
+		case formatStatusbar:
+			writeStatusbar(os.Stdout, statusbarError(err))
+		case formatNDJSON:
+			writeNDJSON(ndjsonEvent{Event: "error", ID: res.ID, Error: &newJSONErrors([]error{err})[0]})
+		}
+		return err
+	}
+	history := recordHistory(opts, res)
+	if err := writeResult(opts, res, history); err != nil {
+		return fmt.Errorf("writing result: %w", err)
+	}
+	exportResult(opts, res, nil)
+	if opts.GHA {
+		writeGHAAnnotations(os.Stderr, res, opts.Thresholds)
+	}
+	if len(res.Errors) > 0 {
+		os.Exit(exitStatus(res.Errors[0])) // Already logged as it happened
+	}
+	if failures := opts.Thresholds.check(res); len(failures) > 0 {
+		log.Printf("Thresholds not met: %s", strings.Join(failures, ", "))
+		os.Exit(1)
+	}
+	return nil
+}
 
-	fmt.Println("Pinging servers to select the best ones...")
-	pingedTargets := measurePings(initialTargets)
+// selectServers fetches the provider's server list and picks the --streams
+// best servers, best first: those with the lowest latency, or with --rank
+// combined, the best mix of latency and throughput. Servers that did badly
+// in the runs recorded in the history come last.
+func selectServers(opts *options) (clientInfo, []pingedTarget, error) {
+	ctx, cancel := opts.selectionContext()
+	defer cancel()
+	client, initialTargets, err := fetchCandidates(opts)
+	if err != nil {
+		return client, nil, err
+	}
+	if opts.PinServers != "" {
+		pins, err := readPins(opts.PinServers)
+		if err != nil {
+			return client, nil, err
+		}
+		if pins != nil {
+			servers, err := selectPinned(ctx, opts, initialTargets, pins)
+			return client, servers, err
+		}
+	}
+
+	fmt.Fprintln(statusOut, lang.tr("Pinging servers to select the best ones..."))
+	streams := cmp.Or(opts.Streams, numServersToTest)
+	ranked := opts.Rank == rankCombined && len(initialTargets) > streams
+	var pingedTargets []pingedTarget
+	if ranked && opts.Overlap {
+		pingedTargets = pingAndProbe(ctx, initialTargets, cmp.Or(opts.PingTimeout, defaultPingTimeout), opts.PingWarmup)
+	} else {
+		pingedTargets = measurePings(ctx, initialTargets, cmp.Or(opts.PingTimeout, defaultPingTimeout), opts.PingWarmup)
+	}
 
 	if len(pingedTargets) == 0 {
-		log.Fatalf("No servers responded to ping successfully.")
+		return client, nil, withCode(codeAllPingsFailed, errors.New("no servers responded to ping successfully"))
 	}
 
-	numToUse := numServersToTest
+	numToUse := streams
 	if len(pingedTargets) < numToUse {
 		numToUse = len(pingedTargets)
-		fmt.Printf("Warning: Fewer than %d responsive servers available, using %d.\n", numServersToTest, numToUse)
+		fmt.Fprintf(statusOut, "Warning: Fewer than %d responsive servers available, using %d.\n", streams, numToUse)
 	}
-	if numToUse == 0 {
-		log.Fatalf("No responsive servers to test with.")
+	switch {
+	case len(pingedTargets) <= numToUse: // Ranking wouldn't change which servers are used
+	case ranked && opts.Overlap:
+		pingedTargets = byScore(pingedTargets) // Probed already
+	case opts.Rank == rankCombined:
+		pingedTargets = rankServers(ctx, pingedTargets)
 	}
+	pingedTargets = deprioritize(pingedTargets, loadServerScores(opts))
+	return client, pingedTargets[:numToUse], nil
+}
 
-	selectedPingedTargets := pingedTargets[:numToUse]
-	var selectedTargetsForTest []target
-	var totalPingLatency time.Duration
+// fetchCandidates returns the servers of --server, or else those the
+// provider offers for the test to choose from.
+func fetchCandidates(opts *options) (clientInfo, []target, error) {
+	apiResp := &apiResponse{Targets: peerTargets(opts.Servers)}
+	if len(opts.Servers) == 0 {
+		fmt.Fprintln(statusOut, lang.tr("Fetching server list..."))
+		var err error
+		if apiResp, err = fetchProviderServers(httpClient, opts.Provider, cmp.Or(opts.Streams, numServersToTest), cmp.Or(opts.Candidates, defaultURLCount)); err != nil {
+			return clientInfo{}, nil, fmt.Errorf("fetching test servers: %w", err)
+		}
+		if err := opts.checkHosts(apiResp.Targets); err != nil {
+			return clientInfo{}, nil, err
+		}
+	}
+	recording.servers(apiResp)
+	if len(apiResp.Targets) == 0 {
+		return apiResp.Client, nil, withCode(codeNoServers, errors.New("server list returned no test servers"))
+	}
+	lang.fprintf(statusOut, "Found %d potential servers from API.\n", len(apiResp.Targets))
+	return apiResp.Client, apiResp.Targets, nil
+}
+
+// runSpeedTest runs one test wrapped in the user's pre/post hooks.
+func runSpeedTest(opts *options) (testResult, error) {
+	if opts.HARPath != "" {
+		defer recordHAR(opts.HARPath)()
+	}
+	if opts.RecordDir != "" {
+		defer startRecording(opts)()
+	}
+	if opts.PreCmd != "" {
+		fmt.Fprintln(statusOut, "Running pre-cmd...")
+		if err := runHook(opts.PreCmd, nil, nil); err != nil {
+			return testResult{}, fmt.Errorf("pre-cmd failed: %w", err)
+		}
+	}
+	stopBudget := startBudget(opts)
+	deadline, budget := opts.deadline, opts.budget
+	redirects.reset(opts.Strict)
+	clockCheck.reset()
+	res, err := measureSpeed(opts)
+	stopBudget()
+	res.Tags, res.Seed, res.ClockSkew = opts.Tags, opts.Seed, clockCheck.offset()
+	res.Redirects = redirects.followed()
+	res.SendBuffer, res.ReceiveBuffer = effectiveBuffers.get()
+	res.EndedAt = time.Now()
+	if err != nil && !deadline.IsZero() && !res.EndedAt.Before(deadline) && errorCodeOf(err) != codeTimedOut {
+		err = withCode(codeTimedOut, fmt.Errorf("%w (%s): %w", errBudgetExhausted, budget, err))
+	}
+	if hop, ok := offHost(res.Redirects); ok && opts.Strict && errorCodeOf(err) != codeTimedOut {
+		// Whatever else failed, it was for the refused redirect
+		err = withCode(codeRedirected, fmt.Errorf("%s redirected to %s, off the server's host (--strict)", hop.From, hop.To))
+	} else if err == nil && opts.Strict {
+		err = res.strictCheck(opts.StrictMaxErrors)
+	}
+	if opts.PostCmd != "" {
+		fmt.Fprintln(statusOut, "Running post-cmd...")
+		runPostHook(opts, res, err)
+	}
+	return res, err
+}
 
-	fmt.Println("\nSelected servers for speed tests:")
-	for _, pt := range selectedPingedTargets {
-		fmt.Printf("  - %s (%s, %s) - Latency: %v\n", pt.Target.Name, pt.Target.Location.City, pt.Target.Location.Country, pt.Latency.Round(time.Millisecond))
-		selectedTargetsForTest = append(selectedTargetsForTest, pt.Target)
+// listSelected prints the servers the test uses and returns them as
+// targets, with their average ping.
+func listSelected(servers []pingedTarget) ([]target, time.Duration) {
+	var totalPingLatency time.Duration
+	fmt.Fprintln(statusOut, lang.tr("\nSelected servers for speed tests:"))
+	for _, pt := range servers {
+		lang.fprintf(statusOut, "  - %s (%s, %s) - Latency: %v", pt.Target.Name, pt.Target.Location.City, pt.Target.Location.Country, pt.Latency.Round(time.Millisecond))
+		if pt.Setup.Round(time.Millisecond) > 0 {
+			fmt.Fprintf(statusOut, ", connection setup %v", pt.Setup.Round(time.Millisecond))
+		}
+		if pt.ProbeMbps > 0 {
+			fmt.Fprintf(statusOut, ", probe %.0f Mbps", pt.ProbeMbps)
+		}
+		if c := pt.Connect.String(); c != "" {
+			fmt.Fprintf(statusOut, " %s", c)
+		}
+		fmt.Fprintln(statusOut)
 		totalPingLatency += pt.Latency
 	}
+	return targetsOf(servers), totalPingLatency / time.Duration(len(servers))
+}
+
+// targetsOf returns the targets of servers.
+func targetsOf(servers []pingedTarget) []target {
+	targets := make([]target, len(servers))
+	for i, pt := range servers {
+		targets[i] = pt.Target
+	}
+	return targets
+}
+
+// measureSpeed selects servers and runs the latency, download and upload
+// measurements. Errors in individual phases are logged and leave their
+// numbers at zero; only failures that leave nothing to test are returned.
+func measureSpeed(opts *options) (testResult, error) {
+	if opts.Simulate.IsSet() {
+		return simulateSpeed(opts), nil
+	}
+	res := testResult{ID: newUUID(), StartedAt: time.Now(), Provider: cmp.Or(opts.Provider, providerFast), Congestion: opts.congestion}
+	if res.Provider == providerMock {
+		startMockFastCom(opts.Mock)
+		if b := opts.Mock.Replay; b != nil {
+			fmt.Fprintf(statusOut, "Replaying the server selection of a %s test by fast-cli %s on %s, which ran with %s\n", b.Provider, b.Version, b.RecordedAt.Format(time.DateTime), strings.Join(b.Flags, " "))
+		}
+	}
+
+	if err := precheck(opts); err != nil {
+		return res, err
+	}
+	var err error
+	var answered <-chan pingedTarget // Servers joining the test, with overlap
+	if opts.overlaps() {
+		var first pingedTarget
+		res.Client, first, answered, err = selectServersEarly(opts)
+		res.Servers = []pingedTarget{first}
+	} else {
+		res.Client, res.Servers, err = selectServers(opts)
+	}
+	if err != nil {
+		return res, err
+	}
+	if opts.PinServers != "" {
+		if err := writePins(opts.PinServers, res.Servers); err != nil {
+			log.Printf("Warning: pinning servers: %v", err)
+		}
+	}
+	streams := cmp.Or(opts.Streams, numServersToTest)
+	var selectedTargetsForTest []target
+	if answered == nil {
+		selectedTargetsForTest, res.AvgPing = listSelected(res.Servers)
+	}
 
-	avgPingStr := "N/A"
-	if numToUse > 0 {
-		avgPing := totalPingLatency / time.Duration(numToUse)
-		avgPingStr = avgPing.Round(time.Millisecond).String()
+	// Latency is always probed against the lowest-ping server
+	best, _ := bestServer(res)
+	bestTarget := best.Target
+
+	idle := make(chan latencyStats, 1)
+	alongside := opts.Quick || answered != nil
+	if alongside {
+		lang.fprintf(statusOut, "\nMeasuring idle latency to %s alongside the prechecks...\n", bestTarget.Name)
+		go func() { idle <- measureIdleLatency(bestTarget, cmp.Or(opts.LatencySamples, idleLatencySamples)) }()
+	}
+	if res.Background, err = checkBackgroundTraffic(opts, bestTarget); err != nil {
+		if opts.RequireIdle {
+			return res, err
+		}
+		log.Printf("Warning: %v", err)
+	}
+	if res.WiFi, err = probeWiFi(bestTarget); err != nil {
+		log.Printf("Warning: reading Wi-Fi details: %v", err)
+	}
+	if res.Network, err = probeNetwork(bestTarget); err != nil {
+		log.Printf("Warning: identifying the network: %v", err)
+	}
+
+	if opts.ProbePMTU {
+		fmt.Fprintf(statusOut, "\nProbing path MTU to %s...\n", targetHost(bestTarget))
+		if res.PathMTU, err = probeTargetMTU(bestTarget); err != nil {
+			log.Printf("Warning: path MTU probe: %v", err)
+		}
+	}
+
+	if opts.PcapPath != "" {
+		defer startPcap(opts.PcapPath, selectedTargetsForTest)()
+	}
+
+	if alongside {
+		res.IdleLatency = <-idle
+	} else {
+		lang.fprintf(statusOut, "\nMeasuring idle latency to %s...\n", bestTarget.Name)
+		res.IdleLatency = measureIdleLatency(bestTarget, cmp.Or(opts.LatencySamples, idleLatencySamples))
+	}
+	streamPartial(opts, "ping", res)
+
+	if answered != nil {
+		// Those that answered meanwhile start with it, the others join later
+		res.Servers = append(res.Servers, moreServers(answered, streams-1)...)
+		selectedTargetsForTest, res.AvgPing = listSelected(res.Servers)
 	}
 
 	// Perform Download Test
-	fmt.Printf("\nPerforming download test...\n")
-	
-	downloadSpeedMbps, err := performDownloadTest(selectedTargetsForTest, downloadTestDuration, downloadChunkSizeBytes)
+	fmt.Fprintln(statusOut, lang.tr("\nPerforming download test..."))
+
+	uploadNominal := cmp.Or(opts.UploadDuration, uploadTestDuration)
+	if opts.SkipUpload {
+		uploadNominal = 0
+	}
+	downloadDuration, runUpload, err := opts.phaseDuration(cmp.Or(opts.DownloadDuration, downloadTestDuration), uploadNominal, "download")
+	if err != nil {
+		return res, err
+	}
+	if !runUpload && !opts.SkipUpload {
+		fmt.Fprintf(statusOut, "Skipping the upload phase to stay within %s.\n", opts.budget)
+	}
+	limits := opts.transferLimits()
+	if opts.AutoLatency > 0 && len(res.IdleLatency.Samples) > 0 {
+		limits.LatencyCeiling = res.IdleLatency.Avg + opts.AutoLatency
+	}
+	probe := startLatencyProbe(bestTarget, loadedLatencyInterval)
+	limits.Latency = probe
+	if answered != nil {
+		limits.Join, limits.JoinStreams = answered, streams-len(res.Servers)
+	}
+	downloadChunk, uploadChunk := opts.chunkSizes()
+	res.Download, err = performDownloadTest(httpClient, selectedTargetsForTest, downloadDuration, downloadChunk, limits)
+	res.DownloadLatency = probe.Stop()
 	if err != nil {
 		log.Printf("Download test error: %v. Reported speed might be affected.", err)
+		res.Errors = append(res.Errors, err)
+	}
+	if answered != nil {
+		res.Servers = append(res.Servers, res.Download.Joined...)
+		res.Servers = append(res.Servers, moreServers(answered, streams-len(res.Servers))...)
+		if len(res.Servers) < streams {
+			fmt.Fprintf(statusOut, "Warning: Fewer than %d responsive servers available, using %d.\n", streams, len(res.Servers))
+		}
+		selectedTargetsForTest = targetsOf(res.Servers)
+		limits.Join, limits.JoinStreams = nil, 0
+	}
+	streamPartial(opts, "download", res)
+
+	if opts.SkipUpload || !runUpload {
+		return res, nil
 	}
 
 	// Perform Upload Test
-	fmt.Printf("\nPerforming upload test...\n")
-	uploadSpeedMbps, err := performUploadTest(selectedTargetsForTest, uploadTestDuration, uploadChunkSizeBytes)
+	fmt.Fprintln(statusOut, lang.tr("\nPerforming upload test..."))
+	uploadDuration, _, err := opts.phaseDuration(uploadNominal, 0, "upload")
+	if err != nil {
+		log.Printf("Upload test skipped: %v", err)
+		res.Errors = append(res.Errors, err)
+		return res, nil
+	}
+	probe = startLatencyProbe(bestTarget, loadedLatencyInterval)
+	limits.Latency = probe
+	res.Upload, err = performUploadTest(httpClient, selectedTargetsForTest, uploadDuration, uploadChunk, limits)
+	res.UploadLatency = probe.Stop()
 	if err != nil {
 		log.Printf("Upload test error: %v. Reported speed might be affected.", err)
+		res.Errors = append(res.Errors, err)
 	}
+	streamPartial(opts, "upload", res)
 
-	// Output Results
-	fmt.Println("\n--- Speed Test Results ---")
-	fmt.Printf("Average Ping to selected servers: %s\n", avgPingStr)
-	fmt.Printf("Download Speed: %.2f Mbps\n", downloadSpeedMbps)
-	fmt.Printf("Upload Speed: %.2f Mbps\n", uploadSpeedMbps)
+	return res, nil
 }
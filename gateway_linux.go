@@ -0,0 +1,53 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readGateway returns the MAC address of the default gateway through iface,
+// from the kernel's route and neighbour tables, or "" while it isn't known.
+func readGateway(iface net.Interface) (string, error) {
+	routes, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", fmt.Errorf("reading routes: %w", err)
+	}
+	defer routes.Close()
+	var gateway net.IP
+	scanner := bufio.NewScanner(routes)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != iface.Name || fields[1] != "00000000" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[2], 16, 32) // The address as printed in host byte order
+		if err != nil {
+			continue
+		}
+		gateway = make(net.IP, 4)
+		binary.NativeEndian.PutUint32(gateway, uint32(v))
+		break
+	}
+	if gateway == nil || gateway.IsUnspecified() {
+		return "", nil
+	}
+
+	arp, err := os.ReadFile("/proc/net/arp")
+	if err != nil {
+		return "", fmt.Errorf("reading the ARP table: %w", err)
+	}
+	for line := range strings.Lines(string(arp)) {
+		fields := strings.Fields(line)
+		if len(fields) >= 6 && fields[0] == gateway.String() && fields[5] == iface.Name && fields[3] != "00:00:00:00:00:00" {
+			return fields[3], nil
+		}
+	}
+	return "", nil
+}
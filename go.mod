@@ -0,0 +1,3 @@
+module github.com/sh4dowb/fast-cli
+
+go 1.24
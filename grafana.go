@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// grafanaPath is where the collector answers Grafana's JSON datasource
+// (the "simple JSON" protocol): a test request at the root and search,
+// query, tag-keys and tag-values below it.
+const grafanaPath = "/grafana"
+
+// grafanaTags are the ad hoc filters the datasource offers, besides the
+// --tag labels of the results.
+var grafanaTags = []struct {
+	Key   string
+	Value func(e historyEntry) string
+}{
+	{"host", func(e historyEntry) string { return e.Host }},
+	{"provider", func(e historyEntry) string { return cmp.Or(e.Provider, providerFast) }},
+	{"via", func(e historyEntry) string { return e.Via }},
+}
+
+type grafanaFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"` // = or !=
+	Value    string `json:"value"`
+}
+
+func (f grafanaFilter) keeps(e historyEntry) bool {
+	return (grafanaTag(f.Key)(e) == f.Value) == (f.Operator != "!=")
+}
+
+// grafanaTag returns what the filter key reads from an entry: one of
+// grafanaTags, or else the --tag label of that key.
+func grafanaTag(key string) func(e historyEntry) string {
+	for _, t := range grafanaTags {
+		if t.Key == key {
+			return t.Value
+		}
+	}
+	return func(e historyEntry) string { return e.Tags[key] }
+}
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	MaxDataPoints int `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string            `json:"target"`
+		Data   map[string]string `json:"data"` // Tag filters of this target alone, e.g. {"host": "probe1"}
+	} `json:"targets"`
+	AdhocFilters []grafanaFilter `json:"adhocFilters"`
+}
+
+// grafanaSeries is a time series as the datasource expects it: datapoints
+// of [value, Unix milliseconds].
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+func (h *collectorHandler) grafana(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, grafanaPath) {
+	case "", "/":
+		w.WriteHeader(http.StatusOK) // Grafana's "Save & test"
+	case "/search":
+		var names []string
+		for _, f := range historyFloatFields {
+			names = append(names, f.Name)
+		}
+		writeAPIJSON(w, http.StatusOK, names)
+	case "/query":
+		h.grafanaQuery(w, r)
+	case "/tag-keys":
+		var keys []map[string]string
+		for _, t := range grafanaTags {
+			keys = append(keys, map[string]string{"type": "string", "text": t.Key})
+		}
+		labels := map[string]bool{}
+		for _, e := range h.grafanaEntries() {
+			for k := range e.Tags {
+				labels[k] = true
+			}
+		}
+		for _, k := range slices.Sorted(maps.Keys(labels)) {
+			keys = append(keys, map[string]string{"type": "string", "text": k})
+		}
+		writeAPIJSON(w, http.StatusOK, keys)
+	case "/tag-values":
+		h.grafanaTagValues(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// grafanaEntries returns the collected results in the history format,
+// oldest first.
+func (h *collectorHandler) grafanaEntries() []historyEntry {
+	_, results := h.matching(collectorQuery{})
+	entries := make([]historyEntry, 0, len(results))
+	for _, res := range results {
+		entries = append(entries, historyEntryFromJSON(res))
+	}
+	slices.SortStableFunc(entries, func(a, b historyEntry) int { return a.Time.Compare(b.Time) })
+	return entries
+}
+
+func (h *collectorHandler) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var q grafanaQuery
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadSize)).Decode(&q); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errors.New("the body is not a Grafana query"))
+		return
+	}
+	entries := h.grafanaEntries()
+	series := []grafanaSeries{}
+	for _, t := range q.Targets {
+		var field func(e *historyEntry) *float64
+		for _, f := range historyFloatFields {
+			if f.Name == t.Target {
+				field = f.Field
+			}
+		}
+		if field == nil {
+			writeAPIError(w, http.StatusBadRequest, errors.New("unknown metric "+t.Target+", see /search"))
+			return
+		}
+		filters := slices.Clone(q.AdhocFilters)
+		for key, value := range t.Data {
+			filters = append(filters, grafanaFilter{Key: key, Operator: "=", Value: value})
+		}
+		s := grafanaSeries{Target: t.Target, Datapoints: [][2]float64{}}
+		for _, e := range entries {
+			if e.Time.Before(q.Range.From) || (!q.Range.To.IsZero() && e.Time.After(q.Range.To)) {
+				continue
+			}
+			if !slices.ContainsFunc(filters, func(f grafanaFilter) bool { return !f.keeps(e) }) {
+				s.Datapoints = append(s.Datapoints, [2]float64{*field(&e), float64(e.Time.UnixMilli())})
+			}
+		}
+		if n := q.MaxDataPoints; n > 0 && len(s.Datapoints) > n {
+			s.Datapoints = s.Datapoints[len(s.Datapoints)-n:] // The most recent
+		}
+		series = append(series, s)
+	}
+	writeAPIJSON(w, http.StatusOK, series)
+}
+
+func (h *collectorHandler) grafanaTagValues(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadSize)).Decode(&q); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errors.New("the body is not a Grafana tag-values request"))
+		return
+	}
+	tag := grafanaTag(q.Key)
+	var values []string
+	for _, e := range h.grafanaEntries() {
+		if v := tag(e); v != "" && !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	slices.Sort(values)
+	out := []map[string]string{}
+	for _, v := range values {
+		out = append(out, map[string]string{"text": v})
+	}
+	writeAPIJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The subset of HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/)
+// that browser devtools need to import a log. Bodies are not recorded: they
+// are random bytes and gigabytes of them.
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+// harTimings are in milliseconds, -1 where a phase didn't happen (a reused
+// connection has no DNS, connect or TLS).
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"` // Includes SSL, as the spec asks
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	pairs := []harNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			pairs = append(pairs, harNameValue{name, v})
+		}
+	}
+	slices.SortFunc(pairs, func(a, b harNameValue) int { return strings.Compare(a.Name, b.Name) })
+	return pairs
+}
+
+// harRecorder collects the entries of every request made while it is
+// installed, across tests.
+type harRecorder struct {
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+var harRecording = &harRecorder{}
+
+func (r *harRecorder) add(e harEntry) {
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+}
+
+// save writes the entries so far and returns how many there were.
+func (r *harRecorder) save(path string) (int, error) {
+	r.mu.Lock()
+	entries := slices.Clone(r.entries)
+	r.mu.Unlock()
+	slices.SortFunc(entries, func(a, b harEntry) int { return a.StartedDateTime.Compare(b.StartedDateTime) })
+	data, err := json.MarshalIndent(struct {
+		Log harLog `json:"log"`
+	}{harLog{Version: "1.2", Creator: harCreator{"fast-cli", version}, Entries: entries}}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), os.WriteFile(path, data, 0o644)
+}
+
+// harDoer records every request sent through base.
+type harDoer struct {
+	base     doer
+	recorder *harRecorder
+}
+
+func (t *harDoer) Do(req *http.Request) (*http.Response, error) {
+	ms := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() {
+			return -1
+		}
+		return durationMs(to.Sub(from))
+	}
+	var tr struct {
+		dnsStart, dnsDone, connStart, connDone, tlsStart, tlsDone, gotConn, wrote, firstByte time.Time
+		remote                                                                               string
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { tr.dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { tr.dnsDone = time.Now() },
+		ConnectStart:      func(string, string) { tr.connStart = time.Now() },
+		ConnectDone:       func(string, string, error) { tr.connDone = time.Now() },
+		TLSHandshakeStart: func() { tr.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { tr.tlsDone = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			tr.gotConn = time.Now()
+			if info.Conn != nil {
+				tr.remote = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { tr.wrote = time.Now() },
+		GotFirstResponseByte: func() { tr.firstByte = time.Now() },
+	}
+	start := time.Now()
+	resp, err := t.base.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+
+	entry := harEntry{
+		StartedDateTime: start,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+	}
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, v})
+		}
+	}
+	if host, _, splitErr := net.SplitHostPort(tr.remote); splitErr == nil {
+		entry.ServerIPAddress, entry.Connection = host, tr.remote
+	}
+	finish := func(done time.Time, size int64) {
+		entry.Timings = harTimings{
+			Blocked: -1,
+			DNS:     ms(tr.dnsStart, tr.dnsDone),
+			Connect: ms(tr.connStart, cmp.Or(tr.tlsDone, tr.connDone)),
+			SSL:     ms(tr.tlsStart, tr.tlsDone),
+			Send:    ms(tr.gotConn, tr.wrote),
+			Wait:    ms(tr.wrote, tr.firstByte),
+			Receive: ms(tr.firstByte, done),
+		}
+		for _, p := range []*float64{&entry.Timings.Send, &entry.Timings.Wait, &entry.Timings.Receive} {
+			*p = max(*p, 0) // Required phases, never -1
+		}
+		entry.Time = durationMs(done.Sub(start))
+		entry.Response.Content.Size, entry.Response.BodySize = size, size
+		t.recorder.add(entry)
+	}
+
+	if err != nil {
+		entry.Response = harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1}
+		entry.Comment = err.Error()
+		finish(time.Now(), 0)
+		return nil, err
+	}
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	resp.Body = &harBody{ReadCloser: resp.Body, finish: finish}
+	return resp, nil
+}
+
+// harBody completes its entry when the body has been read or closed, which
+// is when the receive phase ends.
+type harBody struct {
+	io.ReadCloser
+	size   int64
+	once   sync.Once
+	finish func(done time.Time, size int64)
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if err != nil {
+		b.once.Do(func() { b.finish(time.Now(), b.size) })
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.once.Do(func() { b.finish(time.Now(), b.size) })
+	return b.ReadCloser.Close()
+}
+
+// recordHAR starts recording the requests of httpClient for --har and
+// returns the function that stops and writes everything recorded so far.
+func recordHAR(path string) (stop func()) {
+	base := httpClient
+	httpClient = &harDoer{base: base, recorder: harRecording}
+	return func() {
+		httpClient = base
+		n, err := harRecording.save(path)
+		if err != nil {
+			log.Printf("Warning: writing HAR file: %v", err)
+			return
+		}
+		fmt.Fprintf(statusOut, "Recorded %d requests to %s\n", n, path)
+	}
+}
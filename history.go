@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// historyEntry is one recorded run. The history file holds one JSON-encoded
+// entry per line so that it can be appended to cheaply and read with jq.
+type historyEntry struct {
+	ID                string    `json:"id,omitempty"` // Random UUID of the run, used to dedup imports
+	Time              time.Time `json:"time"`
+	EndTime           time.Time `json:"end_time,omitzero"`
+	Host              string    `json:"host,omitempty"`     // Hostname of the machine that ran the test
+	Platform          string    `json:"platform,omitempty"` // GOOS/GOARCH
+	Version           string    `json:"version,omitempty"`  // fast-cli version
+	Provider          string    `json:"provider,omitempty"` // Empty for fast.com
+	Via               string    `json:"via,omitempty"`      // Interface or proxy the test went through
+	Trigger           string    `json:"trigger,omitempty"`  // Why the daemon ran the test out of schedule
+	DownloadMbps      float64   `json:"download_mbps"`
+	UploadMbps        float64   `json:"upload_mbps"`
+	LatencyMs         float64   `json:"latency_ms"`
+	JitterMs          float64   `json:"jitter_ms"`
+	DownloadLatencyMs float64   `json:"download_latency_ms,omitempty"`
+	UploadLatencyMs   float64   `json:"upload_latency_ms,omitempty"`
+	RPM               float64   `json:"rpm,omitempty"`
+	DownloadCV        float64   `json:"download_cv,omitempty"`
+	UploadCV          float64   `json:"upload_cv,omitempty"`
+	ClockSkewSeconds  float64   `json:"clock_skew_seconds,omitempty"`
+	Runs              int       `json:"runs,omitempty"` // Set on hourly aggregates to the number of runs they stand for
+	WiFi              *wifiInfo `json:"wifi,omitempty"`
+	// How each server did, for deprioritizing bad ones in later runs
+	Servers []historyServer  `json:"servers,omitempty"`
+	Outage  *historyOutage   `json:"outage,omitempty"`  // Set on entries that stand for an outage, not a test
+	Network *networkIdentity `json:"network,omitempty"` // What results are grouped by, see networkIdentity.name
+	Tags    resultTags       `json:"tags,omitempty"`
+}
+
+// historyFloatFields lists the numeric fields of historyEntry, for code that
+// treats them generically (CSV columns, aggregation).
+var historyFloatFields = []struct {
+	Name  string
+	Field func(e *historyEntry) *float64
+}{
+	{"download_mbps", func(e *historyEntry) *float64 { return &e.DownloadMbps }},
+	{"upload_mbps", func(e *historyEntry) *float64 { return &e.UploadMbps }},
+	{"latency_ms", func(e *historyEntry) *float64 { return &e.LatencyMs }},
+	{"jitter_ms", func(e *historyEntry) *float64 { return &e.JitterMs }},
+	{"download_latency_ms", func(e *historyEntry) *float64 { return &e.DownloadLatencyMs }},
+	{"upload_latency_ms", func(e *historyEntry) *float64 { return &e.UploadLatencyMs }},
+	{"rpm", func(e *historyEntry) *float64 { return &e.RPM }},
+	{"download_cv", func(e *historyEntry) *float64 { return &e.DownloadCV }},
+	{"upload_cv", func(e *historyEntry) *float64 { return &e.UploadCV }},
+}
+
+// hostInfo identifies where and with what a result was produced, so that
+// results collected from several probes can be told apart.
+type hostInfo struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Version  string `json:"version"`
+}
+
+func currentHost() hostInfo {
+	hostname, _ := os.Hostname()
+	return hostInfo{Hostname: hostname, OS: runtime.GOOS, Arch: runtime.GOARCH, Version: version}
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// dedupKey identifies a run across probes. Entries recorded before runs had
+// an ID fall back to their timestamp and headline numbers.
+func (e historyEntry) dedupKey() string {
+	if e.ID != "" {
+		return e.ID
+	}
+	return fmt.Sprintf("%s/%.3f/%.3f", e.Time.UTC().Format(time.RFC3339Nano), e.DownloadMbps, e.UploadMbps)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newHistoryEntry(res testResult) historyEntry {
+	downloadConsistency, _ := measureConsistency(res.Download.Samples)
+	uploadConsistency, _ := measureConsistency(res.Upload.Samples)
+	host := currentHost()
+	provider := res.Provider
+	if provider == providerFast {
+		provider = "" // Keeps entries the same as before providers existed
+	}
+	return historyEntry{
+		ID:                res.ID,
+		Time:              res.StartedAt,
+		EndTime:           res.EndedAt,
+		Host:              host.Hostname,
+		Platform:          host.OS + "/" + host.Arch,
+		Version:           host.Version,
+		Provider:          provider,
+		Via:               res.Via,
+		Trigger:           res.Trigger,
+		Tags:              res.Tags,
+		DownloadMbps:      res.Download.Mbps,
+		UploadMbps:        res.Upload.Mbps,
+		LatencyMs:         durationMs(res.IdleLatency.Avg),
+		JitterMs:          durationMs(res.IdleLatency.Jitter),
+		DownloadLatencyMs: durationMs(res.DownloadLatency.Avg),
+		UploadLatencyMs:   durationMs(res.UploadLatency.Avg),
+		RPM:               responsivenessRPM(res.LoadedLatencySamples()),
+		DownloadCV:        downloadConsistency.CV,
+		UploadCV:          uploadConsistency.CV,
+		ClockSkewSeconds:  res.ClockSkew.Seconds(),
+		WiFi:              res.WiFi,
+		Servers:           newHistoryServers(res),
+		Network:           res.Network,
+	}
+}
+
+func appendHistory(path string, entry historyEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening history file: %w", err)
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding history entry: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing history entry: %w", err)
+	}
+	return nil
+}
+
+// loadHistory returns all recorded entries in file order (oldest first).
+// A missing file is not an error, it just means nothing was recorded yet.
+func loadHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening history file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("decoding history line %d: %w", lineNo, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history file: %w", err)
+	}
+	return entries, nil
+}
+
+// recordHistory appends the result to the history file unless disabled, and
+// returns the full history including this run for trend reporting.
+func recordHistory(opts *options, res testResult) []historyEntry {
+	if opts.NoHistory {
+		return nil
+	}
+	entry := newHistoryEntry(res)
+	history, err := loadHistory(opts.HistoryPath)
+	if err != nil {
+		log.Printf("Warning: reading history: %v", err)
+	}
+	history = append(history, entry)
+	if err := appendHistory(opts.HistoryPath, entry); err != nil {
+		log.Printf("Warning: recording result to history: %v", err)
+	}
+	return speedResults(history)
+}
+
+// speedResults leaves the outages out of entries, for code that looks at
+// the speeds.
+func speedResults(entries []historyEntry) []historyEntry {
+	return slices.DeleteFunc(slices.Clone(entries), func(e historyEntry) bool { return e.Outage != nil })
+}
+
+// saveHistory rewrites the whole history file atomically, so that a crash
+// halfway through never leaves a truncated file behind.
+func saveHistory(path string, entries []historyEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating history directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".history-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("creating temporary history file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	tmp.Chmod(0o644)            // CreateTemp is owner-only, match appendHistory
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			tmp.Close()
+			return fmt.Errorf("encoding history entry: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing history file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing history file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing history file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyCSVColumn maps one CSV column to a history entry field
+type historyCSVColumn struct {
+	Name string
+	Get  func(e *historyEntry) string
+	Set  func(e *historyEntry, v string) error
+}
+
+// historyCSVColumns defines the CSV layout used by export and import, so
+// that both directions stay in sync as fields are added.
+var historyCSVColumns = append([]historyCSVColumn{
+	{"id", func(e *historyEntry) string { return e.ID }, func(e *historyEntry, v string) error { e.ID = v; return nil }},
+	{"time", func(e *historyEntry) string { return e.Time.Format(time.RFC3339Nano) }, func(e *historyEntry, v string) (err error) {
+		e.Time, err = time.Parse(time.RFC3339Nano, v)
+		return err
+	}},
+	{"runs", func(e *historyEntry) string { return strconv.Itoa(e.Runs) }, func(e *historyEntry, v string) (err error) {
+		if v != "" {
+			e.Runs, err = strconv.Atoi(v)
+		}
+		return err
+	}},
+}, slices.Concat(historyFloatCSVColumns(), outageCSVColumns, []historyCSVColumn{tagsCSVColumn, networkCSVColumn})...)
+
+func historyFloatCSVColumns() []historyCSVColumn {
+	columns := make([]historyCSVColumn, len(historyFloatFields))
+	for i, f := range historyFloatFields {
+		columns[i] = csvFloatColumn(f.Name, f.Field)
+	}
+	return columns
+}
+
+func csvFloatColumn(name string, field func(e *historyEntry) *float64) historyCSVColumn {
+	return historyCSVColumn{
+		Name: name,
+		Get:  func(e *historyEntry) string { return strconv.FormatFloat(*field(e), 'f', -1, 64) },
+		Set: func(e *historyEntry, v string) error {
+			if v == "" {
+				return nil
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			*field(e) = f
+			return err
+		},
+	}
+}
+
+// runHistory implements `fast-cli history <export|import|prune|stats>`.
+func runHistory(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fast-cli history <export|import|prune|stats> [flags]")
+	}
+	switch args[0] {
+	case "stats", "--stats":
+		return runHistoryStats(args[1:])
+	case "export":
+		return runHistoryExport(args[1:])
+	case "import":
+		return runHistoryImport(args[1:])
+	case "prune":
+		return runHistoryPrune(args[1:])
+	default:
+		return fmt.Errorf("unknown history command %q, expected export, import, prune or stats", args[0])
+	}
+}
+
+// historyExportFlags are the flags of `fast-cli history export`.
+type historyExportFlags struct {
+	HistoryPath, Format, Output, Network string
+}
+
+func newHistoryExportFlagSet(f *historyExportFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli history export", flag.ContinueOnError)
+	fs.StringVar(&f.HistoryPath, "history", defaultHistoryPath(), "history `file` to export")
+	fs.StringVar(&f.Format, "format", "json", "output format: json or csv")
+	fs.StringVar(&f.Output, "output", "-", "`file` to write to, - for stdout")
+	fs.StringVar(&f.Network, "network", "", networkFilterHelp)
+	return fs
+}
+
+func runHistoryExport(args []string) error {
+	var f historyExportFlags
+	if err := newHistoryExportFlagSet(&f).Parse(args); err != nil {
+		return err
+	}
+
+	entries, err := loadHistory(f.HistoryPath)
+	if err != nil {
+		return err
+	}
+	entries = onNetwork(entries, f.Network)
+
+	var w io.Writer = os.Stdout
+	if f.Output != "-" {
+		f, err := os.Create(f.Output)
+		if err != nil {
+			return fmt.Errorf("creating export file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch f.Format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []historyEntry{} // Export "[]" rather than "null"
+		}
+		return enc.Encode(entries)
+	case "csv":
+		return writeHistoryCSV(w, entries)
+	default:
+		return fmt.Errorf("unknown export format %q, expected json or csv", f.Format)
+	}
+}
+
+func writeHistoryCSV(w io.Writer, entries []historyEntry) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(historyCSVColumns))
+	for i, col := range historyCSVColumns {
+		header[i] = col.Name
+	}
+	cw.Write(header)
+
+	row := make([]string, len(historyCSVColumns))
+	for i := range entries {
+		for j, col := range historyCSVColumns {
+			row[j] = col.Get(&entries[i])
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readHistoryCSV(r io.Reader) ([]historyEntry, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	// Map header names to columns so files with reordered or missing columns still import
+	index := make(map[string]int)
+	for i, name := range records[0] {
+		index[strings.TrimSpace(name)] = i
+	}
+	var entries []historyEntry
+	for lineNo, record := range records[1:] {
+		var entry historyEntry
+		for _, col := range historyCSVColumns {
+			i, ok := index[col.Name]
+			if !ok || i >= len(record) {
+				continue
+			}
+			if err := col.Set(&entry, record[i]); err != nil {
+				return nil, fmt.Errorf("CSV line %d, column %s: %w", lineNo+2, col.Name, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readHistoryFile accepts anything export produces (JSON array, CSV) as well
+// as raw history files (JSON lines), detected from the content.
+func readHistoryFile(path string) ([]historyEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	trimmed := bytes.TrimSpace(data)
+
+	switch {
+	case len(trimmed) == 0:
+		return nil, nil
+	case trimmed[0] == '[':
+		var entries []historyEntry
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		return entries, nil
+	case trimmed[0] == '{':
+		var entries []historyEntry
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var entry historyEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("decoding %s: %w", path, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries, scanner.Err()
+	default:
+		entries, err := readHistoryCSV(bytes.NewReader(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return entries, nil
+	}
+}
+
+func newHistoryImportFlagSet(historyPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli history import", flag.ContinueOnError)
+	fs.StringVar(historyPath, "history", defaultHistoryPath(), "history `file` to merge into")
+	return fs
+}
+
+func runHistoryImport(args []string) error {
+	var historyPath string
+	fs := newHistoryImportFlagSet(&historyPath)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: fast-cli history import [flags] FILE...")
+	}
+
+	entries, err := loadHistory(historyPath)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[e.dedupKey()] = true
+	}
+
+	added, skipped := 0, 0
+	for _, path := range fs.Args() {
+		imported, err := readHistoryFile(path)
+		if err != nil {
+			return err
+		}
+		for _, e := range imported {
+			if e.Time.IsZero() {
+				return fmt.Errorf("%s: entry without a timestamp", path)
+			}
+			if seen[e.dedupKey()] {
+				skipped++
+				continue
+			}
+			seen[e.dedupKey()] = true
+			entries = append(entries, e)
+			added++
+		}
+	}
+
+	if added > 0 {
+		// Keep the file in chronological order, trend reporting reads it newest-last
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+		if err := saveHistory(historyPath, entries); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Imported %d results, skipped %d duplicates.\n", added, skipped)
+	return nil
+}
+
+// historyStatsFlags are the flags of `fast-cli history stats`.
+type historyStatsFlags struct {
+	HistoryPath, Network string
+	ByNetwork            bool
+}
+
+func newHistoryStatsFlagSet(f *historyStatsFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli history stats", flag.ContinueOnError)
+	fs.StringVar(&f.HistoryPath, "history", defaultHistoryPath(), "history `file` to summarize")
+	fs.StringVar(&f.Network, "network", "", networkFilterHelp)
+	fs.BoolVar(&f.ByNetwork, "by-network", false, "summarize each network the results were measured on instead of each month")
+	return fs
+}
+
+// runHistoryStats implements `fast-cli history stats`: per month, the tests
+// and their median speeds, and the outages and availability.
+func runHistoryStats(args []string) error {
+	var f historyStatsFlags
+	if err := parseFlags(newHistoryStatsFlagSet(&f), args); err != nil {
+		return err
+	}
+	entries, err := loadHistory(f.HistoryPath)
+	if err != nil {
+		return err
+	}
+	if entries = onNetwork(entries, f.Network); len(entries) == 0 {
+		if f.Network != "" {
+			return fmt.Errorf("no results recorded on network %q in %s", f.Network, f.HistoryPath)
+		}
+		return fmt.Errorf("no results recorded in %s yet", f.HistoryPath)
+	}
+	if f.ByNetwork {
+		printNetworkStats(entries)
+		return nil
+	}
+
+	fmt.Printf("%-8s %6s %14s %14s %8s %10s %13s\n", "Month", "Tests", "Download", "Upload", "Outages", "Downtime", "Availability")
+	first, speeds := entries[0].Time.Local(), speedResults(entries)
+	for month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.Local); month.Before(time.Now()); month = month.AddDate(0, 1, 0) {
+		next := month.AddDate(0, 1, 0)
+		var tests int
+		var down, up []float64
+		for _, e := range speeds {
+			if !e.Time.Before(month) && e.Time.Before(next) {
+				tests += max(e.Runs, 1)
+				down, up = append(down, e.DownloadMbps), append(up, e.UploadMbps)
+			}
+		}
+		_, percent, downtime, outages := monthAvailability(entries, next)
+		medians := fmt.Sprintf("%14s %14s", "-", "-")
+		if len(down) > 0 {
+			medians = fmt.Sprintf("%9.2f Mbps %9.2f Mbps", median(down), median(up))
+		}
+		fmt.Printf("%-8s %6d %s %8d %10s %12.2f%%\n", month.Format("2006-01"), tests, medians, outages, downtime.Round(time.Second), percent)
+	}
+	return nil
+}
+
+// printNetworkStats prints, for each network the results were measured on,
+// most tested first, their number, when, and their median speeds and latency.
+func printNetworkStats(entries []historyEntry) {
+	type network struct {
+		name                string
+		tests               int
+		first, last         time.Time
+		down, up, latencies []float64
+	}
+	byName := map[string]*network{}
+	var networks []*network
+	for _, e := range speedResults(entries) {
+		name := "unknown" // Recorded before networks were
+		if e.Network != nil {
+			name = e.Network.name()
+		}
+		n := byName[name]
+		if n == nil {
+			n = &network{name: name, first: e.Time}
+			byName[name] = n
+			networks = append(networks, n)
+		}
+		n.tests += max(e.Runs, 1)
+		n.last = e.Time
+		n.down, n.up, n.latencies = append(n.down, e.DownloadMbps), append(n.up, e.UploadMbps), append(n.latencies, e.LatencyMs)
+	}
+	sort.SliceStable(networks, func(i, j int) bool { return networks[i].tests > networks[j].tests })
+
+	fmt.Printf("%-24s %6s %-10s %-10s %14s %14s %10s\n", "Network", "Tests", "First", "Last", "Download", "Upload", "Latency")
+	for _, n := range networks {
+		fmt.Printf("%-24s %6d %-10s %-10s %9.2f Mbps %9.2f Mbps %7.1f ms\n", n.name, n.tests, n.first.Local().Format(time.DateOnly), n.last.Local().Format(time.DateOnly),
+			median(n.down), median(n.up), median(n.latencies))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hookTimeout bounds how long a pre/post command may run, so a hung hook
+// can't stall the daemon forever.
+const hookTimeout = 5 * time.Minute
+
+// runHook runs command through the system shell. Its stdout goes to the
+// progress stream so that it never mixes with machine-readable output.
+func runHook(command string, stdin io.Reader, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = statusOut
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s", hookTimeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// hookEnv exposes the flattened result as FAST_RESULT_* variables, e.g.
+// FAST_RESULT_DOWNLOAD_MBPS, for hooks that don't want to parse JSON.
+func hookEnv(res testResult) []string {
+	e := newHistoryEntry(res)
+	env := []string{
+		"FAST_RESULT_ID=" + e.ID,
+		"FAST_RESULT_TIME=" + e.Time.Format(time.RFC3339),
+	}
+	for _, f := range historyFloatFields {
+		env = append(env, fmt.Sprintf("FAST_RESULT_%s=%s", strings.ToUpper(f.Name), strconv.FormatFloat(*f.Field(&e), 'f', -1, 64)))
+	}
+	return env
+}
+
+// runPostHook runs --post-cmd with the JSON result on stdin. When the test
+// failed stdin is empty and FAST_RESULT_ERROR holds the error instead, so
+// that whatever the pre hook paused can still be resumed.
+func runPostHook(opts *options, res testResult, testErr error) {
+	var stdin bytes.Buffer
+	var env []string
+	if testErr != nil {
+		env = []string{"FAST_RESULT_ERROR=" + testErr.Error()}
+	} else {
+		env = hookEnv(res)
+		if err := json.NewEncoder(&stdin).Encode(newJSONResult(res, opts.Plan)); err != nil {
+			log.Printf("Warning: encoding result for post hook: %v", err)
+		}
+	}
+	if err := runHook(opts.PostCmd, &stdin, env); err != nil {
+		log.Printf("Warning: post-cmd failed: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Values of --host-check
+const (
+	hostCheckWarn   = "warn"
+	hostCheckRefuse = "refuse"
+	hostCheckOff    = "off"
+)
+
+var hostCheckModes = []string{hostCheckWarn, hostCheckRefuse, hostCheckOff}
+
+// expectedHosts are the hosts, as patterns of path.Match, of the servers
+// each provider's API hands out. LibreSpeed lists community servers on any
+// host, so only --allow-host patterns check them.
+var expectedHosts = map[string][]string{
+	providerFast:       {"*.nflxvideo.net"},
+	providerCloudflare: {"speed.cloudflare.com"},
+	providerMock:       {"127.0.0.1"},
+}
+
+// checkHosts matches the hosts of the servers a provider's API returned
+// against the ones expected of it and --allow-host, so that a hijacked DNS
+// or a tampered API can't point a scripted test, and its uploads, at any
+// host. It warns of the others, or with --host-check refuse fails.
+func (o *options) checkHosts(targets []target) error {
+	patterns := append(slices.Clone(expectedHosts[cmp.Or(o.Provider, providerFast)]), o.AllowHosts...)
+	if o.HostCheck == hostCheckOff || len(patterns) == 0 {
+		return nil
+	}
+	var unexpected []string
+	for _, t := range targets {
+		host := t.URL
+		if u, err := url.Parse(t.URL); err == nil && u.Host != "" {
+			host = strings.ToLower(u.Hostname())
+		}
+		matches := func(pattern string) bool {
+			ok, _ := path.Match(pattern, host)
+			return ok
+		}
+		if !slices.ContainsFunc(patterns, matches) && !slices.Contains(unexpected, host) {
+			unexpected = append(unexpected, host)
+		}
+	}
+	if len(unexpected) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("the server list named hosts other than %s: %s", strings.Join(patterns, ", "), strings.Join(unexpected, ", "))
+	if o.HostCheck == hostCheckRefuse {
+		return withCode(codeUnexpectedHost, err)
+	}
+	log.Printf("Warning: %v; testing them anyway, --host-check refuse would stop", err)
+	return nil
+}
+
+func badHostPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err != nil
+}
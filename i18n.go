@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// locale is a language of the text output, by its ISO 639-1 code. JSON, the
+// history and every sink stay in English whatever it is, so that scripts and
+// dashboards don't depend on where the test ran.
+type locale string
+
+const localeEnglish locale = "en"
+
+// Languages with a catalog, English first
+var languages = []string{"en", "de", "es", "fr", "pt", "tr"}
+
+// lang is the language of the terminal output, from --lang or the environment
+var lang = localeEnglish
+
+// detectLocale is the language of --lang if set, else that of the first of
+// LC_ALL, LC_MESSAGES and LANG that is set, as gettext picks it. Languages
+// without a catalog, and C or POSIX, are English.
+func detectLocale(flagValue string) locale {
+	if flagValue != "" {
+		return locale(flagValue)
+	}
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		code, _, _ := strings.Cut(v, "_") // de_DE.UTF-8
+		code, _, _ = strings.Cut(code, ".")
+		if code = strings.ToLower(code); slices.Contains(languages, code) {
+			return locale(code)
+		}
+		return localeEnglish
+	}
+	return localeEnglish
+}
+
+// tr is msg in the language, or msg itself if the catalog lacks it.
+func (l locale) tr(msg string) string {
+	if t, ok := catalog[l][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+func (l locale) sprintf(format string, args ...any) string {
+	return fmt.Sprintf(l.tr(format), args...)
+}
+
+func (l locale) printf(format string, args ...any) {
+	fmt.Print(l.sprintf(format, args...))
+}
+
+func (l locale) fprintf(w io.Writer, format string, args ...any) {
+	fmt.Fprint(w, l.sprintf(format, args...))
+}
+
+// catalog maps the English messages of the text output to their
+// translations. Formats keep the verbs of the English one, reordered with
+// explicit argument indexes where the language needs it.
+var catalog = map[locale]map[string]string{
+	"de": {
+		"Fetching server list...":                                              "Serverliste wird abgerufen...",
+		"Found %d potential servers from API.\n":                               "%d mögliche Server von der API erhalten.\n",
+		"Pinging servers to select the best ones...":                           "Server werden angepingt, um die besten auszuwählen...",
+		"\nSelected servers for speed tests:":                                  "\nAusgewählte Server für die Messung:",
+		"  - %s (%s, %s) - Latency: %v":                                        "  - %s (%s, %s) - Latenz: %v",
+		"\nMeasuring idle latency to %s alongside the prechecks...\n":          "\nLatenz im Leerlauf zu %s wird neben den Vorprüfungen gemessen...\n",
+		"\nMeasuring idle latency to %s...\n":                                  "\nLatenz im Leerlauf zu %s wird gemessen...\n",
+		"\nPerforming download test...":                                        "\nDownload wird gemessen...",
+		"\nPerforming upload test...":                                          "\nUpload wird gemessen...",
+		"Starting download from %d server(s) for %s, chunk size %d bytes...\n": "Download von %d Server(n) für %s, Blockgröße %d Byte...\n",
+		"Starting upload to %d server(s) for %s, chunk size %d bytes...\n":     "Upload zu %d Server(n) für %s, Blockgröße %d Byte...\n",
+		"Data cap reached after %s.\n":                                         "Datenlimit nach %s erreicht.\n",
+		"Speed stable, stopped after %s.\n":                                    "Geschwindigkeit stabil, nach %s beendet.\n",
+		"%s: %s of %s, %.1f Mbps so far\n":                                     "%s: %s von %s, bisher %.1f Mbit/s\n",
+
+		"\n--- Speed Test Results ---":           "\n--- Ergebnisse der Geschwindigkeitsmessung ---",
+		"Download Speed: %.*f Mbps\n":            "Download: %.*f Mbit/s\n",
+		"Upload Speed: %.*f Mbps\n":              "Upload: %.*f Mbit/s\n",
+		"Average Ping to selected servers: %s\n": "Durchschnittlicher Ping zu den ausgewählten Servern: %s\n",
+		"Latency":                                "Latenz",
+		"Avg":                                    "Mittel",
+		"vs idle":                                "vs. Ruhe",
+		"Unloaded":                               "Ohne Last",
+		"During download":                        "Beim Download",
+		"During upload":                          "Beim Upload",
+		"N/A":                                    "k. A.",
+		"Verdict: %s\n":                          "Urteil: %s\n",
+		"\nChecking for other traffic...":        "\nAnderer Datenverkehr wird geprüft...",
+		"Responsiveness: %s\n":                   "Reaktionsfähigkeit: %s\n",
+		"Download Consistency: %s\n":             "Gleichmäßigkeit des Downloads: %s\n",
+		"Upload Consistency: %s\n":               "Gleichmäßigkeit des Uploads: %s\n",
+		"TCP congestion control: %s\n":           "TCP-Überlastkontrolle: %s\n",
+		"Tester load: %.0f%% CPU downloading":    "Last des Testgeräts: %.0f %% CPU beim Download",
+		", %.0f%% uploading":                     ", %.0f %% beim Upload",
+		"%s (100%% per core, of %d), %.0f MiB peak memory\n": "%s (100 %% je Kern, von %d), Speicherspitze %.0f MiB\n",
+		"High":     "hoch",
+		"Medium":   "mittel",
+		"Low":      "niedrig",
+		"steady":   "stetig",
+		"variable": "schwankend",
+		"unstable": "instabil",
+
+		"\n--- What can you do with this connection? ---": "\n--- Was geht mit dieser Verbindung? ---",
+		"Netflix streaming":    "Netflix-Streaming",
+		"Video calls":          "Videoanrufe",
+		"Cloud gaming":         "Cloud-Gaming",
+		"VoIP MOS (estimated)": "VoIP-MOS (geschätzt)",
+		"Up to %d concurrent 4K streams (or %d HD)":                  "Bis zu %d 4K-Streams gleichzeitig (oder %d HD)",
+		"HD only, up to %d concurrent streams":                       "Nur HD, bis zu %d Streams gleichzeitig",
+		"SD only, a single stream":                                   "Nur SD, ein einzelner Stream",
+		"Not enough bandwidth for smooth streaming":                  "Zu wenig Bandbreite für ruckelfreies Streaming",
+		"Poor - latency %v / jitter %v will cause lag and dropouts":  "Schlecht - Latenz %v / Jitter %v führen zu Verzögerungen und Aussetzern",
+		"Excellent - 1080p group calls":                              "Ausgezeichnet - Gruppenanrufe in 1080p",
+		"Good - 720p group calls":                                    "Gut - Gruppenanrufe in 720p",
+		"Poor - audio-only or low-resolution video":                  "Schlecht - nur Audio oder Video in geringer Auflösung",
+		"Excellent - 4K streaming":                                   "Ausgezeichnet - Streaming in 4K",
+		"Good - 1080p60":                                             "Gut - 1080p60",
+		"Playable - 720p60, fast-paced games may feel sluggish":      "Spielbar - 720p60, schnelle Spiele können träge wirken",
+		"Not recommended":                                            "Nicht empfohlen",
+		"%.1f (excellent)":                                           "%.1f (ausgezeichnet)",
+		"%.1f (good)":                                                "%.1f (gut)",
+		"%.1f (fair)":                                                "%.1f (mittel)",
+		"%.1f (poor)":                                                "%.1f (schlecht)",
+		"%.1f (bad)":                                                 "%.1f (sehr schlecht)",
+		"downloading":                                                "beim Download",
+		"uploading":                                                  "beim Upload",
+		"unknown, idle latency could not be measured":                "unbekannt, die Latenz im Leerlauf ließ sich nicht messen",
+		"unknown, latency under load could not be measured":          "unbekannt, die Latenz unter Last ließ sich nicht messen",
+		"no noticeable bufferbloat, latency holds steady under load": "kein spürbarer Bufferbloat, die Latenz bleibt unter Last stabil",
+		"minor bufferbloat, latency rises by %v while %s":            "leichter Bufferbloat, die Latenz steigt %[2]s um %[1]v",
+		"moderate bufferbloat, latency rises by %v while %s; calls may stutter during large transfers":                                 "mäßiger Bufferbloat, die Latenz steigt %[2]s um %[1]v; Anrufe können bei großen Übertragungen stocken",
+		"significant bufferbloat, latency rises by %v while %s; enable SQM (fq_codel/cake) on the router":                              "deutlicher Bufferbloat, die Latenz steigt %[2]s um %[1]v; SQM (fq_codel/cake) am Router einschalten",
+		"severe bufferbloat, latency rises by %v while %s; calls and games suffer whenever the link is busy, enable SQM on the router": "starker Bufferbloat, die Latenz steigt %[2]s um %[1]v; Anrufe und Spiele leiden, sobald die Leitung ausgelastet ist, SQM am Router einschalten",
+	},
+	"es": {
+		"Fetching server list...":                                              "Obteniendo la lista de servidores...",
+		"Found %d potential servers from API.\n":                               "La API ofreció %d servidores posibles.\n",
+		"Pinging servers to select the best ones...":                           "Haciendo ping a los servidores para elegir los mejores...",
+		"\nSelected servers for speed tests:":                                  "\nServidores elegidos para la prueba:",
+		"  - %s (%s, %s) - Latency: %v":                                        "  - %s (%s, %s) - Latencia: %v",
+		"\nMeasuring idle latency to %s alongside the prechecks...\n":          "\nMidiendo la latencia en reposo hacia %s junto con las comprobaciones previas...\n",
+		"\nMeasuring idle latency to %s...\n":                                  "\nMidiendo la latencia en reposo hacia %s...\n",
+		"\nPerforming download test...":                                        "\nMidiendo la descarga...",
+		"\nPerforming upload test...":                                          "\nMidiendo la subida...",
+		"Starting download from %d server(s) for %s, chunk size %d bytes...\n": "Descargando de %d servidor(es) durante %s, bloques de %d bytes...\n",
+		"Starting upload to %d server(s) for %s, chunk size %d bytes...\n":     "Subiendo a %d servidor(es) durante %s, bloques de %d bytes...\n",
+		"Data cap reached after %s.\n":                                         "Límite de datos alcanzado tras %s.\n",
+		"Speed stable, stopped after %s.\n":                                    "Velocidad estable, detenido tras %s.\n",
+		"%s: %s of %s, %.1f Mbps so far\n":                                     "%s: %s de %s, %.1f Mbps hasta ahora\n",
+		"Download":                                                             "Descarga",
+		"Upload":                                                               "Subida",
+
+		"\n--- Speed Test Results ---":           "\n--- Resultados de la prueba de velocidad ---",
+		"Download Speed: %.*f Mbps\n":            "Velocidad de descarga: %.*f Mbps\n",
+		"Upload Speed: %.*f Mbps\n":              "Velocidad de subida: %.*f Mbps\n",
+		"Average Ping to selected servers: %s\n": "Ping medio a los servidores elegidos: %s\n",
+		"Latency":                                "Latencia",
+		"Avg":                                    "Media",
+		"vs idle":                                "vs reposo",
+		"Unloaded":                               "Sin carga",
+		"During download":                        "Descargando",
+		"During upload":                          "Subiendo",
+		"N/A":                                    "N/D",
+		"Verdict: %s\n":                          "Veredicto: %s\n",
+		"\nChecking for other traffic...":        "\nComprobando si hay otro tráfico...",
+		"Responsiveness: %s\n":                   "Capacidad de respuesta: %s\n",
+		"Download Consistency: %s\n":             "Regularidad de la descarga: %s\n",
+		"Upload Consistency: %s\n":               "Regularidad de la subida: %s\n",
+		"TCP congestion control: %s\n":           "Control de congestión TCP: %s\n",
+		"Tester load: %.0f%% CPU downloading":    "Carga del equipo: %.0f%% de CPU al descargar",
+		", %.0f%% uploading":                     ", %.0f%% al subir",
+		"%s (100%% per core, of %d), %.0f MiB peak memory\n": "%s (100%% por núcleo, de %d), pico de memoria %.0f MiB\n",
+		"High":     "alta",
+		"Medium":   "media",
+		"Low":      "baja",
+		"steady":   "estable",
+		"variable": "variable",
+		"unstable": "inestable",
+
+		"\n--- What can you do with this connection? ---": "\n--- ¿Qué puedes hacer con esta conexión? ---",
+		"Netflix streaming":    "Streaming de Netflix",
+		"Video calls":          "Videollamadas",
+		"Cloud gaming":         "Juego en la nube",
+		"VoIP MOS (estimated)": "MOS de VoIP (estimado)",
+		"Up to %d concurrent 4K streams (or %d HD)":                  "Hasta %d streams 4K a la vez (o %d HD)",
+		"HD only, up to %d concurrent streams":                       "Solo HD, hasta %d streams a la vez",
+		"SD only, a single stream":                                   "Solo SD, un único stream",
+		"Not enough bandwidth for smooth streaming":                  "No hay ancho de banda suficiente para un streaming fluido",
+		"Poor - latency %v / jitter %v will cause lag and dropouts":  "Mala - una latencia de %v / jitter de %v causarán retrasos y cortes",
+		"Excellent - 1080p group calls":                              "Excelente - llamadas grupales en 1080p",
+		"Good - 720p group calls":                                    "Buena - llamadas grupales en 720p",
+		"Poor - audio-only or low-resolution video":                  "Mala - solo audio o vídeo de baja resolución",
+		"Excellent - 4K streaming":                                   "Excelente - streaming en 4K",
+		"Good - 1080p60":                                             "Bueno - 1080p60",
+		"Playable - 720p60, fast-paced games may feel sluggish":      "Jugable - 720p60, los juegos rápidos pueden ir lentos",
+		"Not recommended":                                            "No recomendado",
+		"%.1f (excellent)":                                           "%.1f (excelente)",
+		"%.1f (good)":                                                "%.1f (buena)",
+		"%.1f (fair)":                                                "%.1f (aceptable)",
+		"%.1f (poor)":                                                "%.1f (mala)",
+		"%.1f (bad)":                                                 "%.1f (muy mala)",
+		"downloading":                                                "al descargar",
+		"uploading":                                                  "al subir",
+		"unknown, idle latency could not be measured":                "desconocido, no se pudo medir la latencia en reposo",
+		"unknown, latency under load could not be measured":          "desconocido, no se pudo medir la latencia bajo carga",
+		"no noticeable bufferbloat, latency holds steady under load": "sin bufferbloat apreciable, la latencia se mantiene estable bajo carga",
+		"minor bufferbloat, latency rises by %v while %s":            "bufferbloat leve, la latencia sube %v %s",
+		"moderate bufferbloat, latency rises by %v while %s; calls may stutter during large transfers":                                 "bufferbloat moderado, la latencia sube %v %s; las llamadas pueden entrecortarse durante transferencias grandes",
+		"significant bufferbloat, latency rises by %v while %s; enable SQM (fq_codel/cake) on the router":                              "bufferbloat importante, la latencia sube %v %s; activa SQM (fq_codel/cake) en el router",
+		"severe bufferbloat, latency rises by %v while %s; calls and games suffer whenever the link is busy, enable SQM on the router": "bufferbloat grave, la latencia sube %v %s; las llamadas y los juegos sufren cuando la conexión está ocupada, activa SQM en el router",
+	},
+	"fr": {
+		"Fetching server list...":                                              "Récupération de la liste des serveurs...",
+		"Found %d potential servers from API.\n":                               "L'API a proposé %d serveurs possibles.\n",
+		"Pinging servers to select the best ones...":                           "Ping des serveurs pour choisir les meilleurs...",
+		"\nSelected servers for speed tests:":                                  "\nServeurs choisis pour le test :",
+		"  - %s (%s, %s) - Latency: %v":                                        "  - %s (%s, %s) - Latence : %v",
+		"\nMeasuring idle latency to %s alongside the prechecks...\n":          "\nMesure de la latence au repos vers %s pendant les vérifications préalables...\n",
+		"\nMeasuring idle latency to %s...\n":                                  "\nMesure de la latence au repos vers %s...\n",
+		"\nPerforming download test...":                                        "\nMesure du téléchargement...",
+		"\nPerforming upload test...":                                          "\nMesure de l'envoi...",
+		"Starting download from %d server(s) for %s, chunk size %d bytes...\n": "Téléchargement depuis %d serveur(s) pendant %s, blocs de %d octets...\n",
+		"Starting upload to %d server(s) for %s, chunk size %d bytes...\n":     "Envoi vers %d serveur(s) pendant %s, blocs de %d octets...\n",
+		"Data cap reached after %s.\n":                                         "Plafond de données atteint après %s.\n",
+		"Speed stable, stopped after %s.\n":                                    "Débit stable, arrêt après %s.\n",
+		"%s: %s of %s, %.1f Mbps so far\n":                                     "%s : %s sur %s, %.1f Mbit/s pour l'instant\n",
+		"Download":                                                             "Téléchargement",
+		"Upload":                                                               "Envoi",
+
+		"\n--- Speed Test Results ---":           "\n--- Résultats du test de débit ---",
+		"Download Speed: %.*f Mbps\n":            "Débit descendant : %.*f Mbit/s\n",
+		"Upload Speed: %.*f Mbps\n":              "Débit montant : %.*f Mbit/s\n",
+		"Average Ping to selected servers: %s\n": "Ping moyen vers les serveurs choisis : %s\n",
+		"Latency":                                "Latence",
+		"Avg":                                    "Moy.",
+		"Jitter":                                 "Gigue",
+		"vs idle":                                "vs repos",
+		"Unloaded":                               "Au repos",
+		"During download":                        "Téléchargement",
+		"During upload":                          "Envoi",
+		"N/A":                                    "N/D",
+		"Verdict: %s\n":                          "Verdict : %s\n",
+		"\nChecking for other traffic...":        "\nRecherche d'autre trafic...",
+		"Responsiveness: %s\n":                   "Réactivité : %s\n",
+		"Download Consistency: %s\n":             "Régularité du débit descendant : %s\n",
+		"Upload Consistency: %s\n":               "Régularité du débit montant : %s\n",
+		"TCP congestion control: %s\n":           "Contrôle de congestion TCP : %s\n",
+		"Tester load: %.0f%% CPU downloading":    "Charge de l'appareil : %.0f %% de CPU pendant le téléchargement",
+		", %.0f%% uploading":                     ", %.0f %% pendant l'envoi",
+		"%s (100%% per core, of %d), %.0f MiB peak memory\n": "%s (100 %% par cœur, sur %d), pic de mémoire %.0f Mio\n",
+		"High":     "élevée",
+		"Medium":   "moyenne",
+		"Low":      "faible",
+		"steady":   "stable",
+		"variable": "variable",
+		"unstable": "instable",
+
+		"\n--- What can you do with this connection? ---": "\n--- Que permet cette connexion ? ---",
+		"Netflix streaming":    "Streaming Netflix",
+		"Video calls":          "Appels vidéo",
+		"Cloud gaming":         "Jeu en streaming",
+		"VoIP MOS (estimated)": "MOS VoIP (estimé)",
+		"Up to %d concurrent 4K streams (or %d HD)":                  "Jusqu'à %d flux 4K simultanés (ou %d HD)",
+		"HD only, up to %d concurrent streams":                       "HD seulement, jusqu'à %d flux simultanés",
+		"SD only, a single stream":                                   "SD seulement, un seul flux",
+		"Not enough bandwidth for smooth streaming":                  "Pas assez de débit pour un streaming fluide",
+		"Poor - latency %v / jitter %v will cause lag and dropouts":  "Mauvais - une latence de %v / gigue de %v causeront du décalage et des coupures",
+		"Excellent - 1080p group calls":                              "Excellent - appels de groupe en 1080p",
+		"Good - 720p group calls":                                    "Bon - appels de groupe en 720p",
+		"Poor - audio-only or low-resolution video":                  "Mauvais - audio seul ou vidéo en basse résolution",
+		"Excellent - 4K streaming":                                   "Excellent - streaming en 4K",
+		"Good - 1080p60":                                             "Bon - 1080p60",
+		"Playable - 720p60, fast-paced games may feel sluggish":      "Jouable - 720p60, les jeux rapides peuvent sembler lents",
+		"Not recommended":                                            "Déconseillé",
+		"%.1f (good)":                                                "%.1f (bon)",
+		"%.1f (fair)":                                                "%.1f (moyen)",
+		"%.1f (poor)":                                                "%.1f (médiocre)",
+		"%.1f (bad)":                                                 "%.1f (mauvais)",
+		"downloading":                                                "pendant le téléchargement",
+		"uploading":                                                  "pendant l'envoi",
+		"unknown, idle latency could not be measured":                "inconnu, la latence au repos n'a pas pu être mesurée",
+		"unknown, latency under load could not be measured":          "inconnu, la latence en charge n'a pas pu être mesurée",
+		"no noticeable bufferbloat, latency holds steady under load": "pas de bufferbloat notable, la latence reste stable en charge",
+		"minor bufferbloat, latency rises by %v while %s":            "bufferbloat léger, la latence augmente de %v %s",
+		"moderate bufferbloat, latency rises by %v while %s; calls may stutter during large transfers":                                 "bufferbloat modéré, la latence augmente de %v %s ; les appels peuvent saccader pendant les gros transferts",
+		"significant bufferbloat, latency rises by %v while %s; enable SQM (fq_codel/cake) on the router":                              "bufferbloat important, la latence augmente de %v %s ; activez SQM (fq_codel/cake) sur le routeur",
+		"severe bufferbloat, latency rises by %v while %s; calls and games suffer whenever the link is busy, enable SQM on the router": "bufferbloat sévère, la latence augmente de %v %s ; appels et jeux souffrent dès que la ligne est chargée, activez SQM sur le routeur",
+	},
+	"pt": {
+		"Fetching server list...":                                              "Obtendo a lista de servidores...",
+		"Found %d potential servers from API.\n":                               "A API ofereceu %d servidores possíveis.\n",
+		"Pinging servers to select the best ones...":                           "Fazendo ping nos servidores para escolher os melhores...",
+		"\nSelected servers for speed tests:":                                  "\nServidores escolhidos para o teste:",
+		"  - %s (%s, %s) - Latency: %v":                                        "  - %s (%s, %s) - Latência: %v",
+		"\nMeasuring idle latency to %s alongside the prechecks...\n":          "\nMedindo a latência em repouso até %s junto com as verificações prévias...\n",
+		"\nMeasuring idle latency to %s...\n":                                  "\nMedindo a latência em repouso até %s...\n",
+		"\nPerforming download test...":                                        "\nMedindo o download...",
+		"\nPerforming upload test...":                                          "\nMedindo o upload...",
+		"Starting download from %d server(s) for %s, chunk size %d bytes...\n": "Baixando de %d servidor(es) por %s, blocos de %d bytes...\n",
+		"Starting upload to %d server(s) for %s, chunk size %d bytes...\n":     "Enviando para %d servidor(es) por %s, blocos de %d bytes...\n",
+		"Data cap reached after %s.\n":                                         "Limite de dados atingido após %s.\n",
+		"Speed stable, stopped after %s.\n":                                    "Velocidade estável, parado após %s.\n",
+		"%s: %s of %s, %.1f Mbps so far\n":                                     "%s: %s de %s, %.1f Mbps até agora\n",
+
+		"\n--- Speed Test Results ---":           "\n--- Resultados do teste de velocidade ---",
+		"Download Speed: %.*f Mbps\n":            "Velocidade de download: %.*f Mbps\n",
+		"Upload Speed: %.*f Mbps\n":              "Velocidade de upload: %.*f Mbps\n",
+		"Average Ping to selected servers: %s\n": "Ping médio até os servidores escolhidos: %s\n",
+		"Latency":                                "Latência",
+		"Avg":                                    "Média",
+		"vs idle":                                "vs repouso",
+		"Unloaded":                               "Sem carga",
+		"During download":                        "No download",
+		"During upload":                          "No upload",
+		"N/A":                                    "N/D",
+		"Verdict: %s\n":                          "Veredito: %s\n",
+		"\nChecking for other traffic...":        "\nVerificando se há outro tráfego...",
+		"Responsiveness: %s\n":                   "Responsividade: %s\n",
+		"Download Consistency: %s\n":             "Regularidade do download: %s\n",
+		"Upload Consistency: %s\n":               "Regularidade do upload: %s\n",
+		"TCP congestion control: %s\n":           "Controle de congestionamento TCP: %s\n",
+		"Tester load: %.0f%% CPU downloading":    "Carga do dispositivo: %.0f%% de CPU durante o download",
+		", %.0f%% uploading":                     ", %.0f%% durante o upload",
+		"%s (100%% per core, of %d), %.0f MiB peak memory\n": "%s (100%% por núcleo, de %d), pico de memória %.0f MiB\n",
+		"High":     "alta",
+		"Medium":   "média",
+		"Low":      "baixa",
+		"steady":   "estável",
+		"variable": "variável",
+		"unstable": "instável",
+
+		"\n--- What can you do with this connection? ---": "\n--- O que dá para fazer com esta conexão? ---",
+		"Netflix streaming":    "Streaming da Netflix",
+		"Video calls":          "Videochamadas",
+		"Cloud gaming":         "Jogos na nuvem",
+		"VoIP MOS (estimated)": "MOS de VoIP (estimado)",
+		"Up to %d concurrent 4K streams (or %d HD)":                  "Até %d streams 4K simultâneos (ou %d HD)",
+		"HD only, up to %d concurrent streams":                       "Só HD, até %d streams simultâneos",
+		"SD only, a single stream":                                   "Só SD, um único stream",
+		"Not enough bandwidth for smooth streaming":                  "Banda insuficiente para streaming sem travamentos",
+		"Poor - latency %v / jitter %v will cause lag and dropouts":  "Ruim - latência de %v / jitter de %v causarão atraso e quedas",
+		"Excellent - 1080p group calls":                              "Excelente - chamadas em grupo em 1080p",
+		"Good - 720p group calls":                                    "Boa - chamadas em grupo em 720p",
+		"Poor - audio-only or low-resolution video":                  "Ruim - só áudio ou vídeo em baixa resolução",
+		"Excellent - 4K streaming":                                   "Excelente - streaming em 4K",
+		"Good - 1080p60":                                             "Bom - 1080p60",
+		"Playable - 720p60, fast-paced games may feel sluggish":      "Jogável - 720p60, jogos rápidos podem parecer lentos",
+		"Not recommended":                                            "Não recomendado",
+		"%.1f (excellent)":                                           "%.1f (excelente)",
+		"%.1f (good)":                                                "%.1f (boa)",
+		"%.1f (fair)":                                                "%.1f (razoável)",
+		"%.1f (poor)":                                                "%.1f (ruim)",
+		"%.1f (bad)":                                                 "%.1f (péssima)",
+		"downloading":                                                "durante o download",
+		"uploading":                                                  "durante o upload",
+		"unknown, idle latency could not be measured":                "desconhecido, não foi possível medir a latência em repouso",
+		"unknown, latency under load could not be measured":          "desconhecido, não foi possível medir a latência sob carga",
+		"no noticeable bufferbloat, latency holds steady under load": "sem bufferbloat perceptível, a latência se mantém estável sob carga",
+		"minor bufferbloat, latency rises by %v while %s":            "bufferbloat leve, a latência sobe %v %s",
+		"moderate bufferbloat, latency rises by %v while %s; calls may stutter during large transfers":                                 "bufferbloat moderado, a latência sobe %v %s; chamadas podem falhar durante transferências grandes",
+		"significant bufferbloat, latency rises by %v while %s; enable SQM (fq_codel/cake) on the router":                              "bufferbloat significativo, a latência sobe %v %s; ative o SQM (fq_codel/cake) no roteador",
+		"severe bufferbloat, latency rises by %v while %s; calls and games suffer whenever the link is busy, enable SQM on the router": "bufferbloat grave, a latência sobe %v %s; chamadas e jogos sofrem sempre que a conexão está ocupada, ative o SQM no roteador",
+	},
+	"tr": {
+		"Fetching server list...":                                              "Sunucu listesi alınıyor...",
+		"Found %d potential servers from API.\n":                               "API %d olası sunucu önerdi.\n",
+		"Pinging servers to select the best ones...":                           "En iyileri seçmek için sunuculara ping atılıyor...",
+		"\nSelected servers for speed tests:":                                  "\nTest için seçilen sunucular:",
+		"  - %s (%s, %s) - Latency: %v":                                        "  - %s (%s, %s) - Gecikme: %v",
+		"\nMeasuring idle latency to %s alongside the prechecks...\n":          "\nÖn kontrollerle birlikte %s sunucusuna boştaki gecikme ölçülüyor...\n",
+		"\nMeasuring idle latency to %s...\n":                                  "\n%s sunucusuna boştaki gecikme ölçülüyor...\n",
+		"\nPerforming download test...":                                        "\nİndirme testi yapılıyor...",
+		"\nPerforming upload test...":                                          "\nYükleme testi yapılıyor...",
+		"Starting download from %d server(s) for %s, chunk size %d bytes...\n": "%d sunucudan %s boyunca indiriliyor, parça boyutu %d bayt...\n",
+		"Starting upload to %d server(s) for %s, chunk size %d bytes...\n":     "%d sunucuya %s boyunca yükleniyor, parça boyutu %d bayt...\n",
+		"Data cap reached after %s.\n":                                         "Veri sınırına %s sonra ulaşıldı.\n",
+		"Speed stable, stopped after %s.\n":                                    "Hız sabit, %s sonra durduruldu.\n",
+		"%s: %s of %s, %.1f Mbps so far\n":                                     "%s: %s / %s, şu ana kadar %.1f Mbps\n",
+		"Download":                                                             "İndirme",
+		"Upload":                                                               "Yükleme",
+
+		"\n--- Speed Test Results ---":           "\n--- Hız Testi Sonuçları ---",
+		"Download Speed: %.*f Mbps\n":            "İndirme Hızı: %.*f Mbps\n",
+		"Upload Speed: %.*f Mbps\n":              "Yükleme Hızı: %.*f Mbps\n",
+		"Average Ping to selected servers: %s\n": "Seçilen sunuculara ortalama ping: %s\n",
+		"Latency":                                "Gecikme",
+		"Avg":                                    "Ort.",
+		"vs idle":                                "boşa göre",
+		"Unloaded":                               "Yüksüz",
+		"During download":                        "İndirirken",
+		"During upload":                          "Yüklerken",
+		"N/A":                                    "Yok",
+		"Verdict: %s\n":                          "Değerlendirme: %s\n",
+		"\nChecking for other traffic...":        "\nDiğer trafik kontrol ediliyor...",
+		"Responsiveness: %s\n":                   "Yanıt hızı: %s\n",
+		"Download Consistency: %s\n":             "İndirme tutarlılığı: %s\n",
+		"Upload Consistency: %s\n":               "Yükleme tutarlılığı: %s\n",
+		"TCP congestion control: %s\n":           "TCP tıkanıklık denetimi: %s\n",
+		"Tester load: %.0f%% CPU downloading":    "Test cihazı yükü: indirirken %%%.0f CPU",
+		", %.0f%% uploading":                     ", yüklerken %%%.0f",
+		"%s (100%% per core, of %d), %.0f MiB peak memory\n": "%s (çekirdek başına %%100, %d çekirdek), en yüksek bellek %.0f MiB\n",
+		"High":     "yüksek",
+		"Medium":   "orta",
+		"Low":      "düşük",
+		"steady":   "istikrarlı",
+		"variable": "değişken",
+		"unstable": "dengesiz",
+
+		"\n--- What can you do with this connection? ---": "\n--- Bu bağlantıyla neler yapabilirsiniz? ---",
+		"Netflix streaming":    "Netflix izleme",
+		"Video calls":          "Görüntülü görüşmeler",
+		"Cloud gaming":         "Bulut oyun",
+		"VoIP MOS (estimated)": "VoIP MOS (tahmini)",
+		"Up to %d concurrent 4K streams (or %d HD)":                  "Aynı anda en fazla %d 4K yayın (veya %d HD)",
+		"HD only, up to %d concurrent streams":                       "Yalnızca HD, aynı anda en fazla %d yayın",
+		"SD only, a single stream":                                   "Yalnızca SD, tek bir yayın",
+		"Not enough bandwidth for smooth streaming":                  "Akıcı izleme için bant genişliği yetersiz",
+		"Poor - latency %v / jitter %v will cause lag and dropouts":  "Kötü - %v gecikme / %v jitter takılma ve kopmalara yol açar",
+		"Excellent - 1080p group calls":                              "Mükemmel - 1080p grup görüşmeleri",
+		"Good - 720p group calls":                                    "İyi - 720p grup görüşmeleri",
+		"Poor - audio-only or low-resolution video":                  "Kötü - yalnızca ses veya düşük çözünürlüklü görüntü",
+		"Excellent - 4K streaming":                                   "Mükemmel - 4K yayın",
+		"Good - 1080p60":                                             "İyi - 1080p60",
+		"Playable - 720p60, fast-paced games may feel sluggish":      "Oynanabilir - 720p60, hızlı oyunlar ağır hissettirebilir",
+		"Not recommended":                                            "Önerilmez",
+		"%.1f (excellent)":                                           "%.1f (mükemmel)",
+		"%.1f (good)":                                                "%.1f (iyi)",
+		"%.1f (fair)":                                                "%.1f (orta)",
+		"%.1f (poor)":                                                "%.1f (zayıf)",
+		"%.1f (bad)":                                                 "%.1f (kötü)",
+		"downloading":                                                "indirme sırasında",
+		"uploading":                                                  "yükleme sırasında",
+		"unknown, idle latency could not be measured":                "bilinmiyor, boştaki gecikme ölçülemedi",
+		"unknown, latency under load could not be measured":          "bilinmiyor, yük altındaki gecikme ölçülemedi",
+		"no noticeable bufferbloat, latency holds steady under load": "belirgin bufferbloat yok, gecikme yük altında sabit kalıyor",
+		"minor bufferbloat, latency rises by %v while %s":            "hafif bufferbloat, %[2]s gecikme %[1]v artıyor",
+		"moderate bufferbloat, latency rises by %v while %s; calls may stutter during large transfers":                                 "orta düzeyde bufferbloat, %[2]s gecikme %[1]v artıyor; büyük aktarımlar sırasında görüşmeler takılabilir",
+		"significant bufferbloat, latency rises by %v while %s; enable SQM (fq_codel/cake) on the router":                              "ciddi bufferbloat, %[2]s gecikme %[1]v artıyor; yönlendiricide SQM'i (fq_codel/cake) açın",
+		"severe bufferbloat, latency rises by %v while %s; calls and games suffer whenever the link is busy, enable SQM on the router": "aşırı bufferbloat, %[2]s gecikme %[1]v artıyor; bağlantı meşgulken görüşmeler ve oyunlar etkilenir, yönlendiricide SQM'i açın",
+	},
+}
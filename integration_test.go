@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockTestOptions parses args after the flags of a short test against the
+// mock provider, with no config, history or status output of the user's.
+func mockTestOptions(t *testing.T, args ...string) *options {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	out := statusOut
+	statusOut = io.Discard
+	t.Cleanup(func() { statusOut = out })
+
+	base := []string{"--provider", "mock", "--no-history", "--mock-rate", "1000/500", "--mock-latency", "2ms", "--download-duration", "3s", "--upload-duration", "3s"}
+	opts, err := parseOptions(append(base, args...))
+	if err != nil {
+		t.Fatalf("parsing options: %v", err)
+	}
+	return opts
+}
+
+func TestMockServerList(t *testing.T) {
+	mockTestOptions(t)
+	resp, err := fetchMockServers(http.DefaultClient, defaultURLCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Targets) != defaultURLCount {
+		t.Errorf("got %d servers, want %d", len(resp.Targets), defaultURLCount)
+	}
+	if resp.Client.Location.City != "Mockville" {
+		t.Errorf("client city = %q, want Mockville", resp.Client.Location.City)
+	}
+	for _, s := range resp.Targets {
+		if !strings.HasPrefix(s.URL, mockServer.url+"/s") {
+			t.Errorf("server %s isn't on the mock at %s", s.URL, mockServer.url)
+		}
+	}
+}
+
+func TestMockRangeDownload(t *testing.T) {
+	mockTestOptions(t)
+	resp, err := fetchMockServers(http.DefaultClient, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := resp.Targets[0]
+
+	res, err := http.Get(srv.rangeURL(100, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(body) != 1000 {
+		t.Errorf("range of 1000 bytes: status %d, %d bytes", res.StatusCode, len(body))
+	}
+
+	res, err = http.Get(modifySpeedtestURL(srv.URL, "/range/10-5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("backwards range: status %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestMockUploadSink(t *testing.T) {
+	mockTestOptions(t)
+	resp, err := fetchMockServers(http.DefaultClient, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(resp.Targets[0].uploadURL(), "application/octet-stream", bytes.NewReader(make([]byte, 64<<10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("upload: status %d", res.StatusCode)
+	}
+}
+
+// TestMockSpeedTest runs the whole test against the mock, whose simulated
+// link sets the speeds the result must come close to.
+func TestMockSpeedTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a 6s test")
+	}
+	opts := mockTestOptions(t)
+	res, err := runSpeedTest(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Servers) != numServersToTest {
+		t.Errorf("tested against %d servers, want %d", len(res.Servers), numServersToTest)
+	}
+	for _, phase := range []struct {
+		name string
+		got  float64
+		want float64
+	}{{"download", res.Download.Mbps, 1000}, {"upload", res.Upload.Mbps, 500}} {
+		// Only completed chunks count, which leaves out the last of each stream
+		if phase.got < phase.want/2 || phase.got > phase.want*1.1 {
+			t.Errorf("%s at %.1f Mbps, want about %.0f", phase.name, phase.got, phase.want)
+		}
+	}
+
+	data, err := json.Marshal(newJSONResult(res, opts.Plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Status   string `json:"status"`
+		Provider string `json:"provider"`
+		Servers  []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Download struct {
+			Mbps float64 `json:"mbps"`
+		} `json:"download"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Status != "ok" || out.Provider != providerMock || len(out.Servers) != numServersToTest {
+		t.Errorf("JSON result: status %q, provider %q, %d servers", out.Status, out.Provider, len(out.Servers))
+	}
+	if out.Download.Mbps != res.Download.Mbps {
+		t.Errorf("JSON download at %.1f Mbps, the result at %.1f", out.Download.Mbps, res.Download.Mbps)
+	}
+}
+
+// TestMockSmallBudget runs a test in a budget too small for both phases,
+// which skips the upload rather than failing.
+func TestMockSmallBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a 5s test")
+	}
+	opts := mockTestOptions(t, "--total-timeout", "5s")
+	res, err := runSpeedTest(opts)
+	if err != nil || len(res.Errors) > 0 {
+		t.Fatalf("test in a 5s budget: %v %v", err, res.Errors)
+	}
+	if res.Download.Mbps == 0 || res.Upload.Bytes != 0 {
+		t.Errorf("download at %.1f Mbps and %d bytes uploaded, want a download only", res.Download.Mbps, res.Upload.Bytes)
+	}
+	if d := res.EndedAt.Sub(res.StartedAt); d > 5*time.Second {
+		t.Errorf("test took %v in a 5s budget", d)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"maps"
+)
+
+// With --check-integrity, downloads hash integritySampleLen bytes of every
+// integritySampleStride of the resource they read. A server sends the same
+// bytes for the same offset every time, and chunk ranges start at multiples
+// of the stride (see randomRange), so a sample that differs from what the
+// stream read at that offset before was altered on the way, as a
+// transparent proxy injecting content would. Servers that generate new data
+// for every request (LibreSpeed does) leave nothing to compare, and are
+// reported unverified.
+const (
+	integritySampleStride = 1 << 20
+	integritySampleLen    = 4 << 10
+)
+
+// chunkSampler hashes the sampled bytes of one chunk as they are read.
+type chunkSampler struct {
+	offset  int64       // In the resource, of the next byte
+	windows []windowSum // Sampled so far
+}
+
+// windowSum is the hash of the sample at offset index*integritySampleStride.
+type windowSum struct {
+	index int64
+	sum   uint32
+	n     int // Bytes hashed, short for a chunk that ended in the sample
+}
+
+// reset starts a chunk whose range begins at offset start.
+func (s *chunkSampler) reset(start int64) { s.offset, s.windows = start, s.windows[:0] }
+
+func (s *chunkSampler) write(p []byte) {
+	for len(p) > 0 {
+		pos := int(s.offset % integritySampleStride)
+		n := min(len(p), integritySampleStride-pos)
+		if pos < integritySampleLen {
+			if pos == 0 || len(s.windows) == 0 {
+				s.windows = append(s.windows, windowSum{index: s.offset / integritySampleStride})
+			}
+			n = min(len(p), integritySampleLen-pos)
+			w := &s.windows[len(s.windows)-1]
+			w.sum, w.n = crc32.Update(w.sum, castagnoli, p[:n]), w.n+n
+		}
+		p = p[n:]
+		s.offset += int64(n)
+	}
+}
+
+// streamIntegrity is what the chunks of one download stream looked like.
+type streamIntegrity struct {
+	Sums      map[int64]map[uint32]int // Samples by offset, then by hash
+	Chunks    int                      // Checked
+	WrongSize int                      // Whose length or Content-Length wasn't the range asked for
+}
+
+func newStreamIntegrity() *streamIntegrity {
+	return &streamIntegrity{Sums: map[int64]map[uint32]int{}}
+}
+
+// record checks a chunk of want bytes that read to its end.
+func (i *streamIntegrity) record(contentLength, read int64, want int, windows []windowSum) {
+	i.Chunks++
+	if read != int64(want) || contentLength >= 0 && contentLength != int64(want) {
+		i.WrongSize++
+		return
+	}
+	for _, w := range windows {
+		if w.n < integritySampleLen {
+			continue
+		}
+		if i.Sums[w.index] == nil {
+			i.Sums[w.index] = map[uint32]int{}
+		}
+		i.Sums[w.index][w.sum]++
+	}
+}
+
+// integrityReport is the content integrity check of a download phase.
+type integrityReport struct {
+	Chunks     int // Checked
+	WrongSize  int // Truncated, padded, or announced with another length
+	Samples    int // Read more than once at the same offset, so compared
+	Altered    int // Of those, differed from what the same server sent most often
+	Compared   int // Streams whose chunks could be compared
+	Unverified int // Streams whose server sent different data for every chunk
+}
+
+// summarizeIntegrity sums up the streams of a phase run with
+// --check-integrity, or returns nil for one without.
+func summarizeIntegrity(streams []serverStats) *integrityReport {
+	var r *integrityReport
+	for _, s := range streams {
+		if s.Integrity == nil {
+			continue
+		}
+		if r == nil {
+			r = &integrityReport{}
+		}
+		r.Chunks += s.Integrity.Chunks
+		r.WrongSize += s.Integrity.WrongSize
+		var samples, altered int
+		agreed := false
+		for sums := range maps.Values(s.Integrity.Sums) {
+			total, most := 0, 0
+			for n := range maps.Values(sums) {
+				total += n
+				most = max(most, n)
+			}
+			if total < 2 {
+				continue
+			}
+			samples += total
+			altered += total - most
+			agreed = agreed || most >= 2
+		}
+		switch {
+		case agreed:
+			r.Compared++
+			r.Samples += samples
+			r.Altered += altered
+		case samples > 0:
+			r.Unverified++
+		}
+	}
+	return r
+}
+
+// Status is failed, ok, or unverified when the sizes were right but there
+// was no content to compare.
+func (r integrityReport) Status() string {
+	switch {
+	case r.WrongSize > 0 || r.Altered > 0:
+		return "failed"
+	case r.Compared > 0:
+		return "ok"
+	}
+	return "unverified"
+}
+
+func (r integrityReport) String() string {
+	switch r.Status() {
+	case "failed":
+		return fmt.Sprintf("FAILED, of %d chunks %d had the wrong size, and %d of %d samples differed from what the server sent for the same bytes; a proxy on the path may be altering downloads", r.Chunks, r.WrongSize, r.Altered, r.Samples)
+	case "unverified":
+		return fmt.Sprintf("unverified, the sizes of %d chunks were right but no server sent the same data twice to compare", r.Chunks)
+	}
+	s := fmt.Sprintf("ok, %d chunks checked", r.Chunks)
+	if r.Unverified > 0 {
+		s += fmt.Sprintf(", %d streams only by size as their servers sent new data every time", r.Unverified)
+	}
+	return s
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Just enough of the Kafka protocol to produce one record: Metadata v4 to
+// find the partition leader and Produce v3 with a v2 record batch, the
+// oldest versions Kafka 4 still speaks. Plaintext listeners only, without
+// SASL.
+const (
+	kafkaTimeout       = 10 * time.Second
+	kafkaDefaultPort   = "9092"
+	kafkaMetadataKey   = 3
+	kafkaProduceKey    = 0
+	kafkaMaxResponse   = 16 << 20
+	kafkaAcksAll       = -1 // Every in-sync replica has the record, for at-least-once
+	kafkaLeaderMissing = 5  // LEADER_NOT_AVAILABLE, e.g. while a topic is auto-created
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli) // Of record batches
+
+// kafkaTarget is the --kafka flag, BROKER[,BROKER...]/TOPIC.
+type kafkaTarget struct {
+	Brokers []string
+	Topic   string
+}
+
+func (t *kafkaTarget) String() string {
+	if t.Topic == "" {
+		return ""
+	}
+	return strings.Join(t.Brokers, ",") + "/" + t.Topic
+}
+
+func (t *kafkaTarget) Set(value string) error {
+	brokers, topic, ok := strings.Cut(value, "/")
+	if !ok || topic == "" || brokers == "" || strings.Contains(topic, "/") {
+		return fmt.Errorf("invalid Kafka target %q, expected BROKER[,BROKER...]/TOPIC", value)
+	}
+	*t = kafkaTarget{Topic: topic}
+	for _, b := range strings.Split(brokers, ",") {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			b = net.JoinHostPort(b, kafkaDefaultPort)
+		}
+		t.Brokers = append(t.Brokers, b)
+	}
+	return nil
+}
+
+// kafkaWriter encodes the big-endian primitives of the protocol.
+type kafkaWriter struct{ b []byte }
+
+func (w *kafkaWriter) int8(v int8)   { w.b = append(w.b, byte(v)) }
+func (w *kafkaWriter) int16(v int16) { w.b = binary.BigEndian.AppendUint16(w.b, uint16(v)) }
+func (w *kafkaWriter) int32(v int32) { w.b = binary.BigEndian.AppendUint32(w.b, uint32(v)) }
+func (w *kafkaWriter) int64(v int64) { w.b = binary.BigEndian.AppendUint64(w.b, uint64(v)) }
+func (w *kafkaWriter) varint(v int64) {
+	w.b = binary.AppendVarint(w.b, v) // Zigzag, as records use
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.b = append(w.b, b...)
+}
+
+// kafkaReader decodes a response, remembering the first short read.
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		r.err = errors.New("truncated Kafka response")
+		return make([]byte, n)
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *kafkaReader) int8() int8   { return int8(r.next(1)[0]) }
+func (r *kafkaReader) int16() int16 { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaReader) int32() int32 { return int32(binary.BigEndian.Uint32(r.next(4))) }
+func (r *kafkaReader) int64() int64 { return int64(binary.BigEndian.Uint64(r.next(8))) }
+
+// string reads a string, nullable ones included.
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// array calls each for every element of an array.
+func (r *kafkaReader) array(each func()) {
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		each()
+	}
+}
+
+// kafkaConn is a connection to one broker.
+type kafkaConn struct {
+	conn          net.Conn
+	r             *bufio.Reader
+	correlationID int32
+}
+
+func dialKafka(ctx context.Context, addr string) (*kafkaConn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return &kafkaConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// roundTrip sends a request with header v1 and returns the body of the
+// response, past its header.
+func (c *kafkaConn) roundTrip(apiKey, apiVersion int16, body []byte) (*kafkaReader, error) {
+	c.correlationID++
+	var w kafkaWriter
+	w.int32(0) // Size, filled in below
+	w.int16(apiKey)
+	w.int16(apiVersion)
+	w.int32(c.correlationID)
+	w.string("fast-cli")
+	w.b = append(w.b, body...)
+	binary.BigEndian.PutUint32(w.b, uint32(len(w.b)-4))
+	if _, err := c.conn.Write(w.b); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > kafkaMaxResponse {
+		return nil, fmt.Errorf("Kafka response of %d bytes", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	r := &kafkaReader{b: resp}
+	if id := r.int32(); id != c.correlationID {
+		return nil, fmt.Errorf("Kafka response to request %d, expected %d", id, c.correlationID)
+	}
+	return r, nil
+}
+
+// leader asks the broker for the address of the leader of partition p of
+// topic, where p is chosen by key among the topic's partitions, so that
+// the results of one probe stay in order.
+func (c *kafkaConn) leader(topic string, key []byte) (addr string, partition int32, err error) {
+	var w kafkaWriter
+	w.int32(1)
+	w.string(topic)
+	w.int8(1) // allow_auto_topic_creation
+	r, err := c.roundTrip(kafkaMetadataKey, 4, w.b)
+	if err != nil {
+		return "", 0, fmt.Errorf("fetching metadata: %w", err)
+	}
+	return kafkaLeader(r, topic, key)
+}
+
+// kafkaLeader reads the leader of the partition key goes to from a
+// Metadata v4 response, failing rather than picking another when that
+// partition has no leader, which would reorder the probe's results.
+func kafkaLeader(r *kafkaReader, topic string, key []byte) (addr string, partition int32, err error) {
+	r.int32() // throttle_time_ms
+	brokers := map[int32]string{}
+	r.array(func() {
+		id, host, port := r.int32(), r.string(), r.int32()
+		r.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	})
+	r.string() // cluster_id
+	r.int32()  // controller_id
+	var topicErr int16
+	var indexes []int32
+	leaders := map[int32]int32{}
+	r.array(func() {
+		topicErr = r.int16()
+		r.string() // name
+		r.int8()   // is_internal
+		r.array(func() {
+			partErr, index, leader := r.int16(), r.int32(), r.int32()
+			r.array(func() { r.int32() }) // replica_nodes
+			r.array(func() { r.int32() }) // isr_nodes
+			indexes = append(indexes, index)
+			if partErr == 0 {
+				leaders[index] = leader
+			}
+		})
+	})
+	switch {
+	case r.err != nil:
+		return "", 0, r.err
+	case topicErr == kafkaLeaderMissing:
+		return "", 0, fmt.Errorf("topic %s has no leader yet", topic)
+	case topicErr != 0:
+		return "", 0, fmt.Errorf("metadata of topic %s: broker error %d", topic, topicErr)
+	case len(indexes) == 0:
+		return "", 0, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	for _, index := range indexes {
+		if index < 0 || int(index) >= len(indexes) {
+			return "", 0, fmt.Errorf("metadata of topic %s: partition %d of %d", topic, index, len(indexes))
+		}
+	}
+	p := int32(crc32.ChecksumIEEE(key) % uint32(len(indexes)))
+	leader, ok := leaders[p]
+	if !ok {
+		return "", 0, fmt.Errorf("partition %s/%d has no leader", topic, p)
+	}
+	addr, ok = brokers[leader]
+	if !ok {
+		return "", 0, fmt.Errorf("leader %d of %s/%d isn't among the brokers", leader, topic, p)
+	}
+	return addr, p, nil
+}
+
+// kafkaRecordBatch encodes one record as a v2 record batch.
+func kafkaRecordBatch(key, value []byte, ts time.Time) []byte {
+	var rec kafkaWriter
+	rec.int8(0)   // attributes
+	rec.varint(0) // timestamp_delta
+	rec.varint(0) // offset_delta
+	rec.varint(int64(len(key)))
+	rec.b = append(rec.b, key...)
+	rec.varint(int64(len(value)))
+	rec.b = append(rec.b, value...)
+	rec.varint(0) // headers
+
+	// From attributes to the end, what the CRC covers
+	var tail kafkaWriter
+	tail.int16(0) // attributes: no compression, create time
+	tail.int32(0) // last_offset_delta
+	tail.int64(ts.UnixMilli())
+	tail.int64(ts.UnixMilli())
+	tail.int64(-1) // producer_id
+	tail.int16(-1) // producer_epoch
+	tail.int32(-1) // base_sequence
+	tail.int32(1)  // records
+	tail.varint(int64(len(rec.b)))
+	tail.b = append(tail.b, rec.b...)
+
+	var batch kafkaWriter
+	batch.int64(0)                              // base_offset
+	batch.int32(int32(4 + 1 + 4 + len(tail.b))) // batch_length, from partition_leader_epoch on
+	batch.int32(-1)                             // partition_leader_epoch
+	batch.int8(2)                               // magic
+	batch.b = binary.BigEndian.AppendUint32(batch.b, crc32.Checksum(tail.b, castagnoli))
+	batch.b = append(batch.b, tail.b...)
+	return batch.b
+}
+
+// publishKafka produces msg to t's topic, waiting for every in-sync replica
+// to have it. Brokers are tried in turn for the metadata.
+func publishKafka(t kafkaTarget, msg outboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaTimeout)
+	defer cancel()
+	key := []byte(msg.Key)
+	var addr string
+	var partition int32
+	var errs []error
+	for _, b := range t.Brokers {
+		conn, err := dialKafka(ctx, b)
+		if err == nil {
+			addr, partition, err = conn.leader(t.Topic, key)
+			conn.conn.Close()
+		}
+		if err == nil {
+			break
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b, err))
+	}
+	if addr == "" {
+		return fmt.Errorf("no Kafka broker answered: %w", errors.Join(errs...))
+	}
+
+	conn, err := dialKafka(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("connecting to the leader %s: %w", addr, err)
+	}
+	defer conn.conn.Close()
+	var w kafkaWriter
+	w.int16(-1) // transactional_id, null
+	w.int16(kafkaAcksAll)
+	w.int32(int32(kafkaTimeout / time.Millisecond))
+	w.int32(1)
+	w.string(t.Topic)
+	w.int32(1)
+	w.int32(partition)
+	w.bytes(kafkaRecordBatch(key, msg.Value, time.Now()))
+	r, err := conn.roundTrip(kafkaProduceKey, 3, w.b)
+	if err != nil {
+		return fmt.Errorf("producing to %s: %w", addr, err)
+	}
+	var code int16
+	r.array(func() {
+		r.string()
+		r.array(func() {
+			r.int32() // partition
+			code = r.int16()
+			r.int64() // base_offset
+			r.int64() // log_append_time
+		})
+	})
+	if r.err != nil {
+		return r.err
+	}
+	if code != 0 {
+		return fmt.Errorf("producing to %s/%d: broker error %d", t.Topic, partition, code)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPartition struct {
+	err           int16
+	index, leader int32
+}
+
+// metadataResponse encodes a Metadata v4 response body with brokers 1 and 2
+// and one topic.
+func metadataResponse(topicErr int16, partitions []testPartition) *kafkaReader {
+	var w kafkaWriter
+	w.int32(0) // throttle_time_ms
+	w.int32(2)
+	for id, host := range map[int32]string{1: "broker-1", 2: "broker-2"} {
+		w.int32(id)
+		w.string(host)
+		w.int32(9092)
+		w.int16(-1) // rack, null
+	}
+	w.int16(-1) // cluster_id, null
+	w.int32(1)  // controller_id
+	w.int32(1)
+	w.int16(topicErr)
+	w.string("results")
+	w.int8(0)
+	w.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		w.int16(p.err)
+		w.int32(p.index)
+		w.int32(p.leader)
+		w.int32(0) // replica_nodes
+		w.int32(0) // isr_nodes
+	}
+	return &kafkaReader{b: w.b}
+}
+
+func TestKafkaLeader(t *testing.T) {
+	key := []byte("probe-a")
+	keyed := int32(crc32.ChecksumIEEE(key) % 3) // The partition key goes to of three
+	other := (keyed + 1) % 3
+	healthy := func(leader int32) []testPartition {
+		return []testPartition{{0, 0, leader}, {0, 1, leader}, {0, 2, leader}}
+	}
+	withoutLeader := healthy(1)
+	withoutLeader[keyed].err = kafkaLeaderMissing
+	unknownBroker := healthy(1)
+	unknownBroker[keyed].leader = 7
+	otherUnknown := healthy(2)
+	otherUnknown[other].leader = 7
+
+	for _, tc := range []struct {
+		name     string
+		resp     *kafkaReader
+		wantAddr string
+		wantErr  string
+	}{
+		{"leader of the key's partition", metadataResponse(0, healthy(2)), "broker-2:9092", ""},
+		{"another partition's leader unknown", metadataResponse(0, otherUnknown), "broker-2:9092", ""},
+		{"key's partition without leader", metadataResponse(0, withoutLeader), "", "has no leader"},
+		{"leader not among the brokers", metadataResponse(0, unknownBroker), "", "isn't among the brokers"},
+		{"partition index out of range", metadataResponse(0, []testPartition{{0, 0, 1}, {0, 3, 1}}), "", "partition 3 of 2"},
+		{"negative partition index", metadataResponse(0, []testPartition{{0, -1, 1}}), "", "partition -1 of 1"},
+		{"no partitions", metadataResponse(0, nil), "", "has no partitions"},
+		{"topic being created", metadataResponse(kafkaLeaderMissing, nil), "", "no leader yet"},
+		{"topic error", metadataResponse(3, nil), "", "broker error 3"},
+		{"truncated", &kafkaReader{b: metadataResponse(0, healthy(1)).b[:20]}, "", "truncated"},
+	} {
+		addr, partition, err := kafkaLeader(tc.resp, "results", key)
+		switch {
+		case tc.wantErr == "" && (err != nil || addr != tc.wantAddr || partition != keyed):
+			t.Errorf("%s: got %s partition %d, %v; want %s partition %d", tc.name, addr, partition, err, tc.wantAddr, keyed)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: got error %v, want one about %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestKafkaRecordBatch(t *testing.T) {
+	// The check value of CRC-32C, which record batches use rather than IEEE
+	if got := crc32.Checksum([]byte("123456789"), castagnoli); got != 0xe3069283 {
+		t.Fatalf("castagnoli checksum of 123456789 = %#x, want 0xe3069283", got)
+	}
+
+	ts := time.UnixMilli(1760000000000)
+	for _, tc := range []struct {
+		name       string
+		key, value []byte
+	}{
+		{"small", []byte("probe-a"), []byte(`{"download_mbps":100}`)},
+		{"empty key", nil, []byte("{}")},
+		{"value over a varint byte", []byte("k"), bytes.Repeat([]byte("x"), 300)},
+	} {
+		batch := kafkaRecordBatch(tc.key, tc.value, ts)
+		const crcAt = 8 + 4 + 4 + 1 // base_offset, batch_length, partition_leader_epoch, magic
+		if len(batch) < crcAt+4 {
+			t.Fatalf("%s: batch of %d bytes", tc.name, len(batch))
+		}
+		if n := binary.BigEndian.Uint32(batch[8:]); int(n) != len(batch)-12 {
+			t.Errorf("%s: batch_length %d, want %d", tc.name, n, len(batch)-12)
+		}
+		if batch[16] != 2 {
+			t.Errorf("%s: magic %d, want 2", tc.name, batch[16])
+		}
+		if got, want := binary.BigEndian.Uint32(batch[crcAt:]), crc32.Checksum(batch[crcAt+4:], castagnoli); got != want {
+			t.Errorf("%s: CRC %#x, want %#x", tc.name, got, want)
+		}
+		if int64(binary.BigEndian.Uint64(batch[crcAt+4+2+4:])) != ts.UnixMilli() {
+			t.Errorf("%s: first_timestamp isn't %d", tc.name, ts.UnixMilli())
+		}
+		if !bytes.HasSuffix(batch, append(append([]byte{}, tc.value...), 0)) || !bytes.Contains(batch, tc.key) {
+			t.Errorf("%s: the record doesn't end with the value and no headers", tc.name)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringSecret reads the password of a generic item of the login keychain,
+// as stored with security add-generic-password -s fast-cli -a NAME -w.
+func keyringSecret(name string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("security: %s", cmp.Or(strings.TrimSpace(stderr.String()), err.Error()))
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
@@ -0,0 +1,28 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringSecret reads a secret of the Secret Service (GNOME Keyring,
+// KWallet), as stored with secret-tool store --label fast-cli service
+// fast-cli account NAME.
+func keyringSecret(name string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("secret-tool: %s", cmp.Or(strings.TrimSpace(stderr.String()), err.Error()))
+	}
+	if len(out) == 0 {
+		return "", fmt.Errorf("no secret for service %s and account %s", keyringService, name)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	procCredReadW = advapi32.NewProc("CredReadW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+const credTypeGeneric = 1
+
+// credential is CREDENTIALW.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keyringSecret reads the password of the generic credential fast-cli/NAME
+// of the Credential Manager, as stored with
+// cmdkey /generic:fast-cli/NAME /user:fast-cli /pass.
+func keyringSecret(name string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(keyringService + "/" + name)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	if _, err := winCall(procCredReadW, uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); err != nil {
+		return "", fmt.Errorf("no credential %s/%s: %w", keyringService, name, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	// cmdkey and the control panel store UTF-16, other tools often UTF-8
+	if len(blob)%2 == 0 && len(blob) > 0 && blob[1] == 0 {
+		u := make([]uint16, len(blob)/2)
+		for i := range u {
+			u[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+		}
+		return string(utf16.Decode(u)), nil
+	}
+	return string(blob), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type lanFlags struct {
+	Browse   time.Duration
+	List     bool
+	Peers    stringList
+	Duration time.Duration
+	Format   string
+}
+
+func newLANFlagSet(f *lanFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli lan", flag.ContinueOnError)
+	fs.DurationVar(&f.Browse, "browse", 3*time.Second, "how long to wait for peers to answer")
+	fs.BoolVar(&f.List, "list", false, "only list the peers found, don't test")
+	fs.Var(&f.Peers, "peer", "test only the peer with this `name` (repeatable)")
+	fs.DurationVar(&f.Duration, "duration", 10*time.Second, "download and upload time per peer")
+	fs.StringVar(&f.Format, "format", formatText, "output `format`: text or json")
+	return fs
+}
+
+// runLAN implements `fast-cli lan`: find fast-cli serve instances on the
+// local network via mDNS and test against each of them in turn.
+func runLAN(args []string) error {
+	var f lanFlags
+	if err := parseFlags(newLANFlagSet(&f), args); err != nil {
+		return err
+	}
+	switch {
+	case f.Browse <= 0 || f.Duration <= 0:
+		return fmt.Errorf("--browse and --duration must be positive")
+	case f.Format != formatText && f.Format != formatJSON:
+		return fmt.Errorf("unknown format %q, expected text or json", f.Format)
+	}
+
+	progress := io.Writer(os.Stdout)
+	if f.Format != formatText {
+		progress = os.Stderr
+	}
+	fmt.Fprintln(progress, "Looking for fast-cli serve peers on the local network...")
+	peers, err := browseMDNS(f.Browse)
+	if err != nil {
+		return err
+	}
+	if len(f.Peers) > 0 {
+		peers = slices.DeleteFunc(peers, func(p lanPeer) bool {
+			return !slices.ContainsFunc(f.Peers, func(name string) bool { return strings.EqualFold(name, p.Name) })
+		})
+	}
+	if len(peers) == 0 {
+		return fmt.Errorf("no peers found; start one with `fast-cli serve` and check that the firewall allows mDNS (UDP 5353)")
+	}
+	slices.SortFunc(peers, func(a, b lanPeer) int { return strings.Compare(a.Name, b.Name) })
+
+	if f.List {
+		if f.Format == formatJSON {
+			return writeJSON(peers)
+		}
+		for _, p := range peers {
+			fmt.Printf("%-24s %s\n", p.Name, p.Addr)
+		}
+		return nil
+	}
+
+	statusOut = progress
+	type lanResult struct {
+		Peer   lanPeer
+		Result testResult
+		Err    error
+	}
+	var results []lanResult
+	for _, p := range peers {
+		fmt.Fprintf(progress, "\n=== %s (%s) ===\n", p.Name, p.Addr)
+		opts := &options{
+			Servers:          stringList{p.Addr},
+			Streams:          1,
+			DownloadDuration: f.Duration,
+			UploadDuration:   f.Duration,
+			Format:           f.Format,
+		}
+		res, err := runSpeedTest(opts)
+		results = append(results, lanResult{p, res, err})
+	}
+
+	if f.Format == formatJSON {
+		type jsonLANResult struct {
+			Name   string      `json:"name"`
+			Addr   string      `json:"addr"`
+			Result *jsonResult `json:"result,omitempty"`
+			Error  string      `json:"error,omitempty"`
+		}
+		var out []jsonLANResult
+		for _, r := range results {
+			jr := jsonLANResult{Name: r.Peer.Name, Addr: r.Peer.Addr}
+			if r.Err != nil {
+				jr.Error = r.Err.Error()
+			} else {
+				res := newJSONResult(r.Result, plan{})
+				jr.Result = &res
+			}
+			out = append(out, jr)
+		}
+		return writeJSON(out)
+	}
+
+	fmt.Println("\n--- LAN Peers ---")
+	fmt.Printf("%-24s %-22s %14s %14s %10s\n", "Peer", "Address", "Download", "Upload", "Latency")
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("%-24s %-22s failed: %v\n", r.Peer.Name, r.Peer.Addr, r.Err)
+			continue
+		}
+		fmt.Printf("%-24s %-22s %9.2f Mbps %9.2f Mbps %10s\n", r.Peer.Name, r.Peer.Addr,
+			r.Result.Download.Mbps, r.Result.Upload.Mbps, r.Result.IdleLatency.Avg.Round(100*time.Microsecond))
+	}
+	return nil
+}
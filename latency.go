@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyStats summarizes a series of round-trip samples against one server.
+type latencyStats struct {
+	Samples []time.Duration
+	Min     time.Duration
+	Avg     time.Duration
+	Max     time.Duration
+	Jitter  time.Duration // Mean absolute difference between consecutive samples
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration // Tail latency, what breaks games and calls
+}
+
+func summarizeLatency(samples []time.Duration) latencyStats {
+	stats := latencyStats{Samples: samples}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	var total time.Duration
+	stats.Min = samples[0]
+	for i, s := range samples {
+		total += s
+		if s < stats.Min {
+			stats.Min = s
+		}
+		if s > stats.Max {
+			stats.Max = s
+		}
+		if i > 0 {
+			diff := s - samples[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			stats.Jitter += diff
+		}
+	}
+	stats.Avg = total / time.Duration(len(samples))
+	if len(samples) > 1 {
+		stats.Jitter /= time.Duration(len(samples) - 1)
+	}
+
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	stats.P50, stats.P95, stats.P99 = durationPercentile(sorted, 50), durationPercentile(sorted, 95), durationPercentile(sorted, 99)
+	return stats
+}
+
+// durationPercentile is percentile for sorted durations.
+func durationPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(math.Round(p/100*float64(len(sorted)-1)))]
+}
+
+const (
+	histogramBuckets    = 16
+	histogramMinSamples = 10 // Fewer samples than this make a meaningless shape
+)
+
+var histogramLevels = []rune(" ▁▂▃▄▅▆▇█")
+
+// latencyHistogram renders the samples as one line of bars over
+// logarithmic buckets from the fastest to the slowest sample, so that a
+// long tail stays visible next to the bulk: "3ms ▁▃█▆▂▁  ▁ 180ms".
+// It returns "" when there are too few samples.
+func latencyHistogram(stats latencyStats) string {
+	if len(stats.Samples) < histogramMinSamples || stats.Min <= 0 || stats.Max <= stats.Min {
+		return ""
+	}
+	counts := make([]int, histogramBuckets)
+	span := math.Log(float64(stats.Max) / float64(stats.Min))
+	peak := 0
+	for _, s := range stats.Samples {
+		i := int(math.Log(float64(s)/float64(stats.Min)) / span * histogramBuckets)
+		i = min(max(i, 0), histogramBuckets-1)
+		counts[i]++
+		peak = max(peak, counts[i])
+	}
+	bars := make([]rune, histogramBuckets)
+	for i, c := range counts {
+		level := 0
+		if c > 0 {
+			level = 1 + (len(histogramLevels)-2)*c/peak // Any sample shows at least the lowest bar
+		}
+		bars[i] = histogramLevels[level]
+	}
+	label := func(d time.Duration) time.Duration {
+		if d < 10*time.Millisecond {
+			return d.Round(100 * time.Microsecond)
+		}
+		return d.Round(time.Millisecond)
+	}
+	return fmt.Sprintf("%v %s %v", label(stats.Min), string(bars), label(stats.Max))
+}
+
+// measureIdleLatency pings a single server sequentially, so that jitter is
+// computed from back-to-back round trips on an otherwise quiet connection.
+func measureIdleLatency(srv target, count int) latencyStats {
+	var samples []time.Duration
+	for i := 0; i < count; i++ {
+		latency, err := pingOnce(context.Background(), httpClient, srv)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, latency)
+	}
+	return summarizeLatency(samples)
+}
+
+// latencyProbe keeps pinging a server at a fixed interval while a bulk
+// transfer is running, which gives the latency under working load.
+type latencyProbe struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func startLatencyProbe(srv target, interval time.Duration) *latencyProbe {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &latencyProbe{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			latency, err := pingOnce(ctx, httpClient, srv)
+			if err != nil {
+				// Pings cut short by Stop() or failing under load are simply skipped
+				continue
+			}
+			p.mu.Lock()
+			p.samples = append(p.samples, latency)
+			p.mu.Unlock()
+		}
+	}()
+
+	return p
+}
+
+// Stop ends probing and returns the summary of all samples collected so far.
+func (p *latencyProbe) Stop() latencyStats {
+	p.cancel()
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return summarizeLatency(p.samples)
+}
+
+// recent returns the median of the last n samples, or 0 before the first.
+func (p *latencyProbe) recent(n int) time.Duration {
+	p.mu.Lock()
+	last := slices.Clone(p.samples[max(len(p.samples)-n, 0):])
+	p.mu.Unlock()
+	if len(last) == 0 {
+		return 0
+	}
+	slices.Sort(last)
+	return last[len(last)/2]
+}
+
+// formatLatency renders latency stats as "23ms (jitter 2ms)" or "N/A".
+func formatLatency(stats latencyStats) string {
+	if len(stats.Samples) == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%v (jitter %v)", stats.Avg.Round(time.Millisecond), stats.Jitter.Round(time.Millisecond))
+}
+
+// trimmedMean averages the samples after discarding everything above the
+// given percentile, as the IETF responsiveness draft does with outliers.
+func trimmedMean(samples []time.Duration, percentile float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	keep := int(math.Ceil(float64(len(sorted)) * percentile / 100))
+	if keep < 1 {
+		keep = 1
+	}
+	var total time.Duration
+	for _, s := range sorted[:keep] {
+		total += s
+	}
+	return total / time.Duration(keep)
+}
+
+// responsivenessRPM converts round trips measured under working load into
+// "round trips per minute" following draft-ietf-ippm-responsiveness.
+func responsivenessRPM(samples []time.Duration) float64 {
+	rtt := trimmedMean(samples, 95)
+	if rtt <= 0 {
+		return 0
+	}
+	return float64(time.Minute) / float64(rtt)
+}
+
+// rpmRating uses roughly the same bands as Apple's networkQuality tool.
+func rpmRating(l locale, rpm float64) string {
+	switch {
+	case rpm >= 1000:
+		return l.tr("High")
+	case rpm >= 300:
+		return l.tr("Medium")
+	default:
+		return l.tr("Low")
+	}
+}
+
+func formatRPM(l locale, samples []time.Duration) string {
+	if len(samples) == 0 {
+		return l.tr("N/A")
+	}
+	rpm := responsivenessRPM(samples)
+	return fmt.Sprintf("%.0f RPM (%s)", rpm, rpmRating(l, rpm))
+}
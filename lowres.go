@@ -0,0 +1,33 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// What --low-resource changes, for OpenWrt-class devices with 128 MB of RAM
+// and one or two slow cores, besides the flags of its preset
+const (
+	lowResourceDownloadChunk = 2 * 1024 * 1024
+	lowResourceUploadChunk   = 1 * 1024 * 1024
+	lowResourceMaxProcs      = 2
+	lowResourceMemoryLimit   = 48 << 20 // Soft, the GC works harder near it
+)
+
+// chunkSizes returns the sizes of the download and upload requests.
+func (o *options) chunkSizes() (download, upload int) {
+	if o.LowResource {
+		return lowResourceDownloadChunk, lowResourceUploadChunk
+	}
+	return downloadChunkSizeBytes, uploadChunkSizeBytes
+}
+
+// applyLowResource keeps the Go runtime small with --low-resource: fewer
+// threads running Go code, and a heap the GC holds down to a soft limit.
+func applyLowResource(opts *options) {
+	if !opts.LowResource {
+		return
+	}
+	runtime.GOMAXPROCS(min(runtime.GOMAXPROCS(0), lowResourceMaxProcs))
+	debug.SetMemoryLimit(lowResourceMemoryLimit)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// writeMarkdown writes res as a report to paste into an issue or a wiki: a
+// results table, the client and test parameters, and with --per-server, a
+// collapsed breakdown by server.
+func writeMarkdown(w io.Writer, res testResult, opts *options) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Speed test, %s\n\n", res.StartedAt.Format("2006-01-02 15:04 MST"))
+	b.WriteString("| Metric | Result |\n|---|---|\n")
+	row := func(name, value string) {
+		fmt.Fprintf(&b, "| %s | %s |\n", name, strings.ReplaceAll(value, "|", `\|`))
+	}
+	row("Download", fmt.Sprintf("%.*f Mbps", opts.Precision, res.Download.Mbps))
+	if res.Upload.Bytes > 0 {
+		row("Upload", fmt.Sprintf("%.*f Mbps", opts.Precision, res.Upload.Mbps))
+	}
+	if len(res.IdleLatency.Samples) > 0 {
+		row("Idle latency", fmt.Sprintf("%s (jitter %s)", res.IdleLatency.Avg.Round(time.Millisecond/10), res.IdleLatency.Jitter.Round(time.Millisecond/10)))
+	}
+	if len(res.DownloadLatency.Samples) > 0 {
+		row("Latency while downloading", res.DownloadLatency.Avg.Round(time.Millisecond).String())
+	}
+	if len(res.UploadLatency.Samples) > 0 {
+		row("Latency while uploading", res.UploadLatency.Avg.Round(time.Millisecond).String())
+	}
+	if rpm := responsivenessRPM(res.LoadedLatencySamples()); rpm > 0 {
+		row("Responsiveness", formatRPM(localeEnglish, res.LoadedLatencySamples()))
+	}
+	row("Status", res.Status())
+
+	b.WriteString("\n| Test | |\n|---|---|\n")
+	client := res.Client.IP
+	if isp := asnISP(res.Client.Asn); isp != "" {
+		client += ", " + isp
+	}
+	if loc := strings.Trim(res.Client.Location.City+", "+res.Client.Location.Country, ", "); loc != "" {
+		client += " (" + loc + ")"
+	}
+	if client != "" {
+		row("Client", client)
+	}
+	if res.WiFi != nil {
+		row("Wi-Fi", res.WiFi.String())
+	}
+	row("Provider", res.Provider)
+	var servers []string
+	for _, s := range res.Servers {
+		servers = append(servers, fmt.Sprintf("%s (%s)", targetHost(s.Target), s.Latency.Round(time.Millisecond)))
+	}
+	row("Servers", strings.Join(servers, ", "))
+	phases := "download " + res.Download.Duration.Round(100*time.Millisecond).String()
+	if res.Upload.Duration > 0 {
+		phases += ", upload " + res.Upload.Duration.Round(100*time.Millisecond).String()
+	}
+	row("Phases", fmt.Sprintf("%s, %d streams", phases, res.Download.Streams))
+	host := currentHost()
+	row("fast-cli", fmt.Sprintf("%s on %s/%s", host.Version, host.OS, host.Arch))
+
+	if opts.PerServer {
+		b.WriteString("\n<details>\n<summary>Per server</summary>\n\n| Phase | Server | Speed | Time | Requests | Failed |\n|---|---|---|---|---|---|\n")
+		for _, phase := range []struct {
+			name   string
+			result phaseResult
+		}{{"Download", res.Download}, {"Upload", res.Upload}} {
+			for _, s := range phase.result.PerServer {
+				fmt.Fprintf(&b, "| %s | %s | %.*f Mbps | %s | %d | %d |\n",
+					phase.name, s.Host, opts.Precision, toMbps(s.Bytes, s.Active), s.Active.Round(100*time.Millisecond), s.Requests, s.Failed)
+			}
+		}
+		b.WriteString("\n</details>\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Just enough of multicast DNS (RFC 6762) and DNS-SD (RFC 6763) for serve to
+// announce itself and lan to find it, without a third-party resolver.
+const (
+	mdnsGroup   = "224.0.0.251:5353"
+	mdnsService = "_fast-cli._tcp.local."
+	mdnsTTL     = 120
+
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN         = 1
+	dnsClassCacheFlush = 0x8000 // In answers: this record replaces cached ones
+	dnsClassUnicast    = 0x8000 // In questions: answer by unicast
+)
+
+type dnsRecord struct {
+	Name string
+	Type uint16
+	TTL  uint32
+
+	// Decoded data, depending on Type
+	Target string // PTR, SRV
+	Port   uint16 // SRV
+	IP     net.IP // A
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendDNSRecord(b []byte, name string, typ, class uint16, ttl uint32, data []byte) []byte {
+	b = appendDNSName(b, name)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// readDNSName decodes the name at off, following compression pointers, and
+// returns it with the offset just past it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("name past end of message")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("bad compression pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("label past end of message")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// parseDNSMessage returns the questions' names and types and every answer,
+// authority and additional record of msg.
+func parseDNSMessage(msg []byte) (questions []dnsRecord, records []dnsRecord, err error) {
+	if len(msg) < 12 {
+		return nil, nil, errors.New("short message")
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rr := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for range qd {
+		var q dnsRecord
+		if q.Name, off, err = readDNSName(msg, off); err != nil {
+			return nil, nil, err
+		}
+		if off+4 > len(msg) {
+			return nil, nil, errors.New("short question")
+		}
+		q.Type = binary.BigEndian.Uint16(msg[off:])
+		off += 4
+		questions = append(questions, q)
+	}
+	for range rr {
+		var r dnsRecord
+		if r.Name, off, err = readDNSName(msg, off); err != nil {
+			return nil, nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, nil, errors.New("short record")
+		}
+		r.Type = binary.BigEndian.Uint16(msg[off:])
+		r.TTL = binary.BigEndian.Uint32(msg[off+4:])
+		size := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+size > len(msg) {
+			return nil, nil, errors.New("record data past end of message")
+		}
+		switch {
+		case r.Type == dnsTypeA && size == 4:
+			r.IP = net.IP(msg[off : off+4])
+		case r.Type == dnsTypePTR:
+			r.Target, _, err = readDNSName(msg, off)
+		case r.Type == dnsTypeSRV && size > 6:
+			r.Port = binary.BigEndian.Uint16(msg[off+4:])
+			r.Target, _, err = readDNSName(msg, off+6)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		off += size
+		records = append(records, r)
+	}
+	return questions, records, nil
+}
+
+// mdnsAdvertiser answers DNS-SD queries for one serve instance.
+type mdnsAdvertiser struct {
+	instance string // e.g. "myhost._fast-cli._tcp.local."
+	host     string // e.g. "myhost.local."
+	port     int
+	ips      []net.IP
+}
+
+// response is the full set of records for the instance, as one message.
+// A ttl of zero is a goodbye that makes browsers forget the instance.
+func (a *mdnsAdvertiser) response(id uint16, ttl uint32) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, 0x8400) // Authoritative response
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(2+len(a.ips)))
+
+	b = appendDNSRecord(b, mdnsService, dnsTypePTR, dnsClassIN, ttl, appendDNSName(nil, a.instance))
+	srv := binary.BigEndian.AppendUint16([]byte{0, 0, 0, 0}, uint16(a.port)) // Priority and weight zero
+	b = appendDNSRecord(b, a.instance, dnsTypeSRV, dnsClassIN|dnsClassCacheFlush, ttl, appendDNSName(srv, a.host))
+	txt := "path=" + peerSpeedtestPath
+	b = appendDNSRecord(b, a.instance, dnsTypeTXT, dnsClassIN|dnsClassCacheFlush, ttl, append([]byte{byte(len(txt))}, txt...))
+	for _, ip := range a.ips {
+		b = appendDNSRecord(b, a.host, dnsTypeA, dnsClassIN|dnsClassCacheFlush, ttl, ip.To4())
+	}
+	return b
+}
+
+// wanted reports whether a query asks for something this instance has.
+func (a *mdnsAdvertiser) wanted(questions []dnsRecord) bool {
+	for _, q := range questions {
+		name := strings.ToLower(q.Name)
+		if (name == mdnsService || name == strings.ToLower(a.instance)) && (q.Type == dnsTypePTR || q.Type == dnsTypeSRV || q.Type == dnsTypeANY) {
+			return true
+		}
+	}
+	return false
+}
+
+// localIPv4s lists the addresses serve is reachable on: listenIP if it was
+// given, otherwise every non-loopback IPv4 address.
+func localIPv4s(listenIP net.IP) []net.IP {
+	if listenIP != nil && !listenIP.IsUnspecified() {
+		return []net.IP{listenIP}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	return ips
+}
+
+// advertiseMDNS announces serve on the local network until ctx is done,
+// then says goodbye. It returns the instance name.
+func advertiseMDNS(ctx context.Context, listen net.Addr) (string, error) {
+	tcpAddr, ok := listen.(*net.TCPAddr)
+	if !ok {
+		return "", fmt.Errorf("unexpected listener address %v", listen)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("reading hostname: %w", err)
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	a := &mdnsAdvertiser{
+		instance: hostname + "." + mdnsService,
+		host:     hostname + ".local.",
+		port:     tcpAddr.Port,
+		ips:      localIPv4s(tcpAddr.IP),
+	}
+	if len(a.ips) == 0 {
+		return "", errors.New("no IPv4 address to advertise")
+	}
+
+	group, err := net.ResolveUDPAddr("udp4", mdnsGroup)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return "", fmt.Errorf("joining the mDNS group: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.WriteToUDP(a.response(0, 0), group)
+		conn.Close()
+	}()
+	go func() {
+		// Announce twice, a second apart, as RFC 6762 section 8.3 asks
+		for range 2 {
+			conn.WriteToUDP(a.response(0, mdnsTTL), group)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Warning: mDNS read: %v", err)
+				}
+				return
+			}
+			// Only queries, not other responders' answers
+			if n < 12 || buf[2]&0x80 != 0 {
+				continue
+			}
+			questions, _, err := parseDNSMessage(buf[:n])
+			if err != nil || !a.wanted(questions) {
+				continue
+			}
+			if src.Port != 5353 {
+				// A one-shot querier (like lan) expects a unicast reply with its ID
+				conn.WriteToUDP(a.response(binary.BigEndian.Uint16(buf), mdnsTTL), src)
+			} else {
+				conn.WriteToUDP(a.response(0, mdnsTTL), group)
+			}
+		}
+	}()
+	return strings.TrimSuffix(a.instance, "."+mdnsService), nil
+}
+
+// lanPeer is a fast-cli serve instance found via mDNS.
+type lanPeer struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"` // host:port
+}
+
+// browseMDNS queries the local network for serve instances and collects the
+// answers that arrive within timeout.
+func browseMDNS(timeout time.Duration) ([]lanPeer, error) {
+	group, err := net.ResolveUDPAddr("udp4", mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("opening query socket: %w", err)
+	}
+	defer conn.Close()
+
+	query := binary.BigEndian.AppendUint16(nil, 0x4643) // Any ID, replies echo it
+	query = append(query, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0)
+	query = appendDNSName(query, mdnsService)
+	query = binary.BigEndian.AppendUint16(query, dnsTypePTR)
+	query = binary.BigEndian.AppendUint16(query, dnsClassIN|dnsClassUnicast)
+
+	instances := map[string]bool{}
+	srvs := map[string]dnsRecord{}
+	hosts := map[string]net.IP{}
+	sources := map[string]net.IP{} // Fallback when a reply leaves out the A record
+	buf := make([]byte, 9000)
+	deadline := time.Now().Add(timeout)
+	// Ask twice, in case the first query or its answers are lost
+	for _, wait := range []time.Duration{timeout / 3, timeout} {
+		if _, err := conn.WriteToUDP(query, group); err != nil {
+			return nil, fmt.Errorf("sending mDNS query: %w", err)
+		}
+		wake := time.Now().Add(wait)
+		if wake.After(deadline) {
+			wake = deadline
+		}
+		conn.SetReadDeadline(wake)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if isTimeout(err) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading mDNS answers: %w", err)
+			}
+			_, records, err := parseDNSMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, r := range records {
+				name := strings.ToLower(r.Name)
+				switch {
+				case r.Type == dnsTypePTR && name == mdnsService && r.TTL > 0:
+					instances[r.Target] = true
+				case r.Type == dnsTypeSRV:
+					srvs[r.Name] = r
+					sources[r.Name] = src.IP
+				case r.Type == dnsTypeA:
+					hosts[name] = r.IP
+				}
+			}
+		}
+	}
+
+	var peers []lanPeer
+	for instance := range instances {
+		srv, ok := srvs[instance]
+		if !ok {
+			continue
+		}
+		ip := hosts[strings.ToLower(srv.Target)]
+		if ip == nil {
+			ip = sources[instance]
+		}
+		peers = append(peers, lanPeer{
+			Name: strings.TrimSuffix(instance, "."+mdnsService),
+			Addr: net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))),
+		})
+	}
+	return peers, nil
+}
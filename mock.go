@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// providerMock tests against a fake fast.com inside the process, for demos
+// and integration tests without a network. It isn't part of "all".
+const providerMock = "mock"
+
+const mockChunkSize = 64 << 10
+
+// mockSettings shape the simulated link of --provider mock.
+type mockSettings struct {
+	Rate    plan // Mbps in each direction, shared by all streams
+	Latency time.Duration
+	Replay  *selectionBundle // Server list and pings of --replay, nil for the made-up ones
+}
+
+func (s *mockSettings) register(fs *flag.FlagSet) {
+	s.Rate = plan{DownloadMbps: 1000, UploadMbps: 500}
+	fs.Var(&s.Rate, "mock-rate", "simulated `DOWN/UP` rate in Mbps of --provider mock")
+	fs.DurationVar(&s.Latency, "mock-latency", 10*time.Millisecond, "simulated round-trip `time` of --provider mock")
+}
+
+// mockPacer spaces out bytes at a fixed rate, across all the connections
+// sharing it, like a link of that capacity would.
+type mockPacer struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	next        time.Time // When the link is free again
+}
+
+func (p *mockPacer) wait(n int) {
+	p.mu.Lock()
+	if p.bytesPerSec <= 0 {
+		p.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now // An idle link doesn't save up capacity
+	}
+	p.next = p.next.Add(time.Duration(float64(n) / p.bytesPerSec * float64(time.Second)))
+	until := p.next
+	p.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
+
+func (p *mockPacer) setMbps(mbps float64) {
+	p.mu.Lock()
+	p.bytesPerSec = mbps * 1e6 / 8
+	p.mu.Unlock()
+}
+
+// mockFastCom answers the requests fast-cli makes to fast.com: the server
+// list API, zero-length pings, range downloads and uploads under
+// /sN/speedtest. The payload is the same on every run.
+type mockFastCom struct {
+	url      string // Where it listens, on loopback
+	payload  []byte
+	down, up mockPacer
+	mu       sync.Mutex
+	latency  time.Duration
+	replay   *selectionBundle
+}
+
+var (
+	mockOnce   sync.Once
+	mockServer *mockFastCom
+)
+
+// startMockFastCom starts the fake, once per process, and applies s to it.
+func startMockFastCom(s mockSettings) *mockFastCom {
+	mockOnce.Do(func() {
+		m := &mockFastCom{payload: make([]byte, mockChunkSize)}
+		rng := rand.New(rand.NewChaCha8([32]byte{}))
+		for i := range m.payload {
+			m.payload[i] = byte(rng.Uint32())
+		}
+		// Plain net/http rather than httptest, which would link the testing
+		// package into the binary
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(fmt.Sprintf("starting the mock provider: %v", err)) // As httptest does
+		}
+		m.url = "http://" + ln.Addr().String()
+		go http.Serve(ln, m)
+		mockServer = m
+	})
+	mockServer.down.setMbps(s.Rate.DownloadMbps)
+	mockServer.up.setMbps(s.Rate.UploadMbps)
+	mockServer.mu.Lock()
+	mockServer.latency, mockServer.replay = s.Latency, s.Replay
+	mockServer.mu.Unlock()
+	return mockServer
+}
+
+// mockReplay returns the bundle the mock plays back, nil unless it runs one
+// of --replay.
+func mockReplay() *selectionBundle {
+	if mockServer == nil {
+		return nil
+	}
+	mockServer.mu.Lock()
+	defer mockServer.mu.Unlock()
+	return mockServer.replay
+}
+
+func (m *mockFastCom) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	latency := m.latency
+	m.mu.Unlock()
+	time.Sleep(latency)
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && path == "/netflix/speedtest/v2":
+		m.serveList(w, r)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/speedtest"):
+		buf := make([]byte, mockChunkSize)
+		for {
+			n, err := r.Body.Read(buf)
+			m.up.wait(n)
+			if err != nil {
+				break
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && strings.Contains(path, "/speedtest/range/"):
+		_, rng, _ := strings.Cut(path, "/speedtest/range/")
+		size, err := parseRange(rng)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		for size > 0 {
+			n := min(size, int64(len(m.payload)))
+			m.down.wait(int(n))
+			if _, err := w.Write(m.payload[:n]); err != nil {
+				return
+			}
+			size -= n
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *mockFastCom) serveList(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	replay := m.replay
+	m.mu.Unlock()
+	if replay != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(replay.mockServers(m.url))
+		return
+	}
+	count, _ := strconv.Atoi(r.URL.Query().Get("urlCount"))
+	resp := apiResponse{Client: clientInfo{IP: "192.0.2.1", Asn: "64496", Location: location{City: "Mockville", Country: "ZZ"}}}
+	for i := range min(max(count, 1), 20) {
+		u := fmt.Sprintf("%s/s%d/speedtest?c=zz&n=64496&v=1&t=mock", m.url, i+1)
+		resp.Targets = append(resp.Targets, target{Name: u, URL: u, Location: location{City: "Mockville", Country: "ZZ"}})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// fetchMockServers asks the fake for its server list, like fetchTestServers
+// asks fast.com, starting it with the default settings if no test did.
+func fetchMockServers(client doer, count int) (*apiResponse, error) {
+	m := mockServer
+	if m == nil {
+		var s mockSettings
+		s.register(flag.NewFlagSet("", flag.ContinueOnError))
+		m = startMockFastCom(s)
+	}
+	var resp apiResponse
+	url := fmt.Sprintf("%s/netflix/speedtest/v2?https=false&token=mock&urlCount=%d", m.url, max(count, defaultURLCount))
+	if err := getProviderJSON(client, url, &resp); err != nil {
+		return nil, fmt.Errorf("fetching mock server list: %w", err)
+	}
+	return &resp, nil
+}
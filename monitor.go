@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type monitorFlags struct {
+	Interval time.Duration
+	Count    int
+	Servers  stringList
+}
+
+func newMonitorFlagSet(f *monitorFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli monitor", flag.ContinueOnError)
+	fs.DurationVar(&f.Interval, "interval", time.Second, "time between pings")
+	fs.IntVar(&f.Count, "count", 0, "stop after this many pings, 0 runs until interrupted")
+	fs.Var(&f.Servers, "server", "monitor this fast-cli serve `URL` instead of the best fast.com server")
+	return fs
+}
+
+// runMonitor implements `fast-cli monitor`: ping the best server
+// continuously, like ping(8), and summarize loss and latency on exit.
+func runMonitor(args []string) error {
+	var f monitorFlags
+	if err := parseFlags(newMonitorFlagSet(&f), args); err != nil {
+		return err
+	}
+	if f.Interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	statusOut = os.Stderr
+	_, servers, err := selectServers(&options{Streams: 1, Servers: f.Servers})
+	if err != nil {
+		return err
+	}
+	srv := servers[0].Target
+	// A reply later than this counts as lost
+	timeout := max(f.Interval, 2*time.Second)
+	fmt.Printf("Monitoring %s (%s, %s) every %s, Ctrl-C to stop\n", targetHost(srv), srv.Location.City, srv.Location.Country, f.Interval)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+
+	var samples []time.Duration
+	sent := 0
+	for f.Count == 0 || sent < f.Count {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		latency, err := pingOnce(pingCtx, httpClient, srv)
+		cancel()
+		if ctx.Err() != nil {
+			break // Interrupted mid-ping, which is not a loss
+		}
+		sent++
+		now := time.Now().Format(time.TimeOnly)
+		if err != nil {
+			fmt.Printf("%s  seq=%d  lost (%v)\n", now, sent, err)
+		} else {
+			samples = append(samples, latency)
+			fmt.Printf("%s  seq=%d  %v\n", now, sent, latency.Round(100*time.Microsecond))
+		}
+
+		if f.Count > 0 && sent >= f.Count {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	stats := summarizeLatency(samples)
+	lost := sent - len(samples)
+	fmt.Printf("\n--- %s monitor statistics ---\n", targetHost(srv))
+	fmt.Printf("%d pings, %d lost (%.1f%%)\n", sent, lost, 100*float64(lost)/float64(max(sent, 1)))
+	if len(samples) > 0 {
+		fmt.Printf("latency min/avg/max = %v/%v/%v, jitter %v\n",
+			stats.Min.Round(100*time.Microsecond), stats.Avg.Round(100*time.Microsecond), stats.Max.Round(100*time.Microsecond), stats.Jitter.Round(100*time.Microsecond))
+	}
+	return nil
+}
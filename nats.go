@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	natsTimeout     = 10 * time.Second
+	natsDefaultPort = "4222"
+)
+
+// natsTarget is the --nats flag, nats://[USER:PASSWORD@|TOKEN@]HOST[:PORT]/SUBJECT,
+// or tls:// for a TLS connection.
+type natsTarget struct {
+	URL     *url.URL
+	Subject string
+}
+
+func (t *natsTarget) String() string {
+	if t.URL == nil {
+		return ""
+	}
+	return t.URL.Redacted()
+}
+
+func (t *natsTarget) Set(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid NATS URL %q: %w", value, err)
+	}
+	subject := strings.TrimPrefix(u.Path, "/")
+	switch {
+	case u.Scheme != "nats" && u.Scheme != "tls":
+		return fmt.Errorf("NATS URL %q must start with nats:// or tls://", value)
+	case u.Hostname() == "":
+		return fmt.Errorf("NATS URL %q has no host", value)
+	case subject == "" || strings.ContainsAny(subject, " \t/"):
+		return fmt.Errorf("NATS URL %q needs a subject as its path, e.g. nats://host:4222/speedtest.results", value)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	*t = natsTarget{URL: u, Subject: subject}
+	return nil
+}
+
+// publishNATS publishes msg to t's subject and waits for the PONG to a PING
+// sent after it, which the server answers only once it has processed the
+// publish; a JetStream stream on the subject then has it stored.
+func publishNATS(t natsTarget, msg outboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", t.URL.Host)
+	if err != nil {
+		return fmt.Errorf("connecting to NATS: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("%s doesn't speak NATS", t.URL.Host)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+	if t.URL.Scheme == "tls" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: t.URL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("TLS handshake with NATS: %w", err)
+		}
+		conn, r = tlsConn, bufio.NewReader(tlsConn)
+	} else if info.TLSRequired {
+		return fmt.Errorf("the NATS server requires TLS, use tls://%s", t.URL.Host)
+	}
+
+	connect := map[string]any{"verbose": false, "pedantic": false, "name": "fast-cli", "lang": "go", "version": version}
+	if user := t.URL.User; user != nil {
+		if pass, ok := user.Password(); ok {
+			connect["user"], connect["pass"] = user.Username(), pass
+		} else {
+			connect["auth_token"] = user.Username()
+		}
+	}
+	options, _ := json.Marshal(connect)
+	cmds := fmt.Sprintf("CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", options, t.Subject, len(msg.Value), msg.Value)
+	if _, err := conn.Write([]byte(cmds)); err != nil {
+		return fmt.Errorf("publishing to NATS: %w", err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("waiting for NATS to confirm: %w", err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS: %s", strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		case line == "PING":
+			conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
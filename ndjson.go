@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// ndjsonEvent is a line of the ndjson format, which streams a test as it
+// goes: the idle latency once it was measured, each phase as it ends, and
+// last the whole result as --format json has it. A reader that only wants
+// the download can stop reading once that arrived.
+type ndjsonEvent struct {
+	Event    string       `json:"event"` // ping, download, upload, result or error
+	ID       string       `json:"id,omitempty"`
+	Ping     *jsonLatency `json:"ping,omitempty"`
+	Download *jsonPhase   `json:"download,omitempty"`
+	Upload   *jsonPhase   `json:"upload,omitempty"`
+	Result   *jsonResult  `json:"result,omitempty"`
+	Error    *jsonError   `json:"error,omitempty"`
+}
+
+// streamPartial writes the event of res's step that just ended with the
+// ndjson format, and nothing with any other.
+func streamPartial(opts *options, event string, res testResult) {
+	if opts.Format != formatNDJSON {
+		return
+	}
+	e := ndjsonEvent{Event: event, ID: res.ID}
+	switch event {
+	case "ping":
+		if e.Ping = newJSONLatency(res.IdleLatency); e.Ping == nil {
+			return // Not measured
+		}
+	case "download":
+		phase := newJSONPhase(res.Download, res.DownloadLatency)
+		e.Download = &phase
+	case "upload":
+		phase := newJSONPhase(res.Upload, res.UploadLatency)
+		e.Upload = &phase
+	}
+	writeNDJSON(e)
+}
+
+func writeNDJSON(e ndjsonEvent) error {
+	return json.NewEncoder(os.Stdout).Encode(e)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime"
+	"time"
+)
+
+// With --on-network-change the daemon tests once the network has been quiet
+// for networkSettleDelay after a change, long enough for DHCP and the default
+// route to settle after a roam or a cable being plugged in. Platforms without
+// route change events are polled every networkPollInterval instead.
+const (
+	networkSettleDelay   = 10 * time.Second
+	networkPollInterval  = 30 * time.Second
+	triggerNetworkChange = "network-change"
+)
+
+var errNoRouteEvents = errors.New("no route change events on " + runtime.GOOS)
+
+// watchNetwork sends the identity of the network every time it changed and
+// settled, until ctx is done. Losing the network sends nothing, as outages
+// are tracked on their own, and neither does coming back to the same one.
+func watchNetwork(ctx context.Context) <-chan networkIdentity {
+	changes := make(chan networkIdentity)
+	events := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case events <- struct{}{}:
+		default: // One is pending already
+		}
+	}
+	go func() {
+		err := routeEvents(ctx, notify)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		if !errors.Is(err, errNoRouteEvents) {
+			log.Printf("Warning: watching for network changes: %v, polling every %s instead", err, networkPollInterval)
+		}
+		ticker := time.NewTicker(networkPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				notify()
+			}
+		}
+	}()
+
+	go func() {
+		last, _ := currentNetwork()
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+				settled = time.After(networkSettleDelay)
+			case <-settled:
+				settled = nil
+				id, err := currentNetwork()
+				if err != nil || id == last {
+					continue
+				}
+				last = id
+				select {
+				case changes <- id:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return changes
+}
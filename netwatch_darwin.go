@@ -0,0 +1,22 @@
+//go:build darwin
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+)
+
+// routeEvents calls changed for every message of the PF_ROUTE socket, which
+// carries the interface, address and route changes SCNetworkReachability
+// reports without needing cgo, until ctx is done.
+func routeEvents(ctx context.Context, changed func()) error {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return fmt.Errorf("opening routing socket: %w", err)
+	}
+	syscall.CloseOnExec(fd)
+	defer syscall.Close(fd)
+	return readRouteEvents(ctx, fd, changed)
+}
@@ -0,0 +1,33 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// readRouteEvents calls changed for every message read from the routing
+// socket fd until ctx is done, waking every second to check.
+func readRouteEvents(ctx context.Context, fd int, changed func()) error {
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("setting receive timeout: %w", err)
+	}
+	buf := make([]byte, 16<<10)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		switch {
+		case errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR):
+		case errors.Is(err, syscall.ENOBUFS): // Messages were dropped, so something changed
+			changed()
+		case err != nil:
+			return fmt.Errorf("reading route events: %w", err)
+		case n > 0:
+			changed()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+)
+
+// rtnetlink multicast groups, from linux/rtnetlink.h.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// routeEvents calls changed for every rtnetlink message about links,
+// addresses and routes, which roaming to another access point sends too,
+// until ctx is done.
+func routeEvents(ctx context.Context, changed func()) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("opening rtnetlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	groups := uint32(rtmgrpLink | rtmgrpIPv4Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Ifaddr | rtmgrpIPv6Route)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		return fmt.Errorf("subscribing to rtnetlink groups: %w", err)
+	}
+	return readRouteEvents(ctx, fd, changed)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "context"
+
+// routeEvents has no implementation here; the network is polled instead.
+func routeEvents(ctx context.Context, changed func()) error {
+	return errNoRouteEvents
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const networkFilterHelp = "only the results measured on this `network`, by SSID, gateway MAC address, subnet or interface"
+
+// Addresses the default route is looked up toward, which needs no DNS
+var identityProbeHosts = []string{"1.1.1.1", "2606:4700:4700::1111"}
+
+// networkIdentity tells apart the networks a machine joins: the interface
+// traffic leaves through, its subnet, the MAC address of the gateway, and on
+// Wi-Fi the SSID. Results are recorded with it, so that those of home, the
+// office and a hotspot don't end up in one trend.
+type networkIdentity struct {
+	Interface string `json:"interface"`
+	Subnet    string `json:"subnet,omitempty"`
+	Gateway   string `json:"gateway,omitempty"` // MAC address, which tells apart routers that hand out the same subnet
+	SSID      string `json:"ssid,omitempty"`
+}
+
+func (n networkIdentity) String() string {
+	s := n.Interface
+	if n.SSID != "" {
+		s += fmt.Sprintf(" %q", n.SSID)
+	}
+	if n.Subnet != "" {
+		s += " " + n.Subnet
+	}
+	if n.Gateway != "" {
+		s += " via " + n.Gateway
+	}
+	return s
+}
+
+// name is what results are grouped by: the SSID, or else the gateway, the
+// subnet or the interface, whichever is known first.
+func (n networkIdentity) name() string { return cmp.Or(n.SSID, n.Gateway, n.Subnet, n.Interface) }
+
+// matches reports whether s names the network by any of its parts, which is
+// what --network takes.
+func (n *networkIdentity) matches(s string) bool {
+	return n != nil && (s == n.SSID || strings.EqualFold(s, n.Gateway) || s == n.Subnet || s == n.Interface)
+}
+
+// networkCSVColumn follows tagsCSVColumn, for the same reason.
+var networkCSVColumn = historyCSVColumn{"network", func(e *historyEntry) string { return encodeNetwork(e.Network) }, func(e *historyEntry, v string) (err error) {
+	e.Network, err = decodeNetwork(v)
+	return err
+}}
+
+// encodeNetwork is the network as a query string, the form of the network
+// column of history export.
+func encodeNetwork(n *networkIdentity) string {
+	if n == nil {
+		return ""
+	}
+	q := url.Values{"interface": {n.Interface}}
+	for k, v := range map[string]string{"subnet": n.Subnet, "gateway": n.Gateway, "ssid": n.SSID} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	return q.Encode()
+}
+
+func decodeNetwork(s string) (*networkIdentity, error) {
+	q, err := url.ParseQuery(s)
+	if err != nil || len(q) == 0 {
+		return nil, err
+	}
+	return &networkIdentity{Interface: q.Get("interface"), Subnet: q.Get("subnet"), Gateway: q.Get("gateway"), SSID: q.Get("ssid")}, nil
+}
+
+// currentNetwork reads the identity of the network the default route leads
+// to, preferring the IPv4 one.
+func currentNetwork() (networkIdentity, error) {
+	var iface net.Interface
+	var err error
+	for _, host := range identityProbeHosts {
+		if iface, err = egressInterface(host); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return networkIdentity{}, err
+	}
+	return identifyNetwork(iface), nil
+}
+
+// probeNetwork returns the identity of the network traffic to t goes out on.
+func probeNetwork(t target) (*networkIdentity, error) {
+	iface, err := egressInterface(targetHostname(t))
+	if err != nil {
+		return nil, err
+	}
+	id := identifyNetwork(iface)
+	return &id, nil
+}
+
+// identifyNetwork reads what names the network of iface, leaving out what
+// it can't read.
+func identifyNetwork(iface net.Interface) networkIdentity {
+	id := networkIdentity{Interface: iface.Name}
+	addrs, _ := iface.Addrs()
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || id.Subnet != "" && ipNet.IP.To4() == nil {
+			continue
+		}
+		id.Subnet = (&net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}).String()
+		if ipNet.IP.To4() != nil {
+			break
+		}
+	}
+	id.Gateway, _ = readGateway(iface)
+	if wifi, err := readWiFi(iface); err == nil && wifi != nil {
+		id.SSID = wifi.SSID
+	}
+	return id
+}
+
+// sameNetwork returns the entries recorded on the network of n, and those
+// recorded before networks were, which can't be told apart.
+func sameNetwork(entries []historyEntry, n *networkIdentity) []historyEntry {
+	if n == nil {
+		return entries
+	}
+	var kept []historyEntry
+	for _, e := range entries {
+		if e.Network == nil || e.Network.name() == n.name() {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// onNetwork returns the entries recorded on the network s names, see
+// matches, or all of them for an empty s.
+func onNetwork(entries []historyEntry, s string) []historyEntry {
+	if s == "" {
+		return entries
+	}
+	var kept []historyEntry
+	for _, e := range entries {
+		if e.Network.matches(s) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
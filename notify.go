@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const notifyTimeout = 15 * time.Second
+
+// notification is what notifiers deliver: a short human-readable summary
+// plus the run it refers to.
+type notification struct {
+	Title   string       `json:"title"`
+	Message string       `json:"message"`
+	Format  string       `json:"format,omitempty"` // Of Message: markdown or html, plain text if empty
+	Result  historyEntry `json:"result"`
+}
+
+type notifier interface {
+	Notify(ctx context.Context, n notification) error
+}
+
+// webhookNotifier POSTs the notification as JSON to an arbitrary URL,
+// queueing it in an outbox while the URL is unreachable.
+type webhookNotifier struct {
+	URL    string
+	client doer
+	outbox outbox
+}
+
+func newWebhookNotifier(opts *options, u string) webhookNotifier {
+	name := "a webhook" // Its URL is often a secret
+	if parsed, err := url.Parse(u); err == nil {
+		name = "the webhook on " + parsed.Host
+	}
+	sum := sha256.Sum256([]byte(u))
+	return webhookNotifier{URL: u, client: httpClient, outbox: newOutbox(opts, name, "webhook-"+hex.EncodeToString(sum[:4]))}
+}
+
+func (w webhookNotifier) Notify(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("encoding notification: %w", err)
+	}
+	return w.outbox.deliver(func(m outboxMessage) error { return w.post(ctx, m) }, outboxMessage{Value: body})
+}
+
+func (w webhookNotifier) post(ctx context.Context, m outboxMessage) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(m.Value))
+	if err != nil {
+		return fmt.Errorf("creating webhook request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return rejectedIfClientError(resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode))
+	}
+	return nil
+}
+
+func buildNotifiers(opts *options) []notifier {
+	var notifiers []notifier
+	for _, u := range opts.NotifyWebhooks {
+		notifiers = append(notifiers, newWebhookNotifier(opts, u))
+	}
+	return notifiers
+}
+
+// notifyAll delivers to every notifier, logging rather than returning
+// failures so that one broken notifier doesn't silence the others.
+func notifyAll(notifiers []notifier, n notification) {
+	for _, nt := range notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := nt.Notify(ctx, n); err != nil {
+			log.Printf("Warning: notification failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// webhookSinks are the outboxes of the --notify-webhook URLs, for retrying
+// them between runs.
+func webhookSinks(opts *options) []queuedSink {
+	var sinks []queuedSink
+	for _, u := range opts.NotifyWebhooks {
+		w := newWebhookNotifier(opts, u)
+		sinks = append(sinks, queuedSink{outbox: w.outbox, send: func(m outboxMessage) error {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			return w.post(ctx, m)
+		}})
+	}
+	return sinks
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// options holds everything configurable from the command line
+type options struct {
+	Plan            plan   // Advertised ISP plan, zero if not given
+	HistoryPath     string // JSON-lines file results are appended to
+	NoHistory       bool
+	Format          string // One of outputFormats
+	SimpleField     string // The one field the simple format prints, all if empty
+	Precision       int    // Decimals of the speeds in the text output
+	Lang            string // Language of the text output, from the environment if empty
+	Plain           bool   // No colors or block characters, progress lines instead, see plain.go
+	Thresholds      thresholds
+	Tags            resultTags
+	LogProgress     time.Duration
+	BarInterval     time.Duration
+	GHA             bool   // Emit GitHub Actions workflow annotations
+	PreCmd          string // Shell commands run around every test
+	PostCmd         string
+	DryRun          bool    // Only select servers and print what would be tested
+	SkipPrecheck    bool    // Don't check for a captive portal or other traffic before testing
+	RequireIdle     bool    // Refuse to test while other traffic is on the link
+	Strict          bool    // Fail instead of reporting a degraded result
+	StrictMaxErrors float64 // Percentage of failed chunk requests --strict tolerates per phase
+	ProbePMTU       bool    // Discover the path MTU toward the best server
+	PcapPath        string  // Packets to and from the test servers are captured here
+	HARPath         string  // Every HTTP request is recorded here
+	RecordDir       string  // The server selection and requests are bundled here, see selectionBundle
+	ReplayDir       string  // Bundle of RecordDir the mock provider plays back
+	SignKey         string  // PEM Ed25519 private key the JSON result is signed with
+
+	signingKey   ed25519.PrivateKey // Loaded from SignKey by runTest
+	pushTemplate *template.Template // Parsed from PushTemplate by runTest
+	congestion   string             // TCP congestion control test connections run, if known
+	deadline     time.Time          // End of the time budget of the running test
+	budget       string             // The flag and value deadline comes from, for messages
+	runDeadline  time.Time          // End of MaxRuntime
+	trigger      *networkIdentity   // Network the running daemon test was triggered by moving to
+
+	ConfigPath string // INI file holding defaults and named profiles
+	Profile    string
+
+	// Test shape, zero values use the defaults
+	DownloadDuration time.Duration
+	UploadDuration   time.Duration
+	SkipUpload       bool
+	Quick            bool // Short download that ends once stable, no upload unless Upload
+	Upload           bool
+	Overlap          bool
+	Seed             uint64
+	PinServers       string
+	Streams          int           // Servers transferred to in parallel
+	Candidates       int           // Servers asked of the provider to choose them from
+	LatencySamples   int           // Pings of the idle latency
+	PerServer        bool          // Break the text results down by server
+	Thorough         bool          // Long phases over more servers, see presets
+	LowResource      bool          // Small chunks and a small runtime, see presets and applyLowResource
+	UploadPayload    string        // One of uploadPayloads
+	CheckIntegrity   bool          // Check that downloads arrive unaltered, see chunkSampler
+	FixedRanges      bool          // Download the same range every time, see randomRange
+	CacheBust        string        // One of cacheBustModes
+	HostCheck        string        // One of hostCheckModes
+	AllowHosts       stringList    // Host patterns servers may have besides expectedHosts
+	AutoStreams      bool          // Tune the number of streams during a phase, see streamSet
+	AutoLatency      time.Duration // Most loaded latency over idle that --auto-streams keeps streams for
+	Servers          stringList    // Self-hosted peers (fast-cli serve) used instead of fast.com
+	PingTimeout      time.Duration // For each ping of server selection
+	PingWarmup       bool          // Open the connection with a throwaway ping before timing one
+	Rank             string        // How servers are chosen: rankLatency or rankCombined
+	Provider         string        // One of providerNames, a comma-separated list or "all"
+	CompareVia       string        // Interface or proxy URL to repeat the test through
+	MaxDataMB        float64       // Per-phase data cap, for metered connections
+	Congestion       string        // TCP congestion control algorithm of test connections, Linux only
+	ForceNewConns    bool          // One TCP connection per stream, no HTTP/2 multiplexing
+	SendBuffer       byteSize      // SO_SNDBUF of test connections, 0 for the kernel's default
+	ReceiveBuffer    byteSize      // SO_RCVBUF of test connections, 0 for the kernel's default
+	StallTimeout     time.Duration // Retry a request that moves no data for this long
+	TotalTimeout     time.Duration // Wall-clock budget of a whole test, 0 for none
+	MaxRuntime       time.Duration // Of the whole invocation, enforced by exiting
+	Mock             mockSettings
+	Simulate         simulation // Synthetic results instead of a test
+
+	// Daemon mode only
+	NotifyWebhooks   stringList // URLs notifications are POSTed to as JSON
+	Interval         time.Duration
+	ProbeInterval    time.Duration
+	OnNetworkChange  bool    // Test shortly after the network changed, too
+	AnomalyThreshold float64 // Robust standard deviations from the hourly baseline
+	PushTo           string  // Collector URL every result is uploaded to
+	Digest           string  // digestDaily or digestWeekly, empty for none
+	DigestFormat     string  // One of digestFormats
+	Retention        retentionPolicy
+	MetricsListen    string // Address the /metrics endpoint listens on, empty for none
+	Pprof            string // Address of net/http/pprof and expvar, empty for none
+
+	// Sinks results are sent to after each run, see registerSinkFlags
+	PromTextfile     string // Rewritten with OpenMetrics gauges after every run
+	CSVFile          string // Every result is appended here
+	PushURL          string // Every result is POSTed here
+	PushTemplate     string // Body of the push, a text/template or @file; the JSON result if empty
+	PushHeaders      stringList
+	Syslog           syslogTarget // Results and errors are sent here
+	SyslogFacility   string       // One of syslogFacilities
+	SyslogSeverity   string       // Of results, one of syslogSeverities
+	SplunkURL        string       // HTTP Event Collector results are sent to
+	SplunkToken      string
+	SplunkIndex      string // Empty for the token's default index
+	SplunkSourcetype string
+	ElasticURL       string      // Results are indexed here through _bulk
+	ElasticIndex     string      // Time layouts in braces are expanded, see elasticIndex
+	ElasticAPIKey    string      // Empty for none or credentials in ElasticURL
+	Kafka            kafkaTarget // Topic results are produced to
+	NATS             natsTarget  // Subject results are published to
+	SinkOn           string      // In a [sink] section, which runs it gets: sinkOnAll, sinkOnSuccess or sinkOnFailure
+	sinkName         string      // Of the [sink] section, empty for the command line's sinks
+	sinks            []*options  // The [sink] sections of the config file
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+// repeatableValue is a flag value that may be given more than once, which
+// an environment variable gives as a comma-separated list.
+type repeatableValue interface {
+	flag.Value
+	repeatable()
+}
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) repeatable() {}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// plan is an advertised ISP plan in Mbps, e.g. 500/50
+type plan struct {
+	DownloadMbps float64
+	UploadMbps   float64
+}
+
+func (p plan) IsSet() bool { return p.DownloadMbps > 0 }
+
+func (p plan) String() string {
+	if !p.IsSet() {
+		return ""
+	}
+	return fmt.Sprintf("%g/%g", p.DownloadMbps, p.UploadMbps)
+}
+
+// Set implements flag.Value, accepting "DOWN/UP" or just "DOWN" in Mbps.
+func (p *plan) Set(value string) error {
+	downStr, upStr, hasUp := strings.Cut(value, "/")
+	down, err := strconv.ParseFloat(strings.TrimSpace(downStr), 64)
+	if err != nil || down <= 0 {
+		return fmt.Errorf("invalid download speed %q, expected e.g. 500/50", downStr)
+	}
+	var up float64
+	if hasUp {
+		up, err = strconv.ParseFloat(strings.TrimSpace(upStr), 64)
+		if err != nil || up < 0 {
+			return fmt.Errorf("invalid upload speed %q, expected e.g. 500/50", upStr)
+		}
+	}
+	p.DownloadMbps, p.UploadMbps = down, up
+	return nil
+}
+
+// newRunFlagSet registers the flags shared by every command that runs speed tests.
+func newRunFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Var(&opts.Plan, "plan", "advertised plan `DOWN/UP` in Mbps (e.g. 500/50) to compare results against")
+	fs.Var(&opts.Tags, "tag", "label results with this `KEY=VALUE`, e.g. location=office, in every output, the history and metric sinks (repeatable)")
+	fs.StringVar(&opts.HistoryPath, "history", defaultHistoryPath(), "`file` results are recorded to")
+	fs.BoolVar(&opts.NoHistory, "no-history", false, "don't record this run to the history file")
+	fs.StringVar(&opts.Format, "format", formatText, "output `format`: "+strings.Join(outputFormats, ", "))
+	fs.Var(simpleFlag{&opts.Format, &opts.SimpleField}, "simple", "print only ping=12ms jitter=2ms down=812.4Mbps up=41.2Mbps, or with --simple=FIELD the number of "+strings.Join(simpleFields, ", ")+" alone, for scripts and status bars")
+	fs.DurationVar(&opts.BarInterval, "statusbar-interval", 0, "with --format statusbar, keep running and test every `interval`, printing a line after each, for status bar modules that stay up")
+	fs.IntVar(&opts.Precision, "precision", 2, "`decimals` of the speeds in the text output; the JSON has exact bps as well")
+	fs.BoolVar(&opts.Plain, "plain", false, "screen reader friendly output for terminals and captured logs: no colors or block characters, and a progress line every "+plainProgressInterval.String()+" of each phase")
+	fs.DurationVar(&opts.LogProgress, "log-progress", 0, "print a timestamped line with the phase, the bytes so far and the current speed every `interval`, e.g. 5s, for logs under systemd or cron")
+	fs.StringVar(&opts.Lang, "lang", "", "`language` of the text output: "+strings.Join(languages, ", ")+"; from LC_ALL, LC_MESSAGES or LANG if unset")
+	fs.StringVar(&opts.ConfigPath, "config", defaultConfigPath(), "config `file` with defaults and named profiles")
+	fs.StringVar(&opts.Profile, "profile", "", "apply the settings of this `profile` from the config file")
+	fs.DurationVar(&opts.DownloadDuration, "download-duration", downloadTestDuration, "length of the download phase")
+	fs.DurationVar(&opts.UploadDuration, "upload-duration", uploadTestDuration, "length of the upload phase")
+	fs.BoolVar(&opts.SkipUpload, "no-upload", false, "skip the upload phase")
+	fs.BoolVar(&opts.Quick, "quick", false, "test like fast.com does: a download of up to "+quickPhaseDuration.String()+" that stops once the speed is stable, latency alongside the prechecks, no upload and no ranking download")
+	fs.BoolVar(&opts.Upload, "upload", false, "with --quick, test the upload too")
+	fs.BoolVar(&opts.Overlap, "overlap", true, "start the download on the first server to answer its ping and add the others as they do, rather than pinging every candidate first")
+	fs.IntVar(&opts.Streams, "streams", numServersToTest, "number of `servers` to transfer to in parallel")
+	fs.BoolVar(&opts.AutoStreams, "auto-streams", false, "start each phase with "+strconv.Itoa(autoStartStreams)+" streams and add streams for as long as each raises the speed, up to "+strconv.Itoa(maxAutoStreams)+", to fill multi-gigabit links with the fewest")
+	fs.DurationVar(&opts.AutoLatency, "auto-streams-latency", 0, "with --auto-streams, drop streams while the loaded latency is more than this `duration` over idle; 0 ignores latency")
+	fs.IntVar(&opts.Candidates, "candidates", defaultURLCount, "number of `servers` to ask the provider for and choose from")
+	fs.IntVar(&opts.LatencySamples, "latency-samples", idleLatencySamples, "`pings` that measure the idle latency")
+	fs.BoolVar(&opts.PerServer, "per-server", false, "break the results down by server")
+	fs.StringVar(&opts.UploadPayload, "upload-payload", payloadRandom, "what uploads send: "+strings.Join(uploadPayloads, ", ")+"; a faster zero or mixed upload points to a middlebox that compresses")
+	fs.BoolVar(&opts.CheckIntegrity, "check-integrity", false, "hash samples of every download chunk and check its size, to catch a transparent proxy altering or truncating content")
+	fs.Uint64Var(&opts.Seed, "seed", 0, "seed the random `number`s of download ranges and upload payloads, so that runs with the same seed request and send the same bytes; 0 for new ones every run")
+	fs.StringVar(&opts.PinServers, "pin-servers", "", "test against the servers pinned in this `file`, failing if one is missing, or pin the ones chosen there if it doesn't exist yet")
+	fs.BoolVar(&opts.FixedRanges, "fixed-ranges", false, "download the same range 0-N for every chunk, as fast.com does, instead of a random offset and size that a cache on the path can't serve from the last one")
+	fs.StringVar(&opts.CacheBust, "cache-bust", cacheBustNone, "keep caches on the path from answering downloads: "+strings.Join(cacheBustModes, ", ")+"; header sends Cache-Control: no-cache, query a random nonce in every chunk's URL")
+	fs.StringVar(&opts.HostCheck, "host-check", hostCheckWarn, "what to do when the server list names hosts the provider doesn't use, as a hijacked DNS or API would: "+strings.Join(hostCheckModes, ", "))
+	fs.Var(&opts.AllowHosts, "allow-host", "also accept servers on hosts matching this `pattern`, such as *.example.net, for --host-check (repeatable)")
+	fs.BoolVar(&opts.LowResource, "low-resource", false, "test from a router or small board: 2 streams, small chunks and at most 2 cores, to fit in little RAM")
+	fs.BoolVar(&opts.Thorough, "thorough", false, "test for evidence rather than a quick check: "+thoroughPhaseDuration.String()+" phases over 5 of 10 candidate servers, 20 idle latency pings and results per server")
+	fs.Var(&opts.Servers, "server", "test against this fast-cli serve `URL` instead of fast.com (repeatable)")
+	fs.DurationVar(&opts.PingTimeout, "ping-timeout", defaultPingTimeout, "give up on a server that doesn't answer a ping within this `duration` during selection")
+	fs.BoolVar(&opts.PingWarmup, "ping-warmup", true, "open each server's connection with a throwaway ping, so that server latency leaves out DNS, TCP and TLS setup")
+	fs.StringVar(&opts.Rank, "rank", rankCombined, "choose servers by `latency`, or by latency and a short download from each (combined)")
+	fs.StringVar(&opts.Provider, "provider", providerFast, "test `backend`: "+strings.Join(providerNames, ", ")+"; a comma-separated list or all compares them")
+	fs.StringVar(&opts.CompareVia, "compare-via", "", "run the test again through this `interface` or proxy URL (e.g. wg0, socks5://127.0.0.1:1080) and compare")
+	fs.Float64Var(&opts.MaxDataMB, "max-data", 0, "stop each phase after this many `MB`, 0 for no cap")
+	fs.StringVar(&opts.Congestion, "congestion", "", "TCP congestion control `algorithm` of test connections, e.g. bbr or cubic (Linux only)")
+	fs.BoolVar(&opts.ForceNewConns, "force-new-conns", false, "speak HTTP/1.1 only, so that parallel streams never share a TCP connection")
+	fs.Var(&opts.SendBuffer, "sndbuf", "send buffer `size` of test connections, e.g. 4M; the kernel's default if unset")
+	fs.Var(&opts.ReceiveBuffer, "rcvbuf", "receive buffer `size` of test connections, e.g. 16M; the kernel's default if unset")
+	fs.DurationVar(&opts.StallTimeout, "stall-timeout", defaultStallTimeout, "retry a request that moves no data for this `duration`, 0 to never retry")
+	fs.DurationVar(&opts.MaxRuntime, "max-runtime", 0, "never run longer than this `duration` from start to exit: selection and phases shrink to fit, and the run exits with status 23 if it runs over; 0 for no limit")
+	fs.DurationVar(&opts.TotalTimeout, "total-timeout", 0, "give up on a test that takes longer than this `duration` in all, shortening phases to fit; 0 for no limit")
+	fs.BoolVar(&opts.GHA, "gha", false, "emit GitHub Actions annotations for the result and missed thresholds")
+	opts.Thresholds.register(fs)
+	opts.Mock.register(fs)
+	fs.Var(&opts.Simulate, "simulate", "report a synthetic result for a `DOWN/UP/LATENCY` link (e.g. 300/40/12ms) without testing, for developing dashboards and alerts")
+	fs.BoolVar(&opts.ProbePMTU, "pmtu", false, "discover the path MTU toward the best server, to explain poor uploads over PPPoE or VPNs (Linux only)")
+	fs.StringVar(&opts.PcapPath, "pcap", "", "capture the test's packets to this pcap `file`, headers only (Linux, needs root or CAP_NET_RAW)")
+	fs.StringVar(&opts.RecordDir, "record", "", "save the server list, how each server answered its ping and the test's requests to this `dir`, a bundle for reproducing a selection bug with --replay")
+	fs.StringVar(&opts.ReplayDir, "replay", "", "play back the server selection recorded in this `dir` by --record against the mock provider")
+	fs.StringVar(&opts.HARPath, "har", "", "record every HTTP request with its timings to this HAR `file`, for browser devtools or bug reports")
+	registerSinkFlags(fs, opts)
+	fs.StringVar(&opts.SignKey, "sign-key", "", "sign the JSON result with this PEM Ed25519 private key `file`, for fast-cli verify")
+	fs.BoolVar(&opts.SkipPrecheck, "skip-precheck", false, "don't check for a captive portal, DNS hijack or other traffic before testing")
+	fs.BoolVar(&opts.RequireIdle, "require-idle", false, "refuse to test while other traffic is using the connection")
+	fs.BoolVar(&opts.Strict, "strict", false, "fail the run instead of reporting a number when a stream died, a phase moved no data or too many requests failed")
+	fs.Float64Var(&opts.StrictMaxErrors, "strict-max-errors", 0, "`percent` of failed chunk requests per phase --strict tolerates")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "validate the configuration, select servers and print what would be tested without transferring data")
+	fs.StringVar(&opts.PreCmd, "pre-cmd", "", "shell `command` to run before each test; the test is skipped if it fails")
+	fs.StringVar(&opts.PostCmd, "post-cmd", "", "shell `command` to run after each test, with the JSON result on stdin and FAST_RESULT_* variables set")
+	return fs
+}
+
+// registerSinkFlags registers the flags of the sinks results are sent to
+// after each run, which [sink NAME] sections of the config file take as
+// well.
+func registerSinkFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.CSVFile, "csv-file", "", "append each result to this CSV `file`, in the columns of history export")
+	fs.StringVar(&opts.PromTextfile, "prom-textfile", "", "after each run, atomically replace this `file` with the results in OpenMetrics format, for node_exporter's textfile collector")
+	fs.StringVar(&opts.PushURL, "push-url", "", "POST each result to this `URL`, as JSON or rendered with --push-template; "+secretHelp)
+	fs.StringVar(&opts.PushTemplate, "push-template", "", "Go `template` of the --push-url body over the JSON result's fields, e.g. {\"speed\": {{.Download.Mbps}}}; @file reads it from a file")
+	fs.Var(&opts.PushHeaders, "push-header", "send this `header` with --push-url, e.g. \"Authorization: Bearer TOKEN\", whose value "+secretHelp+" (repeatable)")
+	fs.Var(&opts.Syslog, "syslog", "send results and errors to the local syslog, or with --syslog=URL to udp://HOST:514, tcp://HOST:514 or unix:///PATH")
+	fs.StringVar(&opts.SyslogFacility, "syslog-facility", "user", "syslog `facility` of --syslog messages, e.g. daemon or local0")
+	fs.StringVar(&opts.SyslogSeverity, "syslog-severity", "info", "syslog `severity` of results, e.g. notice; failed tests are err")
+	fs.StringVar(&opts.SplunkURL, "splunk-hec", "", "send each result to this Splunk HTTP Event Collector `URL`, e.g. https://splunk:8088")
+	fs.StringVar(&opts.SplunkToken, "splunk-token", "", "HEC `token` of --splunk-hec; "+secretHelp)
+	fs.StringVar(&opts.SplunkIndex, "splunk-index", "", "Splunk `index` of the events; the token's default if unset")
+	fs.StringVar(&opts.SplunkSourcetype, "splunk-sourcetype", "fast-cli:result", "Splunk `sourcetype` of the events")
+	fs.StringVar(&opts.ElasticURL, "elasticsearch", "", "index each result in the Elasticsearch cluster at this `URL`, with user:password@ for basic auth")
+	fs.StringVar(&opts.ElasticIndex, "elasticsearch-index", defaultElasticIndex, "`index` of --elasticsearch; Go time layouts in braces are filled in from the test's UTC start")
+	fs.StringVar(&opts.ElasticAPIKey, "elasticsearch-api-key", "", "authenticate to --elasticsearch with this encoded API `key`; "+secretHelp)
+	fs.Var(&opts.Kafka, "kafka", "produce each result to this Kafka `BROKERS/TOPIC`, e.g. kafka1:9092,kafka2:9092/speedtest; queued on disk while unreachable")
+	fs.Var(&opts.NATS, "nats", "publish each result to this NATS `URL`, with the subject as its path, e.g. nats://nats:4222/speedtest.results; queued on disk while unreachable")
+}
+
+func parseOptions(args []string) (*options, error) {
+	opts := &options{}
+	fs := newRunFlagSet("fast-cli", opts)
+
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	// Reported like the flag package does, main only sets the exit status
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+		fmt.Fprintln(fs.Output(), err)
+		return nil, err
+	}
+	if err := opts.validateTest(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return nil, err
+	}
+	return opts, nil
+}
+
+// validateTest checks the flags that shape a test, for every command that runs one.
+func (o *options) validateTest() error {
+	providers, err := parseProviders(o.Provider)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(providers) > 1 && o.Format != formatText && o.Format != formatJSON:
+		return fmt.Errorf("comparing providers supports only the text and json formats")
+	case len(o.Servers) > 0 && o.Provider != providerFast:
+		return fmt.Errorf("--server can't be combined with --provider")
+	case o.CompareVia != "" && len(providers) > 1:
+		return fmt.Errorf("--compare-via can't be combined with comparing providers")
+	case o.CompareVia != "" && o.Format != formatText && o.Format != formatJSON:
+		return fmt.Errorf("--compare-via supports only the text and json formats")
+	case !slices.Contains(outputFormats, o.Format):
+		return fmt.Errorf("unknown output format %q, expected one of %s", o.Format, strings.Join(outputFormats, ", "))
+	case o.DownloadDuration <= 0, o.UploadDuration <= 0:
+		return fmt.Errorf("phase durations must be positive")
+	case o.Streams < 1:
+		return fmt.Errorf("--streams must be at least 1")
+	case o.Candidates < 1, o.LatencySamples < 1:
+		return fmt.Errorf("--candidates and --latency-samples must be at least 1")
+	case o.Quick && o.Thorough:
+		return fmt.Errorf("--quick can't be combined with --thorough")
+	case o.LowResource && o.Thorough:
+		return fmt.Errorf("--low-resource can't be combined with --thorough")
+	case !slices.Contains(uploadPayloads, o.UploadPayload):
+		return fmt.Errorf("unknown --upload-payload %q, expected one of %s", o.UploadPayload, strings.Join(uploadPayloads, ", "))
+	case !slices.Contains(cacheBustModes, o.CacheBust):
+		return fmt.Errorf("unknown --cache-bust %q, expected one of %s", o.CacheBust, strings.Join(cacheBustModes, ", "))
+	case !slices.Contains(hostCheckModes, o.HostCheck):
+		return fmt.Errorf("unknown --host-check %q, expected one of %s", o.HostCheck, strings.Join(hostCheckModes, ", "))
+	case slices.ContainsFunc(o.AllowHosts, badHostPattern):
+		return fmt.Errorf("invalid --allow-host pattern in %s", o.AllowHosts.String())
+	case o.AutoLatency < 0:
+		return fmt.Errorf("--auto-streams-latency must not be negative")
+	case o.AutoLatency > 0 && !o.AutoStreams:
+		return fmt.Errorf("--auto-streams-latency needs --auto-streams")
+	case o.RequireIdle && o.SkipPrecheck:
+		return fmt.Errorf("--require-idle can't be combined with --skip-precheck")
+	case o.StrictMaxErrors < 0 || o.StrictMaxErrors > 100:
+		return fmt.Errorf("--strict-max-errors must be between 0 and 100")
+	case o.SignKey != "" && (len(providers) > 1 || o.CompareVia != ""):
+		return fmt.Errorf("--sign-key can't be combined with comparisons")
+	case o.SignKey != "" && o.Format != formatJSON && o.PushTo == "" && o.PushURL == "":
+		return fmt.Errorf("--sign-key needs --format json, --push-to or --push-url")
+	case o.PromTextfile != "" && (len(providers) > 1 || o.CompareVia != ""):
+		return fmt.Errorf("--prom-textfile can't be combined with comparisons")
+	case o.PromTextfile != "" && !strings.HasSuffix(o.PromTextfile, ".prom"):
+		return fmt.Errorf("--prom-textfile must end in .prom, or node_exporter ignores it")
+	case o.PushURL != "" && (len(providers) > 1 || o.CompareVia != ""):
+		return fmt.Errorf("--push-url can't be combined with comparisons")
+	case !slices.Contains(syslogFacilities, o.SyslogFacility):
+		return fmt.Errorf("unknown --syslog-facility %q, expected one of %s", o.SyslogFacility, strings.Join(syslogFacilities, ", "))
+	case !slices.Contains(syslogSeverities, o.SyslogSeverity):
+		return fmt.Errorf("unknown --syslog-severity %q, expected one of %s", o.SyslogSeverity, strings.Join(syslogSeverities, ", "))
+	case o.Syslog.Network != "" && (len(providers) > 1 || o.CompareVia != ""):
+		return fmt.Errorf("--syslog can't be combined with comparisons")
+	case (o.SplunkURL != "" || o.ElasticURL != "") && (len(providers) > 1 || o.CompareVia != ""):
+		return fmt.Errorf("--splunk-hec and --elasticsearch can't be combined with comparisons")
+	case (o.Kafka.Topic != "" || o.NATS.URL != nil) && (len(providers) > 1 || o.CompareVia != ""):
+		return fmt.Errorf("--kafka and --nats can't be combined with comparisons")
+	case o.SplunkURL != "" && o.SplunkToken == "":
+		return fmt.Errorf("--splunk-hec needs --splunk-token")
+	case o.ElasticURL != "" && o.ElasticIndex == "":
+		return fmt.Errorf("--elasticsearch-index must not be empty")
+	case o.PushURL == "" && (o.PushTemplate != "" || len(o.PushHeaders) > 0):
+		return fmt.Errorf("--push-template and --push-header need --push-url")
+	case o.Simulate.IsSet() && (len(providers) > 1 || o.CompareVia != "" || len(o.Servers) > 0):
+		return fmt.Errorf("--simulate can't be combined with comparisons or --server")
+	case o.ReplayDir != "" && (o.Provider != providerFast && o.Provider != providerMock || len(o.Servers) > 0 || o.Simulate.IsSet()):
+		return fmt.Errorf("--replay plays back on the mock provider and can't be combined with --provider, --server or --simulate")
+	case o.Rank != "" && o.Rank != rankLatency && o.Rank != rankCombined:
+		return fmt.Errorf("--rank must be %s or %s", rankLatency, rankCombined)
+	case o.Upload && o.SkipUpload:
+		return fmt.Errorf("--upload can't be combined with --no-upload")
+	case o.Precision < 0 || o.Precision > 6:
+		return fmt.Errorf("--precision must be between 0 and 6")
+	case o.Lang != "" && !slices.Contains(languages, o.Lang):
+		return fmt.Errorf("--lang must be one of %s", strings.Join(languages, ", "))
+	case o.PingTimeout < 0:
+		return fmt.Errorf("--ping-timeout must not be negative")
+	case o.StallTimeout < 0:
+		return fmt.Errorf("--stall-timeout must not be negative")
+	case o.TotalTimeout < 0:
+		return fmt.Errorf("--total-timeout must not be negative")
+	case o.MaxRuntime < 0:
+		return fmt.Errorf("--max-runtime must not be negative")
+	case o.LogProgress < 0:
+		return fmt.Errorf("--log-progress must not be negative")
+	case o.BarInterval < 0:
+		return fmt.Errorf("--statusbar-interval must not be negative")
+	case o.BarInterval > 0 && o.Format != formatStatusbar:
+		return fmt.Errorf("--statusbar-interval needs --format statusbar")
+	case o.MaxDataMB < 0:
+		return fmt.Errorf("--max-data must not be negative")
+	}
+	for _, h := range o.PushHeaders {
+		if _, _, err := parsePushHeader(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envPrefix turns a flag name into the environment variable that sets it,
+// upper-cased with dashes as underscores: --min-download is FAST_MIN_DOWNLOAD.
+const envPrefix = "FAST_"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseFlags parses args, then fills every flag that was not given on the
+// command line from its FAST_* environment variable, and after that from the
+// config file. Precedence is therefore flags, environment, selected profile,
+// the config file's global settings and finally the defaults. Repeatable
+// flags take a comma-separated list in the environment.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery flag can also be set through the environment, e.g. --min-download as %s.\nFlags given on the command line take precedence.\n", envName("min-download"))
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	onCommandLine := maps.Clone(given)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(repeatableValue); repeatable {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if setErr := fs.Set(f.Name, strings.TrimSpace(v)); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), setErr)
+				return
+			}
+		}
+		given[f.Name] = true
+	})
+	if err == nil {
+		err = applyReplay(fs, onCommandLine, given)
+	}
+	if err == nil {
+		err = applyConfig(fs, given)
+	}
+	if err == nil {
+		err = applyPresets(fs, given)
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+	}
+	return err
+}
+
+// defaultHistoryPath follows the OS config directory convention, falling
+// back to the working directory when no home directory is available.
+func defaultHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "fast-cli-history.jsonl"
+	}
+	return filepath.Join(dir, "fast-cli", "history.jsonl")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// outageProbeInterval is how often the daemon checks whether the network is
+// back during an outage, without --probe-interval.
+const outageProbeInterval = 15 * time.Second
+
+// outageProbeFailures probes in a row that fail start an outage with
+// --probe-interval, as once may be a lost packet.
+const outageProbeFailures = 2
+
+// outageCodes are the errors of a test that mean the network was down,
+// rather than the test or its servers failing.
+var outageCodes = []errorCode{codeNoConnectivity, codeDNSFailure, codeAPIUnreachable, codeAllPingsFailed}
+
+// historyOutage marks a history entry that stands for an outage rather
+// than a test, from its time until its end time.
+type historyOutage struct {
+	Code        errorCode `json:"code"`  // Of the first failed test or probe
+	Error       string    `json:"error"` // Likewise
+	FailedTests int       `json:"failed_tests"`
+	Ongoing     bool      `json:"ongoing,omitempty"` // The daemon stopped before the network came back
+}
+
+// outageTracker turns the scheduled tests, and with --probe-interval the
+// probes, that fail for want of a network into one history entry per
+// outage, from the first failure until a probe gets through again, so that
+// the history tells downtime from a daemon that wasn't running.
+type outageTracker struct {
+	every   time.Duration // Between probes
+	entry   *historyEntry // Of the outage going on, nil while the network is up
+	failing []time.Time   // Probes failed in a row before an outage started
+}
+
+// failed notes a scheduled test started at start that failed with err.
+func (t *outageTracker) failed(res testResult, err error, start time.Time) {
+	if !slices.Contains(outageCodes, errorCodeOf(err)) {
+		return
+	}
+	t.start(res.Provider, res.Via, err, start)
+	t.entry.Outage.FailedTests++
+}
+
+// probe checks whether the network is up, starting an outage once
+// outageProbeFailures probes in a row failed, and recording it once one
+// gets through.
+func (t *outageTracker) probe(opts *options) {
+	now := time.Now()
+	err := outageProbe(opts)
+	switch {
+	case err == nil:
+		t.failing = nil
+		t.end(opts, now, false)
+	case t.entry == nil && slices.Contains(outageCodes, errorCodeOf(err)):
+		if t.failing = append(t.failing, now); len(t.failing) >= outageProbeFailures {
+			t.start(opts.Provider, "", err, t.failing[0])
+		}
+	}
+}
+
+// start starts an outage at at for err, unless one is going on.
+func (t *outageTracker) start(provider, via string, err error, at time.Time) {
+	if t.entry != nil {
+		return
+	}
+	code := errorCodeOf(err)
+	if provider == providerFast {
+		provider = ""
+	}
+	host := currentHost()
+	t.entry = &historyEntry{
+		ID:       newUUID(),
+		Time:     at,
+		Host:     host.Hostname,
+		Platform: host.OS + "/" + host.Arch,
+		Version:  host.Version,
+		Provider: provider,
+		Via:      via,
+		Outage:   &historyOutage{Code: code, Error: err.Error()},
+	}
+	if id, err := currentNetwork(); err == nil { // The link may be up with nothing behind it
+		t.entry.Network = &id
+	}
+	t.failing = nil
+	log.Printf("Outage: the network is down (%s), checking every %s until it is back", code, t.every)
+}
+
+// end records the outage going on as over at, or with ongoing as still
+// going on when the daemon stopped.
+func (t *outageTracker) end(opts *options, at time.Time, ongoing bool) {
+	if t.entry == nil {
+		return
+	}
+	e := *t.entry
+	t.entry = nil
+	e.EndTime, e.Outage.Ongoing = at, ongoing
+	state := "the network is back"
+	if ongoing {
+		state = "still going on"
+	}
+	log.Printf("Outage: %s after %s (failed tests: %d)", state, e.EndTime.Sub(e.Time).Round(time.Second), e.Outage.FailedTests)
+	if !opts.NoHistory {
+		if err := appendHistory(opts.HistoryPath, e); err != nil {
+			log.Printf("Warning: recording outage to history: %v", err)
+		}
+	}
+	if opts.CSVFile != "" {
+		if err := appendCSV(opts.CSVFile, e); err != nil {
+			opts.warn("writing --csv-file: %v", err)
+		}
+	}
+}
+
+// outageCSVColumns are the columns of history export that mark an outage by
+// its error code and length, after those of the speeds.
+var outageCSVColumns = []historyCSVColumn{
+	{"outage", func(e *historyEntry) string {
+		if e.Outage == nil {
+			return ""
+		}
+		return string(e.Outage.Code)
+	}, func(e *historyEntry, v string) error {
+		if v != "" {
+			e.Outage = &historyOutage{Code: errorCode(v)}
+		}
+		return nil
+	}},
+	{"outage_seconds", func(e *historyEntry) string {
+		if e.Outage == nil {
+			return ""
+		}
+		return strconv.FormatFloat(e.EndTime.Sub(e.Time).Seconds(), 'f', 0, 64)
+	}, func(e *historyEntry, v string) error {
+		if v == "" || e.Outage == nil {
+			return nil
+		}
+		secs, err := strconv.ParseFloat(v, 64)
+		e.EndTime = e.Time.Add(time.Duration(secs * float64(time.Second)))
+		return err
+	}},
+}
+
+// outageProbe checks what a test needs first: the internet, and DNS for
+// the provider, or with --server the first peer.
+func outageProbe(opts *options) error {
+	if len(opts.Servers) == 0 {
+		return precheck(opts)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
+	defer cancel()
+	if _, _, err := pingWarm(ctx, httpClient, peerTargets(opts.Servers)[0], false); err != nil {
+		return withCode(codeAllPingsFailed, err)
+	}
+	return nil
+}
+
+// availability is the percentage of from until to that no outage of entries
+// covered, how long they did, and how many there were.
+func availability(entries []historyEntry, from, to time.Time) (percent float64, down time.Duration, outages int) {
+	for _, e := range entries {
+		start, end := e.Time, cmp.Or(e.EndTime, e.Time)
+		if e.Outage == nil || !start.Before(to) || end.Before(from) {
+			continue
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		down += end.Sub(start)
+		outages++
+	}
+	if !to.After(from) {
+		return 100, down, outages
+	}
+	return 100 * (1 - float64(down)/float64(to.Sub(from))), down, outages
+}
+
+// monthAvailability is the availability of the local month until is in,
+// up to until, counted from the start of the history if that is later.
+func monthAvailability(history []historyEntry, until time.Time) (month time.Time, percent float64, down time.Duration, outages int) {
+	last := until.Add(-time.Nanosecond).Local()
+	month = time.Date(last.Year(), last.Month(), 1, 0, 0, 0, 0, time.Local)
+	from := month
+	if len(history) > 0 && history[0].Time.After(from) {
+		from = history[0].Time
+	}
+	if now := time.Now(); until.After(now) {
+		until = now
+	}
+	percent, down, outages = availability(history, from, until)
+	return month, percent, down, outages
+}
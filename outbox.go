@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The daemon retries undelivered messages between tests, backing off from
+// outboxRetryMin to outboxRetryMax while sinks stay unreachable.
+const (
+	outboxRetryMin = 30 * time.Second
+	outboxRetryMax = 15 * time.Minute
+)
+
+// Messages queued beyond this many per sink push out the oldest, so that a
+// sink gone for good can't fill the disk.
+const maxOutboxMessages = 5000
+
+// outboxMessage is a result, or a notification, waiting for a sink to take
+// it.
+type outboxMessage struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// rejectedError is a sink refusing a message for good, e.g. with HTTP 400 or
+// 403, which retrying wouldn't change; the outbox drops it.
+type rejectedError struct{ err error }
+
+func (e rejectedError) Error() string { return e.err.Error() }
+func (e rejectedError) Unwrap() error { return e.err }
+
+// outbox is the on-disk queue of one sink: a JSON-lines file next to the
+// history that holds the messages the sink hasn't acknowledged yet.
+type outbox struct {
+	Name string // Of the sink, for messages
+	Path string
+}
+
+// newOutbox returns the outbox of the sink called name, kept in file.jsonl,
+// or in one of its own for a sink of a [sink] section.
+func newOutbox(opts *options, name, file string) outbox {
+	if opts.sinkName != "" {
+		name += " (sink " + opts.sinkName + ")"
+	}
+	return outbox{Name: name, Path: filepath.Join(filepath.Dir(opts.HistoryPath), "outbox", opts.sinkID(file)+".jsonl")}
+}
+
+// id is the sinkID of the outbox's sink, its file name.
+func (o outbox) id() string { return strings.TrimSuffix(filepath.Base(o.Path), ".jsonl") }
+
+// deliver sends the queued messages, oldest first, and then msgs, removing
+// each only once send has returned without error; the first failure keeps
+// it and everything after it queued for the next time. Delivery is thus at
+// least once: a crash between the acknowledgement and the rewrite of the
+// queue sends a message again.
+func (o outbox) deliver(send func(outboxMessage) error, msgs ...outboxMessage) error {
+	queue, err := o.load()
+	if err != nil {
+		log.Printf("Warning: reading the outbox of %s, its messages are lost: %v", o.Name, err)
+	}
+	if len(queue) == 0 && len(msgs) == 0 {
+		return nil
+	}
+	queued := len(queue)
+	queue = append(queue, msgs...)
+	sent := 0
+	for _, m := range queue {
+		if err = send(m); err != nil {
+			selfMetrics.sinkFailed(o.id())
+		}
+		if errors.As(err, new(rejectedError)) {
+			log.Printf("Warning: dropping a message that %s rejected: %v", o.Name, err)
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+		sent++
+	}
+	queue = queue[sent:]
+	if dropped := len(queue) - maxOutboxMessages; dropped > 0 {
+		log.Printf("Warning: the outbox of %s is full, dropping its %d oldest messages", o.Name, dropped)
+		queue = queue[dropped:]
+	}
+	if saveErr := o.save(queue); saveErr != nil {
+		return errors.Join(err, saveErr)
+	}
+	if err != nil {
+		return fmt.Errorf("%w (%d queued to retry)", err, len(queue))
+	}
+	if queued > 0 {
+		log.Printf("Delivered %d messages queued for %s", min(sent, queued), o.Name)
+	}
+	return nil
+}
+
+func (o outbox) load() ([]outboxMessage, error) {
+	f, err := os.Open(o.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var queue []outboxMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxUploadSize)
+	for scanner.Scan() {
+		var m outboxMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			continue // A line cut short by a crash
+		}
+		queue = append(queue, m)
+	}
+	return queue, scanner.Err()
+}
+
+// save rewrites the queue atomically, or removes its file once empty.
+func (o outbox) save(queue []outboxMessage) error {
+	if len(queue) == 0 {
+		if err := os.Remove(o.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("emptying the outbox of %s: %w", o.Name, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(o.Path), 0o755); err != nil {
+		return fmt.Errorf("creating outbox directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(o.Path), ".outbox-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("creating temporary outbox file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, m := range queue {
+		if err := enc.Encode(m); err != nil {
+			tmp.Close()
+			return fmt.Errorf("encoding outbox entry: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing outbox file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing outbox file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), o.Path); err != nil {
+		return fmt.Errorf("replacing outbox file: %w", err)
+	}
+	return nil
+}
+
+// retryOutboxes delivers what the sinks of opts and of its [sink] sections
+// have queued and reports whether anything is still waiting.
+func retryOutboxes(opts *options) (pending bool) {
+	for _, s := range queuedSinks(opts) {
+		if err := s.outbox.deliver(s.send); err != nil {
+			log.Printf("Warning: retrying %s: %v", s.outbox.Name, err)
+		}
+	}
+	return outboxesPending(opts)
+}
+
+func outboxesPending(opts *options) bool {
+	for _, s := range queuedSinks(opts) {
+		if queue, _ := s.outbox.load(); len(queue) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// queuedSinks are the network sinks of opts and of its [sink] sections.
+func queuedSinks(opts *options) []queuedSink {
+	sinks := webhookSinks(opts)
+	for _, o := range append([]*options{opts}, opts.sinks...) {
+		sinks = append(sinks, resultSinks(o)...)
+	}
+	return sinks
+}
@@ -0,0 +1,712 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Output formats accepted by --format
+const (
+	formatText         = "text"
+	formatJSON         = "json"
+	formatSpeedtestCLI = "speedtest-cli" // sivel/speedtest-cli --json
+	formatOokla        = "ookla"         // Ookla speedtest --format=json, as read by speedtest-tracker
+	formatCollectd     = "collectd-exec" // PUTVAL lines for the collectd exec plugin
+	formatJUnit        = "junit"         // JUnit XML, one test case per threshold
+	formatMarkdown     = "markdown"      // Tables to paste into issues and wikis
+	formatSimple       = "simple"        // One line for shell one-liners and status bars
+	formatStatusbar    = "statusbar"     // JSON of Waybar custom modules, see statusbarLine
+	formatNDJSON       = "ndjson"        // A JSON line per step as the test goes, see ndjsonEvent
+)
+
+var outputFormats = []string{formatText, formatJSON, formatSpeedtestCLI, formatOokla, formatCollectd, formatJUnit, formatMarkdown, formatSimple, formatStatusbar, formatNDJSON}
+
+// writeResult prints the final result to stdout in the selected format.
+func writeResult(opts *options, res testResult, history []historyEntry) error {
+	switch opts.Format {
+	case formatText:
+		printResults(res, opts)
+		if opts.Plan.IsSet() {
+			printPlanComparison(res, opts.Plan, history, opts.Precision)
+		}
+		return nil
+	case formatJSON:
+		out := newJSONResult(res, opts.Plan)
+		if opts.signingKey != nil {
+			if err := signResult(&out, opts.signingKey); err != nil {
+				return fmt.Errorf("signing result: %w", err)
+			}
+		}
+		return writeJSON(out)
+	case formatSpeedtestCLI:
+		return writeJSON(newSpeedtestCLIResult(res))
+	case formatOokla:
+		return writeJSON(newOoklaResult(res))
+	case formatCollectd:
+		return writeCollectd(os.Stdout, newHistoryEntry(res), collectdInterval())
+	case formatJUnit:
+		return writeJUnit(os.Stdout, res, opts.Thresholds)
+	case formatMarkdown:
+		return writeMarkdown(os.Stdout, res, opts)
+	case formatSimple:
+		return writeSimple(os.Stdout, res, opts.SimpleField)
+	case formatStatusbar:
+		return writeStatusbar(os.Stdout, newStatusbarLine(res, opts))
+	case formatNDJSON:
+		out := newJSONResult(res, opts.Plan)
+		return writeNDJSON(ndjsonEvent{Event: "result", ID: res.ID, Result: &out})
+	default:
+		return fmt.Errorf("unknown output format %q", opts.Format)
+	}
+}
+
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printResults(res testResult, opts *options) {
+	precision := opts.Precision
+	fmt.Println(lang.tr("\n--- Speed Test Results ---"))
+	lang.printf("Download Speed: %.*f Mbps\n", precision, res.Download.Mbps)
+	lang.printf("Upload Speed: %.*f Mbps\n", precision, res.Upload.Mbps)
+	lang.printf("Average Ping to selected servers: %s\n", res.AvgPing.Round(time.Millisecond))
+	if len(res.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", &res.Tags)
+	}
+	if res.Status() == "degraded" {
+		fmt.Printf("Degraded: %d of %d download and %d of %d upload streams failed; the speeds above add up each stream's speed while it was up, %.1f and %.1f streams on average (--strict fails instead)\n",
+			res.Download.FailedStreams, res.Download.Streams, res.Upload.FailedStreams, res.Upload.Streams, res.Download.Parallelism, res.Upload.Parallelism)
+	}
+	if res.Download.AutoStreams+res.Upload.AutoStreams > 0 {
+		fmt.Printf("Streams: --auto-streams settled on %d for the download and %d for the upload\n", res.Download.AutoStreams, res.Upload.AutoStreams)
+	}
+	if r := res.Download.Integrity; r != nil {
+		fmt.Printf("Content integrity: %s\n", r)
+	}
+	if r := res.Download.Cache; r != nil {
+		fmt.Printf("Caches: %s\n", r)
+	}
+	if p := res.Upload.Payload; p != "" && p != payloadRandom {
+		fmt.Printf("Upload payload: %s, which a middlebox that compresses speeds up; compare with a result of --upload-payload random\n", p)
+	}
+	if n := max(res.Download.StreamsPerConn, res.Upload.StreamsPerConn); n > 1 {
+		fmt.Printf("Shared connections: up to %d streams ran over one TCP connection (HTTP/2), measuring fewer flows than streams; --force-new-conns avoids it\n", n)
+	}
+	if res.Download.Stalls+res.Upload.Stalls > 0 {
+		fmt.Printf("Stalls: %d download and %d upload requests moved no data for a while and were retried\n", res.Download.Stalls, res.Upload.Stalls)
+	}
+	for _, h := range res.Redirects {
+		if h.OffHost {
+			fmt.Printf("Redirected: %d requests from %s to %s, another host, which was measured instead of the server (first %s); --strict fails instead\n", h.Count, h.From, h.To, h.First)
+		} else {
+			fmt.Printf("Redirected: %d requests within %s (first %s)\n", h.Count, h.From, h.First)
+		}
+	}
+	if opts.PerServer {
+		printPerServer(res, precision)
+	}
+
+	printLatencyTable(res)
+	lang.printf("Responsiveness: %s\n", formatRPM(lang, res.LoadedLatencySamples()))
+	lang.printf("Download Consistency: %s\n", formatConsistency(lang, res.Download.Samples))
+	lang.printf("Upload Consistency: %s\n", formatConsistency(lang, res.Upload.Samples))
+	if res.PathMTU.MTU > 0 {
+		fmt.Printf("Path MTU: %s\n", res.PathMTU)
+	}
+	if res.Congestion != "" {
+		lang.printf("TCP congestion control: %s\n", res.Congestion)
+	}
+	var buffers []string
+	if res.SendBuffer > 0 {
+		buffers = append(buffers, "send "+byteSize(res.SendBuffer).String())
+	}
+	if res.ReceiveBuffer > 0 {
+		buffers = append(buffers, "receive "+byteSize(res.ReceiveBuffer).String())
+	}
+	if len(buffers) > 0 {
+		fmt.Printf("Socket buffers: %s\n", strings.Join(buffers, ", "))
+	}
+	if res.WiFi != nil {
+		fmt.Printf("Wi-Fi: %s\n", res.WiFi)
+	}
+	if res.ClockSkew != 0 {
+		fmt.Printf("Clock: %s\n", describeSkew(res.ClockSkew, "the server list API"))
+	}
+	if res.Network != nil {
+		network := "Network: " + res.Network.String()
+		if res.Trigger == triggerNetworkChange {
+			network += ", tested as the daemon moved to it"
+		}
+		fmt.Println(network)
+	}
+	if d, u := res.Download.Resources, res.Upload.Resources; d.Cores > 0 {
+		load := lang.sprintf("Tester load: %.0f%% CPU downloading", d.CPUPercent)
+		if u.Cores > 0 {
+			load += lang.sprintf(", %.0f%% uploading", u.CPUPercent)
+		}
+		lang.printf("%s (100%% per core, of %d), %.0f MiB peak memory\n", load, d.Cores, float64(max(d.PeakRSS, u.PeakRSS))/(1<<20))
+		if d.CPUBound() || u.CPUBound() {
+			fmt.Println("CPU-bound: the test kept this device's CPU busy, so the speeds above may be its limit rather than the connection's")
+		}
+	}
+
+	printVerdicts(res)
+}
+
+// printPerServer shows what each server's stream did, its speed over the
+// time it was up.
+func printPerServer(res testResult, precision int) {
+	fmt.Println("\nPer server:")
+	for _, phase := range []struct {
+		name   string
+		result phaseResult
+	}{{"Download", res.Download}, {"Upload", res.Upload}} {
+		for _, s := range phase.result.PerServer {
+			fmt.Printf("  %-8s %-40s %10.*f Mbps over %s, %d requests, %d failed\n",
+				phase.name, s.Host, precision, toMbps(s.Bytes, s.Active), s.Active.Round(100*time.Millisecond), s.Requests, s.Failed)
+		}
+	}
+}
+
+// printLatencyTable shows unloaded latency next to the latency while each
+// phase saturated the link, like fast.com's "Show more info", followed by
+// the bufferbloat verdict and histograms of the series with enough samples,
+// which --plain leaves out as the percentiles say the same in words.
+func printLatencyTable(res testResult) {
+	series := []struct {
+		Name  string
+		Stats latencyStats
+	}{
+		{"Unloaded", res.IdleLatency},
+		{"During download", res.DownloadLatency},
+		{"During upload", res.UploadLatency},
+	}
+	ms := func(d time.Duration) string { return d.Round(time.Millisecond).String() }
+
+	fmt.Printf("\n%-16s %8s %8s %8s %8s %8s %8s\n", lang.tr("Latency"), lang.tr("Avg"), lang.tr("Jitter"), "p50", "p95", "p99", lang.tr("vs idle"))
+	for _, s := range series {
+		if len(s.Stats.Samples) == 0 {
+			fmt.Printf("%-16s %8s\n", lang.tr(s.Name), lang.tr("N/A"))
+			continue
+		}
+		delta := ""
+		if s.Name != series[0].Name && len(res.IdleLatency.Samples) > 0 {
+			delta = fmt.Sprintf("%+dms", (s.Stats.Avg - res.IdleLatency.Avg).Round(time.Millisecond).Milliseconds())
+		}
+		st := s.Stats
+		fmt.Printf("%-16s %8s %8s %8s %8s %8s %8s\n", lang.tr(s.Name), ms(st.Avg), ms(st.Jitter), ms(st.P50), ms(st.P95), ms(st.P99), delta)
+	}
+	lang.printf("Verdict: %s\n", bufferbloatVerdict(lang, res))
+
+	var histograms []string
+	for _, s := range series {
+		if h := latencyHistogram(s.Stats); h != "" && !plainOutput {
+			histograms = append(histograms, fmt.Sprintf("  %-16s %s", lang.tr(s.Name)+":", h))
+		}
+	}
+	if len(histograms) > 0 {
+		fmt.Println("Latency histograms:")
+		for _, h := range histograms {
+			fmt.Println(h)
+		}
+	}
+	fmt.Println()
+}
+
+// Native JSON output (--format json)
+
+type jsonLatency struct {
+	AvgMs    float64 `json:"avg_ms"`
+	MinMs    float64 `json:"min_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	Samples  int     `json:"samples"`
+}
+
+func newJSONLatency(stats latencyStats) *jsonLatency {
+	if len(stats.Samples) == 0 {
+		return nil
+	}
+	return &jsonLatency{
+		AvgMs:    durationMs(stats.Avg),
+		MinMs:    durationMs(stats.Min),
+		MaxMs:    durationMs(stats.Max),
+		JitterMs: durationMs(stats.Jitter),
+		P50Ms:    durationMs(stats.P50),
+		P95Ms:    durationMs(stats.P95),
+		P99Ms:    durationMs(stats.P99),
+		Samples:  len(stats.Samples),
+	}
+}
+
+type jsonConsistency struct {
+	P10Mbps float64 `json:"p10_mbps"`
+	P90Mbps float64 `json:"p90_mbps"`
+	Ratio   float64 `json:"ratio"`
+	CV      float64 `json:"cv"`
+}
+
+// jsonStream is what the stream to one server did in a phase.
+type jsonStream struct {
+	Host     string  `json:"host"`
+	Mbps     float64 `json:"mbps"` // Over ActiveMs
+	Bytes    int64   `json:"bytes"`
+	ActiveMs float64 `json:"active_ms"`
+	Requests int     `json:"requests"`
+	Failed   int     `json:"failed_requests"`
+}
+
+// jsonWindow is one labeled part of a phase, see measureWindows.
+type jsonWindow struct {
+	Name    string  `json:"name"` // ramp_up, steady or tail
+	StartMs float64 `json:"start_ms"`
+	EndMs   float64 `json:"end_ms"`
+	Mbps    float64 `json:"mbps"`
+	Bps     int64   `json:"bps"`
+	MaxMbps float64 `json:"max_mbps"`
+}
+
+type jsonPhase struct {
+	Mbps        float64          `json:"mbps"`
+	Bps         int64            `json:"bps"` // Mbps in whole bits per second, for consumers that compare exactly
+	Bytes       int64            `json:"bytes"`
+	DurationMs  float64          `json:"duration_ms"`
+	Latency     *jsonLatency     `json:"latency,omitempty"` // Latency under load
+	Consistency *jsonConsistency `json:"consistency,omitempty"`
+	SamplesMbps []float64        `json:"samples_mbps,omitempty"`
+	PerServer   []jsonStream     `json:"per_server,omitempty"`
+	Windows     []jsonWindow     `json:"windows,omitempty"`
+	Requests    int64            `json:"requests"`
+	FailedReqs  int              `json:"failed_requests"`
+	Stalls      int              `json:"stalls"`
+	Connections int              `json:"connections"`
+	PerConn     int              `json:"max_streams_per_connection"`
+	Parallelism float64          `json:"effective_streams"` // Streams up on average
+	DeadStreams int              `json:"failed_streams"`
+	AutoStreams int              `json:"auto_streams,omitempty"`
+	Payload     string           `json:"payload,omitempty"`
+	Integrity   *jsonIntegrity   `json:"integrity,omitempty"`
+	Cache       *jsonCache       `json:"cache,omitempty"`
+	Resources   *jsonResources   `json:"resources,omitempty"` // Of fast-cli itself, where measurable
+}
+
+// jsonIntegrity is the --check-integrity result of a download.
+type jsonIntegrity struct {
+	Status     string `json:"status"` // ok, failed or unverified
+	Chunks     int    `json:"chunks"`
+	WrongSize  int    `json:"wrong_size"`
+	Samples    int    `json:"samples"`
+	Altered    int    `json:"altered_samples"`
+	Unverified int    `json:"unverified_streams"`
+}
+
+// jsonCache is what the cache headers of a download's responses said.
+type jsonCache struct {
+	Bust       string `json:"cache_bust"`
+	Chunks     int    `json:"chunks"`
+	Marked     int    `json:"with_cache_headers"`
+	Hits       int    `json:"hits"`
+	MaxAgeSecs int    `json:"max_age_seconds,omitempty"`
+	Example    string `json:"example,omitempty"` // A header of a hit, or of any marked response
+}
+
+// jsonResources is what fast-cli took of the machine it ran on in a phase.
+type jsonResources struct {
+	CPUPercent     float64 `json:"cpu_percent"` // 100 per fully busy core
+	PeakCPUPercent float64 `json:"peak_cpu_percent"`
+	PeakRSSBytes   int64   `json:"peak_rss_bytes"`
+	Cores          int     `json:"cores"`
+	CPUBound       bool    `json:"cpu_bound"` // The device rather than the line likely limited the speed
+}
+
+func newJSONPhase(phase phaseResult, loaded latencyStats) jsonPhase {
+	p := jsonPhase{
+		Mbps:        phase.Mbps,
+		Bps:         toBps(phase.Mbps),
+		Bytes:       phase.Bytes,
+		DurationMs:  durationMs(phase.Duration),
+		Latency:     newJSONLatency(loaded),
+		SamplesMbps: phase.Samples,
+		Requests:    phase.Requests,
+		FailedReqs:  phase.FailedRequests,
+		Stalls:      phase.Stalls,
+		Connections: phase.Connections,
+		PerConn:     phase.StreamsPerConn,
+		Parallelism: phase.Parallelism,
+		DeadStreams: phase.FailedStreams,
+		AutoStreams: phase.AutoStreams,
+		Payload:     phase.Payload,
+	}
+	if c, ok := measureConsistency(phase.Samples); ok {
+		p.Consistency = &jsonConsistency{P10Mbps: c.P10, P90Mbps: c.P90, Ratio: c.Ratio, CV: c.CV}
+	}
+	if r := phase.Cache; r != nil {
+		p.Cache = &jsonCache{Bust: r.Bust, Chunks: r.Chunks, Marked: r.Marked, Hits: r.Hits, MaxAgeSecs: r.MaxAge, Example: r.Example}
+	}
+	if r := phase.Integrity; r != nil {
+		p.Integrity = &jsonIntegrity{Status: r.Status(), Chunks: r.Chunks, WrongSize: r.WrongSize, Samples: r.Samples, Altered: r.Altered, Unverified: r.Unverified}
+	}
+	if u := phase.Resources; u.Cores > 0 {
+		p.Resources = &jsonResources{CPUPercent: u.CPUPercent, PeakCPUPercent: u.PeakCPUPercent, PeakRSSBytes: u.PeakRSS, Cores: u.Cores, CPUBound: u.CPUBound()}
+	}
+	for _, s := range phase.PerServer {
+		p.PerServer = append(p.PerServer, jsonStream{Host: s.Host, Mbps: toMbps(s.Bytes, s.Active), Bytes: s.Bytes, ActiveMs: durationMs(s.Active), Requests: s.Requests, Failed: s.Failed})
+	}
+	for _, w := range measureWindows(phase.Samples) {
+		p.Windows = append(p.Windows, jsonWindow{Name: w.Name, StartMs: durationMs(w.Start), EndMs: durationMs(w.End), Mbps: w.Mbps, Bps: toBps(w.Mbps), MaxMbps: w.Max})
+	}
+	return p
+}
+
+type jsonServer struct {
+	Name      string       `json:"name"`
+	URL       string       `json:"url"`
+	City      string       `json:"city"`
+	Country   string       `json:"country"`
+	LatencyMs float64      `json:"latency_ms"`
+	SetupMs   float64      `json:"connection_setup_ms,omitempty"` // DNS, TCP and TLS, left out of latency_ms with --ping-warmup
+	ProbeMbps float64      `json:"probe_mbps,omitempty"`          // With --rank combined
+	Connect   *jsonConnect `json:"connect,omitempty"`
+}
+
+// jsonConnect is how the connection to a server was made, see connectReport.
+type jsonConnect struct {
+	Family  string `json:"family"`
+	Address string `json:"address"`
+	IPv6    string `json:"ipv6"`
+	IPv4    string `json:"ipv4"`
+}
+
+type jsonClient struct {
+	IP      string `json:"ip"`
+	ASN     string `json:"asn"`
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+type jsonPlan struct {
+	DownloadMbps    float64 `json:"download_mbps"`
+	UploadMbps      float64 `json:"upload_mbps,omitempty"`
+	DownloadPercent float64 `json:"download_percent"`
+	UploadPercent   float64 `json:"upload_percent,omitempty"`
+}
+
+type jsonResult struct {
+	ID            string             `json:"id"`
+	Timestamp     time.Time          `json:"timestamp"` // Same as StartedAt, kept for existing consumers
+	StartedAt     time.Time          `json:"started_at"`
+	EndedAt       time.Time          `json:"ended_at"`
+	Host          hostInfo           `json:"host"`
+	Provider      string             `json:"provider,omitempty"`
+	Status        string             `json:"status"` // ok, or degraded when a stream or phase failed
+	Via           string             `json:"via,omitempty"`
+	Tags          resultTags         `json:"tags,omitempty"`
+	Seed          uint64             `json:"seed,omitempty"` // Of --seed
+	Client        jsonClient         `json:"client"`
+	Servers       []jsonServer       `json:"servers"`
+	Ping          *jsonLatency       `json:"ping,omitempty"` // Idle latency to the best server
+	Download      jsonPhase          `json:"download"`
+	Upload        jsonPhase          `json:"upload"`
+	RPM           float64            `json:"rpm,omitempty"`
+	Verdicts      []useCaseVerdict   `json:"verdicts"`
+	Plan          *jsonPlan          `json:"plan,omitempty"`
+	PathMTU       *jsonPathMTU       `json:"path_mtu,omitempty"`
+	SocketBuffers *jsonSocketBuffers `json:"socket_buffers,omitempty"`
+	// TCP congestion control of the test connections, Linux only
+	Congestion string    `json:"congestion,omitempty"`
+	WiFi       *wifiInfo `json:"wifi,omitempty"`
+	// Other traffic on the link just before the test
+	Background *backgroundTraffic `json:"background_traffic,omitempty"`
+	Redirects  []redirectHop      `json:"redirects,omitempty"`
+	Trigger    string             `json:"trigger,omitempty"` // Why the daemon ran the test out of schedule
+	Network    *networkIdentity   `json:"network,omitempty"`
+	ClockSkew  float64            `json:"clock_skew_seconds,omitempty"` // Of the system clock, when over 10s
+	Errors     []jsonError        `json:"errors,omitempty"`             // Phases that failed
+	Signature  *jsonSignature     `json:"signature,omitempty"`
+}
+
+// jsonSocketBuffers are the buffer sizes the kernel granted with --sndbuf
+// and --rcvbuf, 0 for the one not set.
+type jsonSocketBuffers struct {
+	SendBytes    int `json:"send_bytes"`
+	ReceiveBytes int `json:"receive_bytes"`
+}
+
+type jsonPathMTU struct {
+	MTU          int  `json:"mtu"`
+	Confirmed    bool `json:"confirmed"`
+	InterfaceMTU int  `json:"interface_mtu"`
+	TCPMSS       int  `json:"tcp_mss,omitempty"`
+}
+
+func newJSONResult(res testResult, p plan) jsonResult {
+	out := jsonResult{
+		ID:        res.ID,
+		Timestamp: res.StartedAt,
+		StartedAt: res.StartedAt,
+		EndedAt:   res.EndedAt,
+		Host:      currentHost(),
+		Provider:  res.Provider,
+		Status:    res.Status(),
+		Via:       res.Via,
+		Tags:      res.Tags,
+		Seed:      res.Seed,
+		Client: jsonClient{
+			IP:      res.Client.IP,
+			ASN:     res.Client.Asn,
+			City:    res.Client.Location.City,
+			Country: res.Client.Location.Country,
+		},
+		Ping:       newJSONLatency(res.IdleLatency),
+		Download:   newJSONPhase(res.Download, res.DownloadLatency),
+		Upload:     newJSONPhase(res.Upload, res.UploadLatency),
+		RPM:        responsivenessRPM(res.LoadedLatencySamples()),
+		Verdicts:   assessConnection(res, localeEnglish),
+		WiFi:       res.WiFi,
+		Background: res.Background,
+		Redirects:  res.Redirects,
+		Trigger:    res.Trigger,
+		Network:    res.Network,
+		ClockSkew:  res.ClockSkew.Seconds(),
+		Errors:     newJSONErrors(res.Errors),
+	}
+	for _, pt := range res.Servers {
+		out.Servers = append(out.Servers, jsonServer{
+			Name:      pt.Target.Name,
+			URL:       pt.Target.URL,
+			City:      pt.Target.Location.City,
+			Country:   pt.Target.Location.Country,
+			LatencyMs: durationMs(pt.Latency),
+			SetupMs:   durationMs(pt.Setup),
+			ProbeMbps: pt.ProbeMbps,
+		})
+		if c := pt.Connect; c.Family != "" {
+			out.Servers[len(out.Servers)-1].Connect = &jsonConnect{Family: c.Family, Address: c.Address, IPv6: c.IPv6, IPv4: c.IPv4}
+		}
+	}
+	out.Congestion = res.Congestion
+	if res.SendBuffer > 0 || res.ReceiveBuffer > 0 {
+		out.SocketBuffers = &jsonSocketBuffers{SendBytes: res.SendBuffer, ReceiveBytes: res.ReceiveBuffer}
+	}
+	if m := res.PathMTU; m.MTU > 0 {
+		out.PathMTU = &jsonPathMTU{MTU: m.MTU, Confirmed: m.Confirmed, InterfaceMTU: m.InterfaceMTU, TCPMSS: m.TCPMSS}
+	}
+	if p.IsSet() {
+		out.Plan = &jsonPlan{
+			DownloadMbps:    p.DownloadMbps,
+			UploadMbps:      p.UploadMbps,
+			DownloadPercent: 100 * res.Download.Mbps / p.DownloadMbps,
+		}
+		if p.UploadMbps > 0 {
+			out.Plan.UploadPercent = 100 * res.Upload.Mbps / p.UploadMbps
+		}
+	}
+	return out
+}
+
+func targetHost(t target) string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// bestServer returns the lowest-latency selected server, which is the one
+// compatibility formats report since they only know about a single server.
+func bestServer(res testResult) (pingedTarget, bool) {
+	if len(res.Servers) == 0 {
+		return pingedTarget{}, false
+	}
+	return slices.MinFunc(res.Servers, func(a, b pingedTarget) int { return cmp.Compare(a.Latency, b.Latency) }), true
+}
+
+// speedtest-cli compatible output (--format speedtest-cli). Field names and
+// types follow `speedtest-cli --json`, including its stringly-typed fields.
+
+type speedtestCLIServer struct {
+	URL     string  `json:"url"`
+	Lat     string  `json:"lat"`
+	Lon     string  `json:"lon"`
+	Name    string  `json:"name"`
+	Country string  `json:"country"`
+	CC      string  `json:"cc"`
+	Sponsor string  `json:"sponsor"`
+	ID      string  `json:"id"`
+	Host    string  `json:"host"`
+	D       float64 `json:"d"`
+	Latency float64 `json:"latency"`
+}
+
+type speedtestCLIClient struct {
+	IP        string `json:"ip"`
+	Lat       string `json:"lat"`
+	Lon       string `json:"lon"`
+	ISP       string `json:"isp"`
+	ISPRating string `json:"isprating"`
+	Rating    string `json:"rating"`
+	ISPDLAvg  string `json:"ispdlavg"`
+	ISPULAvg  string `json:"ispulavg"`
+	LoggedIn  string `json:"loggedin"`
+	Country   string `json:"country"`
+}
+
+type speedtestCLIResult struct {
+	Download      float64            `json:"download"` // bits/s
+	Upload        float64            `json:"upload"`   // bits/s
+	Ping          float64            `json:"ping"`     // ms
+	Server        speedtestCLIServer `json:"server"`
+	Timestamp     string             `json:"timestamp"`
+	BytesSent     int64              `json:"bytes_sent"`
+	BytesReceived int64              `json:"bytes_received"`
+	Share         *string            `json:"share"`
+	Client        speedtestCLIClient `json:"client"`
+}
+
+func asnISP(asn string) string {
+	if asn == "" {
+		return ""
+	}
+	return "AS" + asn
+}
+
+func newSpeedtestCLIResult(res testResult) speedtestCLIResult {
+	out := speedtestCLIResult{
+		Download:      res.Download.Mbps * 1e6,
+		Upload:        res.Upload.Mbps * 1e6,
+		Ping:          durationMs(res.IdleLatency.Avg),
+		Timestamp:     res.StartedAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		BytesSent:     res.Upload.Bytes,
+		BytesReceived: res.Download.Bytes,
+		Client: speedtestCLIClient{
+			IP:       res.Client.IP,
+			ISP:      asnISP(res.Client.Asn),
+			Rating:   "0",
+			LoggedIn: "0",
+			Country:  res.Client.Location.Country,
+		},
+	}
+	if srv, ok := bestServer(res); ok {
+		out.Server = speedtestCLIServer{
+			URL:     srv.Target.URL,
+			Name:    srv.Target.Location.City,
+			Country: srv.Target.Location.Country,
+			CC:      srv.Target.Location.Country,
+			Sponsor: "Netflix",
+			Host:    targetHost(srv.Target),
+			Latency: durationMs(srv.Latency),
+		}
+		if out.Ping == 0 {
+			out.Ping = durationMs(srv.Latency)
+		}
+	}
+	return out
+}
+
+// Ookla speedtest compatible output (--format ookla), the format consumed by
+// speedtest-tracker and most Prometheus speedtest exporters.
+
+type ooklaLatency struct {
+	IQM    float64 `json:"iqm"`
+	Low    float64 `json:"low"`
+	High   float64 `json:"high"`
+	Jitter float64 `json:"jitter"`
+}
+
+type ooklaPing struct {
+	Jitter  float64 `json:"jitter"`
+	Latency float64 `json:"latency"`
+	Low     float64 `json:"low"`
+	High    float64 `json:"high"`
+}
+
+type ooklaPhase struct {
+	Bandwidth int64        `json:"bandwidth"` // Bytes per second
+	Bytes     int64        `json:"bytes"`
+	Elapsed   int64        `json:"elapsed"` // Milliseconds
+	Latency   ooklaLatency `json:"latency"`
+}
+
+type ooklaServer struct {
+	ID       int    `json:"id"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Country  string `json:"country"`
+}
+
+type ooklaInterface struct {
+	ExternalIP string `json:"externalIp"`
+}
+
+type ooklaResultInfo struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	Persisted bool   `json:"persisted"`
+}
+
+type ooklaResult struct {
+	Type      string          `json:"type"`
+	Timestamp string          `json:"timestamp"`
+	Ping      ooklaPing       `json:"ping"`
+	Download  ooklaPhase      `json:"download"`
+	Upload    ooklaPhase      `json:"upload"`
+	ISP       string          `json:"isp"`
+	Interface ooklaInterface  `json:"interface"`
+	Server    ooklaServer     `json:"server"`
+	Result    ooklaResultInfo `json:"result"`
+}
+
+func newOoklaPhase(phase phaseResult, loaded latencyStats) ooklaPhase {
+	return ooklaPhase{
+		Bandwidth: int64(phase.Mbps * 1e6 / 8),
+		Bytes:     phase.Bytes,
+		Elapsed:   phase.Duration.Milliseconds(),
+		Latency: ooklaLatency{
+			IQM:    durationMs(loaded.Avg),
+			Low:    durationMs(loaded.Min),
+			High:   durationMs(loaded.Max),
+			Jitter: durationMs(loaded.Jitter),
+		},
+	}
+}
+
+func newOoklaResult(res testResult) ooklaResult {
+	out := ooklaResult{
+		Type:      "result",
+		Timestamp: res.StartedAt.UTC().Format(time.RFC3339),
+		Ping: ooklaPing{
+			Jitter:  durationMs(res.IdleLatency.Jitter),
+			Latency: durationMs(res.IdleLatency.Avg),
+			Low:     durationMs(res.IdleLatency.Min),
+			High:    durationMs(res.IdleLatency.Max),
+		},
+		Download:  newOoklaPhase(res.Download, res.DownloadLatency),
+		Upload:    newOoklaPhase(res.Upload, res.UploadLatency),
+		ISP:       asnISP(res.Client.Asn),
+		Interface: ooklaInterface{ExternalIP: res.Client.IP},
+		Result:    ooklaResultInfo{ID: res.ID},
+	}
+	if srv, ok := bestServer(res); ok {
+		out.Server = ooklaServer{
+			Host:     targetHost(srv.Target),
+			Port:     443,
+			Name:     "Netflix",
+			Location: srv.Target.Location.City,
+			Country:  srv.Target.Location.Country,
+		}
+	}
+	return out
+}
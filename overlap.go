@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// selectServersEarly returns the first server to answer its ping, as soon as
+// it does, and the servers answering after it in turn. Those that did badly
+// in past runs come last, as with deprioritize. The pings go on in the
+// background until the selection times out.
+func selectServersEarly(opts *options) (clientInfo, pingedTarget, <-chan pingedTarget, error) {
+	ctx, cancel := opts.selectionContext()
+	client, candidates, err := fetchCandidates(opts)
+	if err != nil {
+		cancel()
+		return client, pingedTarget{}, nil, err
+	}
+
+	fmt.Fprintln(statusOut, lang.tr("Pinging servers to select the best ones..."))
+	pings := pingCandidates(ctx, candidates, cmp.Or(opts.PingTimeout, defaultPingTimeout), opts.PingWarmup)
+	answered := make(chan pingedTarget, len(candidates))
+	scores := loadServerScores(opts)
+	go func() {
+		defer cancel()
+		defer close(answered)
+		var bad []pingedTarget
+		for pt := range pings {
+			if pt.Err != nil {
+				log.Printf("Ping error for %s: %v\n", pt.Target.Name, pt.Err)
+				continue
+			}
+			if why := scores[targetHost(pt.Target)].Bad(); why != "" {
+				fmt.Fprintf(statusOut, "Deprioritizing %s: %s.\n", targetHost(pt.Target), why)
+				bad = append(bad, pt)
+				continue
+			}
+			answered <- pt
+		}
+		for _, pt := range bad {
+			answered <- pt
+		}
+	}()
+
+	first, ok := <-answered
+	if !ok {
+		return client, pingedTarget{}, nil, withCode(codeAllPingsFailed, errors.New("no servers responded to ping successfully"))
+	}
+	return client, first, answered, nil
+}
+
+// moreServers returns up to n of the servers that answered already, without
+// waiting for any other.
+func moreServers(answered <-chan pingedTarget, n int) []pingedTarget {
+	var servers []pingedTarget
+	for len(servers) < n {
+		select {
+		case pt, ok := <-answered:
+			if !ok {
+				return servers
+			}
+			servers = append(servers, pt)
+		default:
+			return servers
+		}
+	}
+	return servers
+}
+
+// pingAndProbe pings targets as measurePings does, and meanwhile probes each
+// server for rankServers as soon as it answers, one after the other, so that
+// ranking doesn't wait for the slowest ping before it starts. Those still
+// answering once ctx is done are left unprobed.
+func pingAndProbe(ctx context.Context, targets []target, timeout time.Duration, warmup bool) []pingedTarget {
+	fmt.Fprintf(statusOut, "Ranking servers by latency and a %s download from each as it answers...\n", rankProbeDuration)
+	var servers []pingedTarget
+	for pt := range pingCandidates(ctx, targets, timeout, warmup) {
+		if pt.Err != nil {
+			log.Printf("Ping error for %s: %v\n", pt.Target.Name, pt.Err)
+			continue
+		}
+		pt.ProbeMbps = probeServer(ctx, pt.Target)
+		servers = append(servers, pt)
+	}
+	slices.SortStableFunc(servers, func(a, b pingedTarget) int {
+		return cmp.Compare(a.Latency, b.Latency)
+	})
+	return servers
+}
+
+// overlaps tells whether the test starts on the first server to answer its
+// ping, see selectServersEarly. --pin-servers and --pcap need all the
+// servers before the test starts, and ranking by throughput needs every
+// ping when there are more candidates than streams, so those have the
+// servers selected first.
+func (o *options) overlaps() bool {
+	candidates := cmp.Or(len(o.Servers), o.Candidates, defaultURLCount)
+	return o.Overlap && o.PcapPath == "" && o.PinServers == "" &&
+		(o.Rank != rankCombined || candidates <= cmp.Or(o.Streams, numServersToTest))
+}
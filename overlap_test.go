@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestOverlaps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts options
+		want bool
+	}{
+		{"ranking by latency", options{Overlap: true, Rank: rankLatency}, true},
+		{"overlap=false", options{Overlap: false, Rank: rankLatency}, false},
+		{"combined among more candidates than streams", options{Overlap: true, Rank: rankCombined}, false},
+		{"combined with a candidate per stream", options{Overlap: true, Rank: rankCombined, Candidates: 3, Streams: 3}, true},
+		{"combined among servers given", options{Overlap: true, Rank: rankCombined, Servers: []string{"a", "b"}}, true},
+		{"pinned servers", options{Overlap: true, Rank: rankLatency, PinServers: "pins.json"}, false},
+		{"pcap", options{Overlap: true, Rank: rankLatency, PcapPath: "test.pcap"}, false},
+	} {
+		if got := tc.opts.overlaps(); got != tc.want {
+			t.Errorf("%s: overlaps %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "math/rand/v2"
+
+// Values of --upload-payload. Comparing them shows a middlebox that
+// compresses or dedupes uploads: zeros, or mixed chunks, go faster through
+// it than random data can.
+const (
+	payloadRandom = "random"
+	payloadZero   = "zero"
+	payloadMixed  = "mixed" // Half of every block random and half zeros, which compresses about 2:1
+)
+
+var uploadPayloads = []string{payloadRandom, payloadZero, payloadMixed}
+
+const mixedPayloadBlock = 4096
+
+// chunkFiller returns what fills the upload chunks of one stream with
+// payload. Random data comes from a ChaCha8 generator seeded from rng, just
+// as incompressible as crypto/rand at a fraction of its CPU.
+func chunkFiller(payload string, rng *rand.Rand) func([]byte) error {
+	if payload == payloadZero {
+		// Chunks start out zeroed, and nothing else fills them
+		return func([]byte) error { return nil }
+	}
+	chacha := rand.NewChaCha8(chachaSeed(rng))
+	if payload == payloadMixed {
+		return func(b []byte) error {
+			for len(b) > 0 {
+				block := b[:min(len(b), mixedPayloadBlock)]
+				half := len(block) / 2
+				chacha.Read(block[:half])
+				clear(block[half:])
+				b = b[len(block):]
+			}
+			return nil
+		}
+	}
+	return func(b []byte) error {
+		_, err := chacha.Read(b)
+		return err
+	}
+}
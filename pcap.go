@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	pcapSnapLen    = 128 // Enough for IP and TCP headers with options; payloads are random bytes
+	pcapLinkTypeIP = 101 // LINKTYPE_RAW: packets start at the IP header
+)
+
+// pcapWriter writes the classic libpcap file format that tcpdump and
+// Wireshark read.
+type pcapWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func createPcap(path string) (*pcapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating capture file: %w", err)
+	}
+	w := bufio.NewWriterSize(f, 256*1024)
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // Microsecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeIP)
+	w.Write(hdr[:])
+	return &pcapWriter{f: f, w: w}, nil
+}
+
+func (p *pcapWriter) writePacket(at time.Time, data []byte, origLen int) error {
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(origLen))
+	p.w.Write(hdr[:])
+	_, err := p.w.Write(data)
+	return err
+}
+
+func (p *pcapWriter) Close() error {
+	if err := p.w.Flush(); err != nil {
+		p.f.Close()
+		return err
+	}
+	return p.f.Close()
+}
+
+// packetMatches reports whether an IP packet is from or to one of ips.
+func packetMatches(pkt []byte, ips []net.IP) bool {
+	var src, dst net.IP
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		src, dst = pkt[12:16], pkt[16:20]
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		src, dst = pkt[8:24], pkt[24:40]
+	default:
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(src) || ip.Equal(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetIPs resolves the hosts of the test servers.
+func targetIPs(targets []target) []net.IP {
+	seen := map[string]bool{}
+	var ips []net.IP
+	for _, t := range targets {
+		host := targetHostname(t)
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			log.Printf("Warning: not capturing traffic to %s: %v", host, err)
+			continue
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips
+}
+
+// startPcap starts capturing the traffic to the test servers into path, and
+// returns the function that stops it. Capturing is a debugging aid: when it
+// can't be done, the test runs anyway.
+func startPcap(path string, targets []target) (stop func()) {
+	ips := targetIPs(targets)
+	if len(ips) == 0 {
+		return func() {}
+	}
+	w, err := createPcap(path)
+	if err != nil {
+		log.Printf("Warning: not capturing packets: %v", err)
+		return func() {}
+	}
+	capture, err := capturePackets(ips, w)
+	if err != nil {
+		w.Close()
+		os.Remove(path)
+		log.Printf("Warning: not capturing packets: %v", err)
+		return func() {}
+	}
+	fmt.Fprintf(statusOut, "Capturing test traffic to %s...\n", path)
+	return func() {
+		packets := capture()
+		if err := w.Close(); err != nil {
+			log.Printf("Warning: writing %s: %v", path, err)
+			return
+		}
+		fmt.Fprintf(statusOut, "Captured %d packets to %s\n", packets, path)
+	}
+}
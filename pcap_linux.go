@@ -0,0 +1,122 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	ethPAll         = 0x0003 // ETH_P_ALL
+	arphrdLoopback  = 772
+	packetOutgoing  = 4 // PACKET_OUTGOING
+	captureRecvWait = 200 * time.Millisecond
+	maxFilterIPv4   = 50 // Jump offsets are a byte
+)
+
+// captureFilter builds a classic BPF program that keeps IPv4 packets from or
+// to ips and every IPv6 packet, so that the kernel drops the bulk of other
+// traffic before it is copied. IPv6 addresses don't fit in one comparison and
+// are only matched by packetMatches.
+func captureFilter(ips []net.IP) []syscall.SockFilter {
+	type insn struct {
+		f      syscall.SockFilter
+		jt, jf string // Label to jump to, "" for the next instruction
+	}
+	var prog []insn
+	stmt := func(code, k int) { prog = append(prog, insn{f: *syscall.LsfStmt(code, k)}) }
+	jeq := func(k int, jt, jf string) {
+		prog = append(prog, insn{f: *syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, k, 0, 0), jt: jt, jf: jf})
+	}
+
+	stmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_ABS, 0) // IP version
+	stmt(syscall.BPF_ALU|syscall.BPF_AND|syscall.BPF_K, 0xf0)
+	jeq(0x60, "accept", "")
+	jeq(0x40, "", "drop")
+	for _, offset := range []int{12, 16} { // Source, then destination address
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, offset)
+		n := 0
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && n < maxFilterIPv4 {
+				jeq(int(binary.BigEndian.Uint32(ip4)), "accept", "")
+				n++
+			}
+		}
+	}
+	stmt(syscall.BPF_RET|syscall.BPF_K, 0)       // drop
+	stmt(syscall.BPF_RET|syscall.BPF_K, 0x40000) // accept, whole packet
+	labels := map[string]int{"drop": len(prog) - 2, "accept": len(prog) - 1}
+
+	out := make([]syscall.SockFilter, len(prog))
+	for i, in := range prog {
+		out[i] = in.f
+		if in.jt != "" {
+			out[i].Jt = uint8(labels[in.jt] - i - 1)
+		}
+		if in.jf != "" {
+			out[i].Jf = uint8(labels[in.jf] - i - 1)
+		}
+	}
+	return out
+}
+
+// capturePackets records the packets from or to ips on every interface with
+// a packet socket, and returns the function that stops capturing and
+// returns the number of packets written.
+func capturePackets(ips []net.IP, w *pcapWriter) (stop func() int, err error) {
+	var proto [2]byte
+	binary.BigEndian.PutUint16(proto[:], ethPAll)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, int(binary.NativeEndian.Uint16(proto[:])))
+	if errors.Is(err, syscall.EPERM) {
+		return nil, fmt.Errorf("capturing needs root or CAP_NET_RAW")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening packet socket: %w", err)
+	}
+	if err := syscall.AttachLsf(fd, captureFilter(ips)); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("attaching capture filter: %w", err)
+	}
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 8<<20)
+	timeout := syscall.NsecToTimeval(int64(captureRecvWait))
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+
+	done := make(chan struct{})
+	finished := make(chan int)
+	go func() {
+		buf := make([]byte, pcapSnapLen)
+		packets := 0
+		for {
+			select {
+			case <-done:
+				finished <- packets
+				return
+			default:
+			}
+			// MSG_TRUNC returns the full length even though only the snap length is copied
+			n, from, err := syscall.Recvfrom(fd, buf, syscall.MSG_TRUNC)
+			if err != nil {
+				continue // Timeouts, to check done
+			}
+			// Loopback packets show up both leaving and arriving, keep one copy
+			if ll, ok := from.(*syscall.SockaddrLinklayer); ok && ll.Hatype == arphrdLoopback && ll.Pkttype == packetOutgoing {
+				continue
+			}
+			pkt := buf[:min(n, len(buf))]
+			if packetMatches(pkt, ips) && w.writePacket(time.Now(), pkt, n) == nil {
+				packets++
+			}
+		}
+	}()
+	return func() int {
+		close(done)
+		packets := <-finished
+		syscall.Close(fd)
+		return packets
+	}, nil
+}
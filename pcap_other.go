@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// capturePackets needs Linux's packet sockets; elsewhere the test runs
+// without a capture.
+func capturePackets(ips []net.IP, w *pcapWriter) (stop func() int, err error) {
+	return nil, fmt.Errorf("packet capture is only available on Linux, use tcpdump or Wireshark instead")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// pinKey is what identifies a pinned server across runs: its host and path,
+// as fast.com hands out the same servers under new tokens every time. The
+// mock's servers go by their path alone, the mock listening on a new port
+// every run.
+func pinKey(t target) string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return t.URL
+	}
+	if mockServer != nil && strings.HasPrefix(t.URL, mockServer.url+"/") {
+		return u.Path
+	}
+	return u.Host + u.Path
+}
+
+// readPins returns the servers pinned in path, nil if it doesn't exist yet.
+func readPins(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading pinned servers: %w", err)
+	}
+	defer f.Close()
+	var pins []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			pins = append(pins, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading pinned servers: %w", err)
+	}
+	if len(pins) == 0 {
+		return nil, fmt.Errorf("no servers pinned in %s", path)
+	}
+	return pins, nil
+}
+
+// writePins pins servers in path unless it holds pins already.
+func writePins(path string, servers []pingedTarget) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(f, "# Servers of fast-cli --pin-servers, in stream order. Delete this file to pick others.")
+	for _, pt := range servers {
+		fmt.Fprintln(f, pinKey(pt.Target))
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(statusOut, "Pinned these servers in %s.\n", path)
+	return nil
+}
+
+// selectPinned returns the pinned servers among candidates, in the order
+// they were pinned, which is the order the streams go to them, with their
+// ping. Unlike a test choosing among the servers that answer, it fails as
+// soon as one of them isn't offered or doesn't answer, which would make the
+// run incomparable with the others.
+func selectPinned(ctx context.Context, opts *options, candidates []target, pins []string) ([]pingedTarget, error) {
+	var targets []target
+	for _, pin := range pins {
+		i := slices.IndexFunc(candidates, func(t target) bool { return pinKey(t) == pin })
+		if i < 0 {
+			return nil, withCode(codeNoServers, fmt.Errorf("pinned server %s isn't offered any more, delete %s to pin others", pin, opts.PinServers))
+		}
+		targets = append(targets, candidates[i])
+	}
+
+	fmt.Fprintf(statusOut, "Pinging the servers pinned in %s...\n", opts.PinServers)
+	pinged := measurePings(ctx, targets, cmp.Or(opts.PingTimeout, defaultPingTimeout), opts.PingWarmup)
+	if len(pinged) < len(targets) {
+		return nil, withCode(codeAllPingsFailed, fmt.Errorf("%d of the %d pinned servers didn't answer their ping", len(targets)-len(pinged), len(targets)))
+	}
+	slices.SortFunc(pinged, func(a, b pingedTarget) int {
+		return slices.Index(pins, pinKey(a.Target)) - slices.Index(pins, pinKey(b.Target))
+	})
+	return pinged, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// With --plain the output suits screen readers and logs captured by a
+// supervisor: no colors, no block characters, and a line of progress every
+// plainProgressInterval of a phase instead of silence until it ends.
+const plainProgressInterval = 5 * time.Second
+
+// plainOutput is set by --plain
+var plainOutput bool
+
+// reportProgress prints a line about a phase of length every limits.Progress
+// while ctx lasts, if that is positive: the speed so far for people, or with
+// ProgressLog a timestamped line of the bytes so far and the speed since the
+// last one, for logs.
+func reportProgress(ctx context.Context, counter *int64, phase string, length time.Duration, limits transferLimits) {
+	if limits.Progress <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(limits.Progress)
+		defer ticker.Stop()
+		start := time.Now()
+		last, lastAt := int64(0), start
+		for {
+			var now time.Time
+			select {
+			case <-ctx.Done():
+				return
+			case now = <-ticker.C:
+			}
+			bytes := atomic.LoadInt64(counter)
+			if limits.ProgressLog {
+				fmt.Fprintf(statusOut, "%s phase=%s elapsed=%s bytes=%d mbps=%.1f\n",
+					now.Format(time.RFC3339), strings.ToLower(phase), now.Sub(start).Round(time.Second), bytes, toMbps(bytes-last, now.Sub(lastAt)))
+			} else {
+				elapsed := now.Sub(start)
+				lang.fprintf(statusOut, "%s: %s of %s, %.1f Mbps so far\n", lang.tr(phase), elapsed.Round(time.Second), length, toMbps(bytes, elapsed))
+			}
+			last, lastAt = bytes, now
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// Share of the advertised plan needed for each rating
+	planPassRatio = 0.9
+	planWarnRatio = 0.8
+
+	// "Evening" is when congestion typically shows up on residential lines
+	eveningStartHour  = 18
+	eveningEndHour    = 24
+	planTrendMaxTests = 14 // Number of most recent evening tests the trend looks at
+)
+
+// ANSI colors, only emitted when stdout is a terminal, NO_COLOR is unset and
+// --plain isn't given
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+func colorEnabled() bool {
+	if plainOutput || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(color, text string) string {
+	if !colorEnabled() {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// planRating classifies a measured speed against the advertised one.
+func planRating(measured, advertised float64) string {
+	ratio := measured / advertised
+	switch {
+	case ratio >= planPassRatio:
+		return colorize(colorGreen, "PASS")
+	case ratio >= planWarnRatio:
+		return colorize(colorYellow, "WARN")
+	default:
+		return colorize(colorRed, "FAIL")
+	}
+}
+
+func isEvening(t time.Time) bool {
+	hour := t.Local().Hour()
+	return hour >= eveningStartHour && hour < eveningEndHour
+}
+
+// planTrend counts how many of the most recent evening tests fell below the
+// warn threshold of the plan's download speed.
+func planTrend(entries []historyEntry, p plan) (below, total int) {
+	for i := len(entries) - 1; i >= 0 && total < planTrendMaxTests; i-- {
+		if !isEvening(entries[i].Time) {
+			continue
+		}
+		total++
+		if entries[i].DownloadMbps < p.DownloadMbps*planWarnRatio {
+			below++
+		}
+	}
+	return below, total
+}
+
+func printPlanComparison(res testResult, p plan, entries []historyEntry, precision int) {
+	fmt.Printf("\n--- Plan Comparison (%s Mbps) ---\n", p)
+	fmt.Printf("Download: %.*f of %g Mbps (%.0f%%) %s\n",
+		precision, res.Download.Mbps, p.DownloadMbps, 100*res.Download.Mbps/p.DownloadMbps, planRating(res.Download.Mbps, p.DownloadMbps))
+	if p.UploadMbps > 0 {
+		fmt.Printf("Upload:   %.*f of %g Mbps (%.0f%%) %s\n",
+			precision, res.Upload.Mbps, p.UploadMbps, 100*res.Upload.Mbps/p.UploadMbps, planRating(res.Upload.Mbps, p.UploadMbps))
+	}
+
+	if below, total := planTrend(entries, p); total > 0 {
+		fmt.Printf("You've achieved <%.0f%% of plan in %d of the last %d evening tests.\n", 100*planWarnRatio, below, total)
+	}
+}
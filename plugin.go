@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const pluginName = "fast_cli"
+
+// pluginMetric is one value exported to collectd/netdata, taken from the
+// flattened history entry so every protocol reports the same numbers.
+type pluginMetric struct {
+	Chart string // netdata chart the dimension belongs to
+	Name  string
+	Value func(e historyEntry) float64
+}
+
+var pluginMetrics = []pluginMetric{
+	{"speed", "download_mbps", func(e historyEntry) float64 { return e.DownloadMbps }},
+	{"speed", "upload_mbps", func(e historyEntry) float64 { return e.UploadMbps }},
+	{"latency", "latency_ms", func(e historyEntry) float64 { return e.LatencyMs }},
+	{"latency", "jitter_ms", func(e historyEntry) float64 { return e.JitterMs }},
+	{"latency", "download_latency_ms", func(e historyEntry) float64 { return e.DownloadLatencyMs }},
+	{"latency", "upload_latency_ms", func(e historyEntry) float64 { return e.UploadLatencyMs }},
+	{"responsiveness", "rpm", func(e historyEntry) float64 { return e.RPM }},
+}
+
+// netdataCharts describes the charts in the order they are defined.
+var netdataCharts = []struct {
+	ID, Title, Units string
+}{
+	{"speed", "Internet speed", "Mbps"},
+	{"latency", "Internet latency", "ms"},
+	{"responsiveness", "Responsiveness under load", "RPM"},
+}
+
+// collectdHostname follows the collectd exec plugin convention of passing
+// the hostname through the environment.
+func collectdHostname() string {
+	if h := os.Getenv("COLLECTD_HOSTNAME"); h != "" {
+		return h
+	}
+	h, _ := os.Hostname()
+	return h
+}
+
+// writeCollectd prints one PUTVAL line per metric, as the collectd exec
+// plugin expects. interval is omitted from the lines when zero.
+func writeCollectd(w io.Writer, e historyEntry, interval time.Duration) error {
+	host := collectdHostname()
+	options := ""
+	if interval > 0 {
+		options = fmt.Sprintf(" interval=%d", int(interval.Seconds()))
+	}
+	for _, m := range pluginMetrics {
+		if _, err := fmt.Fprintf(w, "PUTVAL \"%s/%s/gauge-%s\"%s %d:%s\n",
+			host, pluginName, m.Name, options, e.Time.Unix(), strconv.FormatFloat(m.Value(e), 'f', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectdInterval reads COLLECTD_INTERVAL (seconds) if collectd set it.
+func collectdInterval() time.Duration {
+	secs, err := strconv.ParseFloat(os.Getenv("COLLECTD_INTERVAL"), 64)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// writeNetdataCharts declares the charts and their dimensions. Values are
+// sent multiplied by 1000 because the protocol only carries integers.
+func writeNetdataCharts(w io.Writer, updateEvery time.Duration) {
+	for i, c := range netdataCharts {
+		fmt.Fprintf(w, "CHART %s.%s '' '%s' '%s' %s %s.%s line %d %d\n",
+			pluginName, c.ID, c.Title, c.Units, c.ID, pluginName, c.ID, 90000+i, int(updateEvery.Seconds()))
+		for _, m := range pluginMetrics {
+			if m.Chart == c.ID {
+				fmt.Fprintf(w, "DIMENSION %s '' absolute 1 1000\n", m.Name)
+			}
+		}
+	}
+}
+
+func writeNetdataValues(w io.Writer, e historyEntry, sinceLast time.Duration) {
+	for _, c := range netdataCharts {
+		if sinceLast > 0 {
+			fmt.Fprintf(w, "BEGIN %s.%s %d\n", pluginName, c.ID, sinceLast.Microseconds())
+		} else {
+			fmt.Fprintf(w, "BEGIN %s.%s\n", pluginName, c.ID)
+		}
+		for _, m := range pluginMetrics {
+			if m.Chart == c.ID {
+				fmt.Fprintf(w, "SET %s = %d\n", m.Name, int64(m.Value(e)*1000))
+			}
+		}
+		fmt.Fprintln(w, "END")
+	}
+}
+
+func newPluginFlagSet(opts *options, protocol *string) *flag.FlagSet {
+	fs := newRunFlagSet("fast-cli plugin", opts)
+	fs.StringVar(protocol, "protocol", "netdata", "plugin `protocol`: netdata or collectd")
+	fs.DurationVar(&opts.Interval, "interval", time.Hour, "time between speed tests")
+	return fs
+}
+
+// runPlugin implements `fast-cli plugin`: a long-running process that
+// collectd (exec plugin) or netdata (external plugin) can register directly.
+// netdata passes update_every in seconds as the only positional argument.
+func runPlugin(args []string) error {
+	opts := &options{}
+	protocol := new(string)
+	fs := newPluginFlagSet(opts, protocol)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		secs, err := strconv.Atoi(fs.Arg(0))
+		if err != nil || secs <= 0 {
+			return fmt.Errorf("invalid update interval %q, expected seconds", fs.Arg(0))
+		}
+		opts.Interval = time.Duration(secs) * time.Second
+	}
+	if opts.Interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	if err := opts.validateTest(); err != nil {
+		return err
+	}
+	if err := singleProvider(opts.Provider, "plugin"); err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return dryRun(opts)
+	}
+	switch *protocol {
+	case "netdata", "collectd":
+	default:
+		return fmt.Errorf("unknown plugin protocol %q, expected netdata or collectd", *protocol)
+	}
+
+	// Both protocols own stdout, everything else goes to their log via stderr
+	statusOut = os.Stderr
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *protocol == "netdata" {
+		writeNetdataCharts(os.Stdout, opts.Interval)
+	}
+	var lastSent time.Time
+	for {
+		res, err := runSpeedTest(opts)
+		if err != nil {
+			log.Printf("Speed test failed: %v", err)
+		} else {
+			recordHistory(opts, res)
+			entry := newHistoryEntry(res)
+			if *protocol == "netdata" {
+				var sinceLast time.Duration
+				if !lastSent.IsZero() {
+					sinceLast = time.Since(lastSent)
+				}
+				writeNetdataValues(os.Stdout, entry, sinceLast)
+				lastSent = time.Now()
+			} else if err := writeCollectd(os.Stdout, entry, opts.Interval); err != nil {
+				return fmt.Errorf("writing to collectd: %w", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(opts.Interval):
+		}
+	}
+}
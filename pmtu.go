@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+const (
+	ethernetMTU    = 1500 // What a path without tunnels or PPPoE carries
+	ipv4UDPHeaders = 28   // IPv4 + UDP header bytes
+	ipv4TCPHeaders = 40   // IPv4 + TCP header bytes
+	tcpTimestamps  = 12   // Option bytes Linux leaves out of the MSS it reports
+)
+
+// pathMTU is what probePathMTU found out about the path to a server.
+type pathMTU struct {
+	InterfaceMTU int  // MTU of the local route, where discovery starts
+	MTU          int  // Largest packet that gets through without fragmenting
+	Confirmed    bool // The destination answered a probe of MTU bytes
+	TCPMSS       int  // Maximum segment size negotiated on a TCP connection, zero if unknown
+}
+
+// String explains the result, naming the usual suspects when it is low.
+func (p pathMTU) String() string {
+	s := fmt.Sprintf("%d bytes", p.MTU)
+	if !p.Confirmed {
+		s += " (unconfirmed, the server didn't answer probes)"
+	}
+	switch {
+	case p.MTU < p.InterfaceMTU:
+		s += fmt.Sprintf(", below the local interface's %d: a tunnel or PPPoE link along the path", p.InterfaceMTU)
+	case p.MTU < ethernetMTU:
+		s += fmt.Sprintf(", below Ethernet's %d: PPPoE or a VPN", ethernetMTU)
+	}
+	if p.TCPMSS > 0 {
+		s += fmt.Sprintf("; TCP MSS %d", p.TCPMSS)
+		// Only meaningful up to Ethernet size, beyond that the server's own MSS is the limit
+		if p.TCPMSS+ipv4TCPHeaders+tcpTimestamps < min(p.MTU, ethernetMTU) {
+			s += " (clamped by a router)"
+		}
+	}
+	return s
+}
+
+// probeTargetMTU probes the path MTU toward a test server.
+func probeTargetMTU(t target) (pathMTU, error) {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return pathMTU{}, fmt.Errorf("parsing server URL: %w", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return probePathMTU(u.Hostname(), port)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,116 @@
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	pmtuProbePort    = 33434 // traceroute's base port, nothing listens there
+	pmtuProbeRounds  = 8
+	pmtuReplyTimeout = 300 * time.Millisecond
+	maxUDPPayload    = 65507
+)
+
+// probePathMTU finds the path MTU toward host the way tracepath does: UDP
+// datagrams with the don't-fragment bit, as large as the kernel's current
+// path MTU estimate allows. A router with a smaller MTU answers with ICMP
+// "fragmentation needed", which lowers the kernel's estimate for the next
+// round. The destination answering "port unreachable" confirms that a
+// datagram of that size made it all the way. The TCP MSS is read from a
+// connection to tcpPort.
+func probePathMTU(host, tcpPort string) (pathMTU, error) {
+	var res pathMTU
+	raddr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, fmt.Sprint(pmtuProbePort)))
+	if err != nil {
+		return res, fmt.Errorf("resolving %s: %w", host, err)
+	}
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return res, fmt.Errorf("opening probe socket: %w", err)
+	}
+	defer conn.Close()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return res, err
+	}
+	sockopt := func(set bool, level, opt, value int) (int, error) {
+		var sockErr error
+		err := raw.Control(func(fd uintptr) {
+			if set {
+				sockErr = syscall.SetsockoptInt(int(fd), level, opt, value)
+			} else {
+				value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+			}
+		})
+		return value, cmp.Or(err, sockErr)
+	}
+	if _, err := sockopt(true, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO); err != nil {
+		return res, fmt.Errorf("setting don't-fragment: %w", err)
+	}
+
+	mtu, err := sockopt(false, syscall.IPPROTO_IP, syscall.IP_MTU, 0)
+	if err != nil {
+		return res, fmt.Errorf("reading route MTU: %w", err)
+	}
+	res.InterfaceMTU = mtu
+	buf := make([]byte, 64*1024)
+	for range pmtuProbeRounds {
+		_, err := conn.Write(make([]byte, min(mtu-ipv4UDPHeaders, maxUDPPayload)))
+		if err == nil {
+			conn.SetReadDeadline(time.Now().Add(pmtuReplyTimeout))
+			_, err = conn.Read(buf)
+		}
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			res.MTU, res.Confirmed = mtu, true // Port unreachable from the destination itself
+		case errors.Is(err, syscall.EMSGSIZE):
+			// A router asked for smaller packets, the new estimate is below
+		case err != nil && !errors.Is(err, syscall.EHOSTUNREACH) && !isTimeout(err):
+			return res, fmt.Errorf("sending probe: %w", err)
+		}
+		next, err := sockopt(false, syscall.IPPROTO_IP, syscall.IP_MTU, 0)
+		if err != nil {
+			return res, fmt.Errorf("reading path MTU: %w", err)
+		}
+		if res.Confirmed || next == mtu && res.MTU == mtu {
+			break
+		}
+		res.MTU, mtu = next, next
+	}
+	if res.MTU == 0 {
+		res.MTU = mtu
+	}
+
+	res.TCPMSS, err = tcpMSS(host, tcpPort)
+	return res, err
+}
+
+// tcpMSS connects to host and reads the negotiated maximum segment size,
+// which routers that clamp MSS (common with PPPoE) lower.
+func tcpMSS(host, port string) (int, error) {
+	conn, err := net.DialTimeout("tcp4", net.JoinHostPort(host, port), connectivityTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("connecting for MSS: %w", err)
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var mss int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		mss, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG)
+	})
+	if err := cmp.Or(err, sockErr); err != nil {
+		return 0, fmt.Errorf("reading MSS: %w", err)
+	}
+	return mss, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// probePathMTU needs Linux's IP_MTU socket option to read the kernel's path
+// MTU estimate.
+func probePathMTU(host, tcpPort string) (pathMTU, error) {
+	return pathMTU{}, fmt.Errorf("path MTU discovery is only available on Linux")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+const pprofHelp = "serve net/http/pprof and expvar under /debug/ on this `address`, e.g. localhost:6060, to diagnose CPU or memory trouble; keep it off public interfaces"
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("daemon", expvar.Func(func() any {
+		m := selfMetrics
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return map[string]any{"runs": m.runs, "succeeded": m.succeeded, "sink_failures": maps.Clone(m.sinkFailures)}
+	}))
+}
+
+// servePprof serves the profiles of net/http/pprof at /debug/pprof/ and the
+// expvar variables, memstats among them, at /debug/vars on addr until ctx is
+// done. Its own mux keeps them off every other server.
+func servePprof(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening for --pprof: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	// No write timeout: /debug/pprof/profile streams for its ?seconds=
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("Serving profiles on http://%s/debug/pprof/", ln.Addr())
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Warning: serving --pprof: %v", err)
+		}
+	}()
+	return nil
+}
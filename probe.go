@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes of `fast-cli probe`, so healthchecks and CronJobs can tell a
+// slow link from a dead one
+const (
+	probeExitHealthy  = 0
+	probeExitDegraded = 1 // A threshold was not met
+	probeExitUsage    = 2 // Invalid flags, same as the main command
+	probeExitFailed   = 3 // No test could be run at all, e.g. no connectivity
+	probeExitTimeout  = 4 // The wall-clock budget ran out
+
+	// Time reserved for the server list, server selection and idle latency
+	probeSetupBudget = 5 * time.Second
+	probeMinPhase    = 2 * time.Second
+)
+
+// probePhases splits the budget left after setup between download and, if
+// it is checked, upload, never exceeding the normal phase lengths.
+func probePhases(timeout time.Duration, withUpload bool) (download, upload time.Duration, err error) {
+	available := timeout - probeSetupBudget
+	phases := time.Duration(1)
+	if withUpload {
+		phases = 2
+	}
+	perPhase := available / phases
+	if perPhase < probeMinPhase {
+		return 0, 0, fmt.Errorf("--timeout must be at least %s", probeSetupBudget+phases*probeMinPhase)
+	}
+	return min(perPhase, downloadTestDuration), min(perPhase, uploadTestDuration), nil
+}
+
+type probeFlags struct {
+	Timeout time.Duration
+	Verbose bool
+}
+
+// newProbeFlagSet registers the probe flags, which are deliberately few:
+// the test shape is derived from the time budget.
+func newProbeFlagSet(opts *options, f *probeFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli probe", flag.ContinueOnError)
+	opts.Thresholds.register(fs)
+	fs.DurationVar(&f.Timeout, "timeout", 30*time.Second, "hard wall-clock budget for the whole probe")
+	fs.Func("history", "record the result to this `file` (not recorded by default)", func(path string) error {
+		opts.HistoryPath, opts.NoHistory = path, false
+		return nil
+	})
+	fs.BoolVar(&f.Verbose, "verbose", false, "print progress to stderr")
+	return fs
+}
+
+// runProbe implements `fast-cli probe`: a short test with a hard wall-clock
+// budget that prints a single line and reports health via its exit code.
+// Upload is only measured when --min-upload is given, and nothing is
+// recorded to history unless --history is, as probes run far more often
+// than regular tests.
+func runProbe(args []string) error {
+	opts := &options{NoHistory: true}
+	var f probeFlags
+	fs := newProbeFlagSet(opts, &f)
+	if err := parseFlags(fs, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		os.Exit(probeExitUsage)
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		os.Exit(probeExitUsage)
+	}
+
+	var err error
+	opts.SkipUpload = opts.Thresholds.MinUpload <= 0
+	opts.DownloadDuration, opts.UploadDuration, err = probePhases(f.Timeout, !opts.SkipUpload)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(probeExitUsage)
+	}
+
+	statusOut = io.Discard
+	if f.Verbose {
+		statusOut = os.Stderr
+	}
+
+	type outcome struct {
+		res testResult
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := runSpeedTest(opts)
+		done <- outcome{res, err}
+	}()
+
+	// In-flight requests are not waited for: exiting is the hard budget
+	select {
+	case <-time.After(f.Timeout):
+		fmt.Printf("TIMEOUT probe did not finish within %s\n", f.Timeout)
+		os.Exit(probeExitTimeout)
+	case o := <-done:
+		if o.err != nil {
+			fmt.Printf("FAIL %v\n", o.err)
+			os.Exit(probeExitFailed)
+		}
+		recordHistory(opts, o.res)
+
+		summary := fmt.Sprintf("download=%.2fMbps", o.res.Download.Mbps)
+		if !opts.SkipUpload {
+			summary += fmt.Sprintf(" upload=%.2fMbps", o.res.Upload.Mbps)
+		}
+		if lat := o.res.IdleLatency; len(lat.Samples) > 0 {
+			summary += fmt.Sprintf(" latency=%s jitter=%s", lat.Avg.Round(time.Millisecond), lat.Jitter.Round(time.Millisecond))
+		}
+
+		if failures := opts.Thresholds.check(o.res); len(failures) > 0 {
+			fmt.Printf("DEGRADED %s (%s)\n", summary, strings.Join(failures, ", "))
+			os.Exit(probeExitDegraded)
+		}
+		fmt.Printf("OK %s\n", summary)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promMetric is one gauge of the --prom-textfile output.
+type promMetric struct {
+	Name, Help string
+	Value      float64
+}
+
+// promMetrics returns the gauges of a run, only fastcli_last_run_success and
+// the timestamp when it failed, so that stale speeds don't linger as current.
+func promMetrics(res testResult, failed bool) []promMetric {
+	if failed {
+		started := res.StartedAt
+		if started.IsZero() {
+			started = time.Now()
+		}
+		return []promMetric{
+			{"fastcli_last_run_success", "Whether the last test succeeded", 0},
+			{"fastcli_last_run_timestamp_seconds", "When the last test started", float64(started.Unix())},
+		}
+	}
+	metrics := []promMetric{
+		{"fastcli_last_run_success", "Whether the last test succeeded", 1},
+		{"fastcli_last_run_timestamp_seconds", "When the last test started", float64(res.StartedAt.Unix())},
+		{"fastcli_download_bits_per_second", "Download speed", float64(toBps(res.Download.Mbps))},
+		{"fastcli_download_bytes", "Bytes received in the download phase", float64(res.Download.Bytes)},
+	}
+	if res.Upload.Bytes > 0 {
+		metrics = append(metrics,
+			promMetric{"fastcli_upload_bits_per_second", "Upload speed", float64(toBps(res.Upload.Mbps))},
+			promMetric{"fastcli_upload_bytes", "Bytes sent in the upload phase", float64(res.Upload.Bytes)},
+		)
+	}
+	if len(res.IdleLatency.Samples) > 0 {
+		metrics = append(metrics,
+			promMetric{"fastcli_idle_latency_seconds", "Mean round trip time with the link idle", res.IdleLatency.Avg.Seconds()},
+			promMetric{"fastcli_idle_jitter_seconds", "Mean change between consecutive idle pings", res.IdleLatency.Jitter.Seconds()},
+		)
+	}
+	if len(res.DownloadLatency.Samples) > 0 {
+		metrics = append(metrics, promMetric{"fastcli_download_latency_seconds", "Mean round trip time while downloading", res.DownloadLatency.Avg.Seconds()})
+	}
+	if len(res.UploadLatency.Samples) > 0 {
+		metrics = append(metrics, promMetric{"fastcli_upload_latency_seconds", "Mean round trip time while uploading", res.UploadLatency.Avg.Seconds()})
+	}
+	if rpm := responsivenessRPM(res.LoadedLatencySamples()); rpm > 0 {
+		metrics = append(metrics, promMetric{"fastcli_responsiveness_rpm", "Round trips per minute under load", rpm})
+	}
+	var degraded float64
+	if res.Status() == "degraded" {
+		degraded = 1
+	}
+	return append(metrics, promMetric{"fastcli_degraded", "Whether streams failed during the test", degraded})
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatOpenMetrics renders the metrics in the OpenMetrics text format, each
+// labelled with the provider and the --tag labels. There are no sample
+// timestamps, which node_exporter's textfile collector refuses.
+func formatOpenMetrics(metrics []promMetric, provider string, tags resultTags) string {
+	var b strings.Builder
+	writeOpenMetrics(&b, metrics, provider, tags)
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// writeOpenMetrics writes the metrics without the closing # EOF, for others
+// to follow.
+func writeOpenMetrics(b *strings.Builder, metrics []promMetric, provider string, tags resultTags) {
+	labels := fmt.Sprintf(`provider="%s"`, promLabelEscaper.Replace(provider))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		labels += fmt.Sprintf(`,%s="%s"`, k, promLabelEscaper.Replace(tags[k]))
+	}
+	for _, m := range metrics {
+		writeFamily(b, m.Name, "gauge", m.Help, promSample{"{" + labels + "}", m.Value})
+	}
+}
+
+func formatPromValue(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+// writePromTextfile replaces the --prom-textfile with the metrics of the run atomically, so
+// that node_exporter never reads a half-written file. The temporary file
+// doesn't end in .prom, which keeps the collector from picking it up.
+func writePromTextfile(opts *options, res testResult, failed bool) error {
+	path := opts.PromTextfile
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	tmp.Chmod(0o644)            // node_exporter usually runs as another user
+
+	if _, err := tmp.WriteString(formatOpenMetrics(promMetrics(res, failed), cmp.Or(res.Provider, opts.Provider), res.Tags)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing metrics file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing metrics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing metrics file: %w", err)
+	}
+	return nil
+}
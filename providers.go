@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Test backends accepted by --provider
+const (
+	providerFast       = "fast"
+	providerCloudflare = "cloudflare"
+	providerLibreSpeed = "librespeed"
+	providerAll        = "all" // Every provider, back to back
+)
+
+var providerNames = []string{providerFast, providerCloudflare, providerLibreSpeed}
+
+const (
+	cloudflareBaseURL    = "https://speed.cloudflare.com"
+	libreSpeedServerList = "https://librespeed.org/backend-servers/servers.php"
+	providerListMaxBytes = 4 << 20
+)
+
+// providerTitle is how results label a provider.
+func providerTitle(name string) string {
+	switch name {
+	case providerCloudflare:
+		return "Cloudflare"
+	case providerLibreSpeed:
+		return "LibreSpeed"
+	case providerMock:
+		return "mock fast.com"
+	case providerSimulated:
+		return "Simulated"
+	default:
+		return "fast.com"
+	}
+}
+
+// parseProviders expands a --provider value: one name, a comma-separated
+// list, or "all".
+func parseProviders(value string) ([]string, error) {
+	switch value {
+	case "":
+		return []string{providerFast}, nil
+	case providerAll:
+		return providerNames, nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(providerNames, name) && name != providerMock {
+			return nil, fmt.Errorf("unknown provider %q, expected %s, %s or all", name, strings.Join(providerNames, ", "), providerMock)
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// singleProvider rejects a --provider comparison for commands that can only
+// test one provider per run.
+func singleProvider(value, command string) error {
+	if providers, _ := parseProviders(value); len(providers) > 1 {
+		return fmt.Errorf("%s tests a single provider, --provider %s compares several", command, value)
+	}
+	return nil
+}
+
+// The URLs to ping, download from and upload to differ per backend. fast.com
+// and fast-cli serve take byte ranges under /speedtest, Cloudflare takes a
+// byte count and LibreSpeed a size in MiB.
+
+func (t target) pingURL() string {
+	switch t.Provider {
+	case providerCloudflare:
+		return t.URL + "/__down?bytes=0"
+	case providerLibreSpeed:
+		return t.URL + "empty.php"
+	default:
+		return modifySpeedtestURL(t.URL, "/range/0-0")
+	}
+}
+
+func (t target) downloadURL(size int) string { return t.rangeURL(0, size) }
+
+// rangeURL asks for size bytes from offset. Cloudflare and LibreSpeed take
+// only a size, and send generated data no cache could hold anyway.
+func (t target) rangeURL(offset, size int) string {
+	switch t.Provider {
+	case providerCloudflare:
+		return t.URL + "/__down?bytes=" + strconv.Itoa(size)
+	case providerLibreSpeed:
+		return t.URL + "garbage.php?ckSize=" + strconv.Itoa(int(math.Ceil(float64(size)/(1<<20))))
+	default:
+		return modifySpeedtestURL(t.URL, "/range/"+strconv.Itoa(offset)+"-"+strconv.Itoa(offset+size-1)) // range is 0-indexed
+	}
+}
+
+// randomRange picks the range of a download chunk of about chunkSize: from
+// half of it to all, at a random multiple of integritySampleStride that keeps
+// it within the first chunkSize bytes, so that a cache that kept the last
+// range seldom holds the next one, and no request reaches beyond the ones
+// fast.com makes.
+func randomRange(rng *rand.Rand, chunkSize int) (offset, size int) {
+	size = chunkSize/2 + rng.IntN(chunkSize-chunkSize/2+1)
+	if slots := (chunkSize - size) / integritySampleStride; slots > 0 {
+		offset = rng.IntN(slots+1) * integritySampleStride
+	}
+	return offset, size
+}
+
+func (t target) uploadURL() string {
+	switch t.Provider {
+	case providerCloudflare:
+		return t.URL + "/__up"
+	case providerLibreSpeed:
+		return t.URL + "empty.php"
+	default:
+		return t.URL
+	}
+}
+
+// fetchProviderServers returns the client info and candidate servers of a
+// provider, in the shape of fast.com's API response, asking for as many
+// candidates as the provider lets choose from.
+func fetchProviderServers(client doer, name string, streams, candidates int) (*apiResponse, error) {
+	switch name {
+	case providerCloudflare:
+		return fetchCloudflareServers(client, streams)
+	case providerLibreSpeed:
+		return fetchLibreSpeedServers(client)
+	case providerMock:
+		return fetchMockServers(client, max(streams, candidates))
+	default:
+		return fetchTestServers(client, max(streams, candidates))
+	}
+}
+
+func getProviderJSON(client doer, url string, v any) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return withCode(codeAPIUnreachable, err)
+	}
+	defer resp.Body.Close()
+	clockCheck.observe(resp, sent)
+	if resp.StatusCode != http.StatusOK {
+		return withCode(apiStatusCode(resp.StatusCode), fmt.Errorf("%s returned status %d", url, resp.StatusCode))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, providerListMaxBytes)).Decode(v); err != nil {
+		return withCode(codeAPIError, fmt.Errorf("decoding %s: %w", url, err))
+	}
+	return nil
+}
+
+// fetchCloudflareServers reads the client's details from /meta. Cloudflare
+// has one anycast endpoint, so parallel streams all go to it.
+func fetchCloudflareServers(client doer, streams int) (*apiResponse, error) {
+	var meta struct {
+		ClientIP string `json:"clientIp"`
+		ASN      int    `json:"asn"`
+		City     string `json:"city"`
+		Country  string `json:"country"`
+		Colo     string `json:"colo"` // Airport code of the data center answering
+	}
+	if err := getProviderJSON(client, cloudflareBaseURL+"/meta", &meta); err != nil {
+		return nil, fmt.Errorf("fetching Cloudflare metadata: %w", err)
+	}
+	resp := &apiResponse{Client: clientInfo{IP: meta.ClientIP, Location: location{City: meta.City, Country: meta.Country}}}
+	if meta.ASN != 0 {
+		resp.Client.Asn = strconv.Itoa(meta.ASN)
+	}
+	for range max(streams, 1) {
+		resp.Targets = append(resp.Targets, target{
+			Name:     "speed.cloudflare.com",
+			URL:      cloudflareBaseURL,
+			Location: location{City: meta.Colo},
+			Provider: providerCloudflare,
+		})
+	}
+	return resp, nil
+}
+
+// fetchLibreSpeedServers reads the public LibreSpeed server list. Servers
+// are listed with protocol-relative URLs and use the standard backend file
+// names. The list doesn't identify the client.
+func fetchLibreSpeedServers(client doer) (*apiResponse, error) {
+	var servers []struct {
+		Name   string `json:"name"`
+		Server string `json:"server"`
+	}
+	if err := getProviderJSON(client, libreSpeedServerList, &servers); err != nil {
+		return nil, fmt.Errorf("fetching LibreSpeed server list: %w", err)
+	}
+	resp := &apiResponse{}
+	for _, s := range servers {
+		base := s.Server
+		if strings.HasPrefix(base, "//") {
+			base = "https:" + base
+		}
+		if !strings.HasSuffix(base, "/") {
+			base += "/"
+		}
+		name := base
+		if host, _, ok := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(base, "https://"), "http://"), "/"); ok {
+			name = host
+		}
+		resp.Targets = append(resp.Targets, target{Name: name, URL: base, Location: location{City: s.Name}, Provider: providerLibreSpeed})
+	}
+	return resp, nil
+}
+
+// consensusOutlier is how far, as a fraction, a provider may stray from the
+// consensus before the comparison flags it.
+const consensusOutlier = 0.25
+
+// consensusResult combines the runs against several providers into one
+// result holding the median of each measurement. The median shrugs off a
+// single provider that is cached, throttled or badly peered.
+func consensusResult(results []testResult) testResult {
+	var down, up, latency []float64
+	var idle []time.Duration
+	for _, r := range results {
+		down = append(down, r.Download.Mbps)
+		up = append(up, r.Upload.Mbps)
+		latency = append(latency, float64(r.IdleLatency.Avg))
+		idle = append(idle, r.IdleLatency.Samples...)
+	}
+	c := testResult{ID: newUUID(), StartedAt: results[0].StartedAt, EndedAt: results[len(results)-1].EndedAt, Provider: "consensus", Tags: results[0].Tags}
+	c.Download.Mbps, c.Upload.Mbps = positiveMedian(down), positiveMedian(up)
+	c.IdleLatency = latencyStats{Samples: idle, Avg: time.Duration(positiveMedian(latency))}
+	return c
+}
+
+// positiveMedian leaves out the zeros of failed phases.
+func positiveMedian(values []float64) float64 {
+	var positive []float64
+	for _, v := range values {
+		if v > 0 {
+			positive = append(positive, v)
+		}
+	}
+	return median(positive)
+}
+
+func isOutlier(value, consensus float64) bool {
+	return consensus > 0 && math.Abs(value-consensus) > consensusOutlier*consensus
+}
+
+func printProviderComparison(results []testResult, consensus testResult) {
+	fmt.Println("\n--- Provider Comparison ---")
+	fmt.Printf("%-12s %14s %14s %10s  %s\n", "Provider", "Download", "Upload", "Latency", "Server")
+	for _, r := range results {
+		var notes []string
+		if isOutlier(r.Download.Mbps, consensus.Download.Mbps) {
+			notes = append(notes, "download outlier")
+		}
+		if isOutlier(r.Upload.Mbps, consensus.Upload.Mbps) {
+			notes = append(notes, "upload outlier")
+		}
+		server := "-"
+		if len(r.Servers) > 0 {
+			pt := r.Servers[0].Target
+			server = fmt.Sprintf("%s (%s)", pt.Name, pt.Location.City)
+		}
+		if len(notes) > 0 {
+			server += "  [" + strings.Join(notes, ", ") + "]"
+		}
+		fmt.Printf("%-12s %9.2f Mbps %9.2f Mbps %10s  %s\n", providerTitle(r.Provider), r.Download.Mbps, r.Upload.Mbps, r.IdleLatency.Avg.Round(time.Millisecond), server)
+	}
+	fmt.Printf("%-12s %9.2f Mbps %9.2f Mbps %10s\n", "Consensus", consensus.Download.Mbps, consensus.Upload.Mbps, consensus.IdleLatency.Avg.Round(time.Millisecond))
+}
+
+type jsonComparison struct {
+	Providers []jsonResult `json:"providers"`
+	Consensus struct {
+		DownloadMbps float64 `json:"download_mbps"`
+		UploadMbps   float64 `json:"upload_mbps"`
+		LatencyMs    float64 `json:"latency_ms"`
+	} `json:"consensus"`
+}
+
+// runProviderComparison implements --provider with more than one provider:
+// a full test against each, then the comparison and consensus.
+func runProviderComparison(opts *options, providers []string) error {
+	var results []testResult
+	for _, name := range providers {
+		fmt.Fprintf(statusOut, "\n=== %s ===\n", providerTitle(name))
+		providerOpts := *opts
+		providerOpts.Provider = name
+		res, err := runSpeedTest(&providerOpts)
+		if err != nil {
+			log.Printf("Warning: %s test failed: %v", providerTitle(name), err)
+			continue
+		}
+		recordHistory(opts, res)
+		results = append(results, res)
+	}
+	if len(results) == 0 {
+		return errors.New("no provider could be tested")
+	}
+	consensus := consensusResult(results)
+
+	if opts.Format == formatJSON {
+		var out jsonComparison
+		for _, r := range results {
+			out.Providers = append(out.Providers, newJSONResult(r, opts.Plan))
+		}
+		out.Consensus.DownloadMbps = consensus.Download.Mbps
+		out.Consensus.UploadMbps = consensus.Upload.Mbps
+		out.Consensus.LatencyMs = durationMs(consensus.IdleLatency.Avg)
+		if err := writeJSON(out); err != nil {
+			return fmt.Errorf("writing result: %w", err)
+		}
+	} else {
+		printProviderComparison(results, consensus)
+	}
+
+	if opts.GHA {
+		writeGHAAnnotations(os.Stderr, consensus, opts.Thresholds)
+	}
+	if failures := opts.Thresholds.check(consensus); len(failures) > 0 {
+		log.Printf("Thresholds not met by the consensus: %s", strings.Join(failures, ", "))
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// longDuration is a duration flag that also accepts days and weeks
+// ("180d", "4w"), which is how retention periods are usually expressed.
+type longDuration time.Duration
+
+func (d *longDuration) String() string {
+	if *d == 0 {
+		return "0"
+	}
+	if days := time.Duration(*d) / (24 * time.Hour); time.Duration(*d)%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", days)
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *longDuration) Set(value string) error {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return fmt.Errorf("invalid duration %q", value)
+			}
+			*d = longDuration(time.Duration(count) * unit)
+			return nil
+		}
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("invalid duration %q, expected e.g. 180d, 4w or 12h", value)
+	}
+	*d = longDuration(parsed)
+	return nil
+}
+
+// retentionPolicy decides what happens to old history entries. Zero values
+// disable the respective step.
+type retentionPolicy struct {
+	Retention       longDuration // Entries older than this are deleted
+	DownsampleAfter longDuration // Entries older than this are merged into hourly aggregates
+}
+
+func (p retentionPolicy) IsSet() bool { return p.Retention > 0 || p.DownsampleAfter > 0 }
+
+func (p *retentionPolicy) register(fs *flag.FlagSet) {
+	fs.Var(&p.Retention, "retention", "delete history entries older than this `age` (e.g. 180d), 0 keeps everything")
+	fs.Var(&p.DownsampleAfter, "downsample-after", "merge history entries older than this `age` (e.g. 30d) into hourly aggregates")
+}
+
+// aggregateHour merges a bucket of runs into one entry holding the median
+// of every metric, stamped with the start of the hour.
+func aggregateHour(hour time.Time, bucket []historyEntry) historyEntry {
+	if len(bucket) == 1 {
+		return bucket[0]
+	}
+	agg := historyEntry{ID: bucket[0].ID, Time: hour, Tags: bucket[0].Tags, Network: bucket[0].Network}
+	for _, e := range bucket {
+		agg.Runs += max(e.Runs, 1) // Re-aggregating keeps the original run count
+		if !maps.Equal(e.Tags, agg.Tags) {
+			agg.Tags = nil // Only the tags all runs shared describe the aggregate
+		}
+		if encodeNetwork(e.Network) != encodeNetwork(agg.Network) {
+			agg.Network = nil // Likewise the network
+		}
+	}
+	values := make([]float64, len(bucket))
+	for _, f := range historyFloatFields {
+		for i := range bucket {
+			values[i] = *f.Field(&bucket[i])
+		}
+		*f.Field(&agg) = median(values)
+	}
+	return agg
+}
+
+// applyRetention returns the entries that survive the policy, in the same
+// (chronological) order, along with how many were deleted and merged away.
+func applyRetention(entries []historyEntry, policy retentionPolicy, now time.Time) (kept []historyEntry, deleted, merged int) {
+	var deleteBefore, downsampleBefore time.Time
+	if policy.Retention > 0 {
+		deleteBefore = now.Add(-time.Duration(policy.Retention))
+	}
+	if policy.DownsampleAfter > 0 {
+		downsampleBefore = now.Add(-time.Duration(policy.DownsampleAfter))
+	}
+
+	var bucket []historyEntry
+	var bucketHour time.Time
+	flush := func() {
+		if len(bucket) > 0 {
+			kept = append(kept, aggregateHour(bucketHour, bucket))
+			merged += len(bucket) - 1
+			bucket = nil
+		}
+	}
+
+	for _, e := range entries {
+		if !deleteBefore.IsZero() && e.Time.Before(deleteBefore) {
+			deleted++
+			continue
+		}
+		if e.Outage != nil {
+			kept = append(kept, e) // Sorted in below, as merging would lose it
+			continue
+		}
+		if downsampleBefore.IsZero() || !e.Time.Before(downsampleBefore) {
+			flush()
+			kept = append(kept, e)
+			continue
+		}
+		hour := e.Time.Truncate(time.Hour)
+		if !hour.Equal(bucketHour) {
+			flush()
+			bucketHour = hour
+		}
+		bucket = append(bucket, e)
+	}
+	flush()
+	slices.SortStableFunc(kept, func(a, b historyEntry) int { return a.Time.Compare(b.Time) })
+	return kept, deleted, merged
+}
+
+// pruneHistory applies the policy to the history file in place.
+func pruneHistory(path string, policy retentionPolicy) (deleted, merged int, err error) {
+	entries, err := loadHistory(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	kept, deleted, merged := applyRetention(entries, policy, time.Now())
+	if deleted == 0 && merged == 0 {
+		return 0, 0, nil
+	}
+	return deleted, merged, saveHistory(path, kept)
+}
+
+func newHistoryPruneFlagSet(historyPath *string, policy *retentionPolicy) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli history prune", flag.ContinueOnError)
+	fs.StringVar(historyPath, "history", defaultHistoryPath(), "history `file` to prune")
+	policy.register(fs)
+	return fs
+}
+
+func runHistoryPrune(args []string) error {
+	var historyPath string
+	var policy retentionPolicy
+	fs := newHistoryPruneFlagSet(&historyPath, &policy)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if !policy.IsSet() {
+		return fmt.Errorf("nothing to do, give --retention and/or --downsample-after")
+	}
+
+	deleted, merged, err := pruneHistory(historyPath, policy)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d results, merged %d into hourly aggregates.\n", deleted, merged)
+	return nil
+}
+
+// enforceRetention is run by the daemon after every test.
+func enforceRetention(path string, policy retentionPolicy) {
+	if !policy.IsSet() {
+		return
+	}
+	deleted, merged, err := pruneHistory(path, policy)
+	if err != nil {
+		log.Printf("Warning: pruning history: %v", err)
+		return
+	}
+	if deleted > 0 || merged > 0 {
+		log.Printf("History pruned: %d deleted, %d merged into hourly aggregates", deleted, merged)
+	}
+}
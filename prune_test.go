@@ -0,0 +1,105 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestLongDuration(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"180d", 180 * 24 * time.Hour, false},
+		{"4w", 4 * 7 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"0", 0, false},
+		{"-3d", 0, true},
+		{"xd", 0, true},
+		{"-1h", 0, true},
+	} {
+		var d longDuration
+		err := d.Set(tc.in)
+		if (err != nil) != tc.wantErr || err == nil && time.Duration(d) != tc.want {
+			t.Errorf("Set(%q) = %v, %v; want %v, error %v", tc.in, time.Duration(d), err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestAggregateHour(t *testing.T) {
+	hour := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	office := &networkIdentity{Interface: "eth0", Subnet: "192.0.2.0/24"}
+	run := func(download float64, runs int, tags resultTags, network *networkIdentity) historyEntry {
+		return historyEntry{Time: hour.Add(10 * time.Minute), DownloadMbps: download, Runs: runs, Tags: tags, Network: network}
+	}
+	site := resultTags{"site": "office"}
+	for _, tc := range []struct {
+		name         string
+		bucket       []historyEntry
+		wantDownload float64
+		wantRuns     int
+		wantTags     resultTags
+		wantNetwork  bool
+	}{
+		{"single run kept as is", []historyEntry{run(50, 0, site, office)}, 50, 0, site, true},
+		{"median of runs", []historyEntry{run(10, 0, site, office), run(30, 0, site, office), run(20, 0, site, office)}, 20, 3, site, true},
+		{"re-aggregating keeps run counts", []historyEntry{run(10, 4, nil, nil), run(30, 0, nil, nil), run(20, 2, nil, nil)}, 20, 7, nil, false},
+		{"different tags dropped", []historyEntry{run(10, 0, site, office), run(30, 0, resultTags{"site": "home"}, office)}, 20, 2, nil, true},
+		{"different networks dropped", []historyEntry{run(10, 0, site, office), run(30, 0, site, &networkIdentity{Interface: "wlan0"})}, 20, 2, site, false},
+	} {
+		agg := aggregateHour(hour, tc.bucket)
+		if agg.DownloadMbps != tc.wantDownload || agg.Runs != tc.wantRuns {
+			t.Errorf("%s: %.0f Mbps over %d runs, want %.0f over %d", tc.name, agg.DownloadMbps, agg.Runs, tc.wantDownload, tc.wantRuns)
+		}
+		if !maps.Equal(agg.Tags, tc.wantTags) || (agg.Network != nil) != tc.wantNetwork {
+			t.Errorf("%s: tags %v, network %v; want tags %v, network kept %v", tc.name, agg.Tags, agg.Network, tc.wantTags, tc.wantNetwork)
+		}
+		if len(tc.bucket) > 1 && !agg.Time.Equal(hour) {
+			t.Errorf("%s: aggregate at %v, want the start of the hour", tc.name, agg.Time)
+		}
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int, minute int) time.Time {
+		return now.AddDate(0, 0, -days).Truncate(time.Hour).Add(time.Duration(minute) * time.Minute)
+	}
+	entries := []historyEntry{
+		{Time: daysAgo(200, 0), DownloadMbps: 1},                        // Past retention
+		{Time: daysAgo(200, 5), Outage: &historyOutage{FailedTests: 3}}, // Past retention too
+		{Time: daysAgo(60, 0), DownloadMbps: 10},                        // Merged with the two after the outage
+		{Time: daysAgo(60, 10), Outage: &historyOutage{FailedTests: 2}}, // Kept whole
+		{Time: daysAgo(60, 20), DownloadMbps: 30},
+		{Time: daysAgo(60, 40), DownloadMbps: 20},
+		{Time: daysAgo(59, 0), DownloadMbps: 40}, // Alone in its hour
+		{Time: daysAgo(5, 0), DownloadMbps: 50},  // Recent
+		{Time: daysAgo(5, 10), DownloadMbps: 60}, // Recent
+	}
+	policy := retentionPolicy{Retention: longDuration(180 * 24 * time.Hour), DownsampleAfter: longDuration(30 * 24 * time.Hour)}
+	kept, deleted, merged := applyRetention(entries, policy, now)
+	if deleted != 2 || merged != 2 {
+		t.Errorf("deleted %d and merged %d, want 2 and 2", deleted, merged)
+	}
+	want := []struct {
+		download float64
+		outage   bool
+	}{{20, false}, {0, true}, {40, false}, {50, false}, {60, false}}
+	if len(kept) != len(want) {
+		t.Fatalf("kept %d entries, want %d", len(kept), len(want))
+	}
+	for i, w := range want {
+		if kept[i].DownloadMbps != w.download || (kept[i].Outage != nil) != w.outage {
+			t.Errorf("entry %d: %.0f Mbps, outage %v; want %.0f Mbps, outage %v", i, kept[i].DownloadMbps, kept[i].Outage != nil, w.download, w.outage)
+		}
+		if i > 0 && kept[i].Time.Before(kept[i-1].Time) {
+			t.Errorf("entry %d at %v comes before entry %d at %v", i, kept[i].Time, i-1, kept[i-1].Time)
+		}
+	}
+
+	if kept, deleted, merged := applyRetention(entries, retentionPolicy{}, now); len(kept) != len(entries) || deleted != 0 || merged != 0 {
+		t.Errorf("with no policy: kept %d, deleted %d, merged %d; want everything kept", len(kept), deleted, merged)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Of a response body, read for what it says about the upload
+const maxResponseLog = 64 << 10
+
+// pushTemplateFuncs are available in --push-template besides the builtins:
+// json encodes a value, quotes included, for embedding any field in a JSON
+// body.
+var pushTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// loadPushTemplate parses --push-template, the template itself or @file to
+// read it from a file. The empty template is nil, which sends the result as
+// --format json writes it.
+func loadPushTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	if path, ok := strings.CutPrefix(text, "@"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading --push-template: %w", err)
+		}
+		text = string(b)
+	}
+	t, err := template.New("push").Funcs(pushTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --push-template: %w", err)
+	}
+	return t, nil
+}
+
+// parsePushHeader splits a --push-header of the form "Name: value".
+func parsePushHeader(h string) (name, value string, err error) {
+	name, value, ok := strings.Cut(h, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid --push-header %q, expected e.g. \"Authorization: Splunk TOKEN\"", h)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// pushToURL POSTs the result to opts.PushURL, rendered through the
+// --push-template, with the --push-header headers.
+func pushToURL(opts *options, out jsonResult) error {
+	var body bytes.Buffer
+	if opts.pushTemplate != nil {
+		if err := opts.pushTemplate.Execute(&body, out); err != nil {
+			return rejectedError{fmt.Errorf("rendering --push-template: %w", err)}
+		}
+	} else if err := json.NewEncoder(&body).Encode(out); err != nil {
+		return fmt.Errorf("encoding result: %w", err)
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	for _, h := range opts.PushHeaders {
+		name, value, _ := parsePushHeader(h) // Validated with the options
+		header.Set(name, value)
+	}
+	_, err := postResult(httpClient, opts.PushURL, body.Bytes(), header)
+	return err
+}
+
+// rejectedIfClientError marks err as final for a 4xx status other than
+// timeouts and rate limits, which a retry may get past.
+func rejectedIfClientError(status int, err error) error {
+	if status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+		return rejectedError{err}
+	}
+	return err
+}
+
+// postResult POSTs body with the given headers and returns the start of the
+// response, failing on any status but 2xx.
+func postResult(client doer, url string, body []byte, header http.Header) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectorPushTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header = header
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sending result: %w", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseLog))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("%s returned status %d: %s", req.URL.Host, resp.StatusCode, strings.Join(strings.Fields(string(msg[:min(len(msg), 200)])), " "))
+		return nil, rejectedIfClientError(resp.StatusCode, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return msg, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// What --quick changes, like fast.com's own test does
+const (
+	quickPhaseDuration = 8 * time.Second // At most; phases usually end sooner, see stopWhenStable
+
+	thoroughPhaseDuration = 30 * time.Second
+
+	stableMinDuration = 3 * time.Second // Before a phase may end early
+	stableWindow      = 2 * time.Second // Speeds compared are averaged over this long
+	stableChecks      = 4               // Consecutive speeds that have to agree
+	stableTolerance   = 0.05            // By being within this fraction of each other
+)
+
+// presets are the flag values that --quick, --thorough and --low-resource stand for. Flags given in any
+// other way keep their value.
+var presets = map[string][][2]string{
+	"quick": {
+		{"download-duration", quickPhaseDuration.String()},
+		{"upload-duration", quickPhaseDuration.String()},
+		{"rank", rankLatency},
+	},
+	"thorough": {
+		{"download-duration", thoroughPhaseDuration.String()},
+		{"upload-duration", thoroughPhaseDuration.String()},
+		{"streams", "5"},
+		{"candidates", "10"},
+		{"rank", rankCombined},
+		{"latency-samples", "20"},
+		{"per-server", "true"},
+	},
+	"low-resource": {
+		{"streams", "2"},
+		{"candidates", "3"},
+		{"rank", rankLatency},
+	},
+}
+
+// applyPresets sets the flags of the presets enabled in fs that weren't
+// given, and skips the upload of --quick unless --upload is.
+func applyPresets(fs *flag.FlagSet, given map[string]bool) error {
+	for name, values := range presets {
+		f := fs.Lookup(name)
+		if f == nil || f.Value.String() != "true" {
+			continue
+		}
+		if name == "quick" && !given["upload"] && !given["no-upload"] {
+			values = append(values, [2]string{"no-upload", "true"})
+		}
+		for _, v := range values {
+			if given[v[0]] {
+				continue
+			}
+			if err := fs.Set(v[0], v[1]); err != nil {
+				return fmt.Errorf("applying --%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// stopWhenStable cancels the phase once it has run for stableMinDuration and
+// its speed over the last stableWindow has held within stableTolerance for
+// stableChecks samples in a row. The returned flag reports whether that
+// happened; it never does unless enabled.
+func stopWhenStable(ctx context.Context, cancel context.CancelFunc, counter *int64, enabled bool) *atomic.Bool {
+	stable := new(atomic.Bool)
+	if !enabled {
+		return stable
+	}
+	go func() {
+		ticker := time.NewTicker(throughputSampleInterval)
+		defer ticker.Stop()
+		start := time.Now()
+		window := int(stableWindow / throughputSampleInterval)
+		var totals []int64 // Bytes at each tick
+		var speeds []int64 // Bytes per window, ending at each tick
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			totals = append(totals, atomic.LoadInt64(counter))
+			if len(totals) <= window {
+				continue
+			}
+			speeds = append(speeds, totals[len(totals)-1]-totals[len(totals)-1-window])
+			if time.Since(start) < stableMinDuration || len(speeds) < stableChecks {
+				continue
+			}
+			last := speeds[len(speeds)-stableChecks:]
+			low, high := last[0], last[0]
+			for _, s := range last {
+				low, high = min(low, s), max(high, s)
+			}
+			if high > 0 && float64(high-low) <= stableTolerance*float64(high) {
+				stable.Store(true)
+				cancel()
+				return
+			}
+		}
+	}()
+	return stable
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Ways of ranking servers, for --rank
+const (
+	rankLatency  = "latency"
+	rankCombined = "combined"
+)
+
+const rankProbeDuration = 1500 * time.Millisecond
+
+// probeThroughput downloads from srv for d after the first byte and returns
+// the speed in Mbps, a rough figure for telling loaded servers apart.
+func probeThroughput(ctx context.Context, client doer, srv target, d time.Duration) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d+httpClientTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.downloadURL(downloadChunkSizeBytes), nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating probe request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("probe download failed with status %d", resp.StatusCode)
+	}
+	start := time.Now()
+	time.AfterFunc(d, cancel)
+	n, err := io.Copy(io.Discard, resp.Body)
+	if n == 0 && err != nil {
+		return 0, err
+	}
+	return toMbps(n, time.Since(start)), nil
+}
+
+// rankServers orders servers best first by byScore, after a short download
+// from each, one after the other so that they don't compete. Servers not
+// probed before ctx is done rank by latency.
+func rankServers(ctx context.Context, servers []pingedTarget) []pingedTarget {
+	fmt.Fprintf(statusOut, "Ranking servers by latency and a %s download from each...\n", rankProbeDuration)
+	for i := range servers {
+		servers[i].ProbeMbps = probeServer(ctx, servers[i].Target)
+	}
+	return byScore(servers)
+}
+
+// probeServer returns what the probe of srv for ranking found in Mbps, 0 if
+// it failed.
+func probeServer(ctx context.Context, srv target) float64 {
+	mbps, err := probeThroughput(ctx, httpClient, srv, rankProbeDuration)
+	if err != nil {
+		fmt.Fprintf(statusOut, "  - %s: probe failed: %v\n", srv.Name, err)
+	}
+	return mbps
+}
+
+// byScore orders servers sorted by latency best first by a score adding
+// their probed throughput, as a fraction of the fastest one's, to their
+// latency, as a fraction of the nearest one's inverse. The nearest server
+// wins unless it is clearly slower than one a little further away.
+func byScore(servers []pingedTarget) []pingedTarget {
+	var fastest float64
+	for _, s := range servers {
+		fastest = max(fastest, s.ProbeMbps)
+	}
+	nearest := servers[0].Latency
+	score := func(s pingedTarget) float64 {
+		v := float64(max(nearest, time.Millisecond)) / float64(max(s.Latency, time.Millisecond))
+		if fastest > 0 {
+			v += s.ProbeMbps / fastest
+		}
+		return v
+	}
+	slices.SortStableFunc(servers, func(a, b pingedTarget) int {
+		return cmp.Compare(score(b), score(a))
+	})
+	return servers
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files of a --record bundle
+const (
+	bundleSelectionFile = "selection.json"
+	bundleRequestsFile  = "requests.har"
+)
+
+// Why a server's ping got no answer, which --replay plays back by not
+// answering either.
+var (
+	errNoAnswer          = errors.New("no answer")
+	errSelectionTimedOut = errors.New("server selection ran out of time")
+)
+
+// selectionBundle is what --record keeps of a test's server selection, for
+// --replay to play it back against the mock provider: the server list as
+// the provider returned it, and how each server answered its ping. The
+// requests of the test are in requests.har next to it.
+type selectionBundle struct {
+	Version    string         `json:"version"`
+	RecordedAt time.Time      `json:"recorded_at"`
+	Provider   string         `json:"provider"`
+	Flags      []string       `json:"flags"`   // That shape server selection, to replay it with
+	Servers    apiResponse    `json:"servers"` // Includes the client's IP, ISP and location
+	Pings      []recordedPing `json:"pings"`
+}
+
+type recordedPing struct {
+	URL       string  `json:"url"`
+	LatencyMs float64 `json:"latency_ms"`
+	SetupMs   float64 `json:"setup_ms"`
+	Error     string  `json:"error,omitempty"`
+	TimedOut  bool    `json:"timed_out,omitempty"`
+}
+
+// selectionRecorder collects the bundle of one test.
+type selectionRecorder struct {
+	mu     sync.Mutex
+	bundle selectionBundle
+}
+
+// recording is non-nil while --record records a test.
+var recording *selectionRecorder
+
+func (r *selectionRecorder) servers(resp *apiResponse) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.bundle.Servers = *resp
+	r.mu.Unlock()
+}
+
+func (r *selectionRecorder) ping(pt pingedTarget) {
+	if r == nil {
+		return
+	}
+	p := recordedPing{URL: pt.Target.URL, LatencyMs: durationMs(pt.Latency), SetupMs: durationMs(pt.Setup)}
+	if pt.Err != nil {
+		p.Error, p.TimedOut = pt.Err.Error(), errors.Is(pt.Err, errNoAnswer) || errors.Is(pt.Err, errSelectionTimedOut)
+	}
+	r.mu.Lock()
+	r.bundle.Pings = append(r.bundle.Pings, p)
+	r.mu.Unlock()
+}
+
+// selectionFlags are the flags of opts that server selection depends on.
+func selectionFlags(opts *options) []string {
+	flags := []string{
+		"--streams", strconv.Itoa(cmp.Or(opts.Streams, numServersToTest)),
+		"--candidates", strconv.Itoa(cmp.Or(opts.Candidates, defaultURLCount)),
+		"--rank", cmp.Or(opts.Rank, rankCombined),
+		"--ping-timeout", cmp.Or(opts.PingTimeout, defaultPingTimeout).String(),
+		"--ping-warmup=" + strconv.FormatBool(opts.PingWarmup),
+		"--overlap=" + strconv.FormatBool(opts.Overlap),
+	}
+	return flags
+}
+
+// startRecording records the server selection and the requests of a test
+// for --record and returns the function that stops and writes the bundle.
+func startRecording(opts *options) (stop func()) {
+	r := &selectionRecorder{bundle: selectionBundle{Version: version, RecordedAt: time.Now(), Provider: opts.Provider, Flags: selectionFlags(opts)}}
+	requests := &harRecorder{}
+	base := httpClient
+	recording, httpClient = r, &harDoer{base: base, recorder: requests}
+	return func() {
+		recording, httpClient = nil, base
+		if err := os.MkdirAll(opts.RecordDir, 0o755); err != nil {
+			log.Printf("Warning: writing the --record bundle: %v", err)
+			return
+		}
+		r.mu.Lock()
+		data, err := json.MarshalIndent(r.bundle, "", "  ")
+		r.mu.Unlock()
+		if err == nil {
+			err = os.WriteFile(filepath.Join(opts.RecordDir, bundleSelectionFile), data, 0o644)
+		}
+		n, harErr := requests.save(filepath.Join(opts.RecordDir, bundleRequestsFile))
+		if err := errors.Join(err, harErr); err != nil {
+			log.Printf("Warning: writing the --record bundle: %v", err)
+			return
+		}
+		fmt.Fprintf(statusOut, "Recorded the server selection and %d requests to %s\n", n, opts.RecordDir)
+	}
+}
+
+// loadBundle reads the bundle --replay plays back.
+func loadBundle(dir string) (*selectionBundle, error) {
+	data, err := os.ReadFile(filepath.Join(dir, bundleSelectionFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading --replay bundle: %w", err)
+	}
+	var b selectionBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filepath.Join(dir, bundleSelectionFile), err)
+	}
+	if len(b.Servers.Targets) == 0 {
+		return nil, fmt.Errorf("%s holds no servers", filepath.Join(dir, bundleSelectionFile))
+	}
+	return &b, nil
+}
+
+// mockServers is the recorded server list with each server moved to the
+// mock at base, the nth under /sn/ as the mock's own are.
+func (b *selectionBundle) mockServers(base string) apiResponse {
+	resp := apiResponse{Client: b.Servers.Client}
+	for i, t := range b.Servers.Targets {
+		u := fmt.Sprintf("%s/s%d/speedtest", base, i+1)
+		if parsed, err := url.Parse(t.URL); err == nil && parsed.RawQuery != "" {
+			u += "?" + parsed.RawQuery
+		}
+		if t.Name == t.URL {
+			t.Name = u
+		}
+		t.URL = u
+		resp.Targets = append(resp.Targets, t)
+	}
+	return resp
+}
+
+// serverPing returns the index in b.Pings of how the recorded server that
+// the mock serves under path answered its ping, -1 for a server that wasn't
+// pinged.
+func (b *selectionBundle) serverPing(path string) int {
+	prefix, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	n, err := strconv.Atoi(strings.TrimPrefix(prefix, "s"))
+	if err != nil || !strings.HasPrefix(prefix, "s") || n < 1 || n > len(b.Servers.Targets) {
+		return -1
+	}
+	return slices.IndexFunc(b.Pings, func(p recordedPing) bool { return p.URL == b.Servers.Targets[n-1].URL })
+}
+
+// replayPings sends the recorded pings of targets in the order they were
+// answered, instead of pinging the mock, whose own overhead would add to the
+// recorded latencies. A target that wasn't pinged comes last, unanswered.
+func (b *selectionBundle) replayPings(targets []target) <-chan pingedTarget {
+	type replayed struct {
+		order int
+		pt    pingedTarget
+	}
+	var pings []replayed
+	for _, t := range targets {
+		i := -1
+		if u, err := url.Parse(t.URL); err == nil {
+			i = b.serverPing(u.Path)
+		}
+		if i < 0 {
+			pings = append(pings, replayed{len(b.Pings), pingedTarget{Target: t, Err: fmt.Errorf("%w: not pinged in the recording", errNoAnswer)}})
+			continue
+		}
+		p := b.Pings[i]
+		pt := pingedTarget{Target: t, Latency: time.Duration(p.LatencyMs * float64(time.Millisecond)), Setup: time.Duration(p.SetupMs * float64(time.Millisecond))}
+		switch {
+		case p.TimedOut:
+			pt.Err = fmt.Errorf("%w (replayed: %s)", errNoAnswer, p.Error)
+		case p.Error != "":
+			pt.Err = fmt.Errorf("replayed: %s", p.Error)
+		}
+		pings = append(pings, replayed{i, pt})
+	}
+	slices.SortStableFunc(pings, func(a, b replayed) int { return a.order - b.order })
+
+	results := make(chan pingedTarget, len(pings))
+	for _, p := range pings {
+		results <- p.pt
+	}
+	close(results)
+	return results
+}
+
+// applyReplay sets the selection flags a --replay bundle was recorded with,
+// except those given on the command line, so that it selects as it did.
+func applyReplay(fs *flag.FlagSet, onCommandLine, given map[string]bool) error {
+	f := fs.Lookup("replay")
+	if f == nil || f.Value.String() == "" {
+		return nil
+	}
+	b, err := loadBundle(f.Value.String())
+	if err != nil {
+		return err
+	}
+	recorded := newRunFlagSet("replay", &options{})
+	recorded.SetOutput(io.Discard)
+	if err := recorded.Parse(b.Flags); err != nil {
+		return fmt.Errorf("applying the flags of the --replay bundle: %w", err)
+	}
+	recorded.Visit(func(rf *flag.Flag) {
+		if err != nil || onCommandLine[rf.Name] {
+			return
+		}
+		if err = fs.Set(rf.Name, rf.Value.String()); err != nil {
+			err = fmt.Errorf("applying the flags of the --replay bundle: %w", err)
+			return
+		}
+		given[rf.Name] = true
+	})
+	return err
+}
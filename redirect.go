@@ -0,0 +1,92 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+)
+
+// maxRedirects is how many hops a test request follows.
+const maxRedirects = 5
+
+var errOffHostRedirect = errors.New("redirected off the server's host")
+
+// redirectHop is the redirects the requests of a test followed from one
+// host to another, or within one.
+type redirectHop struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Count   int    `json:"count"`
+	OffHost bool   `json:"off_host"` // To another host than the request's
+	// Host and path before and after the first, as the query of a server's
+	// URL holds its token
+	First string `json:"first"`
+}
+
+// redirectLog is every redirect the requests of a test followed. Some
+// networks redirect the servers' hosts to a block page, which a test that
+// followed silently would measure instead.
+type redirectLog struct {
+	mu     sync.Mutex
+	strict bool // Refuse hops off the host, for --strict
+	hops   []redirectHop
+}
+
+var redirects redirectLog
+
+// reset starts the log of a test.
+func (l *redirectLog) reset(strict bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.strict, l.hops = strict, nil
+}
+
+// check is the CheckRedirect of test clients: it logs the first hop between
+// each two hosts, stops after maxRedirects, and with --strict refuses to
+// leave the host the request was made to.
+func (l *redirectLog) check(req *http.Request, via []*http.Request) error {
+	from := via[len(via)-1].URL
+	hop := redirectHop{From: from.Host, To: req.URL.Host, OffHost: req.URL.Hostname() != via[0].URL.Hostname()}
+	l.mu.Lock()
+	i := slices.IndexFunc(l.hops, func(h redirectHop) bool { return h.From == hop.From && h.To == hop.To })
+	if i < 0 {
+		i = len(l.hops)
+		hop.First = hopName(from) + " to " + hopName(req.URL)
+		l.hops = append(l.hops, hop)
+		log.Printf("Warning: %s redirected to %s", hopName(from), hopName(req.URL))
+	}
+	l.hops[i].Count++
+	strict := l.strict
+	l.mu.Unlock()
+
+	switch {
+	case len(via) >= maxRedirects:
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	case strict && hop.OffHost:
+		return fmt.Errorf("%w: %s to %s (--strict)", errOffHostRedirect, via[0].URL.Host, req.URL.Host)
+	}
+	return nil
+}
+
+// followed returns the hops of the test so far.
+func (l *redirectLog) followed() []redirectHop {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.hops)
+}
+
+func hopName(u *url.URL) string { return u.Host + cmp.Or(u.Path, "/") }
+
+// offHost returns the first hop to another host, if any.
+func offHost(hops []redirectHop) (redirectHop, bool) {
+	i := slices.IndexFunc(hops, func(h redirectHop) bool { return h.OffHost })
+	if i < 0 {
+		return redirectHop{}, false
+	}
+	return hops[i], true
+}
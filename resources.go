@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime"
+	"time"
+)
+
+// A phase averaging this share of every core is taken to be limited by the
+// machine running the test, as often on ARM routers, rather than the line.
+const cpuBoundPercent = 90
+
+var errUsageUnsupported = errors.New("process CPU and memory usage isn't available on this system")
+
+// resourceUsage is what the test process took of the machine in a phase.
+type resourceUsage struct {
+	CPUPercent     float64 // Average, 100 per fully busy core as in top
+	PeakCPUPercent float64 // Of the busiest throughputSampleInterval
+	PeakRSS        int64   // Bytes; the process's peak so far where the current size isn't known
+	Cores          int     // The process could use, zero if nothing was measured
+}
+
+// CPUBound reports whether the process kept its cores busy, if measured.
+func (u resourceUsage) CPUBound() bool {
+	return u.Cores > 0 && u.CPUPercent >= cpuBoundPercent*float64(u.Cores)
+}
+
+// sampleResources samples the CPU time and resident memory of the process
+// every interval until ctx is done, then delivers the usage over that time
+// on the returned channel, zero where processUsage isn't supported.
+func sampleResources(ctx context.Context, interval time.Duration) <-chan resourceUsage {
+	out := make(chan resourceUsage, 1)
+	startCPU, rss, err := processUsage()
+	if err != nil {
+		out <- resourceUsage{}
+		return out
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		u := resourceUsage{PeakRSS: rss, Cores: runtime.GOMAXPROCS(0)}
+		start := time.Now()
+		lastCPU, lastTime := startCPU, start
+		sample := func(now time.Time) {
+			cpu, rss, err := processUsage()
+			if err != nil || !now.After(lastTime) {
+				return
+			}
+			u.PeakCPUPercent = max(u.PeakCPUPercent, 100*(cpu-lastCPU).Seconds()/now.Sub(lastTime).Seconds())
+			u.PeakRSS = max(u.PeakRSS, rss)
+			if elapsed := now.Sub(start); elapsed > 0 {
+				u.CPUPercent = 100 * (cpu - startCPU).Seconds() / elapsed.Seconds()
+			}
+			lastCPU, lastTime = cpu, now
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				sample(time.Now())
+				out <- u
+				return
+			case now := <-ticker.C:
+				sample(now)
+			}
+		}
+	}()
+	return out
+}
+
+// warnIfCPUBound tells that the speed of a phase says more about the
+// machine than about the connection.
+func warnIfCPUBound(phase string, u resourceUsage) {
+	if u.CPUBound() {
+		log.Printf("Warning: fast-cli used %.0f%% CPU of %d core(s) during the %s, so this device rather than the connection likely limited the speed", u.CPUPercent, u.Cores, phase)
+	}
+}
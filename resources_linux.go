@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processUsage returns the CPU time the process has used, user and system,
+// and its current resident set size from /proc.
+func processUsage() (cpu time.Duration, rss int64, err error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, fmt.Errorf("getrusage: %w", err)
+	}
+	cpu = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return cpu, int64(ru.Maxrss) * 1024, nil // Peak, in KiB on Linux
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("unexpected /proc/self/statm %q", b)
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing /proc/self/statm: %w", err)
+	}
+	return cpu, pages * int64(os.Getpagesize()), nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+import "time"
+
+func processUsage() (cpu time.Duration, rss int64, err error) {
+	return 0, 0, errUsageUnsupported
+}
@@ -0,0 +1,24 @@
+//go:build unix && !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// processUsage returns the CPU time the process has used, user and system,
+// and its peak resident set size, the current one needing cgo here.
+func processUsage() (cpu time.Duration, rss int64, err error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, fmt.Errorf("getrusage: %w", err)
+	}
+	rss = int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		rss *= 1024 // The BSDs count KiB, macOS bytes
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), rss, nil
+}
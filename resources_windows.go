@@ -0,0 +1,47 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procK32GetProcessMemoryInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("K32GetProcessMemoryInfo")
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS.
+type processMemoryCounters struct {
+	CB                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// processUsage returns the CPU time the process has used, user and kernel,
+// and its working set.
+func processUsage() (cpu time.Duration, rss int64, err error) {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, 0, err
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(process, &creation, &exit, &kernel, &user); err != nil {
+		return 0, 0, fmt.Errorf("GetProcessTimes: %w", err)
+	}
+	ticks := func(ft syscall.Filetime) time.Duration { // Of 100ns
+		return time.Duration(uint64(ft.HighDateTime)<<32|uint64(ft.LowDateTime)) * 100
+	}
+	counters := processMemoryCounters{CB: uint32(unsafe.Sizeof(processMemoryCounters{}))}
+	if _, err := winCall(procK32GetProcessMemoryInfo, uintptr(process), uintptr(unsafe.Pointer(&counters)), uintptr(counters.CB)); err != nil {
+		return 0, 0, err
+	}
+	return ticks(kernel) + ticks(user), int64(counters.WorkingSetSize), nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+)
+
+const (
+	scoreWindow      = 20  // Most recent runs with a server that count toward its score
+	scoreMinRuns     = 3   // Runs before a server is judged at all
+	badErrorRate     = 20  // Percent of failed requests that marks a server as bad
+	badRelativeSpeed = 0.5 // As does averaging under half the speed of the servers tested alongside it
+)
+
+// historyServer is how one server did in a recorded run.
+type historyServer struct {
+	Host     string  `json:"host"`
+	Requests int     `json:"requests"`
+	Failed   int     `json:"failed"`
+	Relative float64 `json:"relative_speed,omitempty"` // Its download bytes over the mean of the run's servers
+}
+
+// newHistoryServers sums up the streams of res by server.
+func newHistoryServers(res testResult) []historyServer {
+	var servers []historyServer
+	index := map[string]int{}
+	add := func(s serverStats) *historyServer {
+		i, ok := index[s.Host]
+		if !ok {
+			i = len(servers)
+			index[s.Host] = i
+			servers = append(servers, historyServer{Host: s.Host})
+		}
+		servers[i].Requests += s.Requests
+		servers[i].Failed += s.Failed
+		return &servers[i]
+	}
+	var total int64
+	for _, s := range res.Download.PerServer {
+		total += s.Bytes
+	}
+	for _, s := range res.Download.PerServer {
+		h := add(s)
+		if n := len(res.Download.PerServer); n > 1 && total > 0 {
+			h.Relative = float64(s.Bytes) * float64(n) / float64(total)
+		}
+	}
+	for _, s := range res.Upload.PerServer {
+		add(s)
+	}
+	return servers
+}
+
+// serverScore is a server's record over its last scoreWindow runs.
+type serverScore struct {
+	Runs             int
+	Requests, Failed int
+	Relative         float64 // Mean over the runs that had other servers to compare to
+	compared         int
+}
+
+func (s serverScore) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(s.Failed) / float64(s.Requests)
+}
+
+// Bad says why a server has done consistently badly, or "" if it hasn't.
+func (s serverScore) Bad() string {
+	switch {
+	case s.Runs < scoreMinRuns:
+		return ""
+	case s.ErrorRate() > badErrorRate:
+		return fmt.Sprintf("%.0f%% of requests failed over the last %d runs", s.ErrorRate(), s.Runs)
+	case s.compared >= scoreMinRuns && s.Relative < badRelativeSpeed:
+		return fmt.Sprintf("%.0f%% of the other servers' speed over the last %d runs", 100*s.Relative, s.compared)
+	}
+	return ""
+}
+
+// serverScores scores every server in history by host.
+func serverScores(history []historyEntry) map[string]serverScore {
+	scores := map[string]serverScore{}
+	for _, e := range slices.Backward(history) {
+		for _, h := range e.Servers {
+			s := scores[h.Host]
+			if s.Runs == scoreWindow {
+				continue
+			}
+			s.Runs++
+			s.Requests += h.Requests
+			s.Failed += h.Failed
+			if h.Relative > 0 {
+				s.Relative = (s.Relative*float64(s.compared) + h.Relative) / float64(s.compared+1)
+				s.compared++
+			}
+			scores[h.Host] = s
+		}
+	}
+	return scores
+}
+
+// loadServerScores scores the servers in the history of opts, or returns
+// nil if it isn't kept.
+func loadServerScores(opts *options) map[string]serverScore {
+	if opts.NoHistory || opts.HistoryPath == "" {
+		return nil
+	}
+	history, err := loadHistory(opts.HistoryPath)
+	if err != nil {
+		log.Printf("Warning: reading history for server scores: %v", err)
+		return nil
+	}
+	return serverScores(history)
+}
+
+// deprioritize moves servers that did consistently badly in past runs
+// behind the others, keeping the order otherwise.
+func deprioritize(servers []pingedTarget, scores map[string]serverScore) []pingedTarget {
+	var good, bad []pingedTarget
+	for _, s := range servers {
+		if why := scores[targetHost(s.Target)].Bad(); why != "" {
+			fmt.Fprintf(statusOut, "Deprioritizing %s: %s.\n", targetHost(s.Target), why)
+			bad = append(bad, s)
+			continue
+		}
+		good = append(good, s)
+	}
+	return append(good, bad...)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Flags holding credentials take, instead of the credential itself, where to
+// read it from, so that config files can be shared without their secrets.
+const (
+	secretEnvPrefix     = "env:"
+	secretKeyringPrefix = "keyring:"
+	secretFilePrefix    = "@"
+	keyringService      = "fast-cli" // Of the keyring entries, see keyringSecret
+	secretHelp          = "@file, env:VAR or keyring:NAME reads it from there"
+)
+
+// resolveSecret returns the credential value refers to: the contents of a
+// file for @file, an environment variable for env:VAR, an entry of the OS
+// keyring for keyring:NAME, and otherwise value itself.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		b, err := os.ReadFile(strings.TrimPrefix(value, secretFilePrefix))
+		if err != nil {
+			return "", fmt.Errorf("reading secret: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, secretKeyringPrefix):
+		name := strings.TrimPrefix(value, secretKeyringPrefix)
+		v, err := keyringSecret(name)
+		if err != nil {
+			return "", fmt.Errorf("reading %s from the keyring: %w", name, err)
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// resolveSecrets replaces the credentials of opts by what they refer to and
+// registers them to be redacted from the logs.
+func (opts *options) resolveSecrets() error {
+	urls := []*string{&opts.PushURL, &opts.PushTo, &opts.SplunkURL, &opts.ElasticURL}
+	for i := range opts.NotifyWebhooks {
+		urls = append(urls, &opts.NotifyWebhooks[i])
+	}
+	for _, p := range urls {
+		v, err := resolveSecret(*p)
+		if err != nil {
+			return err
+		}
+		*p = v
+		addSecretURL(v)
+	}
+	for _, p := range []*string{&opts.SplunkToken, &opts.ElasticAPIKey} {
+		v, err := resolveSecret(*p)
+		if err != nil {
+			return err
+		}
+		*p = v
+		addSecret(v, "")
+	}
+	for i, h := range opts.PushHeaders {
+		name, value, err := parsePushHeader(h)
+		if err != nil {
+			return err
+		}
+		if value, err = resolveSecret(value); err != nil {
+			return fmt.Errorf("--push-header %s: %w", name, err)
+		}
+		opts.PushHeaders[i] = name + ": " + value
+		addSecret(value, "")
+	}
+	if opts.NATS.URL != nil {
+		addSecretURL(opts.NATS.URL.String())
+	}
+	return nil
+}
+
+// redactURL returns u without its credentials, path or query, which for
+// webhooks often is the credential, keeping only the first path segment.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "[REDACTED]"
+	}
+	if u.User != nil {
+		u.User = url.User("xxxxx")
+	}
+	first, rest, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if rest != "" || u.RawQuery != "" {
+		u.Path, u.RawQuery = "/"+first+"/...", ""
+	}
+	return u.String()
+}
+
+// Secrets shorter than this aren't redacted, lest every "1" of a log go
+const minRedactedSecret = 4
+
+var redactions struct {
+	sync.Mutex
+	secrets map[string]string // To their replacement
+}
+
+// addSecret has value replaced by replacement, [REDACTED] if empty, in the logs.
+func addSecret(value, replacement string) {
+	if len(value) < minRedactedSecret {
+		return
+	}
+	redactions.Lock()
+	defer redactions.Unlock()
+	if redactions.secrets == nil {
+		redactions.secrets = map[string]string{}
+	}
+	redactions.secrets[value] = cmp.Or(replacement, "[REDACTED]")
+}
+
+// addSecretURL redacts a URL as redactURL does, and its password on its own.
+func addSecretURL(raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return
+	}
+	if u.User != nil {
+		secret, ok := u.User.Password()
+		if !ok {
+			secret = u.User.Username() // A token, as NATS takes
+		}
+		addSecret(secret, "")
+	}
+	if redacted := redactURL(raw); redacted != raw {
+		addSecret(raw, redacted)
+	}
+}
+
+// redactSecrets replaces the registered secrets in s, longest first so that
+// a URL goes before the password in it.
+func redactSecrets(s string) string {
+	redactions.Lock()
+	defer redactions.Unlock()
+	secrets := make([]string, 0, len(redactions.secrets))
+	for secret := range redactions.secrets {
+		if strings.Contains(s, secret) {
+			secrets = append(secrets, secret)
+		}
+	}
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redactions.secrets[secret])
+	}
+	return s
+}
+
+// redactingWriter is the log output, redacting the secrets written to it.
+type redactingWriter struct{ w io.Writer }
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
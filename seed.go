@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+)
+
+// streamRand returns the random numbers of the nth stream of a phase, which
+// pick its download ranges and fill its upload chunks. With --seed they
+// are the same in every run, stream by stream.
+func (l transferLimits) streamRand(n int) *rand.Rand {
+	if l.Seed == 0 {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	return rand.New(rand.NewPCG(l.Seed, uint64(n)))
+}
+
+// chachaSeed draws the seed of a ChaCha8 generator from rng.
+func chachaSeed(rng *rand.Rand) [32]byte {
+	var seed [32]byte
+	for i := 0; i < len(seed); i += 8 {
+		binary.LittleEndian.PutUint64(seed[i:], rng.Uint64())
+	}
+	return seed
+}
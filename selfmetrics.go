@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+// daemonMetrics is what the daemon has been up to, for monitoring the
+// monitor: served with the gauges of the last result by --metrics-listen.
+type daemonMetrics struct {
+	mu           sync.Mutex
+	started      time.Time
+	runs         float64
+	succeeded    float64
+	failures     map[errorCode]float64 // Failed runs by cause, "" for uncoded errors
+	sinkFailures map[string]float64    // Failed deliveries by sink ID, see sinkID
+	drift        time.Duration         // Of the start of the last run from its schedule
+	lastDuration time.Duration
+	last         *testResult // Nil before the first run
+	lastFailed   bool
+}
+
+var selfMetrics = &daemonMetrics{started: time.Now(), failures: map[errorCode]float64{}, sinkFailures: map[string]float64{}}
+
+// Codes of runs failed at the server list API rather than the test
+var apiErrorCodes = []errorCode{codeAPIUnreachable, codeTokenRejected, codeAPIError}
+
+// ran records a test started at start.
+func (m *daemonMetrics) ran(res testResult, err error, start time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	if err != nil {
+		m.failures[errorCodeOf(err)]++
+	} else {
+		m.succeeded++
+	}
+	if res.StartedAt.IsZero() {
+		res.StartedAt = start // For fastcli_last_run_timestamp_seconds
+	}
+	m.last, m.lastFailed, m.lastDuration = &res, err != nil, time.Since(start)
+}
+
+func (m *daemonMetrics) sinkFailed(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sinkFailures[id]++
+}
+
+func (m *daemonMetrics) scheduled(drift time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drift = drift
+}
+
+// promSample is one sample of a metric family, its labels already rendered.
+type promSample struct {
+	Labels string
+	Value  float64
+}
+
+// writeFamily writes a metric family in the OpenMetrics text format;
+// counters get the _total suffix their samples need.
+func writeFamily(b *strings.Builder, name, typ, help string, samples ...promSample) {
+	fmt.Fprintf(b, "# TYPE %s %s\n# HELP %s %s.\n", name, typ, name, help)
+	if typ == "counter" {
+		name += "_total"
+	}
+	for _, s := range samples {
+		fmt.Fprintf(b, "%s%s %s\n", name, s.Labels, formatPromValue(s.Value))
+	}
+}
+
+func promLabel(name, value string) string {
+	return fmt.Sprintf(`{%s="%s"}`, name, promLabelEscaper.Replace(value))
+}
+
+// format renders the self-metrics, and the sinks' queues, after the gauges
+// of the last result.
+func (m *daemonMetrics) format(opts *options) string {
+	queued := map[string]float64{}
+	for _, s := range queuedSinks(opts) {
+		queue, _ := s.outbox.load()
+		queued[s.outbox.id()] = float64(len(queue))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	if m.last != nil {
+		writeOpenMetrics(&b, promMetrics(*m.last, m.lastFailed), cmp.Or(m.last.Provider, opts.Provider), m.last.Tags)
+		writeFamily(&b, "fastcli_daemon_last_run_duration_seconds", "gauge", "How long the last test took", promSample{Value: m.lastDuration.Seconds()})
+	}
+	writeFamily(&b, "fastcli_daemon_start_time_seconds", "gauge", "When the daemon started", promSample{Value: float64(m.started.Unix())})
+	writeFamily(&b, "fastcli_daemon_runs", "counter", "Tests attempted", promSample{Value: m.runs})
+	writeFamily(&b, "fastcli_daemon_runs_succeeded", "counter", "Tests that produced a result", promSample{Value: m.succeeded})
+
+	var failures, apiErrors []promSample
+	for _, code := range slices.Sorted(maps.Keys(m.failures)) {
+		s := promSample{promLabel("code", cmp.Or(string(code), "OTHER")), m.failures[code]}
+		failures = append(failures, s)
+		if slices.Contains(apiErrorCodes, code) {
+			apiErrors = append(apiErrors, s)
+		}
+	}
+	writeFamily(&b, "fastcli_daemon_runs_failed", "counter", "Failed tests by error code", failures...)
+	writeFamily(&b, "fastcli_daemon_api_errors", "counter", "Tests failed at the server list API, by error code", apiErrors...)
+	writeFamily(&b, "fastcli_daemon_schedule_drift_seconds", "gauge", "How late the last test started after its scheduled time", promSample{Value: m.drift.Seconds()})
+
+	var sinkFailures, queuedSamples []promSample
+	for _, id := range slices.Sorted(maps.Keys(m.sinkFailures)) {
+		sinkFailures = append(sinkFailures, promSample{promLabel("sink", id), m.sinkFailures[id]})
+	}
+	for _, id := range slices.Sorted(maps.Keys(queued)) {
+		queuedSamples = append(queuedSamples, promSample{promLabel("sink", id), queued[id]})
+	}
+	writeFamily(&b, "fastcli_sink_delivery_failures", "counter", "Failed attempts to hand a result or notification to a sink", sinkFailures...)
+	writeFamily(&b, "fastcli_sink_queued_messages", "gauge", "Messages waiting in the outbox of a sink", queuedSamples...)
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// serveMetrics serves the metrics on addr until ctx is done.
+func serveMetrics(ctx context.Context, opts *options, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		fmt.Fprint(w, selfMetrics.format(opts))
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("Serving metrics on http://%s%s", ln.Addr(), metricsPath)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Warning: serving metrics: %v", err)
+		}
+	}()
+	return nil
+}
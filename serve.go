@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	peerSpeedtestPath = "/speedtest"
+	peerMaxRange      = 256 * 1024 * 1024 // Largest download a single request may ask for
+	peerPayloadSize   = 1024 * 1024       // Random block repeated to fill downloads
+)
+
+// peerTargets turns --server URLs into test targets. A bare host:port or a
+// URL without a path gets the peer's /speedtest endpoint.
+func peerTargets(urls []string) []target {
+	var targets []target
+	for _, raw := range urls {
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			log.Printf("Warning: ignoring invalid server URL %q", raw)
+			continue
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = peerSpeedtestPath
+		}
+		targets = append(targets, target{Name: u.Host, URL: u.String(), Location: location{City: "self-hosted"}})
+	}
+	return targets
+}
+
+// peerHandler serves the same endpoints fast.com's servers do, so that a
+// client run with --server measures against it unchanged:
+// GET /speedtest/range/0-N returns N+1 bytes and POST /speedtest discards
+// the body.
+type peerHandler struct {
+	payload []byte
+}
+
+func (h *peerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == peerSpeedtestPath:
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, peerSpeedtestPath+"/range/"):
+		size, err := parseRange(strings.TrimPrefix(r.URL.Path, peerSpeedtestPath+"/range/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		for size > 0 {
+			n := min(size, int64(len(h.payload)))
+			if _, err := w.Write(h.payload[:n]); err != nil {
+				return // Client went away, e.g. at the end of its test
+			}
+			size -= n
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// parseRange parses "START-END" (inclusive) into a byte count.
+func parseRange(spec string) (int64, error) {
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	end, err2 := strconv.ParseInt(endStr, 10, 64)
+	if !ok || err1 != nil || err2 != nil || start < 0 || end < start {
+		return 0, fmt.Errorf("invalid range %q", spec)
+	}
+	if size := end - start + 1; size <= peerMaxRange {
+		return size, nil
+	}
+	return 0, fmt.Errorf("range larger than %d bytes", peerMaxRange)
+}
+
+type serveFlags struct {
+	Listen string
+	UDP    bool
+	MDNS   bool
+	Pprof  string
+}
+
+func newServeFlagSet(f *serveFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli serve", flag.ContinueOnError)
+	fs.StringVar(&f.Listen, "listen", ":8080", "`address` to listen on")
+	fs.BoolVar(&f.UDP, "udp", true, "also answer `fast-cli udp` tests on the same port over UDP")
+	fs.BoolVar(&f.MDNS, "mdns", true, "advertise on the local network via mDNS, for `fast-cli lan`")
+	fs.StringVar(&f.Pprof, "pprof", "", pprofHelp)
+	return fs
+}
+
+// runServe implements `fast-cli serve`: a self-hosted test peer for LAN and
+// tunnel measurements, used with `fast-cli run --server HOST:PORT`.
+func runServe(args []string) error {
+	var f serveFlags
+	if err := parseFlags(newServeFlagSet(&f), args); err != nil {
+		return err
+	}
+
+	payload := make([]byte, peerPayloadSize)
+	if _, err := rand.Read(payload); err != nil {
+		return fmt.Errorf("generating payload: %w", err)
+	}
+	ln, err := net.Listen("tcp", f.Listen)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	srv := &http.Server{Handler: &peerHandler{payload: payload}, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if f.Pprof != "" {
+		if err := servePprof(ctx, f.Pprof); err != nil {
+			return err
+		}
+	}
+	if f.UDP {
+		udpAddr, err := listenUDPPeer(ctx, ln.Addr().String())
+		if err != nil {
+			return err
+		}
+		log.Printf("Serving UDP tests on %s, test with: fast-cli udp --server %s", udpAddr, udpAddr)
+	}
+	if f.MDNS {
+		if name, err := advertiseMDNS(ctx, ln.Addr()); err != nil {
+			log.Printf("Warning: not advertising via mDNS: %v", err)
+		} else {
+			log.Printf("Advertising as %q via mDNS, find it with: fast-cli lan", name)
+		}
+	}
+
+	log.Printf("Serving speed tests on %s, test with: fast-cli run --server %s", ln.Addr(), ln.Addr())
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+type serversFlags struct {
+	Format      string
+	Servers     stringList
+	HistoryPath string
+	ResetScores bool
+}
+
+func newServersFlagSet(f *serversFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli servers", flag.ContinueOnError)
+	fs.StringVar(&f.Format, "format", formatText, "output `format`: text or json")
+	fs.Var(&f.Servers, "server", "list this fast-cli serve `URL` instead of fast.com's servers (repeatable)")
+	fs.StringVar(&f.HistoryPath, "history", defaultHistoryPath(), "history `file` server scores are kept in")
+	fs.BoolVar(&f.ResetScores, "reset-scores", false, "forget how servers did in past runs, so that none is deprioritized")
+	return fs
+}
+
+// runServers implements `fast-cli servers`: list the servers a test would
+// choose from, best first, without transferring any test data.
+func runServers(args []string) error {
+	var f serversFlags
+	if err := parseFlags(newServersFlagSet(&f), args); err != nil {
+		return err
+	}
+	if f.Format != formatText && f.Format != formatJSON {
+		return fmt.Errorf("unknown format %q, expected text or json", f.Format)
+	}
+	if f.ResetScores {
+		return resetServerScores(f.HistoryPath)
+	}
+
+	targets := peerTargets(f.Servers)
+	if len(f.Servers) == 0 {
+		apiResp, err := fetchTestServers(httpClient, defaultURLCount)
+		if err != nil {
+			return fmt.Errorf("fetching test servers: %w", err)
+		}
+		targets = apiResp.Targets
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), selectionTimeout)
+	defer cancel()
+	pinged := measurePings(ctx, targets, defaultPingTimeout, true) // Unreachable servers are logged and left out
+	scores := loadServerScores(&options{HistoryPath: f.HistoryPath})
+	if f.Format == formatText {
+		pinged = deprioritize(pinged, scores)
+	}
+
+	if f.Format == formatJSON {
+		servers := []jsonServer{}
+		for _, pt := range pinged {
+			servers = append(servers, jsonServer{
+				Name:      pt.Target.Name,
+				URL:       pt.Target.URL,
+				City:      pt.Target.Location.City,
+				Country:   pt.Target.Location.Country,
+				LatencyMs: durationMs(pt.Latency),
+				SetupMs:   durationMs(pt.Setup),
+			})
+		}
+		return writeJSON(servers)
+	}
+
+	fmt.Printf("%d of %d servers responding, best first:\n", len(pinged), len(targets))
+	for _, pt := range pinged {
+		fmt.Printf("  %-8v %s (%s, %s)", pt.Latency.Round(time.Millisecond), targetHost(pt.Target), pt.Target.Location.City, pt.Target.Location.Country)
+		if s, ok := scores[targetHost(pt.Target)]; ok {
+			fmt.Printf("  %d runs, %.1f%% failed", s.Runs, s.ErrorRate())
+			if s.compared > 0 {
+				fmt.Printf(", %.0f%% of others' speed", 100*s.Relative)
+			}
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// resetServerScores drops the per-server records from every run in the
+// history, keeping the rest of each entry.
+func resetServerScores(path string) error {
+	entries, err := loadHistory(path)
+	if err != nil {
+		return err
+	}
+	cleared := 0
+	for i := range entries {
+		if entries[i].Servers != nil {
+			entries[i].Servers = nil
+			cleared++
+		}
+	}
+	if cleared == 0 {
+		fmt.Println("No server scores recorded.")
+		return nil
+	}
+	if err := saveHistory(path, entries); err != nil {
+		return err
+	}
+	fmt.Printf("Cleared server scores from %d runs in %s.\n", cleared, path)
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// runService is only meaningful on Windows, other systems use install-service.
+func runService(args []string) error {
+	return fmt.Errorf("fast-cli service is only available on Windows, use install-service for systemd")
+}
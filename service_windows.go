@@ -0,0 +1,345 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Windows service support talks to the Service Control Manager and the
+// event log directly through advapi32, as neither is in the standard library.
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procOpenSCManagerW                = advapi32.NewProc("OpenSCManagerW")
+	procCreateServiceW                = advapi32.NewProc("CreateServiceW")
+	procOpenServiceW                  = advapi32.NewProc("OpenServiceW")
+	procStartServiceW                 = advapi32.NewProc("StartServiceW")
+	procControlService                = advapi32.NewProc("ControlService")
+	procDeleteService                 = advapi32.NewProc("DeleteService")
+	procCloseServiceHandle            = advapi32.NewProc("CloseServiceHandle")
+	procChangeServiceConfig2W         = advapi32.NewProc("ChangeServiceConfig2W")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+	procRegisterEventSourceW          = advapi32.NewProc("RegisterEventSourceW")
+	procReportEventW                  = advapi32.NewProc("ReportEventW")
+	procRegCreateKeyExW               = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW                = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKeyW                 = advapi32.NewProc("RegDeleteKeyW")
+)
+
+// Constants from winsvc.h, winnt.h and winreg.h
+const (
+	scManagerAllAccess       = 0xF003F
+	serviceAllAccess         = 0xF01FF
+	serviceWin32OwnProcess   = 0x10
+	serviceAutoStart         = 2
+	serviceErrorNormal       = 1
+	serviceConfigDescription = 1
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+	serviceAcceptStop         = 1
+	serviceAcceptShutdown     = 4
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	errorFailedServiceControllerConnect = syscall.Errno(1063)
+
+	eventlogErrorType       = 1
+	eventlogWarningType     = 2
+	eventlogInformationType = 4
+
+	hkeyLocalMachine = 0x80000002
+	keyWrite         = 0x20006
+	regExpandSz      = 2
+	regDword         = 4
+)
+
+const eventSourceKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application\` + serviceName
+
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+func utf16Ptr(s string) *uint16 {
+	p, err := syscall.UTF16PtrFromString(s)
+	if err != nil {
+		panic(err) // Only constant strings without NULs are passed in
+	}
+	return p
+}
+
+// winCall invokes a Win32 function that signals failure by returning zero.
+func winCall(proc *syscall.LazyProc, args ...uintptr) (uintptr, error) {
+	r, _, err := proc.Call(args...)
+	if r == 0 {
+		return 0, fmt.Errorf("%s: %w", proc.Name, err)
+	}
+	return r, nil
+}
+
+// withService opens the SCM and the fast-cli service for the duration of fn.
+func withService(fn func(service uintptr) error) error {
+	scm, err := winCall(procOpenSCManagerW, 0, 0, scManagerAllAccess)
+	if err != nil {
+		return fmt.Errorf("opening service manager (run as Administrator?): %w", err)
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	service, err := winCall(procOpenServiceW, scm, uintptr(unsafe.Pointer(utf16Ptr(serviceName))), serviceAllAccess)
+	if err != nil {
+		return fmt.Errorf("opening service %s (is it installed?): %w", serviceName, err)
+	}
+	defer procCloseServiceHandle.Call(service)
+	return fn(service)
+}
+
+// runService implements `fast-cli service install|uninstall|start|stop|run`.
+func runService(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fast-cli service <install|uninstall|start|stop|run> [-- daemon flags]")
+	}
+	switch args[0] {
+	case "install":
+		return installWindowsService(args[1:])
+	case "uninstall":
+		return uninstallWindowsService()
+	case "start":
+		return withService(func(service uintptr) error {
+			_, err := winCall(procStartServiceW, service, 0, 0)
+			if err == nil {
+				fmt.Printf("Service %s started.\n", serviceName)
+			}
+			return err
+		})
+	case "stop":
+		return withService(func(service uintptr) error {
+			var status serviceStatus
+			_, err := winCall(procControlService, service, serviceControlStop, uintptr(unsafe.Pointer(&status)))
+			if err == nil {
+				fmt.Printf("Service %s stopping.\n", serviceName)
+			}
+			return err
+		})
+	case "run":
+		return runWindowsService(args[1:])
+	default:
+		return fmt.Errorf("unknown service command %q", args[0])
+	}
+}
+
+func installWindowsService(daemonArgs []string) error {
+	if len(daemonArgs) > 0 && daemonArgs[0] == "--" {
+		daemonArgs = daemonArgs[1:]
+	}
+	if _, err := parseDaemonOptions(daemonArgs); err != nil {
+		return fmt.Errorf("invalid daemon flags: %w", err)
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating the fast-cli binary: %w", err)
+	}
+	binary, _ = filepath.Abs(binary)
+	cmdLine := []string{syscall.EscapeArg(binary), "service", "run"}
+	for _, a := range daemonArgs {
+		cmdLine = append(cmdLine, syscall.EscapeArg(a))
+	}
+
+	scm, err := winCall(procOpenSCManagerW, 0, 0, scManagerAllAccess)
+	if err != nil {
+		return fmt.Errorf("opening service manager (run as Administrator?): %w", err)
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	service, err := winCall(procCreateServiceW, scm,
+		uintptr(unsafe.Pointer(utf16Ptr(serviceName))),
+		uintptr(unsafe.Pointer(utf16Ptr("fast.com speed test"))),
+		serviceAllAccess, serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal,
+		uintptr(unsafe.Pointer(utf16Ptr(strings.Join(cmdLine, " ")))),
+		0, 0, 0, 0, 0) // No load order group, dependencies; runs as LocalSystem
+	if err != nil {
+		return fmt.Errorf("creating service: %w", err)
+	}
+	defer procCloseServiceHandle.Call(service)
+
+	description := utf16Ptr("Runs scheduled fast.com speed tests and records the results.")
+	procChangeServiceConfig2W.Call(service, serviceConfigDescription, uintptr(unsafe.Pointer(&description)))
+
+	if err := installEventSource(); err != nil {
+		log.Printf("Warning: registering event log source: %v", err)
+	}
+	fmt.Printf("Service %s installed, start it with: fast-cli service start\n", serviceName)
+	return nil
+}
+
+func uninstallWindowsService() error {
+	err := withService(func(service uintptr) error {
+		var status serviceStatus
+		procControlService.Call(service, serviceControlStop, uintptr(unsafe.Pointer(&status))) // Fails harmlessly when not running
+		_, err := winCall(procDeleteService, service)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	procRegDeleteKeyW.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(utf16Ptr(eventSourceKey))))
+	fmt.Printf("Service %s removed.\n", serviceName)
+	return nil
+}
+
+// installEventSource registers fast-cli as an event log source, borrowing
+// EventCreate.exe's message table so Event Viewer shows messages verbatim.
+func installEventSource() error {
+	var key syscall.Handle
+	if r, _, _ := procRegCreateKeyExW.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(utf16Ptr(eventSourceKey))),
+		0, 0, 0, keyWrite, 0, uintptr(unsafe.Pointer(&key)), 0); r != 0 {
+		return fmt.Errorf("creating registry key: %w", syscall.Errno(r))
+	}
+	defer syscall.RegCloseKey(key)
+
+	msgFile, _ := syscall.UTF16FromString(`%SystemRoot%\System32\EventCreate.exe`)
+	if r, _, _ := procRegSetValueExW.Call(uintptr(key), uintptr(unsafe.Pointer(utf16Ptr("EventMessageFile"))),
+		0, regExpandSz, uintptr(unsafe.Pointer(&msgFile[0])), uintptr(len(msgFile)*2)); r != 0 {
+		return fmt.Errorf("setting EventMessageFile: %w", syscall.Errno(r))
+	}
+	types := uint32(eventlogErrorType | eventlogWarningType | eventlogInformationType)
+	if r, _, _ := procRegSetValueExW.Call(uintptr(key), uintptr(unsafe.Pointer(utf16Ptr("TypesSupported"))),
+		0, regDword, uintptr(unsafe.Pointer(&types)), 4); r != 0 {
+		return fmt.Errorf("setting TypesSupported: %w", syscall.Errno(r))
+	}
+	return nil
+}
+
+// eventLogWriter lets the standard logger write to the Windows event log.
+type eventLogWriter struct {
+	handle uintptr
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\r\n")
+	eventType := eventlogInformationType
+	switch {
+	case strings.HasPrefix(msg, "Error"):
+		eventType = eventlogErrorType
+	case strings.HasPrefix(msg, "Warning"), strings.Contains(msg, "failed"), strings.HasPrefix(msg, "Anomaly"):
+		eventType = eventlogWarningType
+	}
+	text, err := syscall.UTF16PtrFromString(strings.ReplaceAll(msg, "\x00", ""))
+	if err != nil {
+		return 0, err
+	}
+	strs := []*uint16{text}
+	if _, err := winCall(procReportEventW, w.handle, uintptr(eventType), 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// State shared between the dispatcher, ServiceMain and the control handler,
+// which are all invoked by the SCM as plain callbacks
+var (
+	serviceOpts         *options
+	serviceStatusHandle uintptr
+	serviceStop         context.CancelFunc = func() {}
+	serviceState        uint32
+	serviceErr          error
+)
+
+func setServiceStatus(state, accepts uint32) {
+	serviceState = state
+	status := serviceStatus{
+		ServiceType:      serviceWin32OwnProcess,
+		CurrentState:     state,
+		ControlsAccepted: accepts,
+	}
+	procSetServiceStatus.Call(serviceStatusHandle, uintptr(unsafe.Pointer(&status)))
+}
+
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceStatus(serviceStopPending, 0)
+		serviceStop()
+	case serviceControlInterrogate:
+		accepts := uint32(0)
+		if serviceState == serviceRunning {
+			accepts = serviceAcceptStop | serviceAcceptShutdown
+		}
+		setServiceStatus(serviceState, accepts)
+	}
+	return 0 // NO_ERROR
+}
+
+func serviceMain(argc, argv uintptr) uintptr {
+	h, err := winCall(procRegisterServiceCtrlHandlerExW, uintptr(unsafe.Pointer(utf16Ptr(serviceName))), syscall.NewCallback(serviceHandler), 0)
+	if err != nil {
+		serviceErr = err
+		return 0
+	}
+	serviceStatusHandle = h
+	setServiceStatus(serviceStartPending, 0)
+
+	// There is no console: logs go to the event log and progress is dropped
+	statusOut = io.Discard
+	if source, err := winCall(procRegisterEventSourceW, 0, uintptr(unsafe.Pointer(utf16Ptr(serviceName)))); err == nil {
+		log.SetOutput(redactingWriter{eventLogWriter{handle: source}})
+	} else {
+		log.SetOutput(io.Discard)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serviceStop = cancel
+	setServiceStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown)
+	serviceErr = runDaemonLoop(ctx, serviceOpts)
+	setServiceStatus(serviceStopped, 0)
+	return 0
+}
+
+// runWindowsService is what the SCM launches. When started from a console
+// instead, it falls back to running as a plain foreground daemon.
+func runWindowsService(daemonArgs []string) error {
+	opts, err := parseDaemonOptions(daemonArgs)
+	if err != nil {
+		return err
+	}
+	serviceOpts = opts
+
+	table := []serviceTableEntry{
+		{ServiceName: utf16Ptr(serviceName), ServiceProc: syscall.NewCallback(serviceMain)},
+		{}, // Terminator
+	}
+	if r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		if errors.Is(err, errorFailedServiceControllerConnect) {
+			return runDaemon(daemonArgs)
+		}
+		return fmt.Errorf("starting service dispatcher: %w", err)
+	}
+	return serviceErr
+}
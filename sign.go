@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const signatureAlgorithm = "ed25519"
+
+// jsonSignature proves that a JSON result was produced by the holder of a
+// key and not edited since. It covers the canonical form of the document
+// without the signature member.
+type jsonSignature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // Base64 of the raw 32-byte key
+	Value     string `json:"value"`      // Base64
+}
+
+// canonicalJSON is the form signatures are computed over: the document with
+// its signature removed, object keys sorted and no whitespace, the way
+// encoding/json writes a decoded value. Numbers are kept as written.
+func canonicalJSON(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v map[string]any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decoding result: %w", err)
+	}
+	delete(v, "signature")
+	return json.Marshal(v)
+}
+
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM-encoded PKCS #8 private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s holds a %T, only Ed25519 keys are supported", path, key)
+	}
+	return priv, nil
+}
+
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s is not a PEM-encoded public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s holds a %T, only Ed25519 keys are supported", path, key)
+	}
+	return pub, nil
+}
+
+// signResult sets out.Signature over the rest of out.
+func signResult(out *jsonResult, key ed25519.PrivateKey) error {
+	out.Signature = nil
+	doc, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	canonical, err := canonicalJSON(doc)
+	if err != nil {
+		return err
+	}
+	out.Signature = &jsonSignature{
+		Algorithm: signatureAlgorithm,
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(key, canonical)),
+	}
+	return nil
+}
+
+// verifyResult checks the signature of a JSON result document and returns
+// the key that made it. With a trusted key, the signature must be by it.
+func verifyResult(doc []byte, trusted ed25519.PublicKey) (ed25519.PublicKey, error) {
+	var signed struct {
+		Signature *jsonSignature `json:"signature"`
+	}
+	if err := json.Unmarshal(doc, &signed); err != nil {
+		return nil, fmt.Errorf("decoding result: %w", err)
+	}
+	sig := signed.Signature
+	if sig == nil {
+		return nil, errors.New("the result is not signed")
+	}
+	if sig.Algorithm != signatureAlgorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
+	pub, err := base64.StdEncoding.DecodeString(sig.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("malformed public key in signature")
+	}
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return nil, errors.New("malformed signature value")
+	}
+	if trusted != nil && !trusted.Equal(ed25519.PublicKey(pub)) {
+		return pub, errors.New("signed by a different key than --key")
+	}
+	canonical, err := canonicalJSON(doc)
+	if err != nil {
+		return pub, err
+	}
+	if !ed25519.Verify(pub, canonical, value) {
+		return pub, errors.New("signature mismatch: the result was modified after it was signed")
+	}
+	return pub, nil
+}
+
+func newVerifyFlagSet(keyPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli verify", flag.ContinueOnError)
+	fs.StringVar(keyPath, "key", "", "PEM public key `file` the result must be signed with")
+	return fs
+}
+
+// runVerify implements `fast-cli verify [--key pub.pem] FILE`: check the
+// signature of a result written with --sign-key. FILE may be - for stdin.
+func runVerify(args []string) error {
+	var keyPath string
+	fs := newVerifyFlagSet(&keyPath)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: fast-cli verify [--key FILE] RESULT.json")
+	}
+	var trusted ed25519.PublicKey
+	if keyPath != "" {
+		var err error
+		if trusted, err = loadVerifyKey(keyPath); err != nil {
+			return err
+		}
+	}
+	var doc []byte
+	var err error
+	if path := fs.Arg(0); path == "-" {
+		doc, err = io.ReadAll(os.Stdin)
+	} else {
+		doc, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("reading result: %w", err)
+	}
+
+	pub, err := verifyResult(doc, trusted)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signature valid, signed by Ed25519 key %s\n", base64.StdEncoding.EncodeToString(pub))
+	if trusted == nil {
+		fmt.Println("The key was taken from the result itself; pass --key to check who signed it.")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"sorted keys", `{"b": 1, "a": {"d": 2, "c": 3}}`, `{"a":{"c":3,"d":2},"b":1}`},
+		{"numbers kept as written", `{"mbps": 1.50, "big": 12345678901234567890}`, `{"big":12345678901234567890,"mbps":1.50}`},
+		{"signature dropped", `{"status": "ok", "signature": {"value": "x"}}`, `{"status":"ok"}`},
+		{"nested signature kept", `{"a": {"signature": 1}}`, `{"a":{"signature":1}}`},
+	} {
+		got, err := canonicalJSON([]byte(tc.in))
+		if err != nil || string(got) != tc.want {
+			t.Errorf("%s: got %s, %v; want %s", tc.name, got, err, tc.want)
+		}
+	}
+	if _, err := canonicalJSON([]byte(`[1, 2]`)); err == nil {
+		t.Error("canonicalJSON accepted a document that isn't an object")
+	}
+}
+
+// signedResult is a result signed with key, as --sign-key writes it.
+func signedResult(t *testing.T, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	out := newJSONResult(testResult{Download: phaseResult{Mbps: 123.4}, Upload: phaseResult{Mbps: 45.6}}, plan{})
+	if err := signResult(&out, key); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestVerifyResult(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	otherPub, otherKey, _ := ed25519.GenerateKey(nil)
+	doc := signedResult(t, key)
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		doc     []byte
+		trusted ed25519.PublicKey
+		wantErr string
+	}{
+		{"signed", doc, nil, ""},
+		{"signed by the trusted key", doc, pub, ""},
+		{"reformatted", compact.Bytes(), pub, ""},
+		{"signed by another key", doc, otherPub, "different key"},
+		{"edited", bytes.Replace(doc, []byte("123.4"), []byte("923.4"), 1), nil, "modified after it was signed"},
+		{"member added", bytes.Replace(doc, []byte("{"), []byte(`{"note": "fast",`), 1), nil, "modified after it was signed"},
+		{"key swapped", swapSignatureKey(t, doc, otherPub), nil, "modified after it was signed"},
+		{"resigned by another key", signedResult(t, otherKey), pub, "different key"},
+		{"unsigned", []byte(`{"status": "ok"}`), nil, "not signed"},
+		{"other algorithm", bytes.Replace(doc, []byte(`"ed25519"`), []byte(`"rsa"`), 1), nil, "unsupported signature algorithm"},
+		{"not JSON", []byte("speed: fast"), nil, "decoding result"},
+	} {
+		_, err := verifyResult(tc.doc, tc.trusted)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: got error %v, want one about %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+// swapSignatureKey replaces the public key of the signature in doc by pub,
+// leaving the signature value as it is.
+func swapSignatureKey(t *testing.T, doc []byte, pub ed25519.PublicKey) []byte {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		t.Fatal(err)
+	}
+	sig := v["signature"].(map[string]any)
+	sig["public_key"] = base64.StdEncoding.EncodeToString(pub)
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields of the simple format, in the order of its line
+var simpleFields = []string{"ping", "jitter", "down", "up"}
+
+// simpleFlag is --simple, which selects the simple format, and with a value
+// the one field of it to print, as a bare number.
+type simpleFlag struct {
+	format, field *string
+}
+
+func (f simpleFlag) String() string {
+	if f.field == nil {
+		return ""
+	}
+	return *f.field
+}
+
+func (f simpleFlag) Set(value string) error {
+	switch {
+	case value == "false":
+		return nil
+	case value == "true":
+		value = ""
+	case !slices.Contains(simpleFields, value):
+		return fmt.Errorf("unknown field %q, expected one of %s", value, strings.Join(simpleFields, ", "))
+	}
+	*f.format, *f.field = formatSimple, value
+	return nil
+}
+
+// IsBoolFlag lets --simple be given without a value; a field needs --simple=FIELD.
+func (f simpleFlag) IsBoolFlag() bool { return true }
+
+// writeSimple writes res as one line for shell one-liners and status bars,
+// ping=12ms jitter=2ms down=812.4Mbps up=41.2Mbps, leaving out what wasn't
+// measured, or only the number of field if it isn't empty.
+func writeSimple(w io.Writer, res testResult, field string) error {
+	ping, jitter := res.AvgPing, time.Duration(-1)
+	if len(res.IdleLatency.Samples) > 0 {
+		ping, jitter = res.IdleLatency.Avg, res.IdleLatency.Jitter
+	}
+	ms := func(d time.Duration) string { return strconv.FormatInt(d.Round(time.Millisecond).Milliseconds(), 10) }
+	mbps := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+
+	var values []struct{ Name, Value, Unit string }
+	add := func(name, value, unit string) {
+		values = append(values, struct{ Name, Value, Unit string }{name, value, unit})
+	}
+	add("ping", ms(ping), "ms")
+	if jitter >= 0 {
+		add("jitter", ms(jitter), "ms")
+	}
+	add("down", mbps(res.Download.Mbps), "Mbps")
+	if res.Upload.Bytes > 0 {
+		add("up", mbps(res.Upload.Mbps), "Mbps")
+	}
+
+	var line []string
+	for _, v := range values {
+		switch {
+		case field == "":
+			line = append(line, v.Name+"="+v.Value+v.Unit)
+		case v.Name == field:
+			_, err := fmt.Fprintln(w, v.Value)
+			return err
+		}
+	}
+	if field != "" {
+		return fmt.Errorf("%s wasn't measured in this test", field)
+	}
+	_, err := fmt.Fprintln(w, strings.Join(line, " "))
+	return err
+}
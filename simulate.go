@@ -0,0 +1,129 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// providerSimulated labels results of --simulate, in the output and the
+// history, so that they are never mistaken for measurements.
+const providerSimulated = "simulated"
+
+// simulation is a --simulate link: DOWN/UP in Mbps and optionally the idle
+// latency, e.g. 300/40/12ms.
+type simulation struct {
+	Rate    plan
+	Latency time.Duration
+}
+
+func (s simulation) IsSet() bool { return s.Rate.IsSet() }
+
+func (s simulation) String() string {
+	if !s.IsSet() {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", s.Rate, s.Latency)
+}
+
+func (s *simulation) Set(value string) error {
+	s.Latency = 20 * time.Millisecond
+	rate := value
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		if d, err := time.ParseDuration(value[i+1:]); err == nil {
+			s.Latency, rate = d, value[:i]
+		}
+	}
+	if err := s.Rate.Set(rate); err != nil {
+		return fmt.Errorf("expected DOWN/UP[/LATENCY], e.g. 300/40/12ms")
+	}
+	if s.Latency <= 0 {
+		return fmt.Errorf("the latency must be positive")
+	}
+	return nil
+}
+
+// simulateSpeed stands in for measureSpeed with --simulate: it reports the
+// same progress and returns a complete result, without a single packet
+// sent. Speeds are exactly the simulated ones; the per-interval samples and
+// latencies vary a little around them, the same way on every run.
+func simulateSpeed(opts *options) testResult {
+	sim := opts.Simulate
+	rng := rand.New(rand.NewPCG(uint64(sim.Rate.DownloadMbps), uint64(sim.Rate.UploadMbps)))
+	res := testResult{
+		ID:        newUUID(),
+		StartedAt: time.Now(),
+		Provider:  providerSimulated,
+		Client:    clientInfo{IP: "192.0.2.1", Asn: "64496", Location: location{City: "Simulated", Country: "ZZ"}},
+	}
+
+	fmt.Fprintf(statusOut, "Simulating a %s Mbps link with %s latency, nothing is sent.\n", sim.Rate, sim.Latency)
+	streams := cmp.Or(opts.Streams, numServersToTest)
+	var total time.Duration
+	for i := range streams {
+		t := target{
+			Name:     fmt.Sprintf("simulated-%d.invalid", i+1),
+			URL:      fmt.Sprintf("https://simulated-%d.invalid/speedtest", i+1),
+			Location: location{City: "Simulated", Country: "ZZ"},
+		}
+		latency := sim.Latency + time.Duration(i)*sim.Latency/20
+		res.Servers = append(res.Servers, pingedTarget{Target: t, Latency: latency})
+		total += latency
+	}
+	res.AvgPing = total / time.Duration(streams)
+
+	latencies := func(n int, base time.Duration) latencyStats {
+		samples := make([]time.Duration, n)
+		for i := range samples {
+			samples[i] = base + time.Duration(rng.NormFloat64()*0.05*float64(base))
+		}
+		return summarizeLatency(samples)
+	}
+	res.IdleLatency = latencies(idleLatencySamples, sim.Latency)
+	streamPartial(opts, "ping", res)
+
+	downloadDuration := cmp.Or(opts.DownloadDuration, downloadTestDuration)
+	fmt.Fprintln(statusOut, lang.tr("\nPerforming download test..."))
+	res.Download = simulatePhase(rng, sim.Rate.DownloadMbps, downloadDuration, downloadChunkSizeBytes, streams)
+	// A moderately buffered link: latency rises under load, more when downloading
+	res.DownloadLatency = latencies(int(downloadDuration/loadedLatencyInterval), sim.Latency*2)
+	streamPartial(opts, "download", res)
+	if opts.SkipUpload || sim.Rate.UploadMbps <= 0 {
+		return res
+	}
+
+	uploadDuration := cmp.Or(opts.UploadDuration, uploadTestDuration)
+	fmt.Fprintln(statusOut, lang.tr("\nPerforming upload test..."))
+	res.Upload = simulatePhase(rng, sim.Rate.UploadMbps, uploadDuration, uploadChunkSizeBytes, streams)
+	res.UploadLatency = latencies(int(uploadDuration/loadedLatencyInterval), sim.Latency*3/2)
+	streamPartial(opts, "upload", res)
+	return res
+}
+
+// simulatePhase returns a phase that moved mbps for d, with samples that
+// average to exactly mbps.
+func simulatePhase(rng *rand.Rand, mbps float64, d time.Duration, chunkSize, streams int) phaseResult {
+	samples := make([]float64, max(int(d/throughputSampleInterval), 1))
+	var sum float64
+	for i := range samples {
+		samples[i] = max(1+rng.NormFloat64()*0.03, 0.5)
+		sum += samples[i]
+	}
+	for i := range samples {
+		samples[i] *= mbps * float64(len(samples)) / sum
+	}
+	bytes := int64(mbps * 1e6 / 8 * d.Seconds())
+	return phaseResult{
+		Mbps:     mbps,
+		Bytes:    bytes,
+		Duration: d,
+		Samples:  samples,
+		Requests: int64(math.Ceil(float64(bytes) / float64(chunkSize))),
+		Streams:  streams,
+		// Every simulated stream lives through the phase
+		Parallelism: float64(streams),
+	}
+}
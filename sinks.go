@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	splunkEventPath     = "/services/collector/event"
+	defaultElasticIndex = "fast-cli-{2006.01.02}"
+)
+
+// exports returns the jobs that hand the outcome of a test to the sinks of
+// opts, each logging rather than returning its failure, which never fails
+// the test. Of a failed test (testErr set), only --prom-textfile, --syslog
+// and --push-url hear, the last with a result of status failed.
+func (opts *options) exports(res testResult, testErr error) []func() {
+	var jobs []func()
+	if opts.PromTextfile != "" {
+		jobs = append(jobs, func() {
+			if err := writePromTextfile(opts, res, testErr != nil); err != nil {
+				selfMetrics.sinkFailed(opts.sinkID("prom-textfile"))
+				opts.warn("writing --prom-textfile: %v", err)
+			}
+		})
+	}
+	if opts.Syslog.Network != "" {
+		jobs = append(jobs, func() {
+			if err := syslogOutcome(opts, res, testErr); err != nil {
+				selfMetrics.sinkFailed(opts.sinkID("syslog"))
+				opts.warn("%v", err)
+			}
+		})
+	}
+	if opts.CSVFile != "" && testErr == nil {
+		jobs = append(jobs, func() {
+			if err := appendCSV(opts.CSVFile, newHistoryEntry(res)); err != nil {
+				selfMetrics.sinkFailed(opts.sinkID("csv-file"))
+				opts.warn("writing --csv-file: %v", err)
+			}
+		})
+	}
+
+	sinks := resultSinks(opts)
+	out := newJSONResult(res, opts.Plan)
+	if testErr != nil {
+		sinks = slices.DeleteFunc(sinks, func(s queuedSink) bool { return !s.failures })
+		out.ID, out.Status, out.Errors = cmp.Or(out.ID, newUUID()), "failed", newJSONErrors([]error{testErr})
+		if out.StartedAt.IsZero() {
+			out.StartedAt, out.Timestamp = time.Now(), time.Now()
+		}
+	}
+	if len(sinks) == 0 {
+		return jobs
+	}
+	if opts.signingKey != nil {
+		if err := signResult(&out, opts.signingKey); err != nil {
+			opts.warn("signing result: %v", err)
+			return jobs
+		}
+	}
+	value, err := json.Marshal(out)
+	if err != nil {
+		opts.warn("encoding result: %v", err)
+		return jobs
+	}
+	msg := outboxMessage{Key: out.Host.Hostname, Value: value}
+	for _, s := range sinks {
+		jobs = append(jobs, func() {
+			if err := s.outbox.deliver(s.send, msg); err != nil {
+				opts.warn("sending result to %s: %v", s.outbox.Name, err)
+			}
+		})
+	}
+	return jobs
+}
+
+// queuedSink is a network sink whose undelivered messages wait in an outbox.
+type queuedSink struct {
+	outbox   outbox
+	send     func(m outboxMessage) error
+	failures bool // Failed tests are sent too
+}
+
+// resultSinks are the network sinks configured for results.
+func resultSinks(opts *options) []queuedSink {
+	// Sinks that take the result decoded, rather than as JSON
+	decoded := func(send func(*options, jsonResult) error) func(outboxMessage) error {
+		return func(m outboxMessage) error {
+			var out jsonResult
+			if err := json.Unmarshal(m.Value, &out); err != nil {
+				return nil // Unreadable, so it would never be delivered
+			}
+			return send(opts, out)
+		}
+	}
+	var sinks []queuedSink
+	if opts.PushTo != "" {
+		sinks = append(sinks, queuedSink{newOutbox(opts, "the collector", "collector"), decoded(pushResult), false})
+	}
+	if opts.PushURL != "" {
+		sinks = append(sinks, queuedSink{newOutbox(opts, "--push-url", "push-url"), decoded(pushToURL), true})
+	}
+	if opts.SplunkURL != "" {
+		sinks = append(sinks, queuedSink{newOutbox(opts, "Splunk", "splunk"), decoded(sendToSplunk), false})
+	}
+	if opts.ElasticURL != "" {
+		sinks = append(sinks, queuedSink{newOutbox(opts, "Elasticsearch", "elasticsearch"), decoded(sendToElastic), false})
+	}
+	if opts.Kafka.Topic != "" {
+		sinks = append(sinks, queuedSink{newOutbox(opts, "Kafka", "kafka"), func(m outboxMessage) error { return publishKafka(opts.Kafka, m) }, false})
+	}
+	if opts.NATS.URL != nil {
+		sinks = append(sinks, queuedSink{newOutbox(opts, "NATS", "nats"), func(m outboxMessage) error { return publishNATS(opts.NATS, m) }, false})
+	}
+	return sinks
+}
+
+// sendToSplunk posts the result as an event to a Splunk HTTP Event
+// Collector. A URL without a path gets the event endpoint's.
+func sendToSplunk(opts *options, out jsonResult) error {
+	event := map[string]any{
+		"time":       float64(out.StartedAt.UnixMilli()) / 1000,
+		"host":       out.Host.Hostname,
+		"source":     "fast-cli",
+		"sourcetype": opts.SplunkSourcetype,
+		"event":      out,
+	}
+	if opts.SplunkIndex != "" {
+		event["index"] = opts.SplunkIndex
+	}
+	if len(out.Tags) > 0 {
+		event["fields"] = out.Tags // Indexed, for searching by tag without parsing events
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+	u, err := url.Parse(opts.SplunkURL)
+	if err != nil {
+		return fmt.Errorf("invalid --splunk-hec URL: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = splunkEventPath
+	}
+	_, err = postResult(httpClient, u.String(), body, http.Header{
+		"Content-Type":  {"application/json"},
+		"Authorization": {"Splunk " + opts.SplunkToken},
+	})
+	return err
+}
+
+var elasticIndexLayout = regexp.MustCompile(`\{[^}]*\}`)
+
+// elasticIndex expands the time layouts in braces in the --elasticsearch-index,
+// e.g. fast-cli-{2006.01.02}, with the UTC start of the test, so that
+// each day or month gets its own index.
+func elasticIndex(pattern string, started time.Time) string {
+	return elasticIndexLayout.ReplaceAllStringFunc(pattern, func(layout string) string {
+		return started.UTC().Format(strings.Trim(layout, "{}"))
+	})
+}
+
+// sendToElastic indexes the result through the _bulk API, with the run's ID
+// as the document ID so that a retried upload doesn't count twice. _bulk
+// answers 200 even when the document was rejected, which its items tell.
+func sendToElastic(opts *options, out jsonResult) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body) // One line each, as the NDJSON of _bulk needs
+	action := map[string]map[string]string{"index": {"_index": elasticIndex(opts.ElasticIndex, out.StartedAt), "_id": out.ID}}
+	if err := enc.Encode(action); err != nil {
+		return fmt.Errorf("encoding bulk action: %w", err)
+	}
+	if err := enc.Encode(out); err != nil {
+		return fmt.Errorf("encoding result: %w", err)
+	}
+	header := http.Header{"Content-Type": {"application/x-ndjson"}}
+	if opts.ElasticAPIKey != "" {
+		header.Set("Authorization", "ApiKey "+opts.ElasticAPIKey)
+	}
+	resp, err := postResult(httpClient, strings.TrimSuffix(opts.ElasticURL, "/")+"/_bulk", body.Bytes(), header)
+	if err != nil {
+		return err
+	}
+	var bulk struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(resp, &bulk); err != nil || !bulk.Errors {
+		return nil
+	}
+	for _, item := range bulk.Items {
+		if e := item["index"].Error; e != nil {
+			return fmt.Errorf("%s: %s", e.Type, e.Reason)
+		}
+	}
+	return errors.New("the bulk request reported errors")
+}
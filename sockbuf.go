@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// byteSize is a flag of bytes, with an optional K, M or G suffix meaning
+// powers of 1024, e.g. 4M.
+type byteSize int
+
+func (b byteSize) String() string {
+	switch {
+	case b == 0:
+		return "0"
+	case b%(1<<20) == 0:
+		return fmt.Sprintf("%dM", b>>20)
+	case b%(1<<10) == 0:
+		return fmt.Sprintf("%dK", b>>10)
+	}
+	return strconv.Itoa(int(b))
+}
+
+func (b *byteSize) Set(value string) error {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(value), "B"), "I")
+	shift := 0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > (1<<31-1)>>shift {
+		return fmt.Errorf("expected a size in bytes, e.g. 262144, 512K or 4M")
+	}
+	*b = byteSize(n << shift)
+	return nil
+}
+
+// socketBuffers are the kernel buffer sizes test connections got, which can
+// be less than asked for.
+type socketBuffers struct {
+	mu            sync.Mutex
+	Send, Receive int
+}
+
+var effectiveBuffers socketBuffers
+
+func (s *socketBuffers) get() (send, receive int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Send, s.Receive
+}
+
+// socketBufferHook returns the dialer hook that sets the send and receive
+// buffers of test connections, those that are positive. It is set before
+// connecting, so that a large receive buffer can be advertised with the
+// TCP window scale of the handshake.
+func socketBufferHook(send, receive byteSize) (func(network, address string, c syscall.RawConn) error, error) {
+	if send == 0 && receive == 0 {
+		return nil, nil
+	}
+	if errNoSocketBuffers != nil {
+		return nil, errNoSocketBuffers
+	}
+	var warned sync.Once
+	return func(network, address string, c syscall.RawConn) error {
+		var got [2]int
+		for i, b := range []struct {
+			size    byteSize
+			receive bool
+		}{{send, false}, {receive, true}} {
+			if b.size == 0 {
+				continue
+			}
+			n, err := setSocketBuffer(c, b.receive, int(b.size))
+			if err != nil {
+				return fmt.Errorf("setting socket buffer: %w", err)
+			}
+			if runtime.GOOS == "linux" {
+				n /= 2 // Linux reserves as much again for its bookkeeping and reports both
+			}
+			got[i] = n
+		}
+		effectiveBuffers.mu.Lock()
+		effectiveBuffers.Send, effectiveBuffers.Receive = got[0], got[1]
+		effectiveBuffers.mu.Unlock()
+		if got[0] < int(send) || got[1] < int(receive) {
+			warned.Do(func() {
+				log.Printf("Warning: the kernel capped the socket buffers at send %s, receive %s; on Linux raise net.core.wmem_max and net.core.rmem_max", byteSize(got[0]), byteSize(got[1]))
+			})
+		}
+		return nil
+	}, nil
+}
+
+// chainControl runs the non-nil dialer hooks in order.
+func chainControl(hooks ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	var set []func(network, address string, c syscall.RawConn) error
+	for _, h := range hooks {
+		if h != nil {
+			set = append(set, h)
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		for _, h := range set {
+			if err := h(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errNoSocketBuffers = errors.New("--sndbuf and --rcvbuf are only available on Linux, macOS and the BSDs")
+
+func setSocketBuffer(c syscall.RawConn, receive bool, size int) (int, error) {
+	return 0, errNoSocketBuffers
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"cmp"
+	"syscall"
+)
+
+var errNoSocketBuffers error
+
+// setSocketBuffer sets the send or receive buffer of c to size and returns
+// the size the kernel reports it got.
+func setSocketBuffer(c syscall.RawConn, receive bool, size int) (int, error) {
+	opt := syscall.SO_SNDBUF
+	if receive {
+		opt = syscall.SO_RCVBUF
+	}
+	var got int
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, opt, size); sockErr == nil {
+			got, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+		}
+	})
+	return got, cmp.Or(err, sockErr)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStallTimeout = 5 * time.Second
+	maxStallRetries     = 3 // Stalls in a row a stream survives before it counts as failed
+)
+
+var errStalled = errors.New("no data moved within --stall-timeout")
+
+// transferLimits bound a download or upload phase.
+type transferLimits struct {
+	MaxBytes     int64         // Stop the phase after this many bytes, if positive
+	StallTimeout time.Duration // Retry a request no byte moved through for this long, if positive
+	Progress     time.Duration // Print the speed so far this often, if positive, see reportProgress
+	ProgressLog  bool          // Print Progress as timestamped lines with the bytes and current speed
+	Stabilize    bool          // End the phase early once the speed holds steady
+	Payload      string        // What upload chunks are filled with, see chunkFiller
+	Integrity    bool          // Check the size and content of download chunks, see chunkSampler
+	AutoStreams  bool          // Tune the number of streams to the speed, see streamSet
+	FixedRanges  bool          // Download the same range every chunk rather than randomRange
+	CacheBust    string        // What download requests do to keep caches from answering, see bustURL
+	Seed         uint64        // Of the streams' random numbers, see streamRand
+
+	// With AutoStreams, streams are removed while the median of the last
+	// pings of Latency is above LatencyCeiling, if positive.
+	LatencyCeiling time.Duration
+	Latency        *latencyProbe
+
+	// Servers that start a stream of their own as they arrive, up to
+	// JoinStreams of them, see selectServersEarly.
+	Join        <-chan pingedTarget
+	JoinStreams int
+}
+
+func (o *options) transferLimits() transferLimits {
+	l := transferLimits{MaxBytes: int64(o.MaxDataMB * 1e6), StallTimeout: o.StallTimeout, Stabilize: o.Quick, Payload: o.UploadPayload, Integrity: o.CheckIntegrity, AutoStreams: o.AutoStreams, FixedRanges: o.FixedRanges, CacheBust: o.CacheBust, Seed: o.Seed}
+	switch {
+	case o.LogProgress > 0:
+		l.Progress, l.ProgressLog = o.LogProgress, true
+	case o.Plain:
+		l.Progress = plainProgressInterval
+	}
+	return l
+}
+
+// stallWatch cancels a request with errStalled once no byte has moved
+// through its reader for timeout. A hung connection is retried this way
+// instead of adding nothing for the rest of the phase.
+type stallWatch struct {
+	timeout time.Duration
+	timer   *time.Timer // Nil when disabled
+	moved   atomic.Bool // Since the last check
+	drained atomic.Bool
+	idle    int         // Checks in a row that found nothing moved
+	r       stallReader // Returned by reader, without an allocation of its own
+}
+
+// A stallWatch checks for progress this many times per timeout, rather than
+// resetting a timer every few kilobytes read, so that a stall is caught up to
+// a quarter of the timeout late.
+const stallChecks = 4
+
+// watchStalls arms a stallWatch over the request about to run in ctx.
+// The watch ends with ctx.
+func watchStalls(ctx context.Context, timeout time.Duration) (context.Context, *stallWatch) {
+	w := &stallWatch{timeout: timeout}
+	if timeout <= 0 {
+		return ctx, w
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	check := timeout / stallChecks
+	w.timer = time.AfterFunc(check, func() {
+		if w.drained.Load() {
+			return
+		}
+		if w.moved.Swap(false) {
+			w.idle = 0
+		} else if w.idle++; w.idle > stallChecks {
+			cancel(errStalled)
+			return
+		}
+		w.timer.Reset(check)
+	})
+	context.AfterFunc(ctx, func() { w.timer.Stop() })
+	return ctx, w
+}
+
+// reader counts reads through r as progress. Once r is drained the watch
+// stops: an upload body is then in the socket buffer, which drains without
+// further reads, and the chunk timeout takes over.
+func (w *stallWatch) reader(r io.Reader) io.Reader {
+	if w.timer == nil {
+		return r
+	}
+	w.r = stallReader{r: r, w: w}
+	return &w.r
+}
+
+type stallReader struct {
+	r io.Reader
+	w *stallWatch
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	switch {
+	case err == io.EOF:
+		s.w.drained.Store(true)
+		s.w.timer.Stop()
+	case n > 0:
+		if !s.w.moved.Load() {
+			s.w.moved.Store(true)
+		}
+	}
+	return n, err
+}
+
+// stalled reports whether the request in ctx was cancelled by its stallWatch.
+func stalled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errStalled)
+}
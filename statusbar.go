@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Classes of a statusbar line, which bars style the module by
+const (
+	statusbarGood     = "good"
+	statusbarWarning  = "warning"  // Degraded, or under the plan's warn ratio
+	statusbarCritical = "critical" // Failed, or a threshold missed
+)
+
+// statusbarLine is the statusbar format: the JSON of a Waybar custom module
+// with "return-type": "json", one object per line, which polybar and xbar
+// scripts can take the text of with jq.
+type statusbarLine struct {
+	Text       string `json:"text"`
+	Alt        string `json:"alt"` // The class again, for Waybar's format-icons
+	Tooltip    string `json:"tooltip"`
+	Class      string `json:"class"`
+	Percentage int    `json:"percentage,omitempty"` // Of the plan's download speed
+}
+
+func newStatusbarLine(res testResult, opts *options) statusbarLine {
+	ping, jitter := res.AvgPing, time.Duration(0)
+	if len(res.IdleLatency.Samples) > 0 {
+		ping, jitter = res.IdleLatency.Avg, res.IdleLatency.Jitter
+	}
+	text := fmt.Sprintf("↓%.0f", res.Download.Mbps)
+	tooltip := []string{fmt.Sprintf("Download %.*f Mbps", opts.Precision, res.Download.Mbps)}
+	if res.Upload.Bytes > 0 {
+		text += fmt.Sprintf(" ↑%.0f", res.Upload.Mbps)
+		tooltip = append(tooltip, fmt.Sprintf("Upload %.*f Mbps", opts.Precision, res.Upload.Mbps))
+	}
+	text += " " + ping.Round(time.Millisecond).String()
+	tooltip = append(tooltip, fmt.Sprintf("Latency %s, jitter %s", ping.Round(time.Millisecond), jitter.Round(time.Millisecond)))
+
+	line := statusbarLine{Class: statusbarGood}
+	if res.Status() == "degraded" {
+		line.Class = statusbarWarning
+		tooltip = append(tooltip, fmt.Sprintf("Degraded: %d of %d streams failed", res.Download.FailedStreams+res.Upload.FailedStreams, res.Download.Streams+res.Upload.Streams))
+	}
+	if p := opts.Plan; p.IsSet() {
+		ratio := res.Download.Mbps / p.DownloadMbps
+		line.Percentage = int(min(100*ratio, 100))
+		tooltip = append(tooltip, fmt.Sprintf("%.0f%% of the %s Mbps plan", 100*ratio, p))
+		if ratio < planWarnRatio {
+			line.Class = statusbarWarning
+		}
+	}
+	if failures := opts.Thresholds.check(res); len(failures) > 0 {
+		line.Class = statusbarCritical
+		tooltip = append(tooltip, "Thresholds not met: "+strings.Join(failures, ", "))
+	}
+	tooltip = append(tooltip, "Tested "+res.StartedAt.Local().Format("15:04"))
+	line.Text, line.Alt, line.Tooltip = text, line.Class, strings.Join(tooltip, "\n")
+	return line
+}
+
+// statusbarError is the line of a failed test, so that the module shows the
+// failure rather than the last speed.
+func statusbarError(err error) statusbarLine {
+	return statusbarLine{Text: "offline", Alt: statusbarCritical, Tooltip: err.Error(), Class: statusbarCritical}
+}
+
+func writeStatusbar(w io.Writer, line statusbarLine) error {
+	return json.NewEncoder(w).Encode(line)
+}
+
+// runStatusbar tests every opts.BarInterval until interrupted, printing a
+// statusbar line after each test, for a module that keeps running rather
+// than one the bar starts again on an interval of its own.
+func runStatusbar(opts *options) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(opts.BarInterval)
+	defer ticker.Stop()
+	for {
+		var line statusbarLine
+		res, err := runSpeedTest(opts)
+		if err != nil {
+			line = statusbarError(err)
+		} else {
+			recordHistory(opts, res)
+			line = newStatusbarLine(res, opts)
+		}
+		exportResult(opts, res, err)
+		// Fails once the bar that reads it is gone
+		if err := writeStatusbar(os.Stdout, line); err != nil {
+			return fmt.Errorf("writing result: %w", err)
+		}
+		if ctx.Err() != nil {
+			return nil // Interrupted during the test
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syslogTimeout = 5 * time.Second
+	syslogPort    = "514"
+	// Structured data ID of the result fields, under the example enterprise
+	// number of RFC 5612 since fast-cli has none of its own
+	syslogSDID = "fastcli@32473"
+)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+	"ntp", "security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// syslogTarget is the --syslog flag: bare, the local syslog daemon, or with
+// a udp://, tcp:// or unix:// URL, that one.
+type syslogTarget struct {
+	Network, Addr string // Network "local" for the local daemon, empty when off
+}
+
+func (t *syslogTarget) String() string {
+	if t.Network == "local" || t.Network == "" {
+		return t.Network
+	}
+	return t.Network + "://" + t.Addr
+}
+
+func (t *syslogTarget) Set(value string) error {
+	switch value {
+	case "", "false":
+		*t = syslogTarget{}
+		return nil
+	case "true", "local":
+		*t = syslogTarget{Network: "local"}
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid syslog URL %q: %w", value, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Hostname() == "" {
+			return fmt.Errorf("syslog URL %q has no host", value)
+		}
+		*t = syslogTarget{Network: u.Scheme, Addr: net.JoinHostPort(u.Hostname(), cmp.Or(u.Port(), syslogPort))}
+	case "unix":
+		*t = syslogTarget{Network: "unix", Addr: u.Path}
+	default:
+		return fmt.Errorf("syslog URL %q must start with udp://, tcp:// or unix://", value)
+	}
+	return nil
+}
+
+// IsBoolFlag lets --syslog be given without a value; a URL needs --syslog=URL.
+func (t *syslogTarget) IsBoolFlag() bool { return true }
+
+// syslogMessage formats an RFC 5424 message, or for the local daemon the
+// older BSD format that every one of them understands, with the fields in
+// the text.
+func syslogMessage(local bool, priority int, msgID, text string, fields [][2]string) string {
+	host, _ := os.Hostname()
+	now := time.Now()
+	if local {
+		for _, f := range fields {
+			text += fmt.Sprintf(" %s=%s", f[0], strconv.Quote(f[1]))
+		}
+		return fmt.Sprintf("<%d>%s fast-cli[%d]: %s", priority, now.Format(time.Stamp), os.Getpid(), text)
+	}
+	sd := "-"
+	if len(fields) > 0 {
+		escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+		sd = "[" + syslogSDID
+		for _, f := range fields {
+			sd += fmt.Sprintf(` %s="%s"`, f[0], escape.Replace(f[1]))
+		}
+		sd += "]"
+	}
+	return fmt.Sprintf("<%d>1 %s %s fast-cli %d %s %s %s", priority, now.Format(time.RFC3339Nano), cmp.Or(host, "-"), os.Getpid(), msgID, sd, text)
+}
+
+// sendSyslog delivers one message to --syslog at the given severity and the
+// --syslog-facility, opening a new connection each time so that a
+// restarted syslog server never leaves the daemon writing into the void.
+func sendSyslog(opts *options, severity, msgID, text string, fields [][2]string) error {
+	priority := slices.Index(syslogFacilities, opts.SyslogFacility)*8 + slices.Index(syslogSeverities, severity)
+	t := opts.Syslog
+	var conn net.Conn
+	var err error
+	if t.Network == "local" {
+		conn, err = dialLocalSyslog()
+	} else {
+		network := t.Network
+		if network == "unix" {
+			network = "unixgram"
+		}
+		conn, err = net.DialTimeout(network, t.Addr, syslogTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to syslog: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(syslogTimeout))
+
+	msg := syslogMessage(t.Network == "local", priority, msgID, text, fields)
+	if t.Network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg) // Octet counting framing, RFC 6587
+	}
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("writing to syslog: %w", err)
+	}
+	return nil
+}
+
+// dialLocalSyslog connects to the local syslog daemon's socket, wherever
+// this OS keeps it.
+func dialLocalSyslog() (net.Conn, error) {
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.DialTimeout(network, path, syslogTimeout); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("no local syslog socket found, give one with --syslog=udp://HOST:514")
+}
+
+// syslogOutcome sends the result of a test, or testErr when it failed.
+func syslogOutcome(opts *options, res testResult, testErr error) error {
+	if testErr != nil {
+		return syslogError(opts, testErr)
+	}
+	return syslogResult(opts, res)
+}
+
+// syslogResult sends a result at the --syslog-severity.
+func syslogResult(opts *options, res testResult) error {
+	text := fmt.Sprintf("download %.*f Mbps, upload %.*f Mbps, latency %s, %s", opts.Precision, res.Download.Mbps, opts.Precision, res.Upload.Mbps, formatLatency(res.IdleLatency), res.Status())
+	fields := [][2]string{
+		{"id", res.ID},
+		{"status", res.Status()},
+		{"download_mbps", strconv.FormatFloat(res.Download.Mbps, 'f', 2, 64)},
+		{"upload_mbps", strconv.FormatFloat(res.Upload.Mbps, 'f', 2, 64)},
+	}
+	if res.Provider != "" {
+		fields = append(fields, [2]string{"provider", res.Provider})
+	}
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
+	}
+	if len(res.IdleLatency.Samples) > 0 {
+		fields = append(fields, [2]string{"latency_ms", ms(res.IdleLatency.Avg)}, [2]string{"jitter_ms", ms(res.IdleLatency.Jitter)})
+	}
+	if len(res.DownloadLatency.Samples) > 0 {
+		fields = append(fields, [2]string{"download_latency_ms", ms(res.DownloadLatency.Avg)})
+	}
+	if len(res.UploadLatency.Samples) > 0 {
+		fields = append(fields, [2]string{"upload_latency_ms", ms(res.UploadLatency.Avg)})
+	}
+	for _, k := range slices.Sorted(maps.Keys(res.Tags)) {
+		fields = append(fields, [2]string{"tag_" + k, res.Tags[k]})
+	}
+	return sendSyslog(opts, opts.SyslogSeverity, "result", text, fields)
+}
+
+// syslogError sends a failed test at severity err, with its exit status.
+func syslogError(opts *options, testErr error) error {
+	fields := [][2]string{{"exit_status", strconv.Itoa(exitStatus(testErr))}}
+	return sendSyslog(opts, "err", "error", "test failed: "+testErr.Error(), fields)
+}
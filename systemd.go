@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serviceName = "fast-cli"
+
+	// Generous enough to cover one full speed test, which pings the watchdog
+	// only before and after it runs
+	serviceWatchdogSec = 10 * time.Minute
+)
+
+// sdNotify sends a state update to systemd when running under a unit with
+// Type=notify. It is a no-op everywhere else.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // Abstract namespace socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+// watchdogInterval returns how often systemd expects WATCHDOG=1, which is
+// half the configured WatchdogSec, or zero when the watchdog is disabled.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // Meant for another process
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// systemdQuote quotes one ExecStart argument. Specifiers (%) and variable
+// expansion ($) are escaped so that flag values are passed through verbatim.
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	return `"` + arg + `"`
+}
+
+func execStart(binary string, args []string) string {
+	quoted := []string{systemdQuote(binary)}
+	for _, a := range args {
+		quoted = append(quoted, systemdQuote(a))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// serviceUnits renders the unit files to install, keyed by file name. In
+// timer mode a oneshot service is triggered by a timer, otherwise the daemon
+// runs permanently with readiness notification and a watchdog.
+func serviceUnits(binary string, runArgs []string, interval time.Duration, daemon, user bool) map[string]string {
+	wantedBy := "multi-user.target"
+	if user {
+		wantedBy = "default.target"
+	}
+
+	if daemon {
+		daemonArgs := append([]string{"daemon", "--interval", interval.String()}, runArgs...)
+		return map[string]string{
+			serviceName + ".service": fmt.Sprintf(`[Unit]
+Description=fast.com speed test daemon
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+WatchdogSec=%d
+Restart=on-failure
+RestartSec=30
+
+[Install]
+WantedBy=%s
+`, execStart(binary, daemonArgs), int(serviceWatchdogSec.Seconds()), wantedBy),
+		}
+	}
+
+	return map[string]string{
+		serviceName + ".service": fmt.Sprintf(`[Unit]
+Description=fast.com speed test
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, execStart(binary, runArgs)),
+		serviceName + ".timer": fmt.Sprintf(`[Unit]
+Description=Run fast.com speed test every %s
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=%d
+RandomizedDelaySec=60
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, interval, int(interval.Seconds())),
+	}
+}
+
+type installServiceFlags struct {
+	User, Daemon, Print bool
+	Interval            time.Duration
+	Dir                 string
+}
+
+func newInstallServiceFlagSet(f *installServiceFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli install-service", flag.ContinueOnError)
+	fs.BoolVar(&f.User, "user", false, "install a user service in ~/.config/systemd/user instead of a system one")
+	fs.BoolVar(&f.Daemon, "daemon", false, "install a long-running Type=notify daemon instead of a service + timer pair")
+	fs.DurationVar(&f.Interval, "interval", time.Hour, "time between speed tests")
+	fs.StringVar(&f.Dir, "dir", "", "`directory` to write units to (default depends on --user)")
+	fs.BoolVar(&f.Print, "print", false, "print the units instead of writing them")
+	return fs
+}
+
+// runInstallService implements `fast-cli install-service [flags] [-- run flags]`.
+// Everything after "--" is baked into ExecStart.
+func runInstallService(args []string) error {
+	var f installServiceFlags
+	fs := newInstallServiceFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if f.Interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	runArgs := fs.Args()
+	// Catch typos in the baked-in flags now rather than on the first run
+	validate := parseOptions
+	if f.Daemon {
+		validate = parseDaemonOptions
+	}
+	if _, err := validate(runArgs); err != nil {
+		return fmt.Errorf("invalid speed test flags: %w", err)
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating the fast-cli binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+		binary = resolved
+	}
+
+	units := serviceUnits(binary, runArgs, f.Interval, f.Daemon, f.User)
+	names := []string{serviceName + ".service"}
+	if !f.Daemon {
+		names = append(names, serviceName+".timer")
+	}
+
+	if f.Print {
+		for _, name := range names {
+			fmt.Printf("# %s\n%s\n", name, units[name])
+		}
+		return nil
+	}
+
+	if f.Dir == "" {
+		f.Dir = "/etc/systemd/system"
+		if f.User {
+			config, err := os.UserConfigDir()
+			if err != nil {
+				return fmt.Errorf("locating user config directory: %w", err)
+			}
+			f.Dir = filepath.Join(config, "systemd", "user")
+		}
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return fmt.Errorf("creating unit directory: %w", err)
+	}
+	for _, name := range names {
+		path := filepath.Join(f.Dir, name)
+		if err := os.WriteFile(path, []byte(units[name]), 0o644); err != nil {
+			return fmt.Errorf("writing unit: %w", err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+
+	systemctl := "systemctl"
+	if f.User {
+		systemctl += " --user"
+	}
+	enable := names[len(names)-1] // The timer when there is one, otherwise the service
+	fmt.Printf("Enable with:\n  %s daemon-reload\n  %s enable --now %s\n", systemctl, systemctl, enable)
+	return nil
+}
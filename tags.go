@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// resultTags are the --tag labels of a result, by key, which every output
+// and sink carries along, so that the results of many sites and links can
+// be told apart without post-processing.
+type resultTags map[string]string
+
+// Tag keys are Prometheus label names, which every other sink accepts too
+var tagKeyPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels the sinks give results already
+var reservedTagKeys = []string{"host", "provider", "via"}
+
+// String is the tags as key=value in the order of their keys.
+func (t *resultTags) String() string {
+	if t == nil {
+		return ""
+	}
+	var pairs []string
+	for _, k := range slices.Sorted(maps.Keys(*t)) {
+		pairs = append(pairs, k+"="+(*t)[k])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (t *resultTags) repeatable() {}
+
+func (t *resultTags) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	switch {
+	case !ok:
+		return fmt.Errorf("%q is not KEY=VALUE", value)
+	case !tagKeyPattern.MatchString(key):
+		return fmt.Errorf("tag key %q must be letters, digits and underscores, not starting with a digit", key)
+	case slices.Contains(reservedTagKeys, key):
+		return fmt.Errorf("tag key %q is reserved, results carry it already", key)
+	}
+	if *t == nil {
+		*t = resultTags{}
+	}
+	(*t)[key] = v
+	return nil
+}
+
+// tagsCSVColumn is the last column of history export, after those that came
+// before it, so that --csv-file keeps appending to older files right.
+var tagsCSVColumn = historyCSVColumn{"tags", func(e *historyEntry) string { return encodeTags(e.Tags) }, func(e *historyEntry, v string) (err error) {
+	e.Tags, err = decodeTags(v)
+	return err
+}}
+
+// encodeTags is the tags as a query string, the form of the tags column of
+// history export: link=starlink&location=office.
+func encodeTags(t resultTags) string {
+	q := url.Values{}
+	for k, v := range t {
+		q.Set(k, v)
+	}
+	return q.Encode()
+}
+
+func decodeTags(s string) (resultTags, error) {
+	q, err := url.ParseQuery(s)
+	if err != nil || len(q) == 0 {
+		return nil, err
+	}
+	t := resultTags{}
+	for k := range q {
+		t[k] = q.Get(k)
+	}
+	return t, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	throttleSignificance = 0.01 // p-value below which a difference counts
+	throttleMinEffect    = 0.15 // Smaller relative differences are never flagged
+)
+
+// trafficClass is one way of reaching a test server: a provider, port and
+// HTTP version. Shaping shows up as one class being consistently slower.
+type trafficClass struct {
+	Name    string
+	Servers []target
+	Client  *http.Client
+	Samples []float64 // Per-interval Mbps, ramp-up excluded, over all rounds
+	Err     error
+}
+
+func newThrottleClient(http2 bool) *http.Client {
+	transport := &http.Transport{MaxIdleConnsPerHost: 10, ForceAttemptHTTP2: http2}
+	if !http2 {
+		// A non-nil empty map is how net/http is told not to negotiate HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return &http.Client{Timeout: httpClientTimeout, Transport: transport}
+}
+
+// portScheme is the scheme spoken on a port: plain HTTP on 80 and 8080,
+// TLS everywhere else.
+func portScheme(port int) string {
+	if port == 80 || port == 8080 {
+		return "http"
+	}
+	return "https"
+}
+
+// withPort points targets at another port.
+func withPort(targets []target, port int) []target {
+	var moved []target
+	for _, t := range targets {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			continue
+		}
+		u.Scheme = portScheme(port)
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+		t.URL, t.Name = u.String(), u.Host
+		moved = append(moved, t)
+	}
+	return moved
+}
+
+// welchTest compares the means of two samples without assuming equal
+// variances. The p-value uses the normal approximation, which is close
+// enough for the dozens of samples a class collects. Consecutive samples
+// are correlated, so p-values err on the small side; throttleMinEffect
+// keeps that from flagging trivial differences.
+func welchTest(a, b []float64) (p float64) {
+	meanVar := func(x []float64) (mean, variance float64) {
+		for _, v := range x {
+			mean += v
+		}
+		mean /= float64(len(x))
+		for _, v := range x {
+			variance += (v - mean) * (v - mean)
+		}
+		return mean, variance / float64(len(x)-1)
+	}
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+	ma, va := meanVar(a)
+	mb, vb := meanVar(b)
+	se := math.Sqrt(va/float64(len(a)) + vb/float64(len(b)))
+	if se == 0 {
+		if ma == mb {
+			return 1
+		}
+		return 0
+	}
+	return math.Erfc(math.Abs(ma-mb) / se / math.Sqrt2)
+}
+
+type throttleFlags struct {
+	Duration time.Duration
+	Rounds   int
+	Ports    string
+	Streams  int
+	Format   string
+}
+
+func newThrottleFlagSet(f *throttleFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli detect-throttling", flag.ContinueOnError)
+	fs.DurationVar(&f.Duration, "duration", 8*time.Second, "download time per traffic class and round")
+	fs.IntVar(&f.Rounds, "rounds", 2, "times each class is measured, interleaved so that changing conditions hit every class alike")
+	fs.StringVar(&f.Ports, "ports", "8080", "comma-separated extra `ports` to reach the fast.com servers on")
+	fs.IntVar(&f.Streams, "streams", numServersToTest, "number of `servers` to download from in parallel")
+	fs.StringVar(&f.Format, "format", formatText, "output `format`: text or json")
+	return fs
+}
+
+type jsonTrafficClass struct {
+	Name       string  `json:"name"`
+	MedianMbps float64 `json:"median_mbps,omitempty"`
+	Samples    int     `json:"samples"`
+	Difference float64 `json:"difference,omitempty"` // Relative to the baseline, -0.3 is 30% slower
+	PValue     float64 `json:"p_value,omitempty"`
+	Flagged    bool    `json:"flagged"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// runDetectThrottling implements `fast-cli detect-throttling`: download from
+// the Netflix servers fast.com uses, from a neutral provider, on other ports
+// and over HTTP/1.1, and flag classes that are significantly slower than
+// fast.com over HTTPS and HTTP/2.
+func runDetectThrottling(args []string) error {
+	var f throttleFlags
+	if err := parseFlags(newThrottleFlagSet(&f), args); err != nil {
+		return err
+	}
+	switch {
+	case f.Duration < 2*consistencyRampUp:
+		return fmt.Errorf("--duration must be at least %s", 2*consistencyRampUp)
+	case f.Rounds < 1, f.Streams < 1:
+		return fmt.Errorf("--rounds and --streams must be at least 1")
+	case f.Format != formatText && f.Format != formatJSON:
+		return fmt.Errorf("unknown format %q, expected text or json", f.Format)
+	}
+	var ports []int
+	for _, p := range strings.Split(f.Ports, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", p)
+		}
+		ports = append(ports, port)
+	}
+
+	progress := io.Writer(os.Stdout)
+	if f.Format != formatText {
+		progress = os.Stderr
+	}
+	statusOut = io.Discard // Each class prints a line of its own instead
+
+	fmt.Fprintln(progress, "Selecting servers...")
+	pick := func(provider string) ([]target, error) {
+		_, pinged, err := selectServers(&options{Provider: provider, Streams: f.Streams})
+		var targets []target
+		for _, pt := range pinged {
+			targets = append(targets, pt.Target)
+		}
+		return targets, err
+	}
+	oca, err := pick(providerFast)
+	if err != nil {
+		return fmt.Errorf("selecting fast.com servers: %w", err)
+	}
+	classes := []*trafficClass{
+		{Name: "fast.com (Netflix) HTTPS :443 HTTP/2", Servers: oca, Client: newThrottleClient(true)},
+		{Name: "fast.com (Netflix) HTTPS :443 HTTP/1.1", Servers: oca, Client: newThrottleClient(false)},
+	}
+	for _, port := range ports {
+		name := fmt.Sprintf("fast.com (Netflix) %s :%d", strings.ToUpper(portScheme(port)), port)
+		classes = append(classes, &trafficClass{Name: name, Servers: withPort(oca, port), Client: newThrottleClient(true)})
+	}
+	neutral := &trafficClass{Name: "Cloudflare (neutral) HTTPS :443 HTTP/2", Client: newThrottleClient(true)}
+	if neutral.Servers, err = pick(providerCloudflare); err != nil {
+		neutral.Err = err
+	}
+	classes = append(classes, neutral)
+
+	for round := 1; round <= f.Rounds; round++ {
+		for _, c := range classes {
+			if c.Err != nil {
+				continue
+			}
+			fmt.Fprintf(progress, "Round %d/%d: %s...\n", round, f.Rounds, c.Name)
+			// One probe first, so that a closed port fails fast instead of after a full timeout
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, err := pingOnce(ctx, httpClient, c.Servers[0])
+			cancel()
+			if err != nil {
+				c.Err = fmt.Errorf("not reachable: %w", err)
+				continue
+			}
+			phase, err := performDownloadTest(c.Client, c.Servers, f.Duration, downloadChunkSizeBytes, transferLimits{StallTimeout: defaultStallTimeout})
+			if err != nil {
+				c.Err = err
+				continue
+			}
+			if skip := int(consistencyRampUp / throughputSampleInterval); len(phase.Samples) > skip {
+				c.Samples = append(c.Samples, phase.Samples[skip:]...)
+			}
+		}
+	}
+
+	baseline := classes[0]
+	if baseline.Err != nil || len(baseline.Samples) == 0 {
+		return fmt.Errorf("baseline measurement failed: %v", baseline.Err)
+	}
+	baseMedian := median(baseline.Samples)
+	var out []jsonTrafficClass
+	var findings []string
+	for i, c := range classes {
+		jc := jsonTrafficClass{Name: c.Name, Samples: len(c.Samples)}
+		if c.Err != nil || len(c.Samples) == 0 {
+			jc.Error = "no samples"
+			if c.Err != nil {
+				jc.Error = c.Err.Error()
+			}
+			out = append(out, jc)
+			continue
+		}
+		jc.MedianMbps = median(c.Samples)
+		if i > 0 {
+			jc.Difference = jc.MedianMbps/baseMedian - 1
+			jc.PValue = welchTest(baseline.Samples, c.Samples)
+			jc.Flagged = jc.PValue < throttleSignificance && math.Abs(jc.Difference) > throttleMinEffect
+		}
+		if jc.Flagged {
+			switch {
+			case c == neutral && jc.Difference > 0:
+				findings = append(findings, fmt.Sprintf("Netflix servers are %.0f%% slower than the neutral provider: video traffic is likely shaped", 100*(1-1/(1+jc.Difference))))
+			case jc.Difference < 0:
+				findings = append(findings, fmt.Sprintf("%s is %.0f%% slower than the baseline", c.Name, -100*jc.Difference))
+			default:
+				findings = append(findings, fmt.Sprintf("%s is %.0f%% faster than the baseline, which suggests the baseline's traffic class is shaped", c.Name, 100*jc.Difference))
+			}
+		}
+		out = append(out, jc)
+	}
+
+	if f.Format == formatJSON {
+		return writeJSON(struct {
+			Classes  []jsonTrafficClass `json:"classes"`
+			Findings []string           `json:"findings"`
+		}{out, append([]string{}, findings...)})
+	}
+
+	fmt.Println("\n--- Traffic Shaping Check ---")
+	fmt.Printf("%-40s %12s %10s %8s\n", "Traffic class", "Median", "vs first", "p")
+	formatP := func(p float64) string {
+		if p < 0.001 {
+			return "<0.001"
+		}
+		return fmt.Sprintf("%.3f", p)
+	}
+	for i, jc := range out {
+		switch {
+		case jc.Error != "":
+			fmt.Printf("%-40s skipped: %s\n", jc.Name, jc.Error)
+		case i == 0:
+			fmt.Printf("%-40s %7.2f Mbps %10s %8s\n", jc.Name, jc.MedianMbps, "baseline", "")
+		default:
+			mark := ""
+			if jc.Flagged {
+				mark = "  <- significant"
+			}
+			fmt.Printf("%-40s %7.2f Mbps %+9.0f%% %8s%s\n", jc.Name, jc.MedianMbps, 100*jc.Difference, formatP(jc.PValue), mark)
+		}
+	}
+	fmt.Println("HTTP/3 is not compared: it needs a QUIC implementation, which Go's standard library lacks.")
+	fmt.Println()
+	if len(findings) == 0 {
+		fmt.Println("No significant differences between traffic classes, no sign of shaping.")
+	}
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// requestTrace is the timing breakdown of one HTTP request.
+type requestTrace struct {
+	DNS, Connect, TLS time.Duration // Zero when skipped, e.g. on a reused connection
+	FirstByte         time.Duration // From writing the request to the first response byte
+	Total             time.Duration
+	RemoteAddr        string
+	Proto             string
+	Reused            bool
+}
+
+func (t requestTrace) String() string {
+	ms := func(d time.Duration) time.Duration { return d.Round(100 * time.Microsecond) }
+	if t.Reused {
+		return fmt.Sprintf("first byte %v  total %v", ms(t.FirstByte), ms(t.Total))
+	}
+	return fmt.Sprintf("DNS %v  connect %v  TLS %v  first byte %v  total %v", ms(t.DNS), ms(t.Connect), ms(t.TLS), ms(t.FirstByte), ms(t.Total))
+}
+
+// traceRequest times a zero-length range request on the given client.
+func traceRequest(client doer, srv target) (requestTrace, error) {
+	var t requestTrace
+	var dnsStart, connectStart, tlsStart, wrote time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.DNS = time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { t.Connect = time.Since(connectStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.TLS = time.Since(tlsStart) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.Reused = info.Reused
+			t.RemoteAddr = info.Conn.RemoteAddr().String()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { t.FirstByte = time.Since(wrote) },
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", srv.pingURL(), nil)
+	if err != nil {
+		return t, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return t, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	t.Total = time.Since(start)
+	t.Proto = resp.Proto
+	if resp.StatusCode != http.StatusOK {
+		return t, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return t, nil
+}
+
+func newTraceFlagSet(servers *stringList) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli trace", flag.ContinueOnError)
+	fs.Var(servers, "server", "trace this fast-cli serve `URL` instead of fast.com's servers (repeatable)")
+	return fs
+}
+
+// runTrace implements `fast-cli trace`: where the time goes when talking to
+// each test server, first on a fresh connection and then on a reused one.
+// The difference is why HTTP pings read higher than ICMP ones.
+func runTrace(args []string) error {
+	var servers stringList
+	if err := parseFlags(newTraceFlagSet(&servers), args); err != nil {
+		return err
+	}
+	targets := peerTargets(servers)
+	if len(servers) == 0 {
+		apiResp, err := fetchTestServers(httpClient, defaultURLCount)
+		if err != nil {
+			return fmt.Errorf("fetching test servers: %w", err)
+		}
+		targets = apiResp.Targets
+	}
+
+	for _, srv := range targets {
+		// A client per server, so that the first request never reuses a connection
+		transport := &http.Transport{ForceAttemptHTTP2: true}
+		client := &http.Client{Timeout: httpClientTimeout, Transport: transport}
+
+		fmt.Printf("%s (%s, %s)\n", targetHost(srv), srv.Location.City, srv.Location.Country)
+		cold, err := traceRequest(client, srv)
+		if err != nil {
+			fmt.Printf("  failed: %v\n\n", err)
+			transport.CloseIdleConnections()
+			continue
+		}
+		fmt.Printf("  %s via %s\n  new connection:    %s\n", cold.RemoteAddr, cold.Proto, cold)
+		if warm, err := traceRequest(client, srv); err == nil {
+			fmt.Printf("  reused connection: %s\n", warm)
+		} else {
+			fmt.Printf("  reused connection: failed: %v\n", err)
+		}
+		fmt.Println()
+		transport.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	throughputSampleInterval = 250 * time.Millisecond // Granularity of per-interval throughput samples
+	consistencyRampUp        = 2 * time.Second        // Samples ignored while TCP slow start ramps up
+	dataCapPollInterval      = 20 * time.Millisecond  // How often transferred bytes are checked against --max-data
+
+	// A chunk request may take chunkTimeoutFactor times as long as the
+	// stream's speed so far says it should, and firstChunkTimeout until the
+	// stream has completed one.
+	chunkTimeoutFactor = 3
+	minChunkTimeout    = 10 * time.Second // Above defaultStallTimeout, so that a hung request is retried first
+	firstChunkTimeout  = 30 * time.Second
+)
+
+// phaseResult is what a download or upload test measured
+type phaseResult struct {
+	Mbps     float64
+	Bytes    int64         // Bytes of completed chunks (all bytes if capped), which Mbps is computed from
+	Duration time.Duration // Nominal phase duration, or the time until the data cap was hit
+	Samples  []float64     // Aggregate throughput in Mbps per throughputSampleInterval
+
+	Requests       int64 // Chunk requests started
+	FailedRequests int   // Stalled requests, and the one each failed stream stopped at
+	Stalls         int   // Requests retried after moving no data for --stall-timeout
+	Streams        int
+	FailedStreams  int           // Streams that died before the phase ended
+	Connections    int           // TCP connections the requests went over
+	StreamsPerConn int           // Most requests one connection carried at once, above 1 with HTTP/2 multiplexing
+	PerServer      []serverStats // One per stream, in the order of the servers
+	Parallelism    float64       // Streams up on average over the phase
+	Resources      resourceUsage // Of the test process during the phase
+	AutoStreams    int           // Streams --auto-streams settled on, 0 without it
+	Payload        string        // Of upload chunks, see chunkFiller
+
+	Integrity *integrityReport // Of download content with --check-integrity
+	Cache     *cacheReport     // Of download responses, nil when no cache showed
+	Joined    []pingedTarget   // Servers that joined the download as they answered their ping
+}
+
+// serverStats is what one server's stream did in a phase.
+type serverStats struct {
+	Host     string
+	Bytes    int64 // Of completed chunks
+	Requests int
+	Failed   int           // Stalled requests, and the one the stream died at
+	Active   time.Duration // Until the stream died or the phase ended
+
+	Integrity *streamIntegrity // Of download chunks with --check-integrity
+	Cache     streamCache      // Headers of download responses
+}
+
+// aggregateStreams computes the speed of a phase in which streams died from
+// each stream's speed over the time it was up. All bytes over the whole
+// phase would count a stream that died early as idle for the rest of it.
+// It also returns how many streams were up on average.
+func aggregateStreams(streams []serverStats, d time.Duration) (mbps, parallelism float64) {
+	for _, s := range streams {
+		active := min(s.Active, d)
+		if active <= 0 {
+			continue
+		}
+		mbps += toMbps(s.Bytes, active)
+		parallelism += active.Seconds() / d.Seconds()
+	}
+	return mbps, parallelism
+}
+
+// ErrorRate is the percentage of chunk requests that failed.
+func (p phaseResult) ErrorRate() float64 {
+	if p.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(p.FailedRequests) / float64(p.Requests)
+}
+
+// countingReader adds every byte read through it to a shared counter, so that
+// throughput can be sampled while a chunk is still in flight.
+type countingReader struct {
+	r       io.Reader
+	counter *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
+
+// chunkReader reads the chunks of one stream through a countingReader, both
+// reused from chunk to chunk so that the hot path allocates nothing per chunk
+// beyond what net/http does.
+type chunkReader struct {
+	counted countingReader
+	upload  bytes.Reader
+	buf     []byte        // Download reads, allocated on the first
+	sample  *chunkSampler // Of downloads with --check-integrity
+}
+
+// Downloads read in drainBufferSize pieces, which net/http passes to the
+// socket as they are, rather than through its 4 KiB buffer or the 8 KiB ones
+// io.Copy to io.Discard takes. Splicing the socket to /dev/null would save
+// the copy as well, but net/http only hands out a body behind its buffering,
+// TLS and chunked decoding.
+const drainBufferSize = 256 << 10
+
+func newChunkReader(counter *int64) *chunkReader {
+	return &chunkReader{counted: countingReader{counter: counter}}
+}
+
+// drain reads a download body, of the range from offset, to the end and
+// returns its size.
+func (c *chunkReader) drain(body io.Reader, offset int64, watch *stallWatch) (int64, error) {
+	if c.buf == nil {
+		c.buf = make([]byte, drainBufferSize)
+	}
+	c.counted.r = body
+	r := watch.reader(&c.counted)
+	if c.sample != nil {
+		c.sample.reset(offset)
+	}
+	var written int64
+	for {
+		n, err := r.Read(c.buf)
+		written += int64(n)
+		if c.sample != nil {
+			c.sample.write(c.buf[:n])
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// body returns chunk as the body of an upload request, valid until the next
+// call, which only comes once the request before has been answered.
+func (c *chunkReader) body(chunk []byte, watch *stallWatch) io.Reader {
+	c.upload.Reset(chunk)
+	c.counted.r = &c.upload
+	return watch.reader(&c.counted)
+}
+
+// chunkTimeout bounds a chunk request of a stream that moved bytes in
+// elapsed, so that a stalled chunk fails the stream instead of holding it
+// until the end of the phase, however long chunks take on a slow link.
+func chunkTimeout(chunkSize int, bytes int64, elapsed time.Duration) time.Duration {
+	if bytes <= 0 || elapsed <= 0 {
+		return firstChunkTimeout
+	}
+	expected := time.Duration(float64(chunkSize) / float64(bytes) * float64(elapsed))
+	return max(chunkTimeoutFactor*expected, minChunkTimeout)
+}
+
+// chunkError tells a chunk that exceeded its chunkTimeout, or the last
+// stall a stream gave up at, from other failures.
+func chunkError(reqCtx context.Context, timeout time.Duration, err error) error {
+	if stalled(reqCtx) {
+		return fmt.Errorf("stalled %d times in a row: %w", maxStallRetries+1, context.Cause(reqCtx))
+	}
+	if errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("chunk stalled, not done after %s: %w", timeout.Round(100*time.Millisecond), err)
+	}
+	return err
+}
+
+// toBps turns Mbps into whole bits per second.
+func toBps(mbps float64) int64 {
+	return int64(math.Round(mbps * 1e6))
+}
+
+func toMbps(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return (float64(bytes) * 8) / (elapsed.Seconds() * 1000000)
+}
+
+// sampleThroughput records how fast counter grows during each interval until
+// ctx is done, then delivers the collected samples on the returned channel.
+func sampleThroughput(ctx context.Context, counter *int64, interval time.Duration) <-chan []float64 {
+	out := make(chan []float64, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var samples []float64
+		var lastBytes int64
+		lastTime := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				out <- samples
+				return
+			case now := <-ticker.C:
+				current := atomic.LoadInt64(counter)
+				samples = append(samples, toMbps(current-lastBytes, now.Sub(lastTime)))
+				lastBytes, lastTime = current, now
+			}
+		}
+	}()
+	return out
+}
+
+// stopAtDataCap cancels the phase once counter reaches limit bytes. The
+// returned flag reports whether that happened; a zero limit disables the cap.
+func stopAtDataCap(ctx context.Context, cancel context.CancelFunc, counter *int64, limit int64) *atomic.Bool {
+	capped := new(atomic.Bool)
+	if limit <= 0 {
+		return capped
+	}
+	go func() {
+		ticker := time.NewTicker(dataCapPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if atomic.LoadInt64(counter) >= limit {
+					capped.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return capped
+}
+
+// consistencyStats describes how steady throughput was over a phase.
+type consistencyStats struct {
+	P10, P90 float64
+	Ratio    float64 // p10/p90, 1.0 means perfectly steady
+	CV       float64 // Coefficient of variation (stddev / mean)
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Round(p / 100 * float64(len(sorted)-1)))
+	return sorted[idx]
+}
+
+// measureConsistency ignores the ramp-up samples (when there are enough left
+// afterwards) since slow start would otherwise make every line look unsteady.
+func measureConsistency(samples []float64) (consistencyStats, bool) {
+	skip := int(consistencyRampUp / throughputSampleInterval)
+	if len(samples)-skip >= 4 {
+		samples = samples[skip:]
+	}
+	if len(samples) < 2 {
+		return consistencyStats{}, false
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, s := range sorted {
+		sum += s
+	}
+	mean := sum / float64(len(sorted))
+	var variance float64
+	for _, s := range sorted {
+		variance += (s - mean) * (s - mean)
+	}
+	variance /= float64(len(sorted))
+
+	stats := consistencyStats{P10: percentile(sorted, 10), P90: percentile(sorted, 90)}
+	if stats.P90 > 0 {
+		stats.Ratio = stats.P10 / stats.P90
+	}
+	if mean > 0 {
+		stats.CV = math.Sqrt(variance) / mean
+	}
+	return stats, true
+}
+
+func consistencyRating(l locale, ratio float64) string {
+	switch {
+	case ratio >= 0.8:
+		return l.tr("steady")
+	case ratio >= 0.5:
+		return l.tr("variable")
+	default:
+		return l.tr("unstable")
+	}
+}
+
+// formatConsistency renders e.g. "0.85 p10/p90, CV 0.08 (steady)" or "N/A".
+func formatConsistency(l locale, samples []float64) string {
+	stats, ok := measureConsistency(samples)
+	if !ok {
+		return l.tr("N/A")
+	}
+	return fmt.Sprintf("%.2f p10/p90, CV %.2f (%s)", stats.Ratio, stats.CV, consistencyRating(l, stats.Ratio))
+}
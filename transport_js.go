@@ -0,0 +1,13 @@
+//go:build js && wasm
+
+package main
+
+import "net/http"
+
+// newHTTPTransport returns the transport of httpClient. Under js/wasm, Go's
+// transport sends requests through the browser's Fetch API as long as it has
+// no custom dialer. The browser then owns connections, HTTP versions and
+// TLS, and test servers must allow the page's origin with CORS.
+func newHTTPTransport() http.RoundTripper {
+	return &http.Transport{}
+}
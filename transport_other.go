@@ -0,0 +1,21 @@
+//go:build !js
+
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// newHTTPTransport returns the transport of httpClient: pooled TCP
+// connections, dialed by testDialer, with HTTP/2 where the server
+// offers it.
+func newHTTPTransport() http.RoundTripper {
+	return &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+		DialContext:         testDialer.DialContext,
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// UDP test protocol between `fast-cli udp` and `fast-cli serve`. Every
+// datagram starts with a fixed header; data packets are padded to the
+// requested size.
+//
+//	0  magic "FCUD"
+//	4  packet type
+//	8  session ID, random per client run
+//	12 sequence number, or a count for control packets
+//	20 send time in Unix nanoseconds
+//	28 type-specific payload
+const (
+	udpMagic      = "FCUD"
+	udpHeaderSize = 28
+
+	udpData        = 1 // Test traffic, in either direction
+	udpReportReq   = 2 // Client to server: upload done, seq = datagrams sent
+	udpReport      = 3 // Server to client: receiver stats for the upload
+	udpDownloadReq = 4 // Client to server: payload = rate, duration, size, cookie
+	udpCookie      = 5 // Server to client: cookie to repeat in the download request
+	udpDownloadEnd = 6 // Server to client: download done, seq = datagrams sent
+
+	udpMinPacket      = udpHeaderSize + 8
+	udpMaxPacket      = 9000
+	udpMaxRateMbps    = 1000             // The most a peer sends on request
+	udpMaxDuration    = 60 * time.Second // The longest a peer sends on request
+	udpSessionTimeout = 2 * time.Minute
+	udpControlTimeout = 500 * time.Millisecond
+	udpControlRetries = 5
+)
+
+type udpHeader struct {
+	Type    byte
+	Session uint32
+	Seq     uint64
+	SentAt  int64 // Nanoseconds on the sender's monotonic clock, see monoNanos
+}
+
+func (h udpHeader) marshal(buf []byte) {
+	copy(buf, udpMagic)
+	buf[4] = h.Type
+	binary.BigEndian.PutUint32(buf[8:], h.Session)
+	binary.BigEndian.PutUint64(buf[12:], h.Seq)
+	binary.BigEndian.PutUint64(buf[20:], uint64(h.SentAt))
+}
+
+func parseUDPHeader(buf []byte) (udpHeader, bool) {
+	if len(buf) < udpHeaderSize || string(buf[:4]) != udpMagic {
+		return udpHeader{}, false
+	}
+	return udpHeader{
+		Type:    buf[4],
+		Session: binary.BigEndian.Uint32(buf[8:]),
+		Seq:     binary.BigEndian.Uint64(buf[12:]),
+		SentAt:  int64(binary.BigEndian.Uint64(buf[20:])),
+	}, true
+}
+
+// udpStats is what the receiving end of a UDP test counted.
+type udpStats struct {
+	Sent      uint64 // As reported by the sender, zero if the report was lost
+	Received  uint64
+	Bytes     uint64
+	Reordered uint64 // Arrived after a packet with a higher sequence number
+	MaxSeq    uint64
+	Elapsed   time.Duration // First to last arrival
+	Jitter    time.Duration // RFC 3550 interarrival jitter
+}
+
+func (s udpStats) marshal(buf []byte) {
+	for i, v := range []uint64{s.Received, s.Bytes, s.Reordered, s.MaxSeq, uint64(s.Elapsed), uint64(s.Jitter)} {
+		binary.BigEndian.PutUint64(buf[8*i:], v)
+	}
+}
+
+func parseUDPStats(buf []byte) (udpStats, bool) {
+	if len(buf) < 48 {
+		return udpStats{}, false
+	}
+	v := func(i int) uint64 { return binary.BigEndian.Uint64(buf[8*i:]) }
+	return udpStats{Received: v(0), Bytes: v(1), Reordered: v(2), MaxSeq: v(3), Elapsed: time.Duration(v(4)), Jitter: time.Duration(v(5))}, true
+}
+
+// Lost is the number of datagrams that never arrived. Without the sender's
+// count, the highest sequence number stands in for it.
+func (s udpStats) Lost() uint64 {
+	expected := max(s.Sent, s.MaxSeq+1)
+	if s.Received == 0 && s.Sent == 0 {
+		return 0
+	}
+	return expected - min(s.Received, expected)
+}
+
+func (s udpStats) LossPercent() float64 {
+	if expected := s.Received + s.Lost(); expected > 0 {
+		return 100 * float64(s.Lost()) / float64(expected)
+	}
+	return 0
+}
+
+func (s udpStats) GoodputMbps() float64 {
+	return toMbps(int64(s.Bytes), s.Elapsed)
+}
+
+// udpReceiver accounts for the data packets of one test.
+type udpReceiver struct {
+	stats       udpStats
+	first       time.Time
+	last        time.Time
+	lastTransit time.Duration
+	jitter      float64
+}
+
+func (r *udpReceiver) add(h udpHeader, size int, now time.Time) {
+	if r.stats.Received == 0 {
+		r.first = now
+	} else if h.Seq < r.stats.MaxSeq {
+		r.stats.Reordered++
+	}
+	// Transit times include the offset between the clocks of the hosts, which
+	// cancels out in the difference between consecutive packets
+	transit := now.Sub(monoEpoch) - time.Duration(h.SentAt)
+	if r.stats.Received > 0 {
+		d := math.Abs(float64(transit - r.lastTransit))
+		r.jitter += (d - r.jitter) / 16
+	}
+	r.lastTransit, r.last = transit, now
+	r.stats.Received++
+	r.stats.Bytes += uint64(size)
+	r.stats.MaxSeq = max(r.stats.MaxSeq, h.Seq)
+}
+
+func (r *udpReceiver) result() udpStats {
+	s := r.stats
+	s.Elapsed = r.last.Sub(r.first)
+	s.Jitter = time.Duration(r.jitter)
+	return s
+}
+
+// sendUDPPaced sends size-byte data packets at rateMbps for duration and
+// returns how many it sent.
+func sendUDPPaced(ctx context.Context, send func([]byte) error, session uint32, rateMbps float64, duration time.Duration, size int) uint64 {
+	buf := make([]byte, size)
+	rand.Read(buf[udpHeaderSize:]) // Incompressible, like the TCP tests
+	perSecond := rateMbps * 1e6 / 8 / float64(size)
+	start := time.Now()
+	var seq uint64
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= duration || ctx.Err() != nil {
+			return seq
+		}
+		// Catch up to where the rate says we should be, then sleep a little
+		for due := uint64(elapsed.Seconds() * perSecond); seq < due; seq++ {
+			udpHeader{Type: udpData, Session: session, Seq: seq, SentAt: monoNanos()}.marshal(buf)
+			if err := send(buf); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+				// ENOBUFS and friends: the datagram is lost, which the receiver counts
+				continue
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// udpPeer is the UDP side of `fast-cli serve`.
+type udpPeer struct {
+	conn   *net.UDPConn
+	secret []byte
+
+	mu       sync.Mutex
+	sessions map[uint32]*udpPeerSession
+}
+
+type udpPeerSession struct {
+	receiver udpReceiver
+	seen     time.Time
+	sending  bool
+}
+
+// cookie ties a download request to the address it came from. Only a client
+// that receives packets at that address can send a valid request, so the
+// peer can't be used to flood a spoofed victim.
+func (p *udpPeer) cookie(addr *net.UDPAddr) uint64 {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(addr.String()))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+func (p *udpPeer) session(id uint32, now time.Time) *udpPeerSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.sessions[id]
+	if !ok {
+		for old, idle := range p.sessions {
+			if now.Sub(idle.seen) > udpSessionTimeout {
+				delete(p.sessions, old)
+			}
+		}
+		s = &udpPeerSession{}
+		p.sessions[id] = s
+	}
+	s.seen = now
+	return s
+}
+
+func (p *udpPeer) serve(ctx context.Context) {
+	buf := make([]byte, 64*1024)
+	for ctx.Err() == nil {
+		n, addr, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Warning: UDP read: %v", err)
+			}
+			continue
+		}
+		h, ok := parseUDPHeader(buf[:n])
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		s := p.session(h.Session, now)
+
+		switch h.Type {
+		case udpData:
+			p.mu.Lock()
+			s.receiver.add(h, n, now)
+			p.mu.Unlock()
+		case udpReportReq:
+			p.mu.Lock()
+			stats := s.receiver.result()
+			p.mu.Unlock()
+			reply := make([]byte, udpHeaderSize+48)
+			udpHeader{Type: udpReport, Session: h.Session, SentAt: int64(now.Sub(monoEpoch))}.marshal(reply)
+			stats.marshal(reply[udpHeaderSize:])
+			p.conn.WriteToUDP(reply, addr)
+		case udpDownloadReq:
+			p.handleDownloadRequest(ctx, h, buf[udpHeaderSize:n], addr, s)
+		}
+	}
+}
+
+func (p *udpPeer) handleDownloadRequest(ctx context.Context, h udpHeader, payload []byte, addr *net.UDPAddr, s *udpPeerSession) {
+	if len(payload) < 24 {
+		return
+	}
+	rate := math.Float64frombits(binary.BigEndian.Uint64(payload))
+	duration := time.Duration(binary.BigEndian.Uint64(payload[8:]))
+	size := int(binary.BigEndian.Uint32(payload[16:]))
+	cookie := p.cookie(addr)
+	if len(payload) < 32 || binary.BigEndian.Uint64(payload[24:]) != cookie {
+		reply := make([]byte, udpHeaderSize+8)
+		udpHeader{Type: udpCookie, Session: h.Session, SentAt: monoNanos()}.marshal(reply)
+		binary.BigEndian.PutUint64(reply[udpHeaderSize:], cookie)
+		p.conn.WriteToUDP(reply, addr)
+		return
+	}
+
+	p.mu.Lock()
+	if s.sending {
+		p.mu.Unlock()
+		return // A retried request while already sending
+	}
+	s.sending = true
+	p.mu.Unlock()
+
+	rate = min(max(rate, 0.01), udpMaxRateMbps)
+	duration = min(max(duration, time.Second), udpMaxDuration)
+	size = min(max(size, udpMinPacket), udpMaxPacket)
+	go func() {
+		send := func(b []byte) error { _, err := p.conn.WriteToUDP(b, addr); return err }
+		sent := sendUDPPaced(ctx, send, h.Session, rate, duration, size)
+		end := make([]byte, udpHeaderSize)
+		for range 3 { // The client only learns the total from these, so a few copies
+			udpHeader{Type: udpDownloadEnd, Session: h.Session, Seq: sent, SentAt: monoNanos()}.marshal(end)
+			send(end)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+// listenUDPPeer starts answering UDP tests on addr until ctx is done.
+func listenUDPPeer(ctx context.Context, addr string) (net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolving UDP address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on UDP: %w", err)
+	}
+	conn.SetReadBuffer(4 << 20)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating cookie secret: %w", err)
+	}
+	p := &udpPeer{conn: conn, secret: secret, sessions: map[uint32]*udpPeerSession{}}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go p.serve(ctx)
+	return conn.LocalAddr(), nil
+}
+
+type udpFlags struct {
+	Server    string
+	RateMbps  float64
+	Duration  time.Duration
+	Size      int
+	Direction string
+	Format    string
+}
+
+func newUDPFlagSet(f *udpFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli udp", flag.ContinueOnError)
+	fs.StringVar(&f.Server, "server", "", "fast-cli serve peer `HOST:PORT` to test against")
+	fs.Float64Var(&f.RateMbps, "rate", 100, "offered load in `Mbps`; UDP has no congestion control, so loss shows where the path gives up")
+	fs.DurationVar(&f.Duration, "duration", 10*time.Second, "length of each direction")
+	fs.IntVar(&f.Size, "size", 1200, "datagram size in `bytes`, headers of IP and UDP excluded")
+	fs.StringVar(&f.Direction, "direction", "both", "upload, download or both")
+	fs.StringVar(&f.Format, "format", formatText, "output `format`: text or json")
+	return fs
+}
+
+// udpExchange sends a control packet until a reply of the wanted type for
+// this session arrives.
+func udpExchange(conn *net.UDPConn, req []byte, session uint32, want byte) ([]byte, error) {
+	buf := make([]byte, 64*1024)
+	for range udpControlRetries {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(udpControlTimeout)
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if h, ok := parseUDPHeader(buf[:n]); ok && h.Session == session && h.Type == want {
+				return buf[udpHeaderSize:n], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no answer from the peer, is fast-cli serve running with UDP enabled and the port open?")
+}
+
+func udpUpload(conn *net.UDPConn, session uint32, f udpFlags) (udpStats, error) {
+	send := func(b []byte) error { _, err := conn.Write(b); return err }
+	sent := sendUDPPaced(context.Background(), send, session, f.RateMbps, f.Duration, f.Size)
+	time.Sleep(100 * time.Millisecond) // Let the last datagrams land before asking
+
+	req := make([]byte, udpHeaderSize)
+	udpHeader{Type: udpReportReq, Session: session, Seq: sent, SentAt: monoNanos()}.marshal(req)
+	payload, err := udpExchange(conn, req, session, udpReport)
+	if err != nil {
+		return udpStats{}, err
+	}
+	stats, ok := parseUDPStats(payload)
+	if !ok {
+		return udpStats{}, fmt.Errorf("malformed report from the peer")
+	}
+	stats.Sent = sent
+	return stats, nil
+}
+
+func udpDownload(conn *net.UDPConn, session uint32, f udpFlags) (udpStats, error) {
+	req := make([]byte, udpHeaderSize+32)
+	udpHeader{Type: udpDownloadReq, Session: session, SentAt: monoNanos()}.marshal(req)
+	binary.BigEndian.PutUint64(req[udpHeaderSize:], math.Float64bits(f.RateMbps))
+	binary.BigEndian.PutUint64(req[udpHeaderSize+8:], uint64(f.Duration))
+	binary.BigEndian.PutUint32(req[udpHeaderSize+16:], uint32(f.Size))
+	cookie, err := udpExchange(conn, req[:udpHeaderSize+24], session, udpCookie)
+	if err != nil {
+		return udpStats{}, err
+	}
+	copy(req[udpHeaderSize+24:], cookie[:8])
+	if _, err := conn.Write(req); err != nil {
+		return udpStats{}, err
+	}
+
+	var r udpReceiver
+	buf := make([]byte, 64*1024)
+	deadline := time.Now().Add(f.Duration + 2*time.Second)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break // The end packets were lost too, MaxSeq stands in for the count
+		}
+		if err != nil {
+			return udpStats{}, err
+		}
+		h, ok := parseUDPHeader(buf[:n])
+		if !ok || h.Session != session {
+			continue
+		}
+		if h.Type == udpDownloadEnd {
+			stats := r.result()
+			stats.Sent = h.Seq
+			return stats, nil
+		}
+		if h.Type == udpData {
+			r.add(h, n, time.Now())
+		}
+	}
+	return r.result(), nil
+}
+
+type jsonUDPDirection struct {
+	Sent        uint64  `json:"sent"`
+	Received    uint64  `json:"received"`
+	Lost        uint64  `json:"lost"`
+	LossPercent float64 `json:"loss_percent"`
+	Reordered   uint64  `json:"reordered"`
+	GoodputMbps float64 `json:"goodput_mbps"`
+	JitterMs    float64 `json:"jitter_ms"`
+}
+
+func newJSONUDPDirection(s udpStats) *jsonUDPDirection {
+	return &jsonUDPDirection{
+		Sent:        s.Sent,
+		Received:    s.Received,
+		Lost:        s.Lost(),
+		LossPercent: s.LossPercent(),
+		Reordered:   s.Reordered,
+		GoodputMbps: s.GoodputMbps(),
+		JitterMs:    durationMs(s.Jitter),
+	}
+}
+
+func formatUDPStats(s udpStats) string {
+	return fmt.Sprintf("goodput %.2f Mbps, %.2f%% lost (%d of %d), %d reordered, jitter %v",
+		s.GoodputMbps(), s.LossPercent(), s.Lost(), s.Received+s.Lost(), s.Reordered, s.Jitter.Round(time.Microsecond))
+}
+
+// runUDP implements `fast-cli udp`: UDP goodput, loss and reordering against
+// a fast-cli serve peer, at a fixed offered load.
+func runUDP(args []string) error {
+	var f udpFlags
+	if err := parseFlags(newUDPFlagSet(&f), args); err != nil {
+		return err
+	}
+	switch {
+	case f.Server == "":
+		return fmt.Errorf("--server is required: the HOST:PORT of a fast-cli serve peer")
+	case f.RateMbps <= 0 || f.RateMbps > udpMaxRateMbps:
+		return fmt.Errorf("--rate must be between 0 and %d Mbps", udpMaxRateMbps)
+	case f.Duration < time.Second || f.Duration > udpMaxDuration:
+		return fmt.Errorf("--duration must be between 1s and %s", udpMaxDuration)
+	case f.Size < udpMinPacket || f.Size > udpMaxPacket:
+		return fmt.Errorf("--size must be between %d and %d bytes", udpMinPacket, udpMaxPacket)
+	case f.Direction != "upload" && f.Direction != "download" && f.Direction != "both":
+		return fmt.Errorf("--direction must be upload, download or both")
+	case f.Format != formatText && f.Format != formatJSON:
+		return fmt.Errorf("unknown format %q, expected text or json", f.Format)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", f.Server)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", f.Server, err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", f.Server, err)
+	}
+	defer conn.Close()
+	conn.SetReadBuffer(4 << 20) // Bursts at high rates overflow the default buffer
+	var id [4]byte
+	rand.Read(id[:])
+	session := binary.BigEndian.Uint32(id[:])
+
+	progress := os.Stdout
+	if f.Format != formatText {
+		progress = os.Stderr
+	}
+	fmt.Fprintf(progress, "UDP test against %s: %d-byte datagrams at %g Mbps for %s\n", raddr, f.Size, f.RateMbps, f.Duration)
+
+	var out struct {
+		Upload   *jsonUDPDirection `json:"upload,omitempty"`
+		Download *jsonUDPDirection `json:"download,omitempty"`
+	}
+	if f.Direction != "download" {
+		fmt.Fprintln(progress, "Sending...")
+		stats, err := udpUpload(conn, session, f)
+		if err != nil {
+			return fmt.Errorf("upload: %w", err)
+		}
+		out.Upload = newJSONUDPDirection(stats)
+		if f.Format == formatText {
+			fmt.Printf("Upload:   %s\n", formatUDPStats(stats))
+		}
+	}
+	if f.Direction != "upload" {
+		fmt.Fprintln(progress, "Receiving...")
+		stats, err := udpDownload(conn, session, f)
+		if err != nil {
+			return fmt.Errorf("download: %w", err)
+		}
+		out.Download = newJSONUDPDirection(stats)
+		if f.Format == formatText {
+			fmt.Printf("Download: %s\n", formatUDPStats(stats))
+		}
+	}
+	if f.Format == formatJSON {
+		return writeJSON(out)
+	}
+	return nil
+}
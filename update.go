@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Release builds set these with -ldflags "-X main.version=... -X main.updatePublicKey=..."
+var (
+	version = "0.1"
+	// Base64 ed25519 public key that signs checksums.txt. When empty, updates
+	// are verified by checksum only.
+	updatePublicKey = ""
+)
+
+const (
+	updateRepo        = "sh4dowb/fast-cli"
+	releasesAPI       = "https://api.github.com/repos/" + updateRepo + "/releases/latest"
+	checksumsAsset    = "checksums.txt"
+	checksumsSigAsset = "checksums.txt.sig"
+	maxUpdateSize     = 100 * 1024 * 1024 // Refuse to buffer anything larger than this
+)
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	HTMLURL string        `json:"html_url"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func (r githubRelease) asset(name string) (githubAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return githubAsset{}, false
+}
+
+// releaseAssetName is the binary built for this platform, e.g. fast-cli_linux_arm64.
+func releaseAssetName() string {
+	name := fmt.Sprintf("fast-cli_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// newerVersion reports whether latest is a higher dotted version than
+// current. A leading "v" and any pre-release suffix are ignored.
+func newerVersion(latest, current string) bool {
+	parse := func(v string) []int {
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	l, c := parse(latest), parse(current)
+	for i := 0; i < max(len(l), len(c)); i++ {
+		var lv, cv int
+		if i < len(l) {
+			lv = l[i]
+		}
+		if i < len(c) {
+			cv = c[i]
+		}
+		if lv != cv {
+			return lv > cv
+		}
+	}
+	return false
+}
+
+func httpGet(client doer, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("GET %s: response larger than %d bytes", url, limit)
+	}
+	return data, nil
+}
+
+func fetchLatestRelease() (githubRelease, error) {
+	var release githubRelease
+	data, err := httpGet(httpClient, releasesAPI, 1024*1024)
+	if err != nil {
+		return release, fmt.Errorf("fetching latest release: %w", err)
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return release, fmt.Errorf("decoding release: %w", err)
+	}
+	return release, nil
+}
+
+// expectedChecksum finds the SHA-256 for name in a sha256sum-style file.
+func expectedChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no entry for %s", checksumsAsset, name)
+}
+
+// verifyChecksumsSignature checks checksums.txt against the embedded key.
+func verifyChecksumsSignature(release githubRelease, checksums []byte) error {
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("built-in update public key is invalid")
+	}
+	sigAsset, ok := release.asset(checksumsSigAsset)
+	if !ok {
+		return fmt.Errorf("release %s is not signed (no %s)", release.TagName, checksumsSigAsset)
+	}
+	sig, err := httpGet(httpClient, sigAsset.URL, 4096)
+	if err != nil {
+		return fmt.Errorf("downloading signature: %w", err)
+	}
+	// Accept both raw and base64-encoded signatures
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), checksums, sig) {
+		return fmt.Errorf("signature of %s does not verify", checksumsAsset)
+	}
+	return nil
+}
+
+// replaceExecutable swaps the running binary for data. The new file is
+// written next to the old one and renamed over it, so a failed update never
+// leaves a half-written binary behind.
+func replaceExecutable(data []byte) (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("locating the fast-cli binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := os.FileMode(0o755)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fast-cli-update-*")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file (is the binary's directory writable?): %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("writing update: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("writing update: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return "", fmt.Errorf("setting permissions: %w", err)
+	}
+
+	// Windows can't replace a running executable, but it can rename it
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			return "", fmt.Errorf("moving old binary aside: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("replacing binary: %w", err)
+	}
+	return path, nil
+}
+
+type updateFlags struct {
+	CheckOnly, Force bool
+}
+
+func newUpdateFlagSet(f *updateFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("fast-cli update", flag.ContinueOnError)
+	fs.BoolVar(&f.CheckOnly, "check-only", false, "only report whether an update is available")
+	fs.BoolVar(&f.Force, "force", false, "reinstall even if already up to date")
+	return fs
+}
+
+// runUpdate implements `fast-cli update`: install the latest GitHub release
+// for this platform after verifying its checksum and, when the build carries
+// a public key, the signature over the checksums.
+func runUpdate(args []string) error {
+	var f updateFlags
+	if err := newUpdateFlagSet(&f).Parse(args); err != nil {
+		return err
+	}
+
+	release, err := fetchLatestRelease()
+	if err != nil {
+		return err
+	}
+	if !newerVersion(release.TagName, version) && !f.Force {
+		fmt.Printf("fast-cli %s is up to date (latest release %s).\n", version, release.TagName)
+		return nil
+	}
+	if f.CheckOnly {
+		fmt.Printf("Update available: %s -> %s\n%s\n", version, release.TagName, release.HTMLURL)
+		return nil
+	}
+
+	name := releaseAssetName()
+	binAsset, ok := release.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no binary for this platform (%s)", release.TagName, name)
+	}
+	sumsAsset, ok := release.asset(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s, refusing to install an unverified binary", release.TagName, checksumsAsset)
+	}
+
+	checksums, err := httpGet(httpClient, sumsAsset.URL, 1024*1024)
+	if err != nil {
+		return fmt.Errorf("downloading checksums: %w", err)
+	}
+	if updatePublicKey != "" {
+		if err := verifyChecksumsSignature(release, checksums); err != nil {
+			return err
+		}
+	}
+	want, err := expectedChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Downloading %s %s...\n", name, release.TagName)
+	data, err := httpGet(httpClient, binAsset.URL, maxUpdateSize)
+	if err != nil {
+		return fmt.Errorf("downloading update: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+
+	path, err := replaceExecutable(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s from %s to %s.\n", path, version, release.TagName)
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// Bandwidth needed per stream, in Mbps (Netflix's published recommendations)
+	sdStreamMbps    = 3.0
+	hdStreamMbps    = 5.0
+	uhd4KStreamMbps = 15.0
+
+	// Video calls (roughly Zoom/Meet/Teams requirements for group calls)
+	videoCall1080pMbps      = 3.8
+	videoCall720pMbps       = 1.5
+	videoCallMaxLatency     = 150 * time.Millisecond
+	videoCallMaxJitter      = 30 * time.Millisecond
+	videoCallGoodMaxLatency = 80 * time.Millisecond
+
+	// Cloud gaming (roughly GeForce NOW / Xbox Cloud tiers)
+	cloudGaming4KMbps    = 45.0
+	cloudGaming1080pMbps = 25.0
+	cloudGaming720pMbps  = 15.0
+)
+
+// useCaseVerdict is one line of the "What can you do with this connection?" section.
+type useCaseVerdict struct {
+	UseCase string `json:"use_case"`
+	Verdict string `json:"verdict"`
+}
+
+// effectiveLatency picks the latency that matters for interactive use: the
+// worst loaded latency when it was measured, otherwise the idle latency.
+func effectiveLatency(res testResult) (latency, jitter time.Duration) {
+	latency, jitter = res.IdleLatency.Avg, res.IdleLatency.Jitter
+	for _, loaded := range []latencyStats{res.DownloadLatency, res.UploadLatency} {
+		if len(loaded.Samples) == 0 {
+			continue
+		}
+		if loaded.Avg > latency {
+			latency = loaded.Avg
+		}
+		if loaded.Jitter > jitter {
+			jitter = loaded.Jitter
+		}
+	}
+	return latency, jitter
+}
+
+// estimateMOS computes a VoIP Mean Opinion Score (1.0-4.5) using the
+// simplified ITU-T G.107 E-model, assuming no packet loss.
+func estimateMOS(latency, jitter time.Duration) float64 {
+	// Jitter buffers roughly double the jitter's cost, plus ~10 ms for codecs
+	effective := durationMs(latency) + 2*durationMs(jitter) + 10
+
+	var r float64
+	if effective < 160 {
+		r = 93.2 - effective/40
+	} else {
+		r = 93.2 - (effective-120)/10
+	}
+	if r < 0 {
+		return 1
+	}
+	if r > 100 {
+		r = 100
+	}
+	return 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+}
+
+func streamingVerdict(l locale, downloadMbps float64) string {
+	switch {
+	case downloadMbps >= uhd4KStreamMbps:
+		return l.sprintf("Up to %d concurrent 4K streams (or %d HD)",
+			int(downloadMbps/uhd4KStreamMbps), int(downloadMbps/hdStreamMbps))
+	case downloadMbps >= hdStreamMbps:
+		return l.sprintf("HD only, up to %d concurrent streams", int(downloadMbps/hdStreamMbps))
+	case downloadMbps >= sdStreamMbps:
+		return l.tr("SD only, a single stream")
+	default:
+		return l.tr("Not enough bandwidth for smooth streaming")
+	}
+}
+
+func videoCallVerdict(l locale, downloadMbps, uploadMbps float64, latency, jitter time.Duration) string {
+	if latency > videoCallMaxLatency || jitter > videoCallMaxJitter {
+		return l.sprintf("Poor - latency %v / jitter %v will cause lag and dropouts",
+			latency.Round(time.Millisecond), jitter.Round(time.Millisecond))
+	}
+	slowest := math.Min(downloadMbps, uploadMbps)
+	switch {
+	case slowest >= videoCall1080pMbps && latency <= videoCallGoodMaxLatency:
+		return l.tr("Excellent - 1080p group calls")
+	case slowest >= videoCall720pMbps:
+		return l.tr("Good - 720p group calls")
+	default:
+		return l.tr("Poor - audio-only or low-resolution video")
+	}
+}
+
+func cloudGamingVerdict(l locale, downloadMbps float64, latency time.Duration) string {
+	switch {
+	case downloadMbps >= cloudGaming4KMbps && latency <= 40*time.Millisecond:
+		return l.tr("Excellent - 4K streaming")
+	case downloadMbps >= cloudGaming1080pMbps && latency <= 60*time.Millisecond:
+		return l.tr("Good - 1080p60")
+	case downloadMbps >= cloudGaming720pMbps && latency <= 80*time.Millisecond:
+		return l.tr("Playable - 720p60, fast-paced games may feel sluggish")
+	default:
+		return l.tr("Not recommended")
+	}
+}
+
+func mosVerdict(l locale, mos float64) string {
+	switch {
+	case mos >= 4.3:
+		return l.sprintf("%.1f (excellent)", mos)
+	case mos >= 4.0:
+		return l.sprintf("%.1f (good)", mos)
+	case mos >= 3.6:
+		return l.sprintf("%.1f (fair)", mos)
+	case mos >= 3.1:
+		return l.sprintf("%.1f (poor)", mos)
+	default:
+		return l.sprintf("%.1f (bad)", mos)
+	}
+}
+
+// bufferbloatVerdict judges how much latency grows while the link is
+// saturated, using the worse of the two phases. The grades follow the
+// common bufferbloat tests: under 5ms is unnoticeable, over 200ms ruins
+// calls and games whenever anyone else uses the connection.
+func bufferbloatVerdict(l locale, res testResult) string {
+	if len(res.IdleLatency.Samples) == 0 {
+		return l.tr("unknown, idle latency could not be measured")
+	}
+	var worst time.Duration
+	phase := ""
+	for _, p := range []struct {
+		Name  string
+		Stats latencyStats
+	}{{"downloading", res.DownloadLatency}, {"uploading", res.UploadLatency}} {
+		if len(p.Stats.Samples) > 0 && (phase == "" || p.Stats.Avg > worst) {
+			worst, phase = p.Stats.Avg, l.tr(p.Name)
+		}
+	}
+	if phase == "" {
+		return l.tr("unknown, latency under load could not be measured")
+	}
+	increase := (worst - res.IdleLatency.Avg).Round(time.Millisecond)
+	switch {
+	case increase < 5*time.Millisecond:
+		return l.tr("no noticeable bufferbloat, latency holds steady under load")
+	case increase < 30*time.Millisecond:
+		return l.sprintf("minor bufferbloat, latency rises by %v while %s", increase, phase)
+	case increase < 60*time.Millisecond:
+		return l.sprintf("moderate bufferbloat, latency rises by %v while %s; calls may stutter during large transfers", increase, phase)
+	case increase < 200*time.Millisecond:
+		return l.sprintf("significant bufferbloat, latency rises by %v while %s; enable SQM (fq_codel/cake) on the router", increase, phase)
+	default:
+		return l.sprintf("severe bufferbloat, latency rises by %v while %s; calls and games suffer whenever the link is busy, enable SQM on the router", increase, phase)
+	}
+}
+
+// assessConnection translates raw measurements into practical use-case
+// verdicts, worded in l.
+func assessConnection(res testResult, l locale) []useCaseVerdict {
+	latency, jitter := effectiveLatency(res)
+
+	verdicts := []useCaseVerdict{
+		{UseCase: l.tr("Netflix streaming"), Verdict: streamingVerdict(l, res.Download.Mbps)},
+		{UseCase: l.tr("Video calls"), Verdict: videoCallVerdict(l, res.Download.Mbps, res.Upload.Mbps, latency, jitter)},
+		{UseCase: l.tr("Cloud gaming"), Verdict: cloudGamingVerdict(l, res.Download.Mbps, latency)},
+	}
+	if len(res.IdleLatency.Samples) > 0 {
+		verdicts = append(verdicts, useCaseVerdict{UseCase: l.tr("VoIP MOS (estimated)"), Verdict: mosVerdict(l, estimateMOS(latency, jitter))})
+	}
+	return verdicts
+}
+
+func printVerdicts(res testResult) {
+	fmt.Println(lang.tr("\n--- What can you do with this connection? ---"))
+	for _, v := range assessConnection(res, lang) {
+		fmt.Printf("%-22s %s\n", v.UseCase+":", v.Verdict)
+	}
+}
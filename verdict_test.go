@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateMOS(t *testing.T) {
+	for _, tc := range []struct {
+		latency, jitter time.Duration
+		min, max        float64
+	}{
+		{10 * time.Millisecond, 0, 4.3, 4.5},
+		{100 * time.Millisecond, 10 * time.Millisecond, 4.0, 4.4},
+		{500 * time.Millisecond, 50 * time.Millisecond, 1, 3.1},
+		{2 * time.Second, time.Second, 1, 1}, // R below zero
+	} {
+		got := estimateMOS(tc.latency, tc.jitter)
+		if got < tc.min || got > tc.max || math.IsNaN(got) {
+			t.Errorf("estimateMOS(%v, %v) = %.2f, want %.1f to %.1f", tc.latency, tc.jitter, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestEffectiveLatency(t *testing.T) {
+	idle := latencyStats{Samples: []time.Duration{1}, Avg: 10 * time.Millisecond, Jitter: 2 * time.Millisecond}
+	loaded := latencyStats{Samples: []time.Duration{1}, Avg: 80 * time.Millisecond, Jitter: 15 * time.Millisecond}
+	for _, tc := range []struct {
+		name                    string
+		res                     testResult
+		wantLatency, wantJitter time.Duration
+	}{
+		{"idle only", testResult{IdleLatency: idle}, 10 * time.Millisecond, 2 * time.Millisecond},
+		{"loaded download", testResult{IdleLatency: idle, DownloadLatency: loaded}, 80 * time.Millisecond, 15 * time.Millisecond},
+		{"loaded without samples", testResult{IdleLatency: idle, UploadLatency: latencyStats{Avg: time.Second}}, 10 * time.Millisecond, 2 * time.Millisecond},
+	} {
+		latency, jitter := effectiveLatency(tc.res)
+		if latency != tc.wantLatency || jitter != tc.wantJitter {
+			t.Errorf("%s: got %v / %v, want %v / %v", tc.name, latency, jitter, tc.wantLatency, tc.wantJitter)
+		}
+	}
+}
+
+func TestUseCaseVerdicts(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"4K streams", streamingVerdict(localeEnglish, 100), "Up to 6 concurrent 4K streams (or 20 HD)"},
+		{"HD streams", streamingVerdict(localeEnglish, 12), "HD only, up to 2 concurrent streams"},
+		{"SD stream", streamingVerdict(localeEnglish, 3), "SD only, a single stream"},
+		{"no stream", streamingVerdict(localeEnglish, 1), "Not enough bandwidth for smooth streaming"},
+		{"1080p call", videoCallVerdict(localeEnglish, 50, 10, 20*time.Millisecond, 2*time.Millisecond), "Excellent - 1080p group calls"},
+		{"720p call on a distant server", videoCallVerdict(localeEnglish, 50, 10, 100*time.Millisecond, 2*time.Millisecond), "Good - 720p group calls"},
+		{"call on a slow upload", videoCallVerdict(localeEnglish, 50, 1, 20*time.Millisecond, 2*time.Millisecond), "Poor - audio-only or low-resolution video"},
+		{"call with jitter", videoCallVerdict(localeEnglish, 50, 10, 20*time.Millisecond, 40*time.Millisecond), "Poor - latency 20ms / jitter 40ms will cause lag and dropouts"},
+		{"4K gaming", cloudGamingVerdict(localeEnglish, 100, 20*time.Millisecond), "Excellent - 4K streaming"},
+		{"gaming held back by latency", cloudGamingVerdict(localeEnglish, 100, 70*time.Millisecond), "Playable - 720p60, fast-paced games may feel sluggish"},
+		{"no gaming", cloudGamingVerdict(localeEnglish, 100, 200*time.Millisecond), "Not recommended"},
+		{"good MOS", mosVerdict(localeEnglish, 4.1), "4.1 (good)"},
+		{"bad MOS", mosVerdict(localeEnglish, 2), "2.0 (bad)"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestBufferbloatVerdict(t *testing.T) {
+	stats := func(avg time.Duration) latencyStats {
+		return latencyStats{Samples: []time.Duration{avg}, Avg: avg}
+	}
+	idle := stats(10 * time.Millisecond)
+	for _, tc := range []struct {
+		name string
+		res  testResult
+		want string
+	}{
+		{"no idle latency", testResult{DownloadLatency: stats(time.Second)}, "unknown, idle latency"},
+		{"no loaded latency", testResult{IdleLatency: idle}, "unknown, latency under load"},
+		{"steady", testResult{IdleLatency: idle, DownloadLatency: stats(12 * time.Millisecond)}, "no noticeable bufferbloat"},
+		{"minor", testResult{IdleLatency: idle, DownloadLatency: stats(30 * time.Millisecond)}, "minor bufferbloat, latency rises by 20ms while downloading"},
+		{"worse uploading", testResult{IdleLatency: idle, DownloadLatency: stats(30 * time.Millisecond), UploadLatency: stats(110 * time.Millisecond)}, "significant bufferbloat, latency rises by 100ms while uploading"},
+		{"severe", testResult{IdleLatency: idle, UploadLatency: stats(500 * time.Millisecond)}, "severe bufferbloat"},
+	} {
+		if got := bufferbloatVerdict(localeEnglish, tc.res); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%s: got %q, want it to start with %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAssessConnectionSkipsMOSWithoutLatency(t *testing.T) {
+	res := testResult{Download: phaseResult{Mbps: 100}, Upload: phaseResult{Mbps: 10}}
+	if n := len(assessConnection(res, localeEnglish)); n != 3 {
+		t.Errorf("got %d verdicts without idle latency, want 3", n)
+	}
+	res.IdleLatency = latencyStats{Samples: []time.Duration{time.Millisecond}, Avg: time.Millisecond}
+	if n := len(assessConnection(res, localeEnglish)); n != 4 {
+		t.Errorf("got %d verdicts with idle latency, want 4", n)
+	}
+}
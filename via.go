@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// viaClient builds an HTTP client whose traffic goes through spec: a proxy
+// URL (http, https, socks5) or the name of a network interface, typically
+// a VPN's.
+func viaClient(spec string) (doer, error) {
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+	if strings.Contains(spec, "://") {
+		proxy, err := url.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", proxy.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+		return timeoutDoer{base: &http.Client{Transport: transport, CheckRedirect: redirects.check}, timeout: httpClientTimeout}, nil
+	}
+
+	iface, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a proxy URL nor a network interface: %w", spec, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("reading addresses of %s: %w", spec, err)
+	}
+	var local net.IP
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			local = ipNet.IP
+			break
+		}
+	}
+	if local == nil {
+		return nil, fmt.Errorf("interface %s has no IPv4 address", spec)
+	}
+	// The source address alone routes through the VPN only with policy
+	// routing, so the socket is bound to the device too where possible
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		LocalAddr: &net.TCPAddr{IP: local},
+		Control:   bindToDevice(spec),
+	}
+	transport.DialContext = dialer.DialContext
+	return timeoutDoer{base: &http.Client{Transport: transport, CheckRedirect: redirects.check}, timeout: httpClientTimeout}, nil
+}
+
+// viaChange formats the change from a to b as a percentage of a.
+func viaChange(a, b float64) string {
+	if a == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(b/a-1))
+}
+
+func printViaComparison(direct, via testResult, spec string) {
+	ms := func(d time.Duration) string { return d.Round(100 * time.Microsecond).String() }
+	delta := func(a, b time.Duration) string {
+		if b >= a {
+			return "+" + ms(b-a)
+		}
+		return ms(b - a)
+	}
+	fmt.Printf("\n--- Default route vs %s ---\n", spec)
+	fmt.Printf("%-14s %14s %14s %10s\n", "", "Default", spec, "Change")
+	fmt.Printf("%-14s %9.2f Mbps %9.2f Mbps %10s\n", "Download", direct.Download.Mbps, via.Download.Mbps, viaChange(direct.Download.Mbps, via.Download.Mbps))
+	fmt.Printf("%-14s %9.2f Mbps %9.2f Mbps %10s\n", "Upload", direct.Upload.Mbps, via.Upload.Mbps, viaChange(direct.Upload.Mbps, via.Upload.Mbps))
+	fmt.Printf("%-14s %14s %14s %10s\n", "Latency", ms(direct.IdleLatency.Avg), ms(via.IdleLatency.Avg), delta(direct.IdleLatency.Avg, via.IdleLatency.Avg))
+	fmt.Printf("%-14s %14s %14s %10s\n", "Jitter", ms(direct.IdleLatency.Jitter), ms(via.IdleLatency.Jitter), delta(direct.IdleLatency.Jitter, via.IdleLatency.Jitter))
+	fmt.Printf("%-14s %14s %14s %10s\n", "Loaded latency", ms(direct.DownloadLatency.Avg), ms(via.DownloadLatency.Avg), delta(direct.DownloadLatency.Avg, via.DownloadLatency.Avg))
+
+	if direct.Download.Mbps > 0 && via.Download.Mbps < 0.8*direct.Download.Mbps {
+		fmt.Printf("\n%s costs %.0f%% of the download speed: it is the bottleneck.\n", spec, 100*(1-via.Download.Mbps/direct.Download.Mbps))
+	} else {
+		fmt.Printf("\n%s keeps at least 80%% of the download speed, it is not the bottleneck.\n", spec)
+	}
+}
+
+// runViaComparison implements --compare-via: the full test on the default
+// route, then again through the interface or proxy, and the difference.
+// Thresholds apply to the default route.
+func runViaComparison(opts *options) error {
+	client, err := viaClient(opts.CompareVia)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(statusOut, "\n=== Default route ===")
+	direct, err := runSpeedTest(opts)
+	if err != nil {
+		return fmt.Errorf("test on the default route: %w", err)
+	}
+	recordHistory(opts, direct)
+
+	fmt.Fprintf(statusOut, "\n=== Via %s ===\n", opts.CompareVia)
+	defaultClient := httpClient
+	httpClient = client
+	via, err := runSpeedTest(opts)
+	httpClient = defaultClient
+	if err != nil {
+		return fmt.Errorf("test via %s: %w", opts.CompareVia, err)
+	}
+	via.Via = opts.CompareVia
+	recordHistory(opts, via)
+
+	if opts.Format == formatJSON {
+		if err := writeJSON(struct {
+			Default jsonResult `json:"default"`
+			Via     jsonResult `json:"via"`
+		}{newJSONResult(direct, opts.Plan), newJSONResult(via, opts.Plan)}); err != nil {
+			return fmt.Errorf("writing result: %w", err)
+		}
+	} else {
+		printViaComparison(direct, via, opts.CompareVia)
+	}
+
+	if opts.GHA {
+		writeGHAAnnotations(os.Stderr, direct, opts.Thresholds)
+	}
+	if failures := opts.Thresholds.check(direct); len(failures) > 0 {
+		log.Printf("Thresholds not met: %s", strings.Join(failures, ", "))
+		os.Exit(1)
+	}
+	return nil
+}
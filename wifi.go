@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// wifiInfo describes the wireless link a test ran over, so that poor
+// results can be told apart from a weak signal or a crowded band.
+type wifiInfo struct {
+	Interface    string  `json:"interface"`
+	SSID         string  `json:"ssid,omitempty"`
+	Band         string  `json:"band,omitempty"` // "2.4 GHz", "5 GHz" or "6 GHz"
+	Channel      int     `json:"channel,omitempty"`
+	FrequencyMHz int     `json:"frequency_mhz,omitempty"`
+	PHYRateMbps  float64 `json:"phy_rate_mbps,omitempty"` // Transmit rate the adapter negotiated
+	RSSIdBm      int     `json:"rssi_dbm,omitempty"`
+}
+
+func (w wifiInfo) String() string {
+	var parts []string
+	if w.SSID != "" {
+		parts = append(parts, fmt.Sprintf("%q", w.SSID))
+	}
+	if w.Band != "" {
+		parts = append(parts, fmt.Sprintf("%s channel %d", w.Band, w.Channel))
+	}
+	if w.PHYRateMbps > 0 {
+		parts = append(parts, fmt.Sprintf("%g Mbps PHY", w.PHYRateMbps))
+	}
+	if w.RSSIdBm != 0 {
+		signal := fmt.Sprintf("%d dBm", w.RSSIdBm)
+		switch {
+		case w.RSSIdBm < -75:
+			signal += " (weak)"
+		case w.RSSIdBm < -67:
+			signal += " (fair)"
+		}
+		parts = append(parts, signal)
+	}
+	return w.Interface + ": " + strings.Join(parts, ", ")
+}
+
+// wifiChannel maps a center frequency to its band and channel number.
+func wifiChannel(mhz int) (band string, channel int) {
+	switch {
+	case mhz == 2484:
+		return "2.4 GHz", 14
+	case mhz >= 2412 && mhz < 2484:
+		return "2.4 GHz", (mhz - 2407) / 5
+	case mhz >= 5150 && mhz <= 5925:
+		return "5 GHz", (mhz - 5000) / 5
+	case mhz >= 5955 && mhz <= 7115:
+		return "6 GHz", (mhz - 5950) / 5
+	}
+	return "", 0
+}
+
+// egressInterface returns the interface traffic to host leaves through.
+func egressInterface(host string) (net.Interface, error) {
+	// Connecting a UDP socket picks the route without sending anything
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "443"))
+	if err != nil {
+		return net.Interface{}, fmt.Errorf("no route to %s: %w", host, err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, fmt.Errorf("listing interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+				return iface, nil
+			}
+		}
+	}
+	return net.Interface{}, fmt.Errorf("no interface has the local address %s", local)
+}
+
+// probeWiFi returns the link details when traffic to t goes out over Wi-Fi,
+// and nil when it doesn't.
+func probeWiFi(t target) (*wifiInfo, error) {
+	iface, err := egressInterface(targetHostname(t))
+	if err != nil {
+		return nil, err
+	}
+	return readWiFi(iface)
+}
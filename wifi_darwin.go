@@ -0,0 +1,59 @@
+//go:build darwin
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const airportPath = "/System/Library/PrivateFrameworks/Apple80211.framework/Versions/Current/Resources/airport"
+
+// readWiFi parses `airport -I`, which describes the active Wi-Fi link. It
+// knows nothing about other interfaces, so networksetup is asked first
+// whether iface is the Wi-Fi one.
+func readWiFi(iface net.Interface) (*wifiInfo, error) {
+	out, err := exec.Command("networksetup", "-getairportpower", iface.Name).CombinedOutput()
+	if err != nil || bytes.Contains(out, []byte("not a Wi-Fi interface")) {
+		return nil, nil
+	}
+	out, err = exec.Command(airportPath, "-I").Output()
+	if err != nil {
+		return nil, fmt.Errorf("running airport: %w", err)
+	}
+
+	info := &wifiInfo{Interface: iface.Name}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "SSID":
+			info.SSID = value
+		case "agrCtlRSSI":
+			info.RSSIdBm, _ = strconv.Atoi(value)
+		case "lastTxRate":
+			info.PHYRateMbps, _ = strconv.ParseFloat(value, 64)
+		case "channel":
+			// "36,80": the primary channel, then the width
+			primary, _, _ := strings.Cut(value, ",")
+			info.Channel, _ = strconv.Atoi(primary)
+			info.Band = "5 GHz"
+			if info.Channel <= 14 {
+				info.Band = "2.4 GHz"
+			}
+		}
+	}
+	if info.SSID == "" && info.RSSIdBm == 0 {
+		return nil, nil // Wi-Fi is on but not associated
+	}
+	return info, nil
+}
@@ -0,0 +1,186 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Generic netlink and nl80211 constants, from linux/genetlink.h and
+// linux/nl80211.h.
+const (
+	genlIDCtrl            = 0x10
+	ctrlCmdGetFamily      = 3
+	ctrlAttrFamilyID      = 1
+	ctrlAttrFamilyName    = 2
+	nl80211CmdGetStation  = 17
+	nl80211CmdGetIface    = 5
+	nl80211AttrIfindex    = 3
+	nl80211AttrStaInfo    = 21
+	nl80211AttrWiphyFreq  = 38
+	nl80211AttrSSID       = 52
+	nl80211StaInfoSignal  = 7
+	nl80211StaInfoTxRate  = 8
+	nl80211RateInfoRate   = 1 // u16, 100 kbit/s
+	nl80211RateInfoRate32 = 5 // u32, 100 kbit/s
+)
+
+type netlinkAttrs map[uint16][]byte
+
+func parseNetlinkAttrs(b []byte) netlinkAttrs {
+	attrs := netlinkAttrs{}
+	for len(b) >= 4 {
+		size := int(binary.NativeEndian.Uint16(b))
+		if size < 4 || size > len(b) {
+			break
+		}
+		attrs[binary.NativeEndian.Uint16(b[2:])&0x3FFF] = b[4:size] // Without the nested and byte-order flags
+		b = b[min((size+3)&^3, len(b)):]
+	}
+	return attrs
+}
+
+func appendNetlinkAttr(b []byte, typ uint16, data []byte) []byte {
+	b = binary.NativeEndian.AppendUint16(b, uint16(4+len(data)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// genlConn is a generic netlink socket, the way nl80211 is talked to.
+type genlConn struct {
+	fd  int
+	seq uint32
+}
+
+func dialGenl() (*genlConn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, fmt.Errorf("opening netlink socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("binding netlink socket: %w", err)
+	}
+	timeout := syscall.NsecToTimeval(int64(connectivityTimeout))
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+	return &genlConn{fd: fd}, nil
+}
+
+func (c *genlConn) Close() error { return syscall.Close(c.fd) }
+
+// request sends a generic netlink command and returns the attributes of
+// every reply, until the kernel acknowledges it or ends the dump.
+func (c *genlConn) request(family uint16, cmd uint8, dump bool, attrs []byte) ([]netlinkAttrs, error) {
+	c.seq++
+	flags := uint16(syscall.NLM_F_REQUEST | syscall.NLM_F_ACK)
+	if dump {
+		flags = syscall.NLM_F_REQUEST | syscall.NLM_F_DUMP
+	}
+	msg := binary.NativeEndian.AppendUint32(nil, uint32(syscall.NLMSG_HDRLEN+4+len(attrs)))
+	msg = binary.NativeEndian.AppendUint16(msg, family)
+	msg = binary.NativeEndian.AppendUint16(msg, flags)
+	msg = binary.NativeEndian.AppendUint32(msg, c.seq)
+	msg = binary.NativeEndian.AppendUint32(msg, 0)
+	msg = append(msg, cmd, 1, 0, 0) // Command, version, reserved
+	msg = append(msg, attrs...)
+	if err := syscall.Sendto(c.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("sending netlink request: %w", err)
+	}
+
+	var replies []netlinkAttrs
+	buf := make([]byte, 32*1024)
+	for {
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("reading netlink reply: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("parsing netlink reply: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != c.seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+						return nil, syscall.Errno(-errno)
+					}
+				}
+				return replies, nil // An ack
+			default:
+				if len(m.Data) >= 4 {
+					replies = append(replies, parseNetlinkAttrs(m.Data[4:]))
+				}
+			}
+		}
+	}
+}
+
+// readWiFi asks nl80211 for the interface's SSID and frequency and for the
+// access point's signal and transmit rate.
+func readWiFi(iface net.Interface) (*wifiInfo, error) {
+	if _, err := os.Stat("/sys/class/net/" + iface.Name + "/wireless"); err != nil {
+		return nil, nil // Not a wireless interface
+	}
+	c, err := dialGenl()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	replies, err := c.request(genlIDCtrl, ctrlCmdGetFamily, false, appendNetlinkAttr(nil, ctrlAttrFamilyName, []byte("nl80211\x00")))
+	if err != nil {
+		return nil, fmt.Errorf("looking up nl80211: %w", err)
+	}
+	if len(replies) == 0 || len(replies[0][ctrlAttrFamilyID]) < 2 {
+		return nil, errors.New("nl80211 is not available")
+	}
+	family := binary.NativeEndian.Uint16(replies[0][ctrlAttrFamilyID])
+	ifindex := appendNetlinkAttr(nil, nl80211AttrIfindex, binary.NativeEndian.AppendUint32(nil, uint32(iface.Index)))
+
+	info := &wifiInfo{Interface: iface.Name}
+	replies, err = c.request(family, nl80211CmdGetIface, false, ifindex)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", iface.Name, err)
+	}
+	for _, r := range replies {
+		info.SSID = string(r[nl80211AttrSSID])
+		if freq := r[nl80211AttrWiphyFreq]; len(freq) >= 4 {
+			info.FrequencyMHz = int(binary.NativeEndian.Uint32(freq))
+			info.Band, info.Channel = wifiChannel(info.FrequencyMHz)
+		}
+	}
+
+	// In station mode the only station is the access point
+	replies, err = c.request(family, nl80211CmdGetStation, true, ifindex)
+	if err != nil {
+		return nil, fmt.Errorf("reading the %s link: %w", iface.Name, err)
+	}
+	for _, r := range replies {
+		sta := parseNetlinkAttrs(r[nl80211AttrStaInfo])
+		if signal := sta[nl80211StaInfoSignal]; len(signal) >= 1 {
+			info.RSSIdBm = int(int8(signal[0]))
+		}
+		rate := parseNetlinkAttrs(sta[nl80211StaInfoTxRate])
+		if r32 := rate[nl80211RateInfoRate32]; len(r32) >= 4 {
+			info.PHYRateMbps = float64(binary.NativeEndian.Uint32(r32)) / 10
+		} else if r16 := rate[nl80211RateInfoRate]; len(r16) >= 2 {
+			info.PHYRateMbps = float64(binary.NativeEndian.Uint16(r16)) / 10
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "net"
+
+// readWiFi has no implementation here; results carry no Wi-Fi details.
+func readWiFi(iface net.Interface) (*wifiInfo, error) {
+	return nil, nil
+}
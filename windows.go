@@ -0,0 +1,49 @@
+package main
+
+import "time"
+
+const tailWindow = 2 * time.Second // Last part of a phase, when streams wind down
+
+// phaseWindow is the speed over one labeled part of a phase.
+type phaseWindow struct {
+	Name       string
+	Start, End time.Duration // From the start of the phase
+	Mbps       float64
+	Max        float64 // Best sample in the window
+}
+
+// measureWindows splits the samples of a phase into its ramp-up, while TCP
+// slow start opens the window, the steady state, and the tail. Short phases
+// get the three a third each. Nil if there are too few samples.
+func measureWindows(samples []float64) []phaseWindow {
+	if len(samples) < 3 {
+		return nil
+	}
+	third := len(samples) / 3
+	rampUp := min(int(consistencyRampUp/throughputSampleInterval), third)
+	tail := min(int(tailWindow/throughputSampleInterval), third)
+	bounds := []struct {
+		name     string
+		from, to int
+	}{
+		{"ramp_up", 0, rampUp},
+		{"steady", rampUp, len(samples) - tail},
+		{"tail", len(samples) - tail, len(samples)},
+	}
+	windows := make([]phaseWindow, 0, len(bounds))
+	for _, b := range bounds {
+		w := phaseWindow{
+			Name:  b.name,
+			Start: time.Duration(b.from) * throughputSampleInterval,
+			End:   time.Duration(b.to) * throughputSampleInterval,
+		}
+		var sum float64
+		for _, s := range samples[b.from:b.to] {
+			sum += s
+			w.Max = max(w.Max, s)
+		}
+		w.Mbps = sum / float64(b.to-b.from)
+		windows = append(windows, w)
+	}
+	return windows
+}